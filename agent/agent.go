@@ -38,6 +38,7 @@ import (
 	"github.com/percona/qan-agent/agent/release"
 	"github.com/percona/qan-agent/pct"
 	pctCmd "github.com/percona/qan-agent/pct/cmd"
+	mongoSummary "github.com/percona/qan-agent/query/plugin/mongo/summary"
 	"github.com/pkg/errors"
 )
 
@@ -512,7 +513,7 @@ func (agent *Agent) Handle(cmd *proto.Cmd) *proto.Reply {
 		data, errs = agent.handleGetMySQLSummary()
 	// TODO @obsolete by query/plugin/mongo/summary
 	case "GetMongoSummary":
-		data, errs = agent.handleGetMongoSummary()
+		return agent.handleGetMongoSummary(cmd)
 	case "CollectServicesData":
 		data, errs = agent.handleCollectInfo()
 	default:
@@ -708,8 +709,32 @@ func (agent *Agent) handleGetMySQLSummary() (interface{}, []error) {
 	return runRealCmd("pt-mysql-summary", "--sleep", "1")
 }
 
-func (agent *Agent) handleGetMongoSummary() (interface{}, []error) {
-	return runRealCmd("pt-mongodb-summary")
+// handleGetMongoSummary replies with the summary of the Mongo instance in
+// cmd.Data, made by the Summary cmd of the query service like for any other
+// instance. Without cmd.Data, like before instances were supported, it replies
+// with the summary of the local mongod.
+func (agent *Agent) handleGetMongoSummary(cmd *proto.Cmd) *proto.Reply {
+	if len(cmd.Data) == 0 {
+		output, err := mongoSummary.Summary("")
+		if err != nil {
+			agent.logger.Error(err)
+			return cmd.Reply(nil, err)
+		}
+		return cmd.Reply(output)
+	}
+	query, ok := agent.services["query"]
+	if !ok {
+		return cmd.Reply(nil, pct.UnknownServiceError{Service: "query"})
+	}
+	summaryCmd := *cmd
+	summaryCmd.Service = "query"
+	summaryCmd.Cmd = "Summary"
+	reply := query.Handle(&summaryCmd)
+	if reply.Error != "" {
+		agent.logger.Error(reply.Error)
+	}
+	reply.Cmd = cmd.Cmd
+	return reply
 }
 
 func (agent *Agent) handleCollectInfo() (interface{}, []error) {
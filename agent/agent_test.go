@@ -631,6 +631,34 @@ func (s *AgentTestSuite) TestGetMySQLSummary(t *C) {
 }
 
 func (s *AgentTestSuite) TestGetMongoSummary(t *C) {
+	// The summary is of the instance, made by the query service.
+	query := mock.NewMockServiceManager("query", s.startWaitGroup, s.traceChan)
+	s.servicesMap["query"] = query
+	data, err := json.Marshal(proto.Instance{Subsystem: "mongo", UUID: "313"})
+	t.Assert(err, IsNil)
+	cmd := &proto.Cmd{
+		Ts:      time.Now(),
+		User:    "zapp brannigan",
+		Cmd:     "GetMongoSummary",
+		Service: "agent",
+		Data:    data,
+	}
+	s.sendChan <- cmd
+
+	got := test.WaitReplyCmd(s.recvChan, "GetMongoSummary")
+	t.Assert(len(got), Equals, 1)
+	t.Assert(got[0].Error, Equals, "")
+	t.Assert(query.Cmds, HasLen, 1)
+	t.Check(query.Cmds[0].Service, Equals, "query")
+	t.Check(query.Cmds[0].Cmd, Equals, "Summary")
+	t.Check(query.Cmds[0].Data, DeepEquals, data)
+}
+
+func (s *AgentTestSuite) TestGetMongoSummaryLocal(t *C) {
+	// Without an instance, the summary is of the local mongod, like before
+	// instances were supported.
+	query := mock.NewMockServiceManager("query", s.startWaitGroup, s.traceChan)
+	s.servicesMap["query"] = query
 	cmd := &proto.Cmd{
 		Ts:      time.Now(),
 		User:    "zapp brannigan",
@@ -642,9 +670,8 @@ func (s *AgentTestSuite) TestGetMongoSummary(t *C) {
 	got := test.WaitReplyCmd(s.recvChan, "GetMongoSummary")
 	t.Assert(len(got), Equals, 1)
 	t.Assert(got[0].Error, Equals, "")
-	// in new version:
-	// t.Assert(string(got[0].Data), Matches, ".*# Mongo Executable.*")
 	t.Assert(string(got[0].Data), Matches, ".*# Instances.*")
+	t.Check(query.Cmds, HasLen, 0)
 }
 
 func (s *AgentTestSuite) TestCollectInfo(t *C) {
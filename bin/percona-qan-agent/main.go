@@ -43,6 +43,7 @@ import (
 	pctCmd "github.com/percona/qan-agent/pct/cmd"
 	"github.com/percona/qan-agent/qan"
 	qanAnalyzerFactory "github.com/percona/qan-agent/qan/analyzer/factory"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/preflight"
 	"github.com/percona/qan-agent/query"
 	"github.com/percona/qan-agent/ticker"
 )
@@ -219,6 +220,11 @@ func run(agentConfig *pc.Agent) error {
 		pct.NewLogger(logChan, "mrms-monitor"),
 		&mysql.RealConnectionFactory{},
 	)
+	mrmsHistory := mrms.NewHistory(pct.Basedir.File("mrms-history"), mrms.MaxHistory)
+	if err := mrmsHistory.Load(); err != nil {
+		golog.Printf("Cannot load MRMS history, starting empty: %s", err)
+	}
+	mrmsMonitor.SetHistory(mrmsHistory)
 	mrmsManager := mrms.NewManager(
 		pct.NewLogger(logChan, "mrms-manager"),
 		mrmsMonitor,
@@ -234,6 +240,7 @@ func run(agentConfig *pc.Agent) error {
 		api,
 		mrmsMonitor,
 	)
+	itManager.SetInstanceChecker("mysql", preflight.InstanceChecker{})
 	if err := itManager.Start(); err != nil {
 		return fmt.Errorf("error starting instance manager: %s", err)
 	}
@@ -0,0 +1,686 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package instance
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/nu7hatch/gouuid"
+	"github.com/percona/go-mysql/dsn"
+	"github.com/percona/pmgo"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct/credential"
+	"github.com/shirou/gopsutil/net"
+	"github.com/shirou/gopsutil/process"
+)
+
+const (
+	DefaultMySQLPort = 3306
+	DefaultMongoPort = 27017
+	MySQLXPort       = 33060 // X Protocol, not for QAN
+)
+
+// MySQLConfigFiles are read, in order, for the port and socket of mysqld if
+// it doesn't have them on its command line.
+var MySQLConfigFiles = []string{
+	"/etc/my.cnf",
+	"/etc/mysql/my.cnf",
+	"/etc/mysql/mysql.conf.d/mysqld.cnf",
+	"/usr/local/mysql/etc/my.cnf",
+}
+
+// MySQLSocketPaths are the usual paths of the MySQL socket. They're checked
+// because the agent sees the sockets and ports of other users' processes
+// only if it runs as root.
+var MySQLSocketPaths = []string{
+	"/var/run/mysqld/mysqld.sock",
+	"/var/lib/mysql/mysql.sock",
+	"/tmp/mysql.sock",
+}
+
+// MongoDialTimeout is how long to wait to connect to a discovered mongod.
+const MongoDialTimeout = 5 * time.Second
+
+// DiscoverTimeout is how long DiscoverInstances waits for all the instances
+// found to be connected to. Those not connected to by then have an error.
+const DiscoverTimeout = 30 * time.Second
+
+// A localProcess is a mysqld or mongod running on the agent host.
+type localProcess struct {
+	pid     int32
+	name    string   // mysqld, mariadbd or mongod
+	args    []string // command line, without the program
+	ports   []int    // listening TCP ports
+	sockets []string // listening Unix sockets
+}
+
+// An endpoint is how to connect to a discovered instance.
+type endpoint struct {
+	subsystem string // "mysql" or "mongo"
+	socket    string // MySQL only, used instead of the port if set
+	port      int
+	addrs     []string // all the sockets and ports it listens on, see instanceAddr
+	source    string   // see proto.DiscoveredInstance.Source
+}
+
+// DiscoverInstances finds the MySQL and MongoDB instances on the agent host
+// from the mysqld and mongod processes, their config files, the usual MySQL
+// socket paths and the listening TCP ports. Their version is read if they can
+// be connected to, which requires the userinfo for MySQL. Instances already
+// in the repo are Registered. With config.Register, the others which can be
+// connected to are registered. DSNs are returned without password.
+func (m *Manager) DiscoverInstances(config proto.DiscoverConfig) ([]proto.DiscoveredInstance, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DiscoverTimeout)
+	defer cancel()
+
+	procs, err := getLocalProcesses()
+	if err != nil {
+		return nil, err
+	}
+	ports, err := getListeningPorts()
+	if err != nil {
+		m.logger.Warn("Cannot get listening ports:", err)
+	}
+	hostname, _ := os.Hostname()
+
+	found := []proto.DiscoveredInstance{}
+	for _, e := range findEndpoints(procs, ports) {
+		d := proto.DiscoveredInstance{
+			Instance: e.instance(hostname, config),
+			Source:   e.source,
+		}
+
+		// Is it in the repo already? It can be registered with any of
+		// its sockets or ports.
+		for _, in := range m.repo.List(e.subsystem) {
+			if e.matches(in) {
+				d.Instance.UUID = in.UUID
+				d.Instance.ParentUUID = in.ParentUUID
+				d.Registered = true
+				break
+			}
+		}
+
+		var err error
+		switch e.subsystem {
+		case "mysql":
+			if config.MySQLUserinfo == "" {
+				err = fmt.Errorf("cannot connect without MySQLUserinfo")
+			} else {
+				err = getInfo(ctx, &d.Instance, getMySQLInfo)
+			}
+		case "mongo":
+			err = getInfo(ctx, &d.Instance, getMongoInfo)
+		}
+		if err != nil {
+			d.Error = err.Error()
+		} else if config.Register && !d.Registered {
+			if err := m.register(&d.Instance); err != nil {
+				d.Error = "cannot register: " + err.Error()
+			} else {
+				d.Registered = true
+			}
+		}
+		d.Instance.DSN = hidePassword(d.Instance)
+		found = append(found, d)
+	}
+	return found, nil
+}
+
+// getInfo sets the distro and version of the instance with get, unless it
+// doesn't return before ctx is done. get must give up and close its
+// connection when ctx is done, too.
+func getInfo(ctx context.Context, in *proto.Instance, get func(context.Context, *proto.Instance) error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("not connected to within %s", DiscoverTimeout)
+	}
+	info := *in
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- get(ctx, &info)
+	}()
+	select {
+	case err := <-errChan:
+		if err != nil {
+			return err
+		}
+		in.Distro = info.Distro
+		in.Version = info.Version
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("not connected to within %s", DiscoverTimeout)
+	}
+}
+
+// hidePassword returns the DSN of the instance without password. Mongo
+// URLs keep their scheme, which dsn.HidePassword would take as the user.
+func hidePassword(in proto.Instance) string {
+	if in.Subsystem == "mongo" && strings.HasPrefix(in.DSN, "mongodb://") {
+		return "mongodb://" + dsn.HidePassword(strings.TrimPrefix(in.DSN, "mongodb://"))
+	}
+	return dsn.HidePassword(in.DSN)
+}
+
+// register adds the instance to the API, as a child of the OS instance, and
+// to the repo.
+func (m *Manager) register(in *proto.Instance) error {
+	osInstances := m.repo.List("os")
+	if len(osInstances) == 0 {
+		return fmt.Errorf("no OS instance")
+	}
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+	in.UUID = strings.Replace(u4.String(), "-", "", -1)
+	in.ParentUUID = osInstances[0].UUID
+
+	// Like updateInstance, the API gets the DSN without the password.
+	created := *in
+	created.DSN = hidePassword(*in)
+	if _, err := m.api.CreateInstance("/instances", &created); err != nil {
+		return err
+	}
+	in.UUID = created.UUID
+	in.Id = created.Id
+	in.Created = created.Created
+	if err := m.repo.Add(*in, true); err != nil {
+		return err
+	}
+	m.logger.Info(fmt.Sprintf("Registered %s %s", in.Subsystem, in.Name))
+	return nil
+}
+
+// instance returns the proto.Instance of the endpoint, named like by
+// GetMySQLInfo: the hostname, followed by the port if it's not the default.
+func (e endpoint) instance(hostname string, config proto.DiscoverConfig) proto.Instance {
+	in := proto.Instance{
+		Subsystem: e.subsystem,
+		Name:      hostname,
+	}
+	switch e.subsystem {
+	case "mysql":
+		if e.port != 0 && e.port != DefaultMySQLPort {
+			in.Name += "." + strconv.Itoa(e.port)
+		}
+		userinfo := config.MySQLUserinfo
+		if userinfo == "" {
+			userinfo = "USER:PASSWORD"
+		}
+		if e.socket != "" {
+			in.DSN = userinfo + "@unix(" + e.socket + ")/"
+		} else {
+			in.DSN = fmt.Sprintf("%s@tcp(127.0.0.1:%d)/", userinfo, e.port)
+		}
+	case "mongo":
+		if e.port != DefaultMongoPort {
+			in.Name += "." + strconv.Itoa(e.port)
+		}
+		in.DSN = fmt.Sprintf("127.0.0.1:%d", e.port)
+		if config.MongoUserinfo != "" {
+			in.DSN = "mongodb://" + config.MongoUserinfo + "@" + in.DSN
+		}
+	}
+	return in
+}
+
+// matches returns true if the instance connects to one of the sockets or
+// ports of the endpoint. Addresses which can't be parsed never match.
+func (e endpoint) matches(in proto.Instance) bool {
+	addr := instanceAddr(in)
+	if addr == "" {
+		return false
+	}
+	for _, a := range e.addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// instanceAddr returns the socket or host and port the instance connects to,
+// e.g. unix:/tmp/mysql.sock or tcp:127.0.0.1:3306 for MySQL, and
+// 127.0.0.1:27017 for MongoDB, or "" if its DSN can't be parsed.
+func instanceAddr(in proto.Instance) string {
+	switch in.Subsystem {
+	case "mysql":
+		// The user and password can be credential references, which the
+		// driver can't parse.
+		d := in.DSN
+		if i := strings.LastIndex(d, "@"); i >= 0 {
+			d = "user:password" + d[i:]
+		}
+		cfg, err := mysqlDriver.ParseDSN(d)
+		if err != nil {
+			return ""
+		}
+		addr := cfg.Addr
+		if cfg.Net == "tcp" && strings.HasPrefix(addr, "localhost:") {
+			addr = "127.0.0.1" + addr[len("localhost"):]
+		}
+		return cfg.Net + ":" + addr
+	case "mongo":
+		dialInfo, err := pmgo.ParseURL(in.DSN)
+		if err != nil || len(dialInfo.Addrs) == 0 {
+			return ""
+		}
+		addr := dialInfo.Addrs[0]
+		if !strings.Contains(addr, ":") {
+			addr += ":" + strconv.Itoa(DefaultMongoPort)
+		}
+		if strings.HasPrefix(addr, "localhost:") {
+			addr = "127.0.0.1" + addr[len("localhost"):]
+		}
+		return addr
+	}
+	return ""
+}
+
+// getMongoInfo sets the version of the mongod of the instance. mgo has no
+// context, so it times out when ctx is done instead.
+func getMongoInfo(ctx context.Context, in *proto.Instance) error {
+	dialInfo, err := pmgo.ParseURL(in.DSN)
+	if err != nil {
+		return err
+	}
+	dialInfo.Timeout = timeout(ctx, MongoDialTimeout)
+	dialInfo.Direct = true
+	session, err := credential.NewMongoDialer(pmgo.NewDialer()).DialWithInfo(dialInfo)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.SetSocketTimeout(timeout(ctx, MongoDialTimeout))
+	buildInfo, err := session.BuildInfo()
+	if err != nil {
+		return err
+	}
+	in.Version = buildInfo.Version
+	return nil
+}
+
+// timeout returns how long until ctx is done, but at most max.
+func timeout(ctx context.Context, max time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return max
+	}
+	if d := deadline.Sub(time.Now()); d < max {
+		return d
+	}
+	return max
+}
+
+/////////////////////////////////////////////////////////////////////////////
+// Finding endpoints
+/////////////////////////////////////////////////////////////////////////////
+
+// findEndpoints returns the endpoints of the processes, then those of the
+// usual MySQL sockets and of the default ports in ports which aren't the
+// endpoint of a process.
+func findEndpoints(procs []localProcess, ports []int) []endpoint {
+	endpoints := []endpoint{}
+	seenSockets := map[string]bool{}
+	seenPorts := map[int]bool{}
+	for _, p := range procs {
+		var e endpoint
+		switch p.name {
+		case "mongod":
+			e = mongoEndpoint(p)
+		default:
+			e = mysqlEndpoint(p)
+		}
+		if e.socket != "" {
+			seenSockets[e.socket] = true
+		}
+		if e.port != 0 {
+			seenPorts[e.port] = true
+		}
+		for _, socket := range p.sockets {
+			seenSockets[socket] = true
+		}
+		for _, port := range p.ports {
+			seenPorts[port] = true
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	for _, socket := range MySQLSocketPaths {
+		if seenSockets[socket] || !isSocket(socket) {
+			continue
+		}
+		endpoints = append(endpoints, endpoint{
+			subsystem: "mysql",
+			socket:    socket,
+			addrs:     mysqlAddrs([]string{socket}, nil),
+			source:    "socket " + socket,
+		})
+		seenSockets[socket] = true
+		// Don't also report the default port, it's probably the same server.
+		seenPorts[DefaultMySQLPort] = true
+	}
+
+	for _, port := range ports {
+		if seenPorts[port] {
+			continue
+		}
+		switch port {
+		case DefaultMySQLPort:
+			endpoints = append(endpoints, endpoint{subsystem: "mysql", port: port, addrs: mysqlAddrs(nil, []int{port}), source: "port " + strconv.Itoa(port)})
+		case DefaultMongoPort:
+			endpoints = append(endpoints, endpoint{subsystem: "mongo", port: port, addrs: mongoAddrs([]int{port}), source: "port " + strconv.Itoa(port)})
+		}
+		seenPorts[port] = true
+	}
+	return endpoints
+}
+
+// mysqlEndpoint returns the socket and port of mysqld from, in order, its
+// command line, its config files and its listening sockets and ports.
+func mysqlEndpoint(p localProcess) endpoint {
+	e := endpoint{
+		subsystem: "mysql",
+		source:    fmt.Sprintf("process %d", p.pid),
+	}
+	args := parseArgs(p.args)
+	configFiles := MySQLConfigFiles
+	if file, ok := args["defaults-file"]; ok {
+		configFiles = []string{file}
+	} else if file, ok := args["defaults-extra-file"]; ok {
+		configFiles = append(configFiles, file)
+	}
+	options := map[string]string{}
+	for _, file := range configFiles {
+		for k, v := range readOptionFile(file, "mysqld") {
+			options[k] = v
+		}
+	}
+	for k, v := range args {
+		options[k] = v // command line overrides config files
+	}
+
+	e.socket = options["socket"]
+	e.port, _ = strconv.Atoi(options["port"])
+
+	if e.socket == "" {
+		for _, socket := range p.sockets {
+			if !strings.Contains(filepath.Base(socket), "mysqlx") {
+				e.socket = socket
+				break
+			}
+		}
+	}
+	if e.port == 0 {
+		for _, port := range p.ports {
+			if port != MySQLXPort {
+				e.port = port
+				break
+			}
+		}
+	}
+	if e.port == 0 {
+		e.port = DefaultMySQLPort
+	}
+
+	sockets := []string{}
+	if e.socket != "" {
+		sockets = append(sockets, e.socket)
+	}
+	for _, socket := range p.sockets {
+		if !strings.Contains(filepath.Base(socket), "mysqlx") {
+			sockets = append(sockets, socket)
+		}
+	}
+	ports := []int{e.port}
+	for _, port := range p.ports {
+		if port != MySQLXPort {
+			ports = append(ports, port)
+		}
+	}
+	e.addrs = mysqlAddrs(sockets, ports)
+	return e
+}
+
+// mongoEndpoint returns the port of mongod from its command line, its config
+// file or its listening ports.
+func mongoEndpoint(p localProcess) endpoint {
+	e := endpoint{
+		subsystem: "mongo",
+		source:    fmt.Sprintf("process %d", p.pid),
+	}
+	args := parseArgs(p.args)
+	if port, err := strconv.Atoi(args["port"]); err == nil {
+		e.port = port
+	}
+	configFile := args["config"]
+	if configFile == "" {
+		configFile = args["f"]
+	}
+	if e.port == 0 && configFile != "" {
+		e.port = readMongoPort(configFile)
+	}
+	if e.port == 0 && len(p.ports) > 0 {
+		e.port = p.ports[0]
+	}
+	if e.port == 0 {
+		e.port = DefaultMongoPort
+	}
+	e.addrs = mongoAddrs(append([]int{e.port}, p.ports...))
+	return e
+}
+
+// mysqlAddrs returns the addresses of MySQL sockets and ports, without
+// duplicates, like instanceAddr returns them.
+func mysqlAddrs(sockets []string, ports []int) []string {
+	addrs := []string{}
+	seen := map[string]bool{}
+	add := func(addr string) {
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+	for _, socket := range sockets {
+		add("unix:" + socket)
+	}
+	for _, port := range ports {
+		add(fmt.Sprintf("tcp:127.0.0.1:%d", port))
+	}
+	return addrs
+}
+
+// mongoAddrs returns the addresses of mongod ports, without duplicates, like
+// instanceAddr returns them.
+func mongoAddrs(ports []int) []string {
+	addrs := []string{}
+	seen := map[int]bool{}
+	for _, port := range ports {
+		if !seen[port] {
+			seen[port] = true
+			addrs = append(addrs, fmt.Sprintf("127.0.0.1:%d", port))
+		}
+	}
+	return addrs
+}
+
+// parseArgs returns the options of a command line, keyed on their name
+// without dashes. Like MySQL, underscores in names are dashes. Options
+// without a value, like --skip-grant-tables, have an empty value.
+func parseArgs(args []string) map[string]string {
+	options := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		value := ""
+		if eq := strings.Index(name, "="); eq >= 0 {
+			name, value = name[:eq], name[eq+1:]
+		} else if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+			value = args[i+1]
+			i++
+		}
+		options[strings.Replace(name, "_", "-", -1)] = value
+	}
+	return options
+}
+
+// readOptionFile returns the options in the section of a MySQL option file,
+// or none if it can't be read. !include directives are ignored.
+func readOptionFile(file, section string) map[string]string {
+	options := map[string]string{}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return options
+	}
+	inSection := false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!' {
+			continue
+		}
+		if line[0] == '[' && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		name := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1)
+		value := ""
+		if len(kv) == 2 {
+			value = strings.Trim(strings.TrimSpace(kv[1]), `"'`)
+		}
+		options[name] = value
+	}
+	return options
+}
+
+// readMongoPort returns net.port in a mongod YAML config file, or 0.
+func readMongoPort(file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	inNet := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			inNet = strings.TrimSpace(line) == "net:"
+			continue
+		}
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if inNet && len(kv) == 2 && kv[0] == "port" {
+			port, _ := strconv.Atoi(strings.TrimSpace(kv[1]))
+			return port
+		}
+	}
+	return 0
+}
+
+func isSocket(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&os.ModeSocket != 0
+}
+
+// getLocalProcesses returns the mysqld and mongod processes. Their ports and
+// sockets are known only if the agent runs as root or as their user.
+func getLocalProcesses() ([]localProcess, error) {
+	pids, err := process.Pids()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get the list of PIDs: %s", err)
+	}
+	procs := []localProcess{}
+	for _, pid := range pids {
+		proc, err := process.NewProcess(pid)
+		if err != nil {
+			continue
+		}
+		name, err := proc.Name()
+		if err != nil {
+			continue
+		}
+		switch name {
+		case "mysqld", "mariadbd", "mongod":
+		default:
+			continue
+		}
+		p := localProcess{
+			pid:  pid,
+			name: name,
+		}
+		if cmdline, err := proc.CmdlineSlice(); err == nil && len(cmdline) > 0 {
+			p.args = cmdline[1:]
+		}
+		if conns, err := net.ConnectionsPid("tcp", pid); err == nil {
+			for _, c := range conns {
+				if c.Status == "LISTEN" {
+					p.ports = append(p.ports, int(c.Laddr.Port))
+				}
+			}
+		}
+		if conns, err := net.ConnectionsPid("unix", pid); err == nil {
+			for _, c := range conns {
+				if c.Laddr.IP != "" {
+					p.sockets = append(p.sockets, c.Laddr.IP)
+				}
+			}
+		}
+		sort.Ints(p.ports)
+		sort.Strings(p.sockets)
+		procs = append(procs, p)
+	}
+	return procs, nil
+}
+
+// getListeningPorts returns the listening TCP ports of all processes.
+func getListeningPorts() ([]int, error) {
+	conns, err := net.Connections("tcp")
+	if err != nil {
+		return nil, err
+	}
+	ports := []int{}
+	for _, c := range conns {
+		if c.Status == "LISTEN" {
+			ports = append(ports, int(c.Laddr.Port))
+		}
+	}
+	sort.Ints(ports)
+	return ports, nil
+}
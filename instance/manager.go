@@ -18,6 +18,7 @@
 package instance
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -25,7 +26,9 @@ import (
 
 	"github.com/percona/go-mysql/dsn"
 	"github.com/percona/pmm/proto"
+	"github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/mrms"
+	"github.com/percona/qan-agent/mrms/checker"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 )
@@ -42,14 +45,22 @@ func (e ErrInstanceNotFound) Error() string {
 	return string(e)
 }
 
+// An InstanceChecker checks whether an instance can be monitored at all,
+// without a config. It's set by the analyzer of the subsystem, see
+// Manager.SetInstanceChecker.
+type InstanceChecker interface {
+	CheckInstance(in proto.Instance) (qan.InstanceCheck, error)
+}
+
 type Manager struct {
 	logger *pct.Logger
 	api    pct.APIConnector
 	// --
 	status      *pct.Status
 	repo        *Repo
-	restartChan chan proto.Instance
+	restartChan chan mrms.Change
 	stopChan    chan struct{}
+	checkers    map[string]InstanceChecker // keyed on subsystem
 }
 
 func NewManager(logger *pct.Logger, instanceDir string, api pct.APIConnector, monitor mrms.Monitor) *Manager {
@@ -63,10 +74,17 @@ func NewManager(logger *pct.Logger, instanceDir string, api pct.APIConnector, mo
 		repo:        repo,
 		restartChan: monitor.Add(proto.Instance{}),
 		stopChan:    make(chan struct{}),
+		checkers:    map[string]InstanceChecker{},
 	}
 	return m
 }
 
+// SetInstanceChecker sets the checker of instances of the subsystem for the
+// CheckInstance command. It must be called before Start.
+func (m *Manager) SetInstanceChecker(subsystem string, checker InstanceChecker) {
+	m.checkers[subsystem] = checker
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Interface
 /////////////////////////////////////////////////////////////////////////////
@@ -117,6 +135,27 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		}
 		err := GetMySQLInfo(&in)
 		return cmd.Reply(in, err)
+	case "CheckInstance":
+		// Check without a QAN config, i.e. whether QAN can run at all.
+		var in proto.Instance
+		if err := json.Unmarshal(cmd.Data, &in); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		checker, ok := m.checkers[in.Subsystem]
+		if !ok {
+			return cmd.Reply(nil, ErrCmdNotSupport)
+		}
+		check, err := checker.CheckInstance(in)
+		return cmd.Reply(check, err)
+	case "DiscoverInstances":
+		config := proto.DiscoverConfig{}
+		if len(cmd.Data) > 0 {
+			if err := json.Unmarshal(cmd.Data, &config); err != nil {
+				return cmd.Reply(nil, err)
+			}
+		}
+		found, err := m.DiscoverInstances(config)
+		return cmd.Reply(found, err)
 	}
 	return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
 }
@@ -134,18 +173,31 @@ func (m *Manager) Repo() *Repo {
 }
 
 func GetMySQLInfo(in *proto.Instance) error {
-	conn := mysql.NewConnection(in.DSN)
-	if err := conn.Connect(); err != nil {
+	return getMySQLInfo(context.Background(), in)
+}
+
+// getMySQLInfo is GetMySQLInfo which gives up, and closes its connection,
+// when ctx is done.
+func getMySQLInfo(ctx context.Context, in *proto.Instance) error {
+	dsnString, err := mysql.InstanceDSN(*in)
+	if err != nil {
 		return err
 	}
-	defer conn.Close()
+	db, err := mysql.Open(dsnString)
+	if err != nil {
+		return fmt.Errorf("Cannot connect to MySQL %s: %s", dsn.HidePassword(dsnString), mysql.FormatError(err))
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("Cannot connect to MySQL %s: %s", dsn.HidePassword(dsnString), mysql.FormatError(err))
+	}
 	sql := "SELECT /* percona-qan-agent */" +
 		" CONCAT_WS('.', @@hostname, IF(@@port='3306',NULL,@@port)) AS Hostname," +
 		" @@version_comment AS Distro," +
 		" @@version AS Version"
 	// Need auxiliary vars because can't get map attribute addresses
 	var hostname, distro, version string
-	if err := conn.DB().QueryRow(sql).Scan(&hostname, &distro, &version); err != nil {
+	if err := db.QueryRowContext(ctx, sql).Scan(&hostname, &distro, &version); err != nil {
 		return err
 	}
 	in.Distro = distro
@@ -173,7 +225,11 @@ func (m *Manager) monitor() {
 	for {
 		m.status.Update("instance-mrms", "Idle")
 		select {
-		case in := <-m.restartChan:
+		case change := <-m.restartChan:
+			if change.Instance.Subsystem != "mysql" || change.Reason != checker.ReasonRestart {
+				continue // only a MySQL restart can change MySQL info
+			}
+			in := change.Instance
 			safeDSN := dsn.HidePassword(in.DSN)
 			m.logger.Debug("mrms:restart:" + fmt.Sprintf("%s:%s", in.UUID, safeDSN))
 			m.status.Update("instance-mrms", "Getting info "+safeDSN)
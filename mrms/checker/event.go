@@ -0,0 +1,67 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"fmt"
+)
+
+// ReasonRestart is the reason to reconfigure a server which restarted, see
+// Event.String().
+const ReasonRestart = "restarted"
+
+// Types of Event.
+const (
+	EventRestart   = "restart"   // Old and New are the server uptimes, in seconds
+	EventDrift     = "drift"     // Name is the variable which QAN depends on
+	EventRole      = "role"      // Old and New are mysql.Role.String()
+	EventProfiling = "profiling" // Name is the database, Old and New are profiling levels
+	EventHealth    = "health"    // Name is the HealthCheck, New its value, Limit its threshold, Over if New is over Limit
+)
+
+// An Event is something a checker detected which requires QAN to reconfigure
+// the instance, or, if it's an EventHealth, which makes QAN data misleading.
+type Event struct {
+	Type  string
+	Name  string `json:",omitempty"`
+	Old   string `json:",omitempty"`
+	New   string `json:",omitempty"`
+	Limit string `json:",omitempty"`
+	Over  bool   `json:",omitempty"`
+}
+
+// String returns the event as a reason to reconfigure, e.g. ReasonRestart or
+// "slow_query_log changed from '1' to '0'".
+func (e Event) String() string {
+	switch e.Type {
+	case EventRestart:
+		return ReasonRestart
+	case EventDrift:
+		return fmt.Sprintf("%s changed from '%s' to '%s'", e.Name, e.Old, e.New)
+	case EventRole:
+		return fmt.Sprintf("role changed from '%s' to '%s'", e.Old, e.New)
+	case EventProfiling:
+		return fmt.Sprintf("profiling disabled on %s", e.Name)
+	case EventHealth:
+		if e.Over {
+			return fmt.Sprintf("%s is %s, over %s", e.Name, e.New, e.Limit)
+		}
+		return fmt.Sprintf("%s is %s, back under %s", e.Name, e.New, e.Limit)
+	}
+	return fmt.Sprintf("%s %s changed from '%s' to '%s'", e.Type, e.Name, e.Old, e.New)
+}
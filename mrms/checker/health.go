@@ -0,0 +1,252 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/percona/qan-agent/mysql"
+	"golang.org/x/sys/unix"
+)
+
+// A HealthCheck measures something which makes QAN data misleading when it's
+// over a threshold, like replica lag. Thresholds are set per instance in
+// proto.Instance.Health, keyed on the check name. Checks without a threshold
+// don't run.
+type HealthCheck interface {
+	// Name returns the key of the check in proto.Instance.Health.
+	Name() string
+	// Value returns the current value of the server conn is connected to.
+	Value(conn mysql.Connector) (float64, error)
+}
+
+var healthChecks = map[string]HealthCheck{}
+
+// RegisterHealthCheck makes the check available to all MySQL checkers. It
+// replaces the check of the same name, if any.
+func RegisterHealthCheck(check HealthCheck) {
+	healthChecks[check.Name()] = check
+}
+
+func init() {
+	RegisterHealthCheck(longTransaction{})
+	RegisterHealthCheck(replicaLag{})
+	RegisterHealthCheck(threadsRunning{})
+	RegisterHealthCheck(slowLogDiskUsage{})
+}
+
+// checkHealth returns an EventHealth for every check which went over its
+// threshold, or back under it, since the last check. A check which is over
+// its threshold on the first check is reported, too.
+func (m *MySQL) checkHealth() []Event {
+	m.healthMux.Lock()
+	health := m.health
+	m.healthMux.Unlock()
+
+	// Forget the state of checks which no longer run, so if a threshold
+	// is set again, the check is reported like on the first check.
+	for name := range m.healthOver {
+		if _, ok := health[name]; !ok {
+			delete(m.healthOver, name)
+		}
+	}
+
+	names := make([]string, 0, len(health))
+	for name := range health {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	events := []Event{}
+	for _, name := range names {
+		check, ok := healthChecks[name]
+		if !ok {
+			m.logger.Warn("unknown health check:", name)
+			continue
+		}
+		value, err := check.Value(m.mysqlConn)
+		if err != nil {
+			m.logger.Warn(fmt.Sprintf("cannot check %s: %s", name, err))
+			continue
+		}
+		limit := health[name]
+		over := value > limit
+		if over == m.healthOver[name] {
+			continue
+		}
+		m.healthOver[name] = over
+		events = append(events, Event{
+			Type:  EventHealth,
+			Name:  name,
+			New:   formatValue(value),
+			Limit: formatValue(limit),
+			Over:  over,
+		})
+	}
+	return events
+}
+
+// longTransaction is the age of the oldest InnoDB transaction, in seconds.
+// Its undo log grows, and queries get slower, until it ends.
+type longTransaction struct{}
+
+func (longTransaction) Name() string {
+	return "long_transaction"
+}
+
+func (longTransaction) Value(conn mysql.Connector) (float64, error) {
+	db := conn.DB()
+	if db == nil {
+		return 0, mysql.ErrNotConnected
+	}
+	var age float64
+	err := db.QueryRow("SELECT COALESCE(MAX(TIMESTAMPDIFF(SECOND, trx_started, NOW())), 0)" +
+		" FROM information_schema.INNODB_TRX").Scan(&age)
+	return age, err
+}
+
+// replicaLag is the Seconds_Behind_Master of a replica, 0 if the server isn't
+// a replica. It's an error if replication isn't running.
+type replicaLag struct{}
+
+func (replicaLag) Name() string {
+	return "replica_lag"
+}
+
+func (replicaLag) Value(conn mysql.Connector) (float64, error) {
+	db := conn.DB()
+	if db == nil {
+		return 0, mysql.ErrNotConnected
+	}
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		// MySQL 8.4 removed SHOW SLAVE STATUS.
+		var err2 error
+		if rows, err2 = db.Query("SHOW REPLICA STATUS"); err2 != nil {
+			return 0, err
+		}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	lagCol := -1
+	for i, col := range columns {
+		if col == "Seconds_Behind_Master" || col == "Seconds_Behind_Source" {
+			lagCol = i
+		}
+	}
+	if lagCol < 0 {
+		return 0, fmt.Errorf("no Seconds_Behind_Master in replica status")
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	// The lag of the most lagging channel.
+	lag := 0.0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return 0, err
+		}
+		if values[lagCol] == nil {
+			return 0, fmt.Errorf("replication is not running")
+		}
+		channelLag, err := strconv.ParseFloat(string(values[lagCol]), 64)
+		if err != nil {
+			return 0, err
+		}
+		if channelLag > lag {
+			lag = channelLag
+		}
+	}
+	return lag, rows.Err()
+}
+
+// threadsRunning is the Threads_running status variable. Spikes usually mean
+// queries are stuck waiting on locks, and so are slower than usual.
+type threadsRunning struct{}
+
+func (threadsRunning) Name() string {
+	return "threads_running"
+}
+
+func (threadsRunning) Value(conn mysql.Connector) (float64, error) {
+	db := conn.DB()
+	if db == nil {
+		return 0, mysql.ErrNotConnected
+	}
+	var name string
+	var value float64
+	err := db.QueryRow("SHOW GLOBAL STATUS LIKE 'Threads_running'").Scan(&name, &value)
+	return value, err
+}
+
+// slowLogDiskUsage is the percentage of the filesystem of the slow log which
+// is used. When it's full, MySQL stops writing the slow log. The agent must
+// run on the MySQL host, like for collecting from the slow log.
+type slowLogDiskUsage struct{}
+
+func (slowLogDiskUsage) Name() string {
+	return "slow_log_disk_usage"
+}
+
+func (slowLogDiskUsage) Value(conn mysql.Connector) (float64, error) {
+	file, err := conn.GetGlobalVarString("slow_query_log_file")
+	if err != nil {
+		return 0, err
+	}
+	path := file.String
+	if path == "" {
+		return 0, fmt.Errorf("slow_query_log_file is not set")
+	}
+	if !filepath.IsAbs(path) {
+		dataDir, err := conn.GetGlobalVarString("datadir")
+		if err != nil {
+			return 0, err
+		}
+		path = filepath.Join(dataDir.String, path)
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(filepath.Dir(path), &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, nil
+	}
+	// Like df, blocks reserved for root count as used.
+	used := st.Blocks - st.Bfree
+	return 100 * float64(used) / float64(used+st.Bavail), nil
+}
+
+// formatValue formats a health check value without useless decimals, e.g.
+// 60 not 60.000000.
+func formatValue(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
@@ -0,0 +1,85 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHealthCheck struct {
+	value float64
+}
+
+func (c *fakeHealthCheck) Name() string {
+	return "fake"
+}
+
+func (c *fakeHealthCheck) Value(conn mysql.Connector) (float64, error) {
+	return c.value, nil
+}
+
+func TestCheckHealth(t *testing.T) {
+	fake := &fakeHealthCheck{value: 150}
+	RegisterHealthCheck(fake)
+	defer delete(healthChecks, fake.Name())
+
+	logger := pct.NewLogger(make(chan proto.LogEntry, 100), "health-test")
+	m := NewMySQL(logger, nil)
+	m.SetHealth(map[string]float64{"fake": 100, "unknown": 1})
+
+	// Over the threshold on the first check.
+	events := m.checkHealth()
+	assert.Equal(t, []Event{{Type: EventHealth, Name: "fake", New: "150", Limit: "100", Over: true}}, events)
+	assert.Equal(t, "fake is 150, over 100", events[0].String())
+
+	// Still over, nothing new.
+	fake.value = 120
+	assert.Empty(t, m.checkHealth())
+
+	fake.value = 99.5
+	events = m.checkHealth()
+	assert.Equal(t, []Event{{Type: EventHealth, Name: "fake", New: "99.5", Limit: "100"}}, events)
+	assert.Equal(t, "fake is 99.5, back under 100", events[0].String())
+	assert.Empty(t, m.checkHealth())
+
+	// Over by less than the rounding of the value.
+	fake.value = 100.001
+	events = m.checkHealth()
+	assert.Equal(t, []Event{{Type: EventHealth, Name: "fake", New: "100", Limit: "100", Over: true}}, events)
+	assert.Equal(t, "fake is 100, over 100", events[0].String())
+
+	// Thresholds changed on a running instance apply to the next check.
+	m.SetHealth(map[string]float64{"fake": 200})
+	events = m.checkHealth()
+	assert.Equal(t, []Event{{Type: EventHealth, Name: "fake", New: "100", Limit: "200"}}, events)
+
+	// A check which is removed while over its threshold is forgotten, so
+	// it's reported again when it's set again.
+	m.SetHealth(map[string]float64{"fake": 50})
+	assert.Len(t, m.checkHealth(), 1)
+	m.SetHealth(map[string]float64{})
+	assert.Empty(t, m.checkHealth())
+	assert.Empty(t, m.healthOver)
+	m.SetHealth(map[string]float64{"fake": 50})
+	assert.Equal(t, []Event{{Type: EventHealth, Name: "fake", New: "100", Limit: "50", Over: true}}, m.checkHealth())
+}
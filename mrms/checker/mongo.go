@@ -0,0 +1,143 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/percona/pmgo"
+	"github.com/percona/qan-agent/pct"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// MongoDialTimeout is how long Check waits to connect to mongod.
+const MongoDialTimeout = 5 * time.Second
+
+type Mongo struct {
+	logger   *pct.Logger
+	dialInfo *pmgo.DialInfo
+	dialer   pmgo.Dialer
+	// --
+	uptime        *uptime
+	lastProfiling map[string]int // profiling level by database, nil until taken after init or a change
+}
+
+// NewMongo returns a checker which detects mongod restarts and databases on
+// which profiling was disabled, e.g. db.setProfilingLevel(0). Either way the
+// Mongo analyzer must re-enable profiling and restart its collectors.
+func NewMongo(logger *pct.Logger, dialInfo *pmgo.DialInfo, dialer pmgo.Dialer) *Mongo {
+	m := &Mongo{
+		logger:   logger,
+		dialInfo: dialInfo,
+		dialer:   dialer,
+		// --
+		uptime: &uptime{logger: logger},
+	}
+	return m
+}
+
+// Check returns why profiling must be re-enabled, or no events if it need not
+// be: an EventRestart if mongod restarted, else on which databases profiling
+// was disabled since the last check.
+func (m *Mongo) Check() ([]Event, error) {
+	dialInfo := *m.dialInfo
+	dialInfo.Timeout = MongoDialTimeout
+	// Disable automatic replicaSet detection, connect directly to specified server
+	dialInfo.Direct = true
+	session, err := m.dialer.DialWithInfo(&dialInfo)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+	session.SetMode(mgo.Eventual, true)
+
+	status := struct {
+		Uptime float64 `bson:"uptime"`
+	}{}
+	if err := session.DB("admin").Run(bson.M{"serverStatus": 1}, &status); err != nil {
+		return nil, err
+	}
+	currentUptime := int64(status.Uptime)
+	lastUptime := m.uptime.lastUptime
+	if m.uptime.restarted(currentUptime) {
+		// The analyzer re-enables profiling after a restart, so take the
+		// profiling levels again on the next check, after that.
+		m.lastProfiling = nil
+		return []Event{m.uptime.event(lastUptime, currentUptime)}, nil
+	}
+
+	profiling, err := GetProfilingLevels(session)
+	if err != nil {
+		return nil, err
+	}
+	return m.checkProfiling(profiling), nil
+}
+
+func (m *Mongo) checkProfiling(profiling map[string]int) []Event {
+	lastProfiling := m.lastProfiling
+	m.lastProfiling = profiling
+	if lastProfiling == nil {
+		return nil
+	}
+
+	disabled := []string{}
+	for db, level := range profiling {
+		if level == 0 && lastProfiling[db] > 0 {
+			disabled = append(disabled, db)
+		}
+	}
+	if len(disabled) == 0 {
+		return nil
+	}
+
+	m.lastProfiling = nil
+	sort.Strings(disabled)
+	events := make([]Event, len(disabled))
+	for i, db := range disabled {
+		events[i] = Event{
+			Type: EventProfiling,
+			Name: db,
+			Old:  fmt.Sprintf("%d", lastProfiling[db]),
+			New:  "0",
+		}
+	}
+	return events
+}
+
+// GetProfilingLevels returns the profiling level ("profile: -1") of every
+// database.
+func GetProfilingLevels(session pmgo.SessionManager) (map[string]int, error) {
+	dbNames, err := session.DatabaseNames()
+	if err != nil {
+		return nil, err
+	}
+	levels := map[string]int{}
+	for _, dbName := range dbNames {
+		result := struct {
+			Was int
+		}{}
+		if err := session.DB(dbName).Run(bson.M{"profile": -1}, &result); err != nil {
+			return nil, err
+		}
+		levels[dbName] = result.Was
+	}
+	return levels, nil
+}
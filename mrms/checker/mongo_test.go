@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckProfiling(t *testing.T) {
+	m := NewMongo(nil, nil, nil)
+
+	// First check only takes the baseline.
+	assert.Empty(t, m.checkProfiling(map[string]int{"db1": 1, "db2": 2, "db3": 0}))
+	assert.Empty(t, m.checkProfiling(map[string]int{"db1": 1, "db2": 2, "db3": 0}))
+
+	// Enabling profiling or adding databases is not reported.
+	assert.Empty(t, m.checkProfiling(map[string]int{"db1": 1, "db2": 2, "db3": 1, "db4": 0}))
+
+	events := m.checkProfiling(map[string]int{"db1": 0, "db2": 2, "db3": 0, "db4": 0})
+	assert.Equal(t, []Event{
+		{Type: EventProfiling, Name: "db1", Old: "1", New: "0"},
+		{Type: EventProfiling, Name: "db3", Old: "1", New: "0"},
+	}, events)
+	assert.Equal(t, "profiling disabled on db1", events[0].String())
+
+	// After a change the baseline is taken again, so the change is
+	// reported only once even if profiling stays disabled.
+	assert.Empty(t, m.checkProfiling(map[string]int{"db1": 0, "db2": 2, "db3": 0}))
+	assert.Empty(t, m.checkProfiling(map[string]int{"db1": 0, "db2": 2, "db3": 0}))
+}
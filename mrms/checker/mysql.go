@@ -19,83 +19,188 @@ package checker
 
 import (
 	"fmt"
-	"time"
+	"sort"
+	"sync"
 
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 )
 
+// DriftConsumers is the name of drift events of the enabled
+// performance_schema consumers, which aren't a variable.
+const DriftConsumers = "performance_schema consumers"
+
+// DriftVars are the global variables which QAN depends on, keyed on the
+// source it collects from (pc.QAN.CollectFrom). If one of them changes,
+// QAN collecting from that source must re-apply its config.
+var DriftVars = map[string][]string{
+	"slowlog": {
+		"slow_query_log",
+		"log_output",
+		"slow_query_log_file",
+	},
+	"perfschema": {
+		"performance_schema",
+		DriftConsumers,
+	},
+}
+
+// IsDrift returns true if the event is a drift of config which QAN
+// collecting from collectFrom depends on.
+func IsDrift(collectFrom string, e Event) bool {
+	if e.Type != EventDrift {
+		return false
+	}
+	for _, name := range DriftVars[collectFrom] {
+		if name == e.Name {
+			return true
+		}
+	}
+	return false
+}
+
 type MySQL struct {
 	logger    *pct.Logger
 	mysqlConn mysql.Connector
 	// --
-	lastUptime      int64
-	lastUptimeCheck time.Time
+	uptime     *uptime
+	lastConfig map[string]string  // nil until taken after init or a restart
+	lastRole   string             // mysql.Role.String(), empty until taken after init or a restart
+	health     map[string]float64 // thresholds keyed on HealthCheck name
+	healthOver map[string]bool    // health checks over their threshold
+	healthMux  *sync.Mutex        // guards health, set while checking
 }
 
 func NewMySQL(logger *pct.Logger, mysqlConn mysql.Connector) *MySQL {
 	m := &MySQL{
 		logger:    logger,
 		mysqlConn: mysqlConn,
+		// --
+		uptime:     &uptime{logger: logger},
+		health:     map[string]float64{},
+		healthOver: map[string]bool{},
+		healthMux:  &sync.Mutex{},
 	}
 	return m
 }
 
-func (m *MySQL) Check() (bool, error) {
+// SetHealth sets the thresholds of the health checks to run, usually
+// proto.Instance.Health. They apply from the next check.
+func (m *MySQL) SetHealth(health map[string]float64) {
+	m.healthMux.Lock()
+	defer m.healthMux.Unlock()
+	m.health = health
+}
+
+// Check returns why MySQL must be reconfigured, or no events if it need not
+// be: an EventRestart if it restarted, else which config QAN depends on or
+// which replication role changed since the last check. EventHealth events
+// are returned, too, but QAN needn't be reconfigured for them.
+func (m *MySQL) Check() ([]Event, error) {
 	if err := m.mysqlConn.Connect(); err != nil {
-		return false, err
+		return nil, err
 	}
 	defer m.mysqlConn.Close()
 
-	if m.lastUptime == 0 {
-		// First check, just init and return.
-		var err error
-		m.lastUptime, err = m.mysqlConn.Uptime()
-		if err != nil {
-			return false, err // shouldn't happen because we just opened the connection
+	currentUptime, err := m.mysqlConn.Uptime()
+	if err != nil {
+		return nil, err
+	}
+	lastUptime := m.uptime.lastUptime
+	if m.uptime.restarted(currentUptime) {
+		// The config is reset by a restart, so take it again on the next check.
+		m.lastConfig = nil
+		m.lastRole = ""
+		return []Event{m.uptime.event(lastUptime, currentUptime)}, nil
+	}
+
+	events := m.checkConfig()
+	if event := m.checkRole(); event != nil {
+		events = append(events, *event)
+	}
+	events = append(events, m.checkHealth()...)
+	return events, nil
+}
+
+// checkConfig returns the changes to the config since the last check, e.g.
+// slow_query_log changed from 1 to 0. The config of every source is checked
+// because the instance can have listeners collecting from each; they use
+// IsDrift to ignore the others. Variables which can't be read are ignored,
+// like performance_schema consumers if performance_schema is off.
+func (m *MySQL) checkConfig() []Event {
+	config := map[string]string{}
+	for _, names := range DriftVars {
+		for _, name := range names {
+			if name == DriftConsumers {
+				continue
+			}
+			v, err := m.mysqlConn.GetGlobalVarString(name)
+			if err != nil {
+				m.logger.Debug(fmt.Sprintf("cannot read %s: %s", name, err))
+				continue
+			}
+			config[name] = v.String
 		}
-		m.lastUptimeCheck = time.Now()
-		m.logger.Debug(fmt.Sprintf("init uptime=%d", m.lastUptime))
-		return false, nil
+	}
+	if consumers, err := getConsumers(m.mysqlConn); err != nil {
+		m.logger.Debug("cannot read performance_schema consumers:", err)
+	} else {
+		config[DriftConsumers] = consumers
 	}
 
-	lastUptime := m.lastUptime
-	lastUptimeCheck := m.lastUptimeCheck
-	currentUptime, err := m.mysqlConn.Uptime()
+	lastConfig := m.lastConfig
+	m.lastConfig = config
+	if lastConfig == nil {
+		return nil
+	}
+
+	names := []string{}
+	for name, value := range config {
+		lastValue, ok := lastConfig[name]
+		if ok && value != lastValue {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	sort.Strings(names)
+	events := make([]Event, len(names))
+	for i, name := range names {
+		events[i] = Event{Type: EventDrift, Name: name, Old: lastConfig[name], New: config[name]}
+	}
+	return events
+}
+
+// checkRole returns the change to the replication role since the last check,
+// e.g. from "replica, read_only" to "source" after a failover, or nil.
+func (m *MySQL) checkRole() *Event {
+	r, err := mysql.GetRole(m.mysqlConn)
 	if err != nil {
-		return false, err
-	}
-
-	m.logger.Debug(fmt.Sprintf("lastUptime=%d lastUptimeCheck=%s currentUptime=%d",
-		lastUptime, lastUptimeCheck.UTC(), currentUptime))
-
-	// Calculate expected uptime
-	//   This protects against situation where after restarting MySQL
-	//   we are unable to connect to it for period longer than last registered uptime
-	//
-	// Steps to reproduce:
-	// * currentUptime=60 lastUptime=0
-	// * Restart MySQL
-	// * QAN connection problem for 120s
-	// * currentUptime=120 lastUptime=60 (new uptime (120s) is higher than last registered (60s))
-	// * elapsedTime=120s (time elapsed since last check)
-	// * expectedUptime= 60s + 120s = 180s
-	// * 120s < 180s (currentUptime < expectedUptime) => server was restarted
-	elapsedTime := time.Now().Unix() - lastUptimeCheck.Unix()
-	expectedUptime := lastUptime + elapsedTime
-	m.logger.Debug(fmt.Sprintf("elapsedTime=%d expectedUptime=%d", elapsedTime, expectedUptime))
-
-	// Save uptime from last check
-	m.lastUptime = currentUptime
-	m.lastUptimeCheck = time.Now()
-
-	// If current server uptime is lower than last registered uptime
-	// then we can assume that server was restarted
-	if currentUptime < expectedUptime {
-		return true, nil
-	}
-
-	return false, nil
+		m.logger.Debug("cannot read replication role:", err)
+		return nil
+	}
+	role := r.String()
+
+	lastRole := m.lastRole
+	m.lastRole = role
+	if lastRole == "" || role == lastRole {
+		return nil
+	}
+	return &Event{Type: EventRole, Old: lastRole, New: role}
+}
+
+// getConsumers returns the enabled performance_schema consumers.
+func getConsumers(conn mysql.Connector) (string, error) {
+	db := conn.DB()
+	if db == nil {
+		return "", mysql.ErrNotConnected
+	}
+	var consumers string
+	err := db.QueryRow("SELECT COALESCE(GROUP_CONCAT(NAME ORDER BY NAME), '')" +
+		" FROM performance_schema.setup_consumers WHERE ENABLED = 'YES'").Scan(&consumers)
+	return consumers, err
 }
 
 func (m *MySQL) DSN() string {
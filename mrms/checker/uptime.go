@@ -0,0 +1,84 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package checker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/percona/qan-agent/pct"
+)
+
+// uptime detects server restarts from the server uptime.
+type uptime struct {
+	logger *pct.Logger
+	// --
+	lastUptime      int64
+	lastUptimeCheck time.Time
+}
+
+// restarted returns true if the server restarted since the last check. The
+// first check only saves the current uptime.
+func (u *uptime) restarted(currentUptime int64) bool {
+	if u.lastUptimeCheck.IsZero() {
+		// First check, just init and return.
+		u.lastUptime = currentUptime
+		u.lastUptimeCheck = time.Now()
+		u.logger.Debug(fmt.Sprintf("init uptime=%d", u.lastUptime))
+		return false
+	}
+
+	lastUptime := u.lastUptime
+	lastUptimeCheck := u.lastUptimeCheck
+
+	u.logger.Debug(fmt.Sprintf("lastUptime=%d lastUptimeCheck=%s currentUptime=%d",
+		lastUptime, lastUptimeCheck.UTC(), currentUptime))
+
+	// Calculate expected uptime
+	//   This protects against situation where after restarting MySQL
+	//   we are unable to connect to it for period longer than last registered uptime
+	//
+	// Steps to reproduce:
+	// * currentUptime=60 lastUptime=0
+	// * Restart MySQL
+	// * QAN connection problem for 120s
+	// * currentUptime=120 lastUptime=60 (new uptime (120s) is higher than last registered (60s))
+	// * elapsedTime=120s (time elapsed since last check)
+	// * expectedUptime= 60s + 120s = 180s
+	// * 120s < 180s (currentUptime < expectedUptime) => server was restarted
+	elapsedTime := time.Now().Unix() - lastUptimeCheck.Unix()
+	expectedUptime := lastUptime + elapsedTime
+	u.logger.Debug(fmt.Sprintf("elapsedTime=%d expectedUptime=%d", elapsedTime, expectedUptime))
+
+	// Save uptime from last check
+	u.lastUptime = currentUptime
+	u.lastUptimeCheck = time.Now()
+
+	// If current server uptime is lower than last registered uptime
+	// then we can assume that server was restarted
+	return currentUptime < expectedUptime
+}
+
+// event returns the EventRestart for the uptimes before and after a restart.
+func (u *uptime) event(lastUptime, currentUptime int64) Event {
+	return Event{
+		Type: EventRestart,
+		Old:  fmt.Sprintf("%d", lastUptime),
+		New:  fmt.Sprintf("%d", currentUptime),
+	}
+}
@@ -0,0 +1,144 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mrms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaxHistory is how many events are kept per instance.
+const MaxHistory = 100
+
+// EventError is the Type of an Event which records that checking an instance
+// failed (New is the error) or works again (Old is the last error). Other
+// types are the checker.Event types.
+const EventError = "error"
+
+// An Event is something MRMS detected on an instance: a restart, a change to
+// the config which QAN depends on or to the replication role, a health check
+// going over or back under its threshold, or a failed check.
+type Event struct {
+	Ts        time.Time // UTC
+	UUID      string
+	Subsystem string
+	Type      string // checker.EventRestart, etc. or EventError
+	Name      string `json:",omitempty"` // variable or database
+	Old       string `json:",omitempty"`
+	New       string `json:",omitempty"`
+	Limit     string `json:",omitempty"` // threshold of a health check
+	Over      bool   `json:",omitempty"` // health check went over Limit, else back under it
+}
+
+// History is the last MaxHistory events of every instance. If it has a file,
+// it's saved to it on every change, so it survives agent restarts.
+type History struct {
+	file string
+	max  int
+	// --
+	events map[string][]Event // keyed on instance UUID, oldest first
+	mux    *sync.Mutex
+}
+
+// NewHistory returns an empty History which keeps max events per instance and
+// saves them to file, or only keeps them in memory if file is empty.
+func NewHistory(file string, max int) *History {
+	h := &History{
+		file: file,
+		max:  max,
+		// --
+		events: map[string][]Event{},
+		mux:    &sync.Mutex{},
+	}
+	return h
+}
+
+// Load reads the events saved in the history file, if any. If the file
+// can't be read or is corrupt, the history starts empty and the error is
+// returned to be logged: it's overwritten by the next Add.
+func (h *History) Load() error {
+	if h.file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(h.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	events := map[string][]Event{}
+	if err := json.Unmarshal(data, &events); err != nil {
+		return fmt.Errorf("corrupt history file %s: %s", h.file, err)
+	}
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.events = events
+	return nil
+}
+
+// Add adds the events, dropping the oldest events of an instance if it has
+// more than max, and saves the history.
+func (h *History) Add(events ...Event) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	for _, e := range events {
+		instanceEvents := append(h.events[e.UUID], e)
+		if len(instanceEvents) > h.max {
+			instanceEvents = instanceEvents[len(instanceEvents)-h.max:]
+		}
+		h.events[e.UUID] = instanceEvents
+	}
+	if h.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(h.events)
+	if err != nil {
+		return err
+	}
+	// Write a temp file and rename it, so a crash mid-write doesn't
+	// leave a corrupt history.
+	tmpFile := h.file + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, h.file)
+}
+
+// Get returns the events of the instance, or of all instances if uuid is
+// empty, oldest first.
+func (h *History) Get(uuid string) []Event {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	events := []Event{}
+	if uuid != "" {
+		return append(events, h.events[uuid]...)
+	}
+	for _, instanceEvents := range h.events {
+		events = append(events, instanceEvents...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Ts.Before(events[j].Ts)
+	})
+	return events
+}
@@ -77,7 +77,14 @@ func (m *Manager) GetDefaults(uuid string) map[string]interface{} {
 }
 
 func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
-	return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+	switch cmd.Cmd {
+	case "GetHistory":
+		// Events of the instance, or of all instances if no UUID.
+		uuid := string(cmd.Data)
+		return cmd.Reply(m.monitor.GetHistory(uuid))
+	default:
+		return cmd.Reply(nil, pct.UnknownCmdError{Cmd: cmd.Cmd})
+	}
 }
 
 func (m *Manager) Status() (status map[string]string) {
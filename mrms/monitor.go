@@ -21,66 +21,100 @@ import (
 	"fmt"
 	"log"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/percona/go-mysql/dsn"
+	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/mrms/checker"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/pct/credential"
 )
 
 const MONITOR_NAME = "mrm-monitor"
 
 type Checker interface {
-	Check() (bool, error)
+	// Check returns why the instance must be reconfigured, or no events if
+	// it need not be.
+	Check() ([]checker.Event, error)
+}
+
+// A Change is sent to the listeners of an instance when it must be
+// reconfigured because it restarted (Reason is checker.ReasonRestart),
+// because the config that QAN depends on changed, or because its replication
+// role changed. Events are why, so listeners can ignore config they don't
+// depend on, see checker.IsDrift.
+type Change struct {
+	Instance proto.Instance
+	Reason   string
+	Events   []checker.Event
+}
+
+// healthSetter is a Checker which runs health checks, see checker.HealthCheck.
+type healthSetter interface {
+	SetHealth(map[string]float64)
 }
 
 type instance struct {
 	instance  proto.Instance
 	checker   Checker
-	listeners map[chan proto.Instance]bool
+	listeners map[chan Change]bool
+	lastErr   string // of the last check, recorded in the history when it changes
 }
 
 type Monitor interface {
 	Start(interval time.Duration) error
 	Stop() error
 	Status() map[string]string
-	Add(proto.Instance) chan proto.Instance
-	Remove(string, chan proto.Instance)
+	Add(proto.Instance) chan Change
+	Remove(string, chan Change)
 	ListenerCount(uuid string) uint
 	Check()
+	GetHistory(uuid string) []Event
 }
 
 type RealMonitor struct {
 	logger           *pct.Logger
 	mysqlConnFactory mysql.ConnectionFactory
+	mongoDialer      pmgo.Dialer
 	// --
 	instances map[string]*instance
 	sync.RWMutex
+	checkMux sync.Mutex // serializes Check
 	// --
-	status *pct.Status
-	sync   *pct.SyncChan
+	history *History
+	status  *pct.Status
+	sync    *pct.SyncChan
 }
 
 func NewRealMonitor(logger *pct.Logger, mysqlConnFactory mysql.ConnectionFactory) *RealMonitor {
 	instances := map[string]*instance{
 		"": {
-			listeners: map[chan proto.Instance]bool{},
+			listeners: map[chan Change]bool{},
 		},
 	}
 	m := &RealMonitor{
 		logger:           logger,
 		mysqlConnFactory: mysqlConnFactory,
+		mongoDialer:      credential.NewMongoDialer(pmgo.NewDialer()),
 		// --
 		instances: instances,
+		history:   NewHistory("", MaxHistory),
 		status:    pct.NewStatus([]string{MONITOR_NAME}),
 		sync:      pct.NewSyncChan(),
 	}
 	return m
 }
 
+// SetHistory sets the history in which events are recorded, usually one
+// saved to a file. It must be called before Start.
+func (m *RealMonitor) SetHistory(history *History) {
+	m.history = history
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Interface
 /////////////////////////////////////////////////////////////////////////////
@@ -104,7 +138,7 @@ func (m *RealMonitor) Status() map[string]string {
 	return m.status.All()
 }
 
-func (m *RealMonitor) Add(in proto.Instance) chan proto.Instance {
+func (m *RealMonitor) Add(in proto.Instance) chan Change {
 	m.logger.Debug("Add:call:" + dsn.HidePassword(in.DSN))
 	defer m.logger.Debug("Add:return:" + dsn.HidePassword(in.DSN))
 
@@ -114,19 +148,19 @@ func (m *RealMonitor) Add(in proto.Instance) chan proto.Instance {
 	i, ok := m.instances[in.UUID]
 	if !ok {
 		m.logger.Debug("add:" + in.Subsystem + "-" + in.UUID)
-		c := checker.NewMySQL(
-			pct.NewLogger(m.logger.LogChan(), "mrms-check-mysql-"+in.Name),
-			m.mysqlConnFactory.Make(in.DSN),
-		)
+		c := m.newChecker(in)
 		i = &instance{
 			instance:  in,
 			checker:   c,
-			listeners: map[chan proto.Instance]bool{},
+			listeners: map[chan Change]bool{},
 		}
 		m.instances[in.UUID] = i
+	} else if c, ok := i.checker.(healthSetter); ok {
+		// Health thresholds can change while the instance is checked.
+		c.SetHealth(in.Health)
 	}
 
-	restartChan := make(chan proto.Instance, 1)
+	restartChan := make(chan Change, 1)
 	if in.UUID != "" {
 		i.listeners[restartChan] = true
 	} else {
@@ -136,7 +170,7 @@ func (m *RealMonitor) Add(in proto.Instance) chan proto.Instance {
 	return restartChan
 }
 
-func (m *RealMonitor) Remove(uuid string, c chan proto.Instance) {
+func (m *RealMonitor) Remove(uuid string, c chan Change) {
 	m.logger.Debug("Remove:call:" + uuid)
 	defer m.logger.Debug("Remove:return:" + uuid)
 
@@ -160,39 +194,67 @@ func (m *RealMonitor) Check() {
 	m.logger.Debug("Check:call")
 	defer m.logger.Debug("Check:return")
 
+	// Checkers keep what they saw last, so only one Check runs at a time.
+	m.checkMux.Lock()
+	defer m.checkMux.Unlock()
+
 	m.status.Update(MONITOR_NAME, "Checking")
 
+	// Checks are network round trips, so they're done without holding
+	// the lock which Add and Remove need.
 	m.RLock()
-	defer m.RUnlock()
-
+	instances := make([]*instance, 0, len(m.instances))
 	for uuid, in := range m.instances {
 		if uuid == "" {
 			continue // global
 		}
-		m.logger.Debug("check:" + uuid)
-		restarted, err := in.checker.Check()
+		if in.checker == nil {
+			continue // see newChecker
+		}
+		instances = append(instances, in)
+	}
+	m.RUnlock()
+
+	for _, in := range instances {
+		m.logger.Debug("check:" + in.instance.UUID)
+		events, err := in.checker.Check()
 		if err != nil {
 			m.logger.Warn(err)
+			if _, changed := m.swapLastErr(in, err.Error()); changed {
+				m.record(in, checker.Event{Type: EventError, New: err.Error()})
+			}
 			continue
 		}
-		if !restarted {
+		if lastErr, changed := m.swapLastErr(in, ""); changed {
+			m.record(in, checker.Event{Type: EventError, Old: lastErr})
+		}
+		if len(events) == 0 {
 			continue
 		}
-		m.logger.Info(fmt.Sprintf("%s instance %s restarted", in.instance.Subsystem, in.instance.UUID))
-		for c := range in.listeners { // only this instance
-			select {
-			case c <- in.instance:
-			default:
-				m.logger.Warn("Listener not ready")
+		m.record(in, events...)
+
+		// Health events are only recorded, to annotate QAN reports. The
+		// others are why listeners must reconfigure the instance.
+		reasons := []string{}
+		changes := []checker.Event{}
+		for _, e := range events {
+			if e.Type == checker.EventHealth {
+				m.logger.Info(fmt.Sprintf("%s instance %s: %s", in.instance.Subsystem, in.instance.UUID, e))
+				continue
 			}
+			reasons = append(reasons, e.String())
+			changes = append(changes, e)
 		}
-		for c := range m.instances[""].listeners { // global
-			select {
-			case c <- in.instance:
-			default:
-				m.logger.Warn("Global listener not ready")
-			}
+		if len(reasons) == 0 {
+			continue
 		}
+		reason := strings.Join(reasons, ", ")
+		m.logger.Info(fmt.Sprintf("%s instance %s: %s", in.instance.Subsystem, in.instance.UUID, reason))
+		m.notify(in, Change{
+			Instance: in.instance,
+			Reason:   reason,
+			Events:   changes,
+		})
 	}
 }
 
@@ -208,10 +270,94 @@ func (m *RealMonitor) ListenerCount(uuid string) uint {
 	return uint(len(i.listeners))
 }
 
+// GetHistory returns the events of the instance, or of all instances if uuid
+// is empty, oldest first.
+func (m *RealMonitor) GetHistory(uuid string) []Event {
+	return m.history.Get(uuid)
+}
+
 /////////////////////////////////////////////////////////////////////////////
 // Implementation
 /////////////////////////////////////////////////////////////////////////////
 
+// swapLastErr sets the error of the last check of the instance, returning
+// the previous one and whether it changed.
+func (m *RealMonitor) swapLastErr(in *instance, lastErr string) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+	prev := in.lastErr
+	in.lastErr = lastErr
+	return prev, prev != lastErr
+}
+
+// notify sends the change to the listeners of the instance and the global
+// listeners.
+func (m *RealMonitor) notify(in *instance, change Change) {
+	m.RLock()
+	defer m.RUnlock()
+	for c := range in.listeners { // only this instance
+		select {
+		case c <- change:
+		default:
+			m.logger.Warn("Listener not ready")
+		}
+	}
+	for c := range m.instances[""].listeners { // global
+		select {
+		case c <- change:
+		default:
+			m.logger.Warn("Global listener not ready")
+		}
+	}
+}
+
+// record adds the events detected on the instance to the history.
+func (m *RealMonitor) record(in *instance, events ...checker.Event) {
+	now := time.Now().UTC()
+	history := make([]Event, len(events))
+	for i, e := range events {
+		history[i] = Event{
+			Ts:        now,
+			UUID:      in.instance.UUID,
+			Subsystem: in.instance.Subsystem,
+			Type:      e.Type,
+			Name:      e.Name,
+			Old:       e.Old,
+			New:       e.New,
+			Limit:     e.Limit,
+			Over:      e.Over,
+		}
+	}
+	if err := m.history.Add(history...); err != nil {
+		m.logger.Warn("Cannot save history:", err)
+	}
+}
+
+// newChecker returns the checker for the instance subsystem, or nil if the
+// instance can't be checked.
+func (m *RealMonitor) newChecker(in proto.Instance) Checker {
+	logger := pct.NewLogger(m.logger.LogChan(), "mrms-check-"+in.Subsystem+"-"+in.Name)
+	switch in.Subsystem {
+	case "mongo":
+		dialInfo, err := pmgo.ParseURL(in.DSN)
+		if err != nil {
+			m.logger.Warn(fmt.Sprintf("Cannot check %s: %s", in.Name, err))
+			return nil
+		}
+		return checker.NewMongo(logger, dialInfo, m.mongoDialer)
+	default:
+		instanceDSN, err := mysql.InstanceDSN(in)
+		if err != nil {
+			// Checks will fail if TLS is required, which is logged, too.
+			m.logger.Warn(err)
+			instanceDSN = in.DSN
+		}
+		c := checker.NewMySQL(logger, m.mysqlConnFactory.Make(instanceDSN))
+		c.SetHealth(in.Health)
+		return c
+	}
+}
+
 func (m *RealMonitor) run(interval time.Duration) {
 	m.logger.Debug("run:call")
 	defer m.logger.Debug("run:return")
@@ -18,12 +18,15 @@
 package mrms_test
 
 import (
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/mrms"
+	"github.com/percona/qan-agent/mrms/checker"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/test/mock"
@@ -83,12 +86,13 @@ func (s *TestSuite) TestStartStop(t *C) {
 	mockConn.SetUptime(5)
 
 	// After max 1 second it should notify listener about MySQL restart
-	var gotInstance proto.Instance
+	var gotChange mrms.Change
 	select {
-	case gotInstance = <-restartChan:
+	case gotChange = <-restartChan:
 	case <-time.After(1 * time.Second):
 	}
-	t.Check(gotInstance, DeepEquals, s.instance)
+	t.Check(gotChange.Instance, DeepEquals, s.instance)
+	t.Check(gotChange.Reason, Equals, checker.ReasonRestart)
 
 	// Stop the monitor.
 	err = m.Stop()
@@ -106,12 +110,137 @@ func (s *TestSuite) TestStartStop(t *C) {
 	// After stopping service it should not notify listeners anymore
 	time.Sleep(2 * time.Second)
 	select {
-	case gotInstance = <-restartChan:
+	case <-restartChan:
 		t.Error("Got restart after stopping monitor")
 	default:
 	}
 }
 
+func (s *TestSuite) TestConfigChange(t *C) {
+	mockConn := mock.NewNullMySQL()
+	mockConn.Reset()
+	mockConn.SetUptime(10)
+	mockConn.SetGlobalVarString("slow_query_log", "1")
+	mockConn.SetGlobalVarString("log_output", "FILE")
+	mockConnFactory := &mock.ConnectionFactory{
+		Conn: mockConn,
+	}
+	m := mrms.NewRealMonitor(s.logger, mockConnFactory)
+	restartChan := m.Add(s.instance)
+
+	m.Check() // init uptime and config
+	m.Check()
+	select {
+	case change := <-restartChan:
+		t.Fatalf("Got change without change: %+v", change)
+	default:
+	}
+
+	// Imitate SET GLOBAL slow_query_log=OFF.
+	mockConn.SetGlobalVarString("slow_query_log", "0")
+	m.Check()
+	var gotChange mrms.Change
+	select {
+	case gotChange = <-restartChan:
+	default:
+	}
+	t.Check(gotChange, DeepEquals, mrms.Change{
+		Instance: s.instance,
+		Reason:   "slow_query_log changed from '1' to '0'",
+		Events:   []checker.Event{{Type: checker.EventDrift, Name: "slow_query_log", Old: "1", New: "0"}},
+	})
+	t.Check(checker.IsDrift("slowlog", gotChange.Events[0]), Equals, true)
+	t.Check(checker.IsDrift("perfschema", gotChange.Events[0]), Equals, false)
+
+	// QAN re-applying its config is a change too; QAN ignores it because
+	// the new value is what it set.
+	mockConn.SetGlobalVarString("slow_query_log", "1")
+	m.Check()
+	gotChange = mrms.Change{}
+	select {
+	case gotChange = <-restartChan:
+	default:
+	}
+	t.Check(gotChange.Events, DeepEquals, []checker.Event{{Type: checker.EventDrift, Name: "slow_query_log", Old: "0", New: "1"}})
+	m.Check()
+	select {
+	case change := <-restartChan:
+		t.Errorf("Got change without change: %+v", change)
+	default:
+	}
+
+	// The changes are in the history.
+	history := m.GetHistory(s.instance.UUID)
+	t.Assert(history, HasLen, 2)
+	t.Check(history[0].Ts.IsZero(), Equals, false)
+	history[0].Ts = time.Time{}
+	t.Check(history[0], DeepEquals, mrms.Event{
+		UUID:      s.instance.UUID,
+		Subsystem: "mysql",
+		Type:      checker.EventDrift,
+		Name:      "slow_query_log",
+		Old:       "1",
+		New:       "0",
+	})
+
+	m.Remove(s.instance.UUID, restartChan)
+}
+
+func (s *TestSuite) TestHistory(t *C) {
+	tmpDir, err := ioutil.TempDir("", "mrms-test")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(tmpDir)
+	file := filepath.Join(tmpDir, "mrms-history.json")
+
+	h := mrms.NewHistory(file, 2)
+	t.Assert(h.Load(), IsNil) // no file yet
+	t0 := time.Now().UTC()
+	err = h.Add(
+		mrms.Event{Ts: t0, UUID: "1", Type: checker.EventRestart, Old: "100", New: "5"},
+		mrms.Event{Ts: t0.Add(time.Second), UUID: "2", Type: mrms.EventError, New: "connection refused"},
+		mrms.Event{Ts: t0.Add(2 * time.Second), UUID: "1", Type: checker.EventRole, Old: "replica", New: "source"},
+		mrms.Event{Ts: t0.Add(3 * time.Second), UUID: "1", Type: checker.EventDrift, Name: "log_output", Old: "FILE", New: "TABLE"},
+	)
+	t.Assert(err, IsNil)
+
+	// Only the last 2 events of instance 1 are kept.
+	got := h.Get("1")
+	t.Assert(got, HasLen, 2)
+	t.Check(got[0].Type, Equals, checker.EventRole)
+	t.Check(got[1].Type, Equals, checker.EventDrift)
+
+	// The history survives restarts.
+	h = mrms.NewHistory(file, 2)
+	t.Assert(h.Load(), IsNil)
+	got = h.Get("")
+	t.Assert(got, HasLen, 3)
+	t.Check(got[0].UUID, Equals, "2")
+	t.Check(got[1].Type, Equals, checker.EventRole)
+	t.Check(got[2].Ts.Equal(t0.Add(3*time.Second)), Equals, true)
+}
+
+func (s *TestSuite) TestHistoryCorrupt(t *C) {
+	tmpDir, err := ioutil.TempDir("", "mrms-test")
+	t.Assert(err, IsNil)
+	defer os.RemoveAll(tmpDir)
+	file := filepath.Join(tmpDir, "mrms-history.json")
+	t.Assert(ioutil.WriteFile(file, []byte(`{"1":[{"Ts":`), 0600), IsNil)
+
+	// A corrupt file is reported, and the history starts empty.
+	h := mrms.NewHistory(file, 2)
+	t.Check(h.Load(), NotNil)
+	t.Check(h.Get(""), HasLen, 0)
+
+	// The next event replaces it, without leaving the temp file.
+	err = h.Add(mrms.Event{Ts: time.Now().UTC(), UUID: "1", Type: checker.EventRestart})
+	t.Assert(err, IsNil)
+	h = mrms.NewHistory(file, 2)
+	t.Assert(h.Load(), IsNil)
+	t.Check(h.Get("1"), HasLen, 1)
+	_, err = os.Stat(file + ".tmp")
+	t.Check(os.IsNotExist(err), Equals, true)
+}
+
 /*
 func (s *TestSuite) TestRestart(t *C) {
 	mockConn := mock.NewNullMySQL()
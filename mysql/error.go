@@ -48,4 +48,5 @@ const (
 	ER_SPECIFIC_ACCESS_DENIED_ERROR = 1227
 	ER_SYNTAX_ERROR                 = 1064
 	ER_USER_DENIED                  = 1142
+	ER_UNKNOWN_TABLE                = 1109
 )
@@ -21,9 +21,17 @@ type ConnectionFactory interface {
 	Make(dsn string) Connector
 }
 
+// RealConnectionFactory makes Connections. If Pool is set, they're pooled:
+// every Connection it makes is a separate lease, but Connections to the same
+// DSN share one *sql.DB. Credential references in the DSN are resolved when
+// connecting (see Open).
 type RealConnectionFactory struct {
+	Pool *Pool
 }
 
 func (f *RealConnectionFactory) Make(dsn string) Connector {
-	return NewConnection(dsn)
+	if f.Pool == nil {
+		return NewConnection(dsn)
+	}
+	return NewPooledConnection(dsn, f.Pool)
 }
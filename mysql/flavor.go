@@ -0,0 +1,88 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"github.com/percona/qan-agent/pct"
+)
+
+// A Flavor is the distro and version of a MySQL server. Both are empty if
+// unknown, in which case Oracle MySQL semantics are assumed.
+type Flavor struct {
+	Distro  string // "MySQL", "Percona Server" or "MariaDB", see Distro()
+	Version string // @@version, e.g. 5.7.21-20-log or 10.2.14-MariaDB-log
+}
+
+// NewFlavor returns the Flavor of the server from its @@version_comment and
+// @@version, which are also proto.Instance.Distro and Version.
+func NewFlavor(versionComment, version string) Flavor {
+	f := Flavor{
+		Version: version,
+	}
+	if versionComment != "" || version != "" {
+		f.Distro = Distro(versionComment + " " + version)
+	}
+	return f
+}
+
+// GetFlavor returns the Flavor of the server c is connected to.
+func GetFlavor(c Connector) (Flavor, error) {
+	versionComment, err := c.GetGlobalVarString("version_comment")
+	if err != nil {
+		return Flavor{}, err
+	}
+	version, err := c.GetGlobalVarString("version")
+	if err != nil {
+		return Flavor{}, err
+	}
+	return NewFlavor(versionComment.String, version.String), nil
+}
+
+func (f Flavor) IsMariaDB() bool {
+	return f.Distro == "MariaDB"
+}
+
+func (f Flavor) IsPercona() bool {
+	return f.Distro == "Percona Server"
+}
+
+// HasUserStats returns true if the server has the userstat feature and its
+// INFORMATION_SCHEMA.TABLE_STATISTICS and USER_STATISTICS tables.
+func (f Flavor) HasUserStats() bool {
+	return f.IsPercona() || f.IsMariaDB()
+}
+
+// AtLeastVersion returns true if the version is unknown or >= minVersion.
+// MariaDB versions are compared as is, e.g. 10.0 is newer than MySQL 5.7.
+func (f Flavor) AtLeastVersion(minVersion string) bool {
+	if f.Version == "" {
+		return true
+	}
+	ok, err := pct.AtLeastVersion(f.Version, minVersion)
+	if err != nil {
+		return true
+	}
+	return ok
+}
+
+func (f Flavor) String() string {
+	if f.Distro == "" {
+		return "unknown"
+	}
+	return f.Distro + " " + f.Version
+}
@@ -0,0 +1,47 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlavor(t *testing.T) {
+	f := NewFlavor("Percona Server (GPL), Release 20, Revision 3c5d3e5d53c", "5.7.21-20-log")
+	assert.True(t, f.IsPercona())
+	assert.True(t, f.HasUserStats())
+	assert.True(t, f.AtLeastVersion("5.6"))
+	assert.False(t, f.AtLeastVersion("8.0"))
+
+	f = NewFlavor("mariadb.org binary distribution", "10.2.14-MariaDB-log")
+	assert.True(t, f.IsMariaDB())
+	assert.True(t, f.HasUserStats())
+	assert.True(t, f.AtLeastVersion("10.0"))
+
+	f = NewFlavor("MySQL Community Server (GPL)", "5.6.40")
+	assert.Equal(t, "MySQL", f.Distro)
+	assert.False(t, f.HasUserStats())
+
+	// Unknown flavor has Oracle MySQL semantics.
+	f = NewFlavor("", "")
+	assert.Equal(t, "", f.Distro)
+	assert.True(t, f.AtLeastVersion("5.6"))
+	assert.Equal(t, "unknown", f.String())
+}
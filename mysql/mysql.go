@@ -18,7 +18,9 @@
 package mysql
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"regexp"
@@ -29,6 +31,7 @@ import (
 	"github.com/go-sql-driver/mysql"
 	"github.com/percona/go-mysql/dsn"
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/pct/credential"
 )
 
 var (
@@ -60,8 +63,11 @@ type Connector interface {
 
 type Connection struct {
 	dsn       string
+	pool      *Pool
 	conn      *sql.DB
 	connected bool
+	nQueries  uint // since Connect, see Close
+	nErrors   uint
 	*sync.Mutex
 }
 
@@ -73,6 +79,44 @@ func NewConnection(dsn string) *Connection {
 	return c
 }
 
+// NewPooledConnection returns a Connection which shares its *sql.DB with all
+// other Connections to the same DSN from the pool. Connect and Close acquire
+// and release a lease on it (see Pool).
+func NewPooledConnection(dsn string, pool *Pool) *Connection {
+	c := &Connection{
+		dsn:   dsn,
+		pool:  pool,
+		Mutex: &sync.Mutex{},
+	}
+	return c
+}
+
+// Open returns a *sql.DB for the DSN like sql.Open, except that a credential
+// reference in the DSN (see pct/credential) is resolved every time the
+// *sql.DB makes a MySQL connection, so the secret isn't kept in the DSN.
+func Open(dsnString string) (*sql.DB, error) {
+	if !credential.HasReference(dsnString) {
+		return sql.Open("mysql", dsnString)
+	}
+	return sql.OpenDB(&connector{dsn: dsnString}), nil
+}
+
+type connector struct {
+	dsn string
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	resolved, err := credential.Resolve(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Driver().Open(resolved)
+}
+
+func (c *connector) Driver() driver.Driver {
+	return mysql.MySQLDriver{}
+}
+
 func (c *Connection) DB() *sql.DB {
 	return c.conn
 }
@@ -90,8 +134,19 @@ func (c *Connection) Connect() error {
 	var err error
 	var db *sql.DB
 
+	if c.pool != nil {
+		if db, err = c.pool.Acquire(c.dsn); err != nil {
+			return err
+		}
+		c.conn = db
+		c.connected = true
+		c.nQueries = 0
+		c.nErrors = 0
+		return nil
+	}
+
 	// Make logical sql.DB connection, not an actual MySQL connection...
-	db, err = sql.Open("mysql", c.dsn)
+	db, err = Open(c.dsn)
 	if err != nil {
 		return fmt.Errorf("Cannot connect to MySQL %s: %s", dsn.HidePassword(c.dsn), FormatError(err))
 	}
@@ -113,7 +168,12 @@ func (c *Connection) Close() {
 	if !c.connected {
 		return
 	}
-	if c.conn != nil {
+	if c.pool != nil {
+		// Queries through DB() aren't counted, so the *sql.DB is only known
+		// to be healthy if queries were made through this Connection.
+		c.pool.Release(c.dsn, c.nQueries > 0 && c.nErrors == 0)
+		c.conn = nil
+	} else if c.conn != nil {
 		c.conn.Close()
 		c.conn = nil
 	}
@@ -151,12 +211,14 @@ func (c *Connection) Exec(queries []string) error {
 	if !c.connected {
 		return ErrNotConnected
 	}
-	for _, query := range queries {
-		if _, err := c.conn.Exec(query); err != nil {
-			return err
+	return c.withSession(func(s session) error {
+		for _, query := range queries {
+			if _, err := s.ExecContext(context.Background(), query); err != nil {
+				return err
+			}
 		}
-	}
-	return nil
+		return nil
+	})
 }
 
 func (c *Connection) GetGlobalVarBoolean(varName string) (varValue sql.NullBool, err error) {
@@ -186,10 +248,10 @@ func (c *Connection) getGlobalVar(varName string, varValue interface{}) (err err
 	err = c.conn.QueryRow("SELECT @@GLOBAL." + varName).Scan(varValue)
 	if val, ok := err.(*mysql.MySQLError); ok {
 		if val.Number == 1193 /*ER_UNKNOWN_SYSTEM_VARIABLE*/ {
-			return nil
+			err = nil
 		}
 	}
-	return err
+	return c.count(err)
 }
 
 func (c *Connection) Uptime() (uptime int64, err error) {
@@ -248,22 +310,58 @@ func (c *Connection) UTCOffset() (time.Duration, time.Duration, error) {
 		defer c.Close()
 	}
 
-	// Current time zone (@@session.time_zone)
-	err = c.conn.QueryRow("SELECT TIMESTAMPDIFF(HOUR, NOW(), UTC_TIMESTAMP())").Scan(&curHours)
+	err = c.withSession(func(s session) error {
+		ctx := context.Background()
+
+		// Current time zone (@@session.time_zone)
+		err := s.QueryRowContext(ctx, "SELECT TIMESTAMPDIFF(HOUR, NOW(), UTC_TIMESTAMP())").Scan(&curHours)
+		if err != nil {
+			return err
+		}
+
+		// System time zone (@@global.system_time_zone)
+		_, err = s.ExecContext(ctx, "SET time_zone='SYSTEM'")
+		if err != nil {
+			return err
+		}
+		return s.QueryRowContext(ctx, "SELECT TIMESTAMPDIFF(HOUR, NOW(), UTC_TIMESTAMP())").Scan(&sysHours)
+	})
 	if err != nil {
 		return 0, 0, err
 	}
+	return time.Duration(curHours) * time.Hour, time.Duration(sysHours) * time.Hour, nil
+}
 
-	// System time zone (@@global.system_time_zone)
-	_, err = c.conn.Exec("SET time_zone='SYSTEM'")
+// session is what *sql.DB and *sql.Conn have in common.
+type session interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withSession runs f with one MySQL connection for queries which change the
+// session, like SET time_zone. If the Connection is pooled, the queries run
+// on a MySQL connection of their own, outside the pool, which is closed
+// afterwards so the changes don't leak to the other leases of the pool.
+func (c *Connection) withSession(f func(s session) error) error {
+	if c.pool == nil {
+		return c.count(f(c.conn))
+	}
+	db, err := Open(SessionDSN(c.dsn))
 	if err != nil {
-		return 0, 0, err
+		return c.count(err)
 	}
-	err = c.conn.QueryRow("SELECT TIMESTAMPDIFF(HOUR, NOW(), UTC_TIMESTAMP())").Scan(&sysHours)
+	defer db.Close()
+	db.SetMaxOpenConns(1) // all queries on the same MySQL connection
+	return c.count(f(db))
+}
+
+// count counts a query and its error, if any, for Close.
+func (c *Connection) count(err error) error {
+	c.nQueries++
 	if err != nil {
-		return 0, 0, err
+		c.nErrors++
 	}
-	return time.Duration(curHours) * time.Hour, time.Duration(sysHours) * time.Hour, nil
+	return err
 }
 
 var rePerconaServer = regexp.MustCompile("(?i)Percona Server")
@@ -0,0 +1,256 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/dsn"
+	"github.com/percona/qan-agent/pct"
+)
+
+const (
+	DefaultMaxOpenConns        = 10               // per DSN
+	DefaultMaxIdleConns        = 2                // per DSN
+	DefaultHealthCheckInterval = 5 * time.Second  // ping if not used for this long
+	DefaultIdleTimeout         = 10 * time.Minute // close DSNs without leases after this long
+	MaxReconnectWait           = 15               // seconds
+)
+
+const sessionParams = "time_zone=%27%2B00%3A00%27" // '+00:00'
+
+// A Pool shares one *sql.DB per DSN among all Connections made from it. A
+// pooled Connection is a lease: Connect acquires the shared *sql.DB and Close
+// releases it, so one component closing its Connection doesn't close the
+// connections of other components, and the MySQL connections of the *sql.DB
+// are reused across intervals instead of connecting again every time.
+type Pool struct {
+	maxOpen int
+	maxIdle int
+	// --
+	HealthCheckInterval time.Duration
+	IdleTimeout         time.Duration
+	NowFunc             func() time.Time
+	openFunc            func(dsn string) (*sql.DB, error)
+	pingFunc            func(*sql.DB) error
+	dbs                 map[string]*pooledDB // keyed on DSN
+	mux                 *sync.Mutex
+}
+
+type pooledDB struct {
+	db        *sql.DB
+	leases    uint
+	lastCheck time.Time // last successful ping or query
+	idleSince time.Time // when leases became 0
+	backoff   *pct.Backoff
+	nextTry   time.Time // fail fast until then after an error
+	nErrors   uint
+	lastErr   error
+}
+
+func NewPool(maxOpen, maxIdle int) *Pool {
+	p := &Pool{
+		maxOpen: maxOpen,
+		maxIdle: maxIdle,
+		// --
+		HealthCheckInterval: DefaultHealthCheckInterval,
+		IdleTimeout:         DefaultIdleTimeout,
+		NowFunc:             time.Now,
+		openFunc:            Open,
+		pingFunc:            func(db *sql.DB) error { return db.Ping() },
+		dbs:                 map[string]*pooledDB{},
+		mux:                 &sync.Mutex{},
+	}
+	return p
+}
+
+// Acquire returns the shared *sql.DB for the DSN and adds a lease on it.
+// Every successful Acquire must be followed by one Release. If MySQL isn't
+// reachable, Acquire fails fast until the reconnect backoff expires. The pool
+// isn't locked while pinging, so an unreachable DSN doesn't block the others.
+func (p *Pool) Acquire(dsnString string) (*sql.DB, error) {
+	p.mux.Lock()
+	now := p.NowFunc()
+	p.purge(now)
+
+	pdb, ok := p.dbs[dsnString]
+	if !ok {
+		pdb = &pooledDB{
+			idleSince: now,
+			backoff:   pct.NewBackoff(MaxReconnectWait, 5*time.Minute),
+		}
+		p.dbs[dsnString] = pdb
+	}
+
+	if now.Before(pdb.nextTry) {
+		err := fmt.Errorf("%s (reconnecting in %s)", pdb.lastErr, pdb.nextTry.Sub(now).Round(time.Second))
+		p.mux.Unlock()
+		return nil, err
+	}
+
+	if pdb.db == nil {
+		// Make logical sql.DB connection, not an actual MySQL connection...
+		db, err := p.openFunc(SessionDSN(dsnString))
+		if err != nil {
+			err = p.fail(pdb, dsnString, err, now)
+			p.mux.Unlock()
+			return nil, err
+		}
+		db.SetMaxOpenConns(p.maxOpen)
+		db.SetMaxIdleConns(p.maxIdle)
+		pdb.db = db
+		pdb.lastCheck = time.Time{}
+	}
+
+	// The lease keeps the *sql.DB open while pinging without the lock.
+	db := pdb.db
+	pdb.leases++
+	check := now.Sub(pdb.lastCheck) >= p.HealthCheckInterval
+	p.mux.Unlock()
+	if !check {
+		return db, nil
+	}
+
+	// ...so ping to test the actual MySQL connection, but not every time
+	// because it's a round trip. The *sql.DB reconnects by itself if MySQL
+	// restarts, so it's never closed while it has leases.
+	err := p.pingFunc(db)
+
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if err != nil {
+		pdb.leases--
+		if pdb.leases == 0 {
+			pdb.idleSince = now
+			if pdb.db == db {
+				pdb.db.Close()
+				pdb.db = nil
+			}
+		}
+		return nil, p.fail(pdb, dsnString, err, now)
+	}
+	pdb.lastCheck = p.NowFunc()
+	pdb.backoff.Success()
+	return db, nil
+}
+
+// Release removes a lease from the DSN. The *sql.DB is kept open for
+// IdleTimeout after the last lease is released. If ok, the caller used the
+// *sql.DB without errors, so the next Acquire doesn't need to ping it before
+// the health check interval.
+func (p *Pool) Release(dsnString string, ok bool) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	pdb, found := p.dbs[dsnString]
+	if !found || pdb.leases == 0 {
+		return
+	}
+	now := p.NowFunc()
+	pdb.leases--
+	if ok {
+		pdb.lastCheck = now
+	}
+	if pdb.leases == 0 {
+		pdb.idleSince = now
+	}
+}
+
+// Status returns the stats of the pool ("mysql-pool") and of each DSN
+// ("mysql-pool <DSN without password>").
+func (p *Pool) Status() map[string]string {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	status := map[string]string{}
+	leases := uint(0)
+	for dsnString, pdb := range p.dbs {
+		leases += pdb.leases
+		s := fmt.Sprintf("leases: %d", pdb.leases)
+		if pdb.db != nil {
+			stats := pdb.db.Stats()
+			s += fmt.Sprintf(", open: %d, in use: %d, idle: %d", stats.OpenConnections, stats.InUse, stats.Idle)
+		}
+		s += fmt.Sprintf(", errors: %d", pdb.nErrors)
+		if pdb.lastErr != nil {
+			s += fmt.Sprintf(", last error: %s", pdb.lastErr)
+		}
+		status["mysql-pool "+dsn.HidePassword(dsnString)] = s
+	}
+	status["mysql-pool"] = fmt.Sprintf("DSNs: %d, leases: %d, max open: %d, max idle: %d",
+		len(p.dbs), leases, p.maxOpen, p.maxIdle)
+	return status
+}
+
+// Close closes the *sql.DB of every DSN, even if it has leases. The pool can
+// be used again after, so it's closed by its owner when the leases are done.
+func (p *Pool) Close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	for dsnString, pdb := range p.dbs {
+		if pdb.db != nil {
+			pdb.db.Close()
+		}
+		delete(p.dbs, dsnString)
+	}
+}
+
+func (p *Pool) fail(pdb *pooledDB, dsnString string, err error, now time.Time) error {
+	// Caller must lock p.mux.
+	err = fmt.Errorf("Cannot connect to MySQL %s: %s", dsn.HidePassword(dsnString), FormatError(err))
+	pdb.nErrors++
+	pdb.lastErr = err
+	pdb.nextTry = now.Add(pdb.backoff.Wait())
+	return err
+}
+
+// SessionDSN returns the DSN with the session variables every pooled MySQL
+// connection needs: time_zone is UTC because the queries of one lease can run
+// on different connections of the *sql.DB, so a SET time_zone wouldn't apply
+// to all of them. A time_zone already in the DSN is kept.
+func SessionDSN(dsnString string) string {
+	// Params are after the address, which can have "/" in a socket path.
+	params := dsnString
+	if i := strings.LastIndex(params, ")"); i >= 0 {
+		params = params[i+1:]
+	} else if i := strings.LastIndex(params, "@"); i >= 0 {
+		params = params[i+1:]
+	}
+	if strings.Contains(params, "time_zone=") {
+		return dsnString
+	}
+	if strings.Contains(params, "?") {
+		return dsnString + "&" + sessionParams
+	}
+	return dsnString + "?" + sessionParams
+}
+
+func (p *Pool) purge(now time.Time) {
+	// Caller must lock p.mux.
+	for dsnString, pdb := range p.dbs {
+		if pdb.leases > 0 || now.Sub(pdb.idleSince) < p.IdleTimeout {
+			continue
+		}
+		if pdb.db != nil {
+			pdb.db.Close()
+		}
+		delete(p.dbs, dsnString)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPool(t *testing.T) {
+	now := time.Now()
+	var pingErr error
+	nPings := 0
+	p := NewPool(5, 1)
+	p.NowFunc = func() time.Time { return now }
+	p.pingFunc = func(*sql.DB) error {
+		nPings++
+		return pingErr
+	}
+	dsn := "user:pass@tcp(127.0.0.1:3306)/"
+
+	// Connections to the same DSN share the *sql.DB and its health check.
+	c1 := NewPooledConnection(dsn, p)
+	c2 := NewPooledConnection(dsn, p)
+	require.NoError(t, c1.Connect())
+	require.NoError(t, c2.Connect())
+	assert.True(t, c1.DB() == c2.DB())
+	assert.Equal(t, 1, nPings)
+	assert.Contains(t, p.Status()["mysql-pool user:***@tcp(127.0.0.1:3306)"], "leases: 2")
+
+	// Closing one doesn't close the other.
+	c1.Close()
+	assert.Nil(t, c1.DB())
+	require.NotNil(t, c2.DB())
+	assert.Contains(t, p.Status()["mysql-pool"], "leases: 1")
+	c2.Close()
+
+	// Ping again after the health check interval, and back off on errors.
+	now = now.Add(DefaultHealthCheckInterval)
+	pingErr = fmt.Errorf("connection refused")
+	assert.Error(t, c1.Connect()) // 1st try, backoff is 0s
+	assert.Error(t, c1.Connect()) // 2nd try, backoff is 1s now
+	assert.Equal(t, 3, nPings)
+	err := c1.Connect() // fails fast
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "reconnecting")
+	assert.Equal(t, 3, nPings)
+
+	now = now.Add(1 * time.Second)
+	pingErr = nil
+	require.NoError(t, c1.Connect())
+	c1.Close()
+
+	// Idle DSNs are closed.
+	now = now.Add(DefaultIdleTimeout)
+	require.NoError(t, NewPooledConnection("other", p).Connect())
+	_, ok := p.Status()["mysql-pool user:***@tcp(127.0.0.1:3306)"]
+	assert.False(t, ok)
+}
+
+func TestPoolCredentialReference(t *testing.T) {
+	// The reference is resolved when connecting, not when acquiring, so the
+	// error is the ping error.
+	p := NewPool(5, 1)
+	_, err := p.Acquire("user:{env:QAN_TEST_NOT_SET}@tcp(127.0.0.1:3306)/")
+	assert.EqualError(t, err, "Cannot connect to MySQL user:***@tcp(127.0.0.1:3306): environment variable QAN_TEST_NOT_SET is not set")
+}
+
+func TestPoolRelease(t *testing.T) {
+	now := time.Now()
+	nPings := 0
+	p := NewPool(5, 1)
+	p.NowFunc = func() time.Time { return now }
+	p.pingFunc = func(*sql.DB) error {
+		nPings++
+		return nil
+	}
+	dsn := "user:pass@tcp(127.0.0.1:3306)/"
+
+	_, err := p.Acquire(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, nPings)
+
+	// A lease used successfully counts as a health check...
+	now = now.Add(DefaultHealthCheckInterval)
+	p.Release(dsn, true)
+	_, err = p.Acquire(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, 1, nPings)
+
+	// ...but not one which failed or wasn't used.
+	now = now.Add(DefaultHealthCheckInterval)
+	p.Release(dsn, false)
+	_, err = p.Acquire(dsn)
+	require.NoError(t, err)
+	assert.Equal(t, 2, nPings)
+	p.Release(dsn, false)
+}
+
+func TestPoolPingUnlocked(t *testing.T) {
+	p := NewPool(5, 1)
+	pinging := make(chan struct{})
+	unblock := make(chan struct{})
+	nPings := 0
+	p.pingFunc = func(*sql.DB) error {
+		p.mux.Lock() // only to count, the pool must not be locked by Acquire
+		nPings++
+		first := nPings == 1
+		p.mux.Unlock()
+		if first {
+			close(pinging)
+			<-unblock
+		}
+		return nil
+	}
+
+	// A DSN which doesn't respond doesn't block the others.
+	done := make(chan error)
+	go func() {
+		_, err := p.Acquire("user:pass@tcp(10.0.0.1:3306)/")
+		done <- err
+	}()
+	<-pinging
+	_, err := p.Acquire("user:pass@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+
+	close(unblock)
+	require.NoError(t, <-done)
+	assert.Contains(t, p.Status()["mysql-pool"], "leases: 2")
+}
+
+func TestSessionDSN(t *testing.T) {
+	tests := map[string]string{
+		"user:pass@tcp(127.0.0.1:3306)/":                         "user:pass@tcp(127.0.0.1:3306)/?time_zone=%27%2B00%3A00%27",
+		"user:pass@tcp(127.0.0.1:3306)/?timeout=5s":              "user:pass@tcp(127.0.0.1:3306)/?timeout=5s&time_zone=%27%2B00%3A00%27",
+		"user:pass@unix(/var/run/mysqld/mysqld.sock)/":           "user:pass@unix(/var/run/mysqld/mysqld.sock)/?time_zone=%27%2B00%3A00%27",
+		"user:pass@tcp(127.0.0.1:3306)/?time_zone=%27SYSTEM%27":  "user:pass@tcp(127.0.0.1:3306)/?time_zone=%27SYSTEM%27",
+		"user:pass@tcp(127.0.0.1:3306)/?tls=custom&x=%2Fpath%2F": "user:pass@tcp(127.0.0.1:3306)/?tls=custom&x=%2Fpath%2F&time_zone=%27%2B00%3A00%27",
+	}
+	for dsn, expect := range tests {
+		assert.Equal(t, expect, SessionDSN(dsn), dsn)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p := NewPool(5, 1)
+	p.pingFunc = func(*sql.DB) error { return nil }
+	dsn := "user:pass@tcp(127.0.0.1:3306)/"
+
+	c := NewPooledConnection(dsn, p)
+	require.NoError(t, c.Connect())
+	db := c.DB()
+	c.Close()
+	p.Close()
+	assert.Equal(t, "DSNs: 0, leases: 0, max open: 5, max idle: 1", p.Status()["mysql-pool"])
+
+	// The pool can be used again.
+	require.NoError(t, c.Connect())
+	assert.False(t, c.DB() == db)
+	c.Close()
+}
+
+func TestRealConnectionFactory(t *testing.T) {
+	// Pooling is opt-in.
+	c := (&RealConnectionFactory{}).Make("user:pass@tcp(127.0.0.1:3306)/").(*Connection)
+	assert.Nil(t, c.pool)
+	p := NewPool(5, 1)
+	c = (&RealConnectionFactory{Pool: p}).Make("user:pass@tcp(127.0.0.1:3306)/").(*Connection)
+	assert.True(t, c.pool == p)
+}
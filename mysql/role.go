@@ -0,0 +1,236 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// Replication roles, see Role.Name().
+const (
+	RoleSource  = "source"
+	RoleReplica = "replica"
+)
+
+// Group replication member states, see Role.GroupMemberState.
+const (
+	GroupOnline      = "ONLINE"
+	GroupRecovering  = "RECOVERING"
+	GroupOffline     = "OFFLINE"
+	GroupError       = "ERROR"
+	GroupUnreachable = "UNREACHABLE"
+)
+
+// A Role is the place of a server in its replication topology.
+type Role struct {
+	Replica          bool   // replicates from a source, or is a group replication secondary
+	ReadOnly         bool   // read_only or super_read_only
+	GroupMemberState string // group replication MEMBER_STATE, empty if not a member
+	GroupQuorum      bool   // true if not a group member or the group has quorum
+}
+
+// A GroupMember is a row of performance_schema.replication_group_members.
+type GroupMember struct {
+	Id    string // MEMBER_ID, which is the @@server_uuid of the member
+	State string // MEMBER_STATE
+	Role  string // MEMBER_ROLE, MySQL 8.0 only: "PRIMARY" or "SECONDARY"
+}
+
+// Name returns RoleReplica or RoleSource.
+func (r Role) Name() string {
+	if r.Replica {
+		return RoleReplica
+	}
+	return RoleSource
+}
+
+// String returns the role name followed by the details which matter to QAN,
+// e.g. "replica, read_only, group member ERROR without quorum".
+func (r Role) String() string {
+	s := []string{r.Name()}
+	if r.ReadOnly {
+		s = append(s, "read_only")
+	}
+	if r.GroupMemberState != "" {
+		group := "group member " + r.GroupMemberState
+		if !r.GroupQuorum {
+			group += " without quorum"
+		}
+		s = append(s, group)
+	}
+	return strings.Join(s, ", ")
+}
+
+// GetRole returns the Role of the server c is connected to.
+func GetRole(c Connector) (Role, error) {
+	db := c.DB()
+	if db == nil {
+		return Role{}, ErrNotConnected
+	}
+
+	role := Role{
+		GroupQuorum: true,
+	}
+
+	replica, err := isReplica(db)
+	if err != nil {
+		return role, err
+	}
+	role.Replica = replica
+
+	readOnly, err := c.GetGlobalVarString("read_only")
+	if err != nil {
+		return role, err
+	}
+	role.ReadOnly = isOn(readOnly.String)
+	if !role.ReadOnly {
+		// MySQL 5.7.8 and newer. Group replication secondaries are
+		// super_read_only.
+		superReadOnly, err := c.GetGlobalVarString("super_read_only")
+		role.ReadOnly = err == nil && isOn(superReadOnly.String)
+	}
+
+	// The group replication table doesn't exist before MySQL 5.7.
+	serverUUID, err := c.GetGlobalVarString("server_uuid")
+	if err != nil {
+		return role, nil
+	}
+	members, err := getGroupMembers(db)
+	if err != nil {
+		return role, nil
+	}
+	setGroupRole(&role, serverUUID.String, members)
+	return role, nil
+}
+
+// setGroupRole sets the group replication state and quorum of the server from
+// the members of its group, if it's a member. A member which lost quorum
+// sees a majority of the group UNREACHABLE, or is in ERROR state if it was
+// expelled.
+func setGroupRole(role *Role, serverUUID string, members []GroupMember) {
+	var self *GroupMember
+	reachable := 0
+	for i := range members {
+		if members[i].Id == serverUUID {
+			self = &members[i]
+		}
+		if members[i].State != GroupUnreachable {
+			reachable++
+		}
+	}
+	if self == nil || self.State == GroupOffline {
+		// Not a member, or group replication isn't running.
+		return
+	}
+
+	role.GroupMemberState = self.State
+	role.GroupQuorum = self.State != GroupError && reachable*2 > len(members)
+	switch self.Role {
+	case "SECONDARY":
+		role.Replica = true
+	case "":
+		// Before MySQL 8.0, secondaries of a single-primary group are
+		// super_read_only.
+		role.Replica = role.Replica || role.ReadOnly
+	}
+}
+
+// isReplica returns true if the server has a replication channel, other than
+// the channels of group replication.
+func isReplica(db *sql.DB) (bool, error) {
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		// MySQL 8.4 removed SHOW SLAVE STATUS.
+		var err2 error
+		if rows, err2 = db.Query("SHOW REPLICA STATUS"); err2 != nil {
+			return false, err
+		}
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	channelCol := -1
+	for i, col := range columns {
+		if col == "Channel_Name" || col == "Channel_name" {
+			channelCol = i
+		}
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return false, err
+		}
+		if channelCol >= 0 && strings.HasPrefix(string(values[channelCol]), "group_replication_") {
+			continue
+		}
+		return true, nil
+	}
+	return false, rows.Err()
+}
+
+// getGroupMembers returns the members of the group replication group, which
+// are none if group replication isn't used.
+func getGroupMembers(db *sql.DB) ([]GroupMember, error) {
+	withRole := true
+	rows, err := db.Query("SELECT MEMBER_ID, MEMBER_STATE, MEMBER_ROLE" +
+		" FROM performance_schema.replication_group_members")
+	if err != nil {
+		// MEMBER_ROLE is new in MySQL 8.0.
+		withRole = false
+		rows, err = db.Query("SELECT MEMBER_ID, MEMBER_STATE" +
+			" FROM performance_schema.replication_group_members")
+		if err != nil {
+			return nil, fmt.Errorf("cannot read group replication members: %s", err)
+		}
+	}
+	defer rows.Close()
+
+	members := []GroupMember{}
+	for rows.Next() {
+		var id, state, role sql.NullString
+		if withRole {
+			err = rows.Scan(&id, &state, &role)
+		} else {
+			err = rows.Scan(&id, &state)
+		}
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, GroupMember{
+			Id:    id.String,
+			State: state.String,
+			Role:  role.String,
+		})
+	}
+	return members, rows.Err()
+}
+
+// isOn returns true if a boolean variable is enabled: "1" or "ON".
+func isOn(v string) bool {
+	return v == "1" || strings.EqualFold(v, "ON")
+}
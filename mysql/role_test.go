@@ -0,0 +1,69 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRole(t *testing.T) {
+	r := Role{GroupQuorum: true}
+	assert.Equal(t, RoleSource, r.Name())
+	assert.Equal(t, "source", r.String())
+
+	r = Role{Replica: true, ReadOnly: true, GroupMemberState: GroupError}
+	assert.Equal(t, RoleReplica, r.Name())
+	assert.Equal(t, "replica, read_only, group member ERROR without quorum", r.String())
+}
+
+func TestSetGroupRole(t *testing.T) {
+	// Not a member.
+	r := Role{GroupQuorum: true}
+	setGroupRole(&r, "uuid1", []GroupMember{})
+	assert.Equal(t, Role{GroupQuorum: true}, r)
+
+	// Group replication loaded but not started.
+	setGroupRole(&r, "uuid1", []GroupMember{{Id: "uuid1", State: GroupOffline}})
+	assert.Equal(t, Role{GroupQuorum: true}, r)
+
+	members := []GroupMember{
+		{Id: "uuid1", State: GroupOnline, Role: "PRIMARY"},
+		{Id: "uuid2", State: GroupOnline, Role: "SECONDARY"},
+		{Id: "uuid3", State: GroupUnreachable, Role: "SECONDARY"},
+	}
+	r = Role{GroupQuorum: true}
+	setGroupRole(&r, "uuid1", members)
+	assert.Equal(t, Role{GroupMemberState: GroupOnline, GroupQuorum: true}, r)
+
+	r = Role{ReadOnly: true, GroupQuorum: true}
+	setGroupRole(&r, "uuid2", members)
+	assert.Equal(t, Role{Replica: true, ReadOnly: true, GroupMemberState: GroupOnline, GroupQuorum: true}, r)
+
+	// A majority is unreachable.
+	members[1].State = GroupUnreachable
+	r = Role{GroupQuorum: true}
+	setGroupRole(&r, "uuid1", members)
+	assert.Equal(t, Role{GroupMemberState: GroupOnline, GroupQuorum: false}, r)
+
+	// Expelled from the group.
+	r = Role{ReadOnly: true, GroupQuorum: true}
+	setGroupRole(&r, "uuid3", []GroupMember{{Id: "uuid3", State: GroupError}})
+	assert.Equal(t, Role{Replica: true, ReadOnly: true, GroupMemberState: GroupError, GroupQuorum: false}, r)
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/percona/pmm/proto"
+)
+
+// TLSConfigName returns the name under which the TLS config for the instance
+// is registered with the driver, i.e. the value of the DSN tls param.
+func TLSConfigName(in proto.Instance) string {
+	if in.UUID != "" {
+		return "qan-" + in.UUID
+	}
+	return "qan-" + in.Name
+}
+
+// NewTLSConfig returns a TLS config which verifies the server with the CA,
+// if any, and presents the client certificate, if any.
+func NewTLSConfig(t proto.InstanceTLS) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.SkipVerify,
+	}
+	if t.CA != "" {
+		pem, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in CA file %s", t.CA)
+		}
+		cfg.RootCAs = pool
+	}
+	if t.Cert != "" || t.Key != "" {
+		if t.Cert == "" || t.Key == "" {
+			return nil, fmt.Errorf("both client cert and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client cert and key: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// InstanceDSN returns the DSN to connect to the instance. If the instance has
+// TLS settings, they're (re)registered with the driver and the DSN tls param
+// is set to use them, else the instance DSN is returned as-is. Every component
+// that connects to an instance should use this instead of in.DSN.
+func InstanceDSN(in proto.Instance) (string, error) {
+	if in.TLS == nil {
+		return in.DSN, nil
+	}
+	tlsConfig, err := NewTLSConfig(*in.TLS)
+	if err != nil {
+		return "", fmt.Errorf("invalid TLS config for %s: %s", in.Name, err)
+	}
+	name := TLSConfigName(in)
+	if err := mysql.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	cfg, err := mysql.ParseDSN(in.DSN)
+	if err != nil {
+		return "", err
+	}
+	cfg.TLSConfig = name
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,66 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package mysql
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/percona/pmm/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceDSN(t *testing.T) {
+	in := proto.Instance{
+		UUID: "313",
+		Name: "db01",
+		DSN:  "user:pass@tcp(127.0.0.1:3306)/",
+	}
+
+	// No TLS, DSN as-is.
+	dsn, err := InstanceDSN(in)
+	require.NoError(t, err)
+	assert.Equal(t, in.DSN, dsn)
+
+	// TLS registered by instance UUID, existing tls param replaced.
+	in.DSN = "user:pass@tcp(127.0.0.1:3306)/?tls=true"
+	in.TLS = &proto.InstanceTLS{SkipVerify: true}
+	dsn, err = InstanceDSN(in)
+	require.NoError(t, err)
+	assert.Equal(t, "user:pass@tcp(127.0.0.1:3306)/?tls=qan-313", dsn)
+
+	// Bad TLS settings are errors, not silently ignored.
+	in.TLS = &proto.InstanceTLS{CA: "/does/not/exist.pem"}
+	_, err = InstanceDSN(in)
+	assert.Error(t, err)
+
+	in.TLS = &proto.InstanceTLS{Cert: "/client-cert.pem"}
+	_, err = InstanceDSN(in)
+	assert.EqualError(t, err, "invalid TLS config for db01: both client cert and key are required")
+
+	tmpFile, err := ioutil.TempFile("", "qan-ca-")
+	require.NoError(t, err)
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString("not a cert")
+	tmpFile.Close()
+	in.TLS = &proto.InstanceTLS{CA: tmpFile.Name()}
+	_, err = InstanceDSN(in)
+	assert.EqualError(t, err, "invalid TLS config for db01: no certificates in CA file "+tmpFile.Name())
+}
@@ -37,6 +37,7 @@ const (
 	TRASH_DIR    = "trash"
 	START_LOCK   = "start.lock"
 	START_SCRIPT = "start.sh"
+	MRMS_HISTORY = "mrms-history.json"
 )
 
 type basedir struct {
@@ -123,6 +124,8 @@ func (b *basedir) File(file string) string {
 		file = START_LOCK
 	case "start-script":
 		file = START_SCRIPT
+	case "mrms-history":
+		file = MRMS_HISTORY
 	default:
 		log.Panicf("Unknown basedir file: %s", file)
 	}
@@ -0,0 +1,201 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package credential resolves credential references in DSNs so that instance
+// DSNs don't have to contain plaintext passwords. A reference is resolved
+// when connecting, so the secret is never stored in instance files or logged.
+//
+// A reference replaces either the password or the whole user:password:
+//
+//	user:{env:MYSQL_PWD}@tcp(127.0.0.1:3306)/
+//	user:{file:/run/secrets/mysql}@tcp(127.0.0.1:3306)/
+//	{mycnf:/root/.my.cnf}@tcp(127.0.0.1:3306)/
+//	{mycnf:/etc/qan/my.cnf[qan]}@unix(/var/run/mysqld/mysqld.sock)/
+//	{login-path:qan}@tcp(127.0.0.1:3306)/
+//	mongodb://user:{env:MONGO_PWD}@127.0.0.1:27017
+//
+// env is an environment variable, file is a secrets file (trailing newline
+// ignored), mycnf is a section ([client] by default) of a MySQL option file,
+// and login-path is a login path in the mysql_config_editor file
+// (MYSQL_TEST_LOGIN_FILE or ~/.mylogin.cnf). Option files provide the user
+// and password, env and file only the password.
+package credential
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var refRe = regexp.MustCompile(`\{(env|file|mycnf|login-path):([^{}]*)\}`)
+
+// Credentials are the user and password that a reference resolves to.
+// User is empty for env and file references.
+type Credentials struct {
+	User     string
+	Password string
+}
+
+// HasReference returns true if the DSN contains a credential reference.
+func HasReference(dsn string) bool {
+	return refRe.MatchString(dsn)
+}
+
+// Resolve returns the DSN with the credential reference, if any, replaced by
+// the credentials. The values are inserted as-is, which suits MySQL DSNs; for
+// URLs, parse them first and call ResolveUserPassword.
+func Resolve(dsn string) (string, error) {
+	m := refRe.FindStringSubmatchIndex(dsn)
+	if m == nil {
+		return dsn, nil
+	}
+	creds, err := Lookup(dsn[m[2]:m[3]], dsn[m[4]:m[5]])
+	if err != nil {
+		return "", err
+	}
+	if m[0] > 0 && dsn[m[0]-1] == ':' {
+		// user:{ref}@
+		return dsn[:m[0]] + creds.Password + dsn[m[1]:], nil
+	}
+	// {ref}@
+	if creds.User == "" {
+		return "", fmt.Errorf("%s has no user", dsn[m[0]:m[1]])
+	}
+	return dsn[:m[0]] + creds.User + ":" + creds.Password + dsn[m[1]:], nil
+}
+
+// ResolveUserPassword resolves a reference in the user and password parsed
+// from a URL. If the reference replaces the whole user:password, the parser
+// splits it at the first colon, so the two are joined again.
+func ResolveUserPassword(user, password string) (string, string, error) {
+	if m := refRe.FindStringSubmatch(password); m != nil && m[0] == password {
+		creds, err := Lookup(m[1], m[2])
+		if err != nil {
+			return "", "", err
+		}
+		return user, creds.Password, nil
+	}
+	userinfo := user + ":" + password
+	if m := refRe.FindStringSubmatch(userinfo); m != nil && m[0] == userinfo {
+		creds, err := Lookup(m[1], m[2])
+		if err != nil {
+			return "", "", err
+		}
+		if creds.User == "" {
+			return "", "", fmt.Errorf("%s has no user", userinfo)
+		}
+		return creds.User, creds.Password, nil
+	}
+	return user, password, nil
+}
+
+// Lookup returns the credentials of one reference, e.g. "env", "MYSQL_PWD".
+func Lookup(kind, arg string) (Credentials, error) {
+	switch kind {
+	case "env":
+		password, ok := os.LookupEnv(arg)
+		if !ok {
+			return Credentials{}, fmt.Errorf("environment variable %s is not set", arg)
+		}
+		return Credentials{Password: password}, nil
+	case "file":
+		data, err := ioutil.ReadFile(expandHome(arg))
+		if err != nil {
+			return Credentials{}, fmt.Errorf("cannot read secrets file: %s", err)
+		}
+		return Credentials{Password: strings.TrimRight(string(data), "\r\n")}, nil
+	case "mycnf":
+		file, section := arg, "client"
+		if i := strings.LastIndex(arg, "["); i != -1 && strings.HasSuffix(arg, "]") {
+			file, section = arg[:i], arg[i+1:len(arg)-1]
+		}
+		data, err := ioutil.ReadFile(expandHome(file))
+		if err != nil {
+			return Credentials{}, fmt.Errorf("cannot read option file: %s", err)
+		}
+		return optionFileCredentials(data, file, section)
+	case "login-path":
+		file := os.Getenv("MYSQL_TEST_LOGIN_FILE")
+		if file == "" {
+			file = filepath.Join(os.Getenv("HOME"), ".mylogin.cnf")
+		}
+		data, err := ReadLoginPathFile(file)
+		if err != nil {
+			return Credentials{}, err
+		}
+		return optionFileCredentials(data, file, arg)
+	}
+	return Credentials{}, fmt.Errorf("invalid credential reference: %s", kind)
+}
+
+// optionFileCredentials returns the user and password in the section of a
+// MySQL option file. It's an error if the section has no password.
+func optionFileCredentials(data []byte, file, section string) (Credentials, error) {
+	var creds Credentials
+	inSection, found, hasPassword := false, false, false
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' || line[0] == ';' || line[0] == '!' {
+			continue
+		}
+		if line[0] == '[' && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			found = found || inSection
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1)
+		value := unquote(strings.TrimSpace(kv[1]))
+		switch key {
+		case "user":
+			creds.User = value
+		case "password":
+			creds.Password = value
+			hasPassword = true
+		}
+	}
+	if !found {
+		return Credentials{}, fmt.Errorf("no [%s] section in %s", section, file)
+	}
+	if !hasPassword {
+		return Credentials{}, fmt.Errorf("no password in [%s] section of %s", section, file)
+	}
+	return creds, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+func expandHome(file string) string {
+	if strings.HasPrefix(file, "~/") {
+		return filepath.Join(os.Getenv("HOME"), file[2:])
+	}
+	return file
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package credential
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-credential-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// No reference.
+	dsn, err := Resolve("user:pass@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "user:pass@tcp(127.0.0.1:3306)/", dsn)
+
+	// env
+	os.Setenv("QAN_TEST_PWD", "env-pass")
+	defer os.Unsetenv("QAN_TEST_PWD")
+	dsn, err = Resolve("user:{env:QAN_TEST_PWD}@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "user:env-pass@tcp(127.0.0.1:3306)/", dsn)
+
+	_, err = Resolve("user:{env:QAN_TEST_NOT_SET}@tcp(127.0.0.1:3306)/")
+	assert.EqualError(t, err, "environment variable QAN_TEST_NOT_SET is not set")
+
+	// file
+	secretsFile := filepath.Join(dir, "secret")
+	require.NoError(t, ioutil.WriteFile(secretsFile, []byte("file-pass\n"), 0600))
+	dsn, err = Resolve("user:{file:" + secretsFile + "}@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "user:file-pass@tcp(127.0.0.1:3306)/", dsn)
+
+	// env and file have no user
+	_, err = Resolve("{env:QAN_TEST_PWD}@tcp(127.0.0.1:3306)/")
+	assert.EqualError(t, err, "{env:QAN_TEST_PWD} has no user")
+
+	// mycnf
+	myCnf := filepath.Join(dir, "my.cnf")
+	require.NoError(t, ioutil.WriteFile(myCnf, []byte(
+		"[mysqld]\npassword=wrong\n\n[client]\n# comment\nuser = qan\npassword = \"cnf pass\"\n\n[qan]\nuser=other\npassword=other-pass\n",
+	), 0600))
+	dsn, err = Resolve("{mycnf:" + myCnf + "}@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "qan:cnf pass@tcp(127.0.0.1:3306)/", dsn)
+
+	dsn, err = Resolve("{mycnf:" + myCnf + "[qan]}@unix(/var/run/mysqld/mysqld.sock)/")
+	require.NoError(t, err)
+	assert.Equal(t, "other:other-pass@unix(/var/run/mysqld/mysqld.sock)/", dsn)
+
+	// Only the password if the reference is the password.
+	dsn, err = Resolve("root:{mycnf:" + myCnf + "}@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "root:cnf pass@tcp(127.0.0.1:3306)/", dsn)
+
+	_, err = Resolve("{mycnf:" + myCnf + "[nope]}@tcp(127.0.0.1:3306)/")
+	assert.EqualError(t, err, "no [nope] section in "+myCnf)
+}
+
+func TestLoginPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-credential-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	loginFile := filepath.Join(dir, ".mylogin.cnf")
+	key := []byte("0123456789abcdefghij")
+	writeLoginPathFile(t, loginFile, key, "[client]\n", "user = root\n", "[qan]\n", "user = \"qan\"\n", "password = \"login pass\"\n")
+	os.Setenv("MYSQL_TEST_LOGIN_FILE", loginFile)
+	defer os.Unsetenv("MYSQL_TEST_LOGIN_FILE")
+
+	dsn, err := Resolve("{login-path:qan}@tcp(127.0.0.1:3306)/")
+	require.NoError(t, err)
+	assert.Equal(t, "qan:login pass@tcp(127.0.0.1:3306)/", dsn)
+
+	_, err = Resolve("{login-path:client}@tcp(127.0.0.1:3306)/")
+	assert.EqualError(t, err, "no password in [client] section of "+loginFile)
+}
+
+func TestResolveUserPassword(t *testing.T) {
+	os.Setenv("QAN_TEST_PWD", "env-pass")
+	defer os.Unsetenv("QAN_TEST_PWD")
+
+	user, password, err := ResolveUserPassword("admin", "{env:QAN_TEST_PWD}")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "env-pass", password)
+
+	user, password, err = ResolveUserPassword("admin", "literal")
+	require.NoError(t, err)
+	assert.Equal(t, "admin", user)
+	assert.Equal(t, "literal", password)
+
+	// mongodb://{mycnf:/file}@host is parsed as user "{mycnf" password "/file}".
+	dir, err := ioutil.TempDir("", "qan-credential-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	myCnf := filepath.Join(dir, "my.cnf")
+	require.NoError(t, ioutil.WriteFile(myCnf, []byte("[client]\nuser=mongo\npassword=cnf-pass\n"), 0600))
+	user, password, err = ResolveUserPassword("{mycnf", myCnf+"}")
+	require.NoError(t, err)
+	assert.Equal(t, "mongo", user)
+	assert.Equal(t, "cnf-pass", password)
+}
+
+// writeLoginPathFile writes lines encrypted like mysql_config_editor does.
+func writeLoginPathFile(t *testing.T, file string, key []byte, lines ...string) {
+	aesKey := make([]byte, aes.BlockSize)
+	for i, b := range key {
+		aesKey[i%aes.BlockSize] ^= b
+	}
+	block, err := aes.NewCipher(aesKey)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0})
+	buf.Write(key)
+	for _, line := range lines {
+		pad := aes.BlockSize - len(line)%aes.BlockSize
+		plain := append([]byte(line), bytes.Repeat([]byte{byte(pad)}, pad)...)
+		cipher := make([]byte, len(plain))
+		for i := 0; i < len(plain); i += aes.BlockSize {
+			block.Encrypt(cipher[i:i+aes.BlockSize], plain[i:i+aes.BlockSize])
+		}
+		size := make([]byte, 4)
+		binary.LittleEndian.PutUint32(size, uint32(len(cipher)))
+		buf.Write(size)
+		buf.Write(cipher)
+	}
+	require.NoError(t, ioutil.WriteFile(file, buf.Bytes(), 0600))
+}
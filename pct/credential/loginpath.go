@@ -0,0 +1,78 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package credential
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+const (
+	loginPathUnusedLen = 4  // leading bytes, zero
+	loginPathKeyLen    = 20 // key bytes, folded into an AES-128 key
+)
+
+// ReadLoginPathFile returns the decrypted contents of a mysql_config_editor
+// login path file, which is a MySQL option file with one section per login
+// path. The file is the key followed by lines encrypted with AES-128-ECB,
+// each prefixed by its length as a 4-byte little-endian integer.
+func ReadLoginPathFile(file string) ([]byte, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read login path file: %s", err)
+	}
+	if len(data) < loginPathUnusedLen+loginPathKeyLen {
+		return nil, fmt.Errorf("invalid login path file %s: too short", file)
+	}
+	key := make([]byte, aes.BlockSize)
+	for i, b := range data[loginPathUnusedLen : loginPathUnusedLen+loginPathKeyLen] {
+		key[i%aes.BlockSize] ^= b
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var plain bytes.Buffer
+	data = data[loginPathUnusedLen+loginPathKeyLen:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("invalid login path file %s: truncated", file)
+		}
+		n := int(binary.LittleEndian.Uint32(data))
+		data = data[4:]
+		if n == 0 || n%aes.BlockSize != 0 || n > len(data) {
+			return nil, fmt.Errorf("invalid login path file %s: bad line length %d", file, n)
+		}
+		line := make([]byte, n)
+		for i := 0; i < n; i += aes.BlockSize {
+			block.Decrypt(line[i:i+aes.BlockSize], data[i:i+aes.BlockSize])
+		}
+		data = data[n:]
+		// Strip PKCS#7 padding.
+		pad := int(line[n-1])
+		if pad == 0 || pad > aes.BlockSize {
+			return nil, fmt.Errorf("invalid login path file %s: bad padding", file)
+		}
+		plain.Write(line[:n-pad])
+	}
+	return plain.Bytes(), nil
+}
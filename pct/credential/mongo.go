@@ -0,0 +1,60 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package credential
+
+import (
+	"time"
+
+	"github.com/percona/pmgo"
+)
+
+// dialTimeout is the mgo.Dial default.
+const dialTimeout = 10 * time.Second
+
+type mongoDialer struct {
+	pmgo.Dialer
+}
+
+// NewMongoDialer returns a dialer which resolves a credential reference in
+// the user and password of the DSN or dial info every time it dials.
+func NewMongoDialer(dialer pmgo.Dialer) pmgo.Dialer {
+	return &mongoDialer{Dialer: dialer}
+}
+
+func (d *mongoDialer) Dial(url string) (pmgo.SessionManager, error) {
+	return d.DialWithTimeout(url, dialTimeout)
+}
+
+func (d *mongoDialer) DialWithInfo(info *pmgo.DialInfo) (pmgo.SessionManager, error) {
+	resolved := *info
+	var err error
+	resolved.Username, resolved.Password, err = ResolveUserPassword(info.Username, info.Password)
+	if err != nil {
+		return nil, err
+	}
+	return d.Dialer.DialWithInfo(&resolved)
+}
+
+func (d *mongoDialer) DialWithTimeout(url string, timeout time.Duration) (pmgo.SessionManager, error) {
+	info, err := pmgo.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = timeout
+	return d.DialWithInfo(info)
+}
@@ -200,3 +200,12 @@ func ToBool(s string) bool {
 		return false
 	}
 }
+
+// BoolValue returns the value of the bool pointer passed in or
+// false if the pointer is nil.
+func BoolValue(v *bool) bool {
+	if v != nil {
+		return *v
+	}
+	return false
+}
@@ -164,3 +164,10 @@ func (s *SysTestSuite) TestAtLeastVersion(t *C) {
 	t.Check(err, IsNil)
 	t.Check(got, Equals, true)
 }
+
+func (s *SysTestSuite) TestBoolValue(t *C) {
+	yes, no := true, false
+	t.Check(pct.BoolValue(&yes), Equals, true)
+	t.Check(pct.BoolValue(&no), Equals, false)
+	t.Check(pct.BoolValue(nil), Equals, false)
+}
@@ -0,0 +1,48 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+)
+
+// New returns Filter which matches names, e.g. databases or namespaces,
+// against include and exclude patterns. Patterns are shell patterns
+// as in path.Match, e.g. "test*" or "app.sessions_*".
+func New(include, exclude []string) (*Filter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+	}
+	return &Filter{
+		include: include,
+		exclude: exclude,
+	}, nil
+}
+
+// Filter decides which names are allowed. A nil Filter allows everything.
+type Filter struct {
+	include []string
+	exclude []string
+}
+
+// Allow returns true if name matches an include pattern, or there are none,
+// and doesn't match any exclude pattern.
+func (f *Filter) Allow(name string) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.include) > 0 && !match(f.include, name) {
+		return false
+	}
+	return !match(f.exclude, name)
+}
+
+func match(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	var f *Filter
+	assert.True(t, f.Allow("admin"))
+
+	f, err := New(nil, []string{"admin", "local"})
+	require.NoError(t, err)
+	assert.False(t, f.Allow("admin"))
+	assert.False(t, f.Allow("local"))
+	assert.True(t, f.Allow("app"))
+
+	f, err = New([]string{"app*"}, []string{"app_test"})
+	require.NoError(t, err)
+	assert.True(t, f.Allow("app"))
+	assert.True(t, f.Allow("app_prod"))
+	assert.False(t, f.Allow("app_test"))
+	assert.False(t, f.Allow("other"))
+
+	// Namespaces, in path.Match "*" matches "." too, it stops only at "/".
+	f, err = New([]string{"app.*"}, []string{"app.system.*"})
+	require.NoError(t, err)
+	assert.True(t, f.Allow("app.users"))
+	assert.False(t, f.Allow("app.system.js"))
+	assert.False(t, f.Allow("other.users"))
+
+	_, err = New([]string{"app["}, nil)
+	assert.Error(t, err)
+}
@@ -3,13 +3,17 @@ package mongo
 import (
 	"context"
 	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/mrms"
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/pct/credential"
 	"github.com/percona/qan-agent/qan/analyzer"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
@@ -22,15 +26,28 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 	// Get services we need
 	logger, _ := services["logger"].(*pct.Logger)
 	spool, _ := services["spool"].(data.Spooler)
+	mrms, _ := services["mrms"].(mrms.Monitor)
 
 	// return initialized MongoAnalyzer
 	return &MongoAnalyzer{
 		protoInstance: protoInstance,
 		spool:         spool,
 		logger:        logger,
+		mrms:          mrms,
 	}
 }
 
+// MaxRestartWait is the max seconds between tries to restart the profiler.
+const MaxRestartWait = 60
+
+// Sources of slow queries, see config.QAN.CollectFrom.
+const (
+	CollectFromProfiler  = "profiler"  // tail system.profile of every database
+	CollectFromLog       = "log"       // read the mongod log file (MongoDB 4.4+)
+	CollectFromCurrentOp = "currentop" // sample in-flight operations, reports are estimates
+	CollectFromOplog     = "oplog"     // tail the oplog, reports are write classes without timings
+)
+
 // MongoAnalyzer
 type MongoAnalyzer struct {
 	// dependencies
@@ -39,6 +56,7 @@ type MongoAnalyzer struct {
 	// dependencies from ctx
 	logger *pct.Logger
 	spool  data.Spooler
+	mrms   mrms.Monitor // optional
 
 	// dependency from setter SetConfig
 	config pc.QAN
@@ -47,12 +65,18 @@ type MongoAnalyzer struct {
 	profiler Profiler
 
 	// state
-	sync.RWMutex      // Lock() to protect internal consistency of the service
-	running      bool // Is this service running?
+	sync.RWMutex                  // Lock() to protect internal consistency of the service
+	running      bool             // Is this service running?
+	restartChan  chan mrms.Change // MRMS notifies restarts and disabled profiling
+	doneChan     chan struct{}    // close(doneChan) to stop watching restartChan
 }
 
-// SetConfig sets the config
+// SetConfig sets the config. Without database filters, the databases
+// in profiler.DefaultExcludeDatabases are excluded.
 func (m *MongoAnalyzer) SetConfig(setConfig pc.QAN) {
+	if setConfig.IncludeDatabases == nil && setConfig.ExcludeDatabases == nil {
+		setConfig.ExcludeDatabases = append([]string{}, profiler.DefaultExcludeDatabases...)
+	}
 	m.config = setConfig
 }
 
@@ -77,20 +101,72 @@ func (m *MongoAnalyzer) Start() error {
 	if err != nil {
 		return err
 	}
-	dialer := pmgo.NewDialer()
+	// Credentials are resolved every time the profiler dials.
+	dialer := credential.NewMongoDialer(pmgo.NewDialer())
 
-	m.profiler = profiler.New(
-		dialInfo,
-		dialer,
-		m.logger,
-		m.spool,
-		m.config,
-	)
+	switch m.config.CollectFrom {
+	case "", CollectFromProfiler:
+		if m.config.DiscoverMembers != nil && *m.config.DiscoverMembers {
+			m.profiler = profiler.NewCluster(
+				dialInfo,
+				dialer,
+				m.logger,
+				m.spool,
+				m.config,
+			)
+			break
+		}
+		m.profiler = profiler.New(
+			dialInfo,
+			dialer,
+			m.logger,
+			m.spool,
+			m.config,
+		)
+	case CollectFromLog:
+		m.profiler = profiler.NewLogProfiler(
+			dialInfo,
+			dialer,
+			m.logger,
+			m.spool,
+			m.config,
+			m.checkpointFile("log"),
+		)
+	case CollectFromCurrentOp:
+		m.profiler = profiler.NewCurrentOpProfiler(
+			dialInfo,
+			dialer,
+			m.logger,
+			m.spool,
+			m.config,
+		)
+	case CollectFromOplog:
+		m.profiler = profiler.NewOplogProfiler(
+			dialInfo,
+			dialer,
+			m.logger,
+			m.spool,
+			m.config,
+			m.checkpointFile("oplog"),
+		)
+	default:
+		return fmt.Errorf("invalid CollectFrom: %s; expected %s, %s, %s or %s", m.config.CollectFrom, CollectFromProfiler, CollectFromLog, CollectFromCurrentOp, CollectFromOplog)
+	}
 
 	if err := m.profiler.Start(); err != nil {
 		return err
 	}
 
+	// If mongod restarts or profiling is disabled, MRMS tells us so we
+	// restart the profiler, which re-enables profiling and re-applies
+	// the profiling configured in QAN config. Only the profiler depends
+	// on profiling, so the other sources don't add the instance to MRMS.
+	if _, ok := m.profiler.(restarter); ok && m.mrms != nil {
+		m.restartChan = m.mrms.Add(m.protoInstance)
+		m.doneChan = make(chan struct{})
+		go m.watch(m.restartChan, m.doneChan)
+	}
+
 	m.running = true
 	return nil
 }
@@ -124,6 +200,12 @@ func (m *MongoAnalyzer) Stop() error {
 		return nil
 	}
 
+	if m.restartChan != nil {
+		m.mrms.Remove(m.protoInstance.UUID, m.restartChan)
+		close(m.doneChan)
+		m.restartChan = nil
+	}
+
 	// stop monitoring databases
 	m.profiler.Stop()
 	m.profiler = nil
@@ -140,12 +222,70 @@ func (m *MongoAnalyzer) GetDefaults(uuid string) map[string]interface{} {
 		m.config.ExampleQueries = &defaultExampleQueries
 	}
 
+	collectFrom := m.config.CollectFrom
+	if collectFrom == "" {
+		collectFrom = CollectFromProfiler
+	}
+
 	return map[string]interface{}{
+		"CollectFrom":    collectFrom,
 		"Interval":       m.config.Interval,
 		"ExampleQueries": m.config.ExampleQueries,
+		"GraceIntervals": m.config.GraceIntervals,
+		// profiler
+		"ProfilingLevel":  m.config.ProfilingLevel,
+		"Slowms":          m.config.Slowms,
+		"SampleRate":      m.config.SampleRate,
+		"DiscoverMembers": m.config.DiscoverMembers,
+		// filters
+		"IncludeDatabases":  m.config.IncludeDatabases,
+		"ExcludeDatabases":  m.config.ExcludeDatabases,
+		"IncludeNamespaces": m.config.IncludeNamespaces,
+		"ExcludeNamespaces": m.config.ExcludeNamespaces,
 	}
 }
 
+// checkpointFile returns the file where the position in the mongod log or the oplog
+// is saved, or "" if there is no basedir, e.g. in tests.
+func (m *MongoAnalyzer) checkpointFile(source string) string {
+	if pct.Basedir.Path() == "" {
+		return ""
+	}
+	return filepath.Join(pct.Basedir.Path(), fmt.Sprintf("mongo-%s-%s.json", source, m.protoInstance.UUID))
+}
+
+// watch restarts the profiler when MRMS says so, and retries until it starts.
+func (m *MongoAnalyzer) watch(restartChan <-chan mrms.Change, doneChan <-chan struct{}) {
+	backoff := pct.NewBackoff(MaxRestartWait, 5*time.Minute)
+	var retryChan <-chan time.Time
+	for {
+		select {
+		case change := <-restartChan:
+			m.logger.Info("Restarting profiler:", change.Reason)
+		case <-retryChan:
+		case <-doneChan:
+			return
+		}
+		retryChan = nil
+		if err := m.restartProfiler(); err != nil {
+			wait := backoff.Wait()
+			m.logger.Warn(fmt.Sprintf("Cannot restart profiler, retrying in %s: %s", wait, err))
+			retryChan = time.After(wait)
+			continue
+		}
+		backoff.Success()
+	}
+}
+
+func (m *MongoAnalyzer) restartProfiler() error {
+	m.Lock()
+	defer m.Unlock()
+	if !m.running {
+		return nil // stopped meanwhile
+	}
+	return m.profiler.(restarter).Restart()
+}
+
 // String returns human readable identification of Analyzer
 func (m *MongoAnalyzer) String() string {
 	return ""
@@ -156,3 +296,10 @@ type Profiler interface {
 	Stop() error
 	Status() map[string]string
 }
+
+// restarter is a Profiler which sets profiling, so it's restarted without
+// restoring profiling in between: MRMS would see it disabled and restart
+// the profiler again.
+type restarter interface {
+	Restart() error
+}
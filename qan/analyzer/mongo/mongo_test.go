@@ -7,6 +7,7 @@ import (
 
 	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/test/mock"
 	"github.com/percona/qan-agent/test/profiling"
@@ -106,6 +107,23 @@ func TestMongo_StartStopStatus(t *testing.T) {
 	assert.Equal(t, map[string]string{serviceName: "Not running"}, plugin.Status())
 }
 
+func TestMongo_DefaultFilters(t *testing.T) {
+	ctx := context.WithValue(context.Background(), "services", map[string]interface{}{})
+	plugin := New(ctx, proto.Instance{})
+
+	// Without database filters admin, local and config are excluded.
+	plugin.SetConfig(pc.QAN{})
+	defaults := plugin.GetDefaults("")
+	assert.Equal(t, []string{"admin", "local", "config"}, defaults["ExcludeDatabases"])
+	assert.Nil(t, defaults["IncludeDatabases"])
+
+	// Filters which are set are kept as is.
+	plugin.SetConfig(pc.QAN{IncludeDatabases: []string{"test"}})
+	defaults = plugin.GetDefaults("")
+	assert.Equal(t, []string{"test"}, defaults["IncludeDatabases"])
+	assert.Nil(t, defaults["ExcludeDatabases"])
+}
+
 // merge merges map[string]string maps
 func merge(maps ...map[string]string) map[string]string {
 	result := make(map[string]string)
@@ -2,6 +2,7 @@ package aggregator
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +13,7 @@ import (
 	mongostats "github.com/percona/percona-toolkit/src/go/mongolib/stats"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
 	"github.com/percona/qan-agent/qan/analyzer/report"
 )
@@ -32,22 +34,34 @@ func New(timeStart time.Time, config pc.QAN) *Aggregator {
 	}
 
 	aggregator := &Aggregator{
-		config: config,
+		config:  config,
+		amended: map[time.Time]*interval{},
 	}
 
 	// create duration from interval
 	aggregator.d = time.Duration(config.Interval) * time.Second
 
-	// create mongolib stats
-	fp := fingerprinter.NewFingerprinter(fingerprinter.DEFAULT_KEY_FILTERS)
-	aggregator.mongostats = mongostats.New(fp)
+	// late documents are accepted until GraceIntervals intervals have passed
+	aggregator.grace = time.Duration(config.GraceIntervals) * aggregator.d
 
-	// create new interval
-	aggregator.newInterval(timeStart)
+	// create fingerprinter for mongolib stats
+	aggregator.fp = fingerprinter.NewFingerprinter(fingerprinter.DEFAULT_KEY_FILTERS)
+
+	// documents before the first interval are skipped,
+	// e.g. 12:15:35 with 1 minute duration it will be 12:15:00
+	aggregator.timeStart = timeStart.UTC().Truncate(aggregator.d)
+	aggregator.closed = aggregator.timeStart
 
 	return aggregator
 }
 
+// interval aggregates documents from timeStart to timeEnd.
+type interval struct {
+	timeStart  time.Time
+	timeEnd    time.Time
+	mongostats *mongostats.Stats
+}
+
 // Aggregator aggregates system.profile document
 type Aggregator struct {
 	// dependencies
@@ -57,15 +71,27 @@ type Aggregator struct {
 	status *status.Status
 	stats  *stats
 
+	// dependencies from setter SetMember
+	member  string
+	replSet string
+	shard   string
+
+	// dependency from setter SetSampled
+	sampleInterval time.Duration
+
 	// provides
 	reportChan chan *qan.Report
 
-	// interval
-	timeStart  time.Time
-	timeEnd    time.Time
-	d          time.Duration
-	t          *time.Timer
-	mongostats *mongostats.Stats
+	// intervals
+	d         time.Duration
+	grace     time.Duration
+	t         *time.Timer
+	fp        *fingerprinter.Fingerprinter
+	timeStart time.Time               // of the first interval
+	closed    time.Time               // intervals ending before this are sent
+	watermark time.Time               // newest document
+	open      []*interval             // not sent yet, sorted by time
+	amended   map[time.Time]*interval // late documents for sent intervals
 
 	// state
 	sync.RWMutex                 // Lock() to protect internal consistency of the service
@@ -90,17 +116,41 @@ func (self *Aggregator) Add(doc proto.SystemProfile) error {
 		return nil
 	}
 
-	// if new doc is outside of interval then finish old interval and flush it
-	if !ts.Before(self.timeEnd) {
+	// if new doc is past the grace window of old intervals then finish them and flush them
+	if ts.After(self.watermark) {
+		self.watermark = ts
 		self.flush(ts)
 	}
 
 	// we had some activity so reset timer
 	self.t.Reset(self.d)
 
-	// add new doc to stats
+	// add new doc to stats of its interval,
+	// or amend the interval if it was already sent
+	i := self.interval(ts)
+	if i == nil {
+		self.stats.DocsLate.Add(1)
+		i = self.amend(ts)
+	}
 	self.stats.DocsIn.Add(1)
-	return self.mongostats.Add(doc)
+	self.updateStats()
+	return i.mongostats.Add(doc)
+}
+
+// SetMember sets the replica set or sharded cluster member
+// reports are tagged with. It must be called before Start.
+func (self *Aggregator) SetMember(member, replSet, shard string) {
+	self.member = member
+	self.replSet = replSet
+	self.shard = shard
+}
+
+// SetSampled marks reports as estimated from operations sampled every
+// interval, as opposed to exact. Query counts aren't extrapolated: they're
+// of the operations seen in samples, so operations shorter than interval
+// are undercounted. It must be called before Start.
+func (self *Aggregator) SetSampled(interval time.Duration) {
+	self.sampleInterval = interval
 }
 
 func (self *Aggregator) Start() <-chan *qan.Report {
@@ -119,6 +169,7 @@ func (self *Aggregator) Start() <-chan *qan.Report {
 	// set status
 	self.stats = &stats{}
 	self.status = status.New(self.stats)
+	self.updateStats()
 
 	// timeout after not receiving data for interval time
 	self.t = time.NewTimer(self.d)
@@ -152,6 +203,10 @@ func (self *Aggregator) Stop() {
 	// wait for goroutines to exit
 	self.wg.Wait()
 
+	// send open intervals, including the current one, and late documents
+	// so what was aggregated until now isn't lost
+	self.flushAll()
+
 	// close reportChan
 	close(self.reportChan)
 }
@@ -175,9 +230,6 @@ func start(
 	// signal WaitGroup when goroutine finished
 	defer wg.Done()
 
-	// update stats
-	stats.IntervalStart.Set(aggregator.TimeStart().Format("2006-01-02 15:04:05"))
-	stats.IntervalEnd.Set(aggregator.TimeEnd().Format("2006-01-02 15:04:05"))
 	for {
 		select {
 		case <-aggregator.t.C:
@@ -185,8 +237,7 @@ func start(
 			// is last sample in the collection until you get sample with higher timestamp than interval.
 			// For this, in cases where we generate only few test queries,
 			// but still expect them to show after interval expires, we need to implement timeout.
-			// This introduces another issue, that in case something goes wrong, and we get metrics for old interval too late, they will be skipped.
-			// A proper solution would be to allow fixing old samples, but API and qan-agent doesn't allow this, yet.
+			// Samples which come too late for their interval, even with GraceIntervals, are sent in amended reports.
 			aggregator.Flush()
 		case <-doneChan:
 			// Check if we should shutdown.
@@ -199,60 +250,149 @@ func (self *Aggregator) Flush() {
 	self.Lock()
 	defer self.Unlock()
 	self.flush(time.Now())
+	self.flushAmended()
+	self.updateStats()
+
+	// keep flushing while there are documents in the grace window
+	if len(self.open) > 0 {
+		self.t.Reset(self.d)
+	}
 }
 
-func (self *Aggregator) flush(ts time.Time) {
-	r := self.interval(ts)
-	if r != nil {
-		self.reportChan <- r
-		self.stats.ReportsOut.Add(1)
+// flush sends intervals which ended before the grace window of watermark.
+func (self *Aggregator) flush(watermark time.Time) {
+	n := 0
+	for _, i := range self.open {
+		if i.timeEnd.Add(self.grace).After(watermark) {
+			break
+		}
+		self.send(i, false)
+		self.closed = i.timeEnd
+		n++
+	}
+	self.open = self.open[n:]
+
+	// send late documents along with intervals so we don't send a report for each of them
+	if n > 0 {
+		self.flushAmended()
 	}
 }
 
-// interval sets interval if necessary and returns *qan.Report for old interval if not empty
-func (self *Aggregator) interval(ts time.Time) *qan.Report {
-	// create new interval
-	defer self.newInterval(ts)
+// flushAll sends all open intervals and late documents.
+func (self *Aggregator) flushAll() {
+	for _, i := range self.open {
+		self.send(i, false)
+		self.closed = i.timeEnd
+	}
+	self.open = nil
+	self.flushAmended()
+}
 
-	// let's check if we have anything to send for current interval
-	if len(self.mongostats.Queries()) == 0 {
-		// if there are no queries then we don't create report #PMM-927
-		return nil
+// flushAmended sends late documents of intervals which were sent already.
+func (self *Aggregator) flushAmended() {
+	starts := make([]time.Time, 0, len(self.amended))
+	for start := range self.amended {
+		starts = append(starts, start)
 	}
+	sort.Slice(starts, func(a, b int) bool { return starts[a].Before(starts[b]) })
+	for _, start := range starts {
+		self.send(self.amended[start], true)
+		delete(self.amended, start)
+	}
+}
+
+func (self *Aggregator) send(i *interval, amended bool) {
+	// if there are no queries then we don't create report #PMM-927
+	if len(i.mongostats.Queries()) == 0 {
+		return
+	}
+
+	// create result and translate it into report
+	result := self.createResult(i.mongostats)
+	r := report.MakeReport(self.config, i.timeStart, i.timeEnd, nil, result)
+
+	r.Member = self.member
+	r.ReplSet = self.replSet
+	r.Shard = self.shard
+	if self.sampleInterval > 0 {
+		r.Sampled = true
+		r.SampleInterval = uint(self.sampleInterval / time.Millisecond)
+	}
+
+	// the report adds to one sent before for the same interval
+	r.Amended = amended
+	if amended {
+		self.stats.ReportsAmended.Add(1)
+	}
+
+	self.reportChan <- r
+	self.stats.ReportsOut.Add(1)
+}
 
-	// create result
-	result := self.createResult()
+// interval returns open interval for ts, it creates one if necessary.
+// It returns nil if the interval for ts was already sent.
+func (self *Aggregator) interval(ts time.Time) *interval {
+	if ts.Before(self.closed) {
+		return nil
+	}
+	start := ts.Truncate(self.d)
+	n := sort.Search(len(self.open), func(k int) bool { return !self.open[k].timeStart.Before(start) })
+	if n < len(self.open) && self.open[n].timeStart.Equal(start) {
+		return self.open[n]
+	}
+	i := self.newInterval(start)
+	self.open = append(self.open, nil)
+	copy(self.open[n+1:], self.open[n:])
+	self.open[n] = i
+	return i
+}
 
-	// translate result into report and return it
-	return report.MakeReport(self.config, self.timeStart, self.timeEnd, nil, result)
+// amend returns interval for late documents at ts.
+func (self *Aggregator) amend(ts time.Time) *interval {
+	start := ts.Truncate(self.d)
+	i, ok := self.amended[start]
+	if !ok {
+		i = self.newInterval(start)
+		self.amended[start] = i
+	}
+	return i
 }
 
-// TimeStart returns start time for current interval
+// TimeStart returns start time for oldest interval not sent yet
 func (self *Aggregator) TimeStart() time.Time {
-	return self.timeStart
+	if len(self.open) > 0 {
+		return self.open[0].timeStart
+	}
+	return self.closed
 }
 
-// TimeEnd returns end time for current interval
+// TimeEnd returns end time for newest interval not sent yet
 func (self *Aggregator) TimeEnd() time.Time {
-	return self.timeEnd
+	if len(self.open) > 0 {
+		return self.open[len(self.open)-1].timeEnd
+	}
+	return self.closed.Add(self.d)
 }
 
-func (self *Aggregator) newInterval(ts time.Time) {
-	// reset stats
-	self.mongostats.Reset()
+func (self *Aggregator) updateStats() {
+	self.stats.IntervalStart.Set(self.TimeStart().Format("2006-01-02 15:04:05"))
+	self.stats.IntervalEnd.Set(self.TimeEnd().Format("2006-01-02 15:04:05"))
+}
 
-	// truncate to the duration e.g 12:15:35 with 1 minute duration it will be 12:15:00
-	self.timeStart = ts.UTC().Truncate(self.d)
-	// create ending time by adding interval
-	self.timeEnd = self.timeStart.Add(self.d)
+func (self *Aggregator) newInterval(start time.Time) *interval {
+	return &interval{
+		timeStart:  start,
+		timeEnd:    start.Add(self.d),
+		mongostats: mongostats.New(self.fp),
+	}
 }
 
-func (self *Aggregator) createResult() *report.Result {
-	queries := self.mongostats.Queries()
+func (self *Aggregator) createResult(mongostats *mongostats.Stats) *report.Result {
+	queries := mongostats.Queries()
 	global := event.NewClass("", "", false)
 	queryStats := queries.CalcQueriesStats(int64(self.config.Interval))
 	classes := []*event.Class{}
-	exampleQueries := boolValue(self.config.ExampleQueries)
+	exampleQueries := pct.BoolValue(self.config.ExampleQueries)
 	for _, queryInfo := range queryStats {
 		class := event.NewClass(queryInfo.ID, queryInfo.Fingerprint, exampleQueries)
 		if exampleQueries {
@@ -315,12 +455,3 @@ func newEventTimeStatsInMilliseconds(s mongostats.Statistics) *event.TimeStats {
 		Max: event.Float64(s.Max / 1000),
 	}
 }
-
-// boolValue returns the value of the bool pointer passed in or
-// false if the pointer is nil.
-func boolValue(v *bool) bool {
-	if v != nil {
-		return *v
-	}
-	return false
-}
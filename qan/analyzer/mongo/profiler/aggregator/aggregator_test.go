@@ -177,10 +177,14 @@ func TestAggregator_Add_EmptyInterval(t *testing.T) {
 		err := aggregator.Add(doc)
 		require.NoError(t, err)
 		aggregator.Stop()
+
+		// no report should be returned for the empty interval,
+		// only for the interval of the doc which is sent on stop
 		report, ok := <-reportChan
+		require.True(t, ok)
+		assert.Equal(t, timeEnd, report.StartTs)
+		report, ok = <-reportChan
 		assert.False(t, ok)
-
-		// no report should be returned
 		assert.Nil(t, report)
 	}
 }
@@ -207,3 +211,131 @@ func TestAggregator_StartStop(t *testing.T) {
 	aggregator.Stop()
 	aggregator.Stop()
 }
+
+func TestAggregator_GraceIntervals(t *testing.T) {
+	t.Parallel()
+
+	t0, err := time.Parse("2006-01-02 15:04:05", "2017-07-02 07:55:00")
+	require.NoError(t, err)
+	d := 60 * time.Second
+
+	config := pc.QAN{
+		UUID:           "abc",
+		Interval:       60, // 60s
+		GraceIntervals: 1,
+	}
+
+	aggregator := New(t0, config)
+	reportChan := aggregator.Start()
+	defer aggregator.Stop()
+
+	add := func(ts time.Time) {
+		err := aggregator.Add(proto.SystemProfile{Ts: ts, Millis: 1000})
+		require.NoError(t, err)
+	}
+	noReport := func() {
+		select {
+		case report := <-reportChan:
+			t.Error("didn't expect report but got:", report)
+		default:
+		}
+	}
+	report := func(start time.Time, queries uint, amended bool) {
+		select {
+		case r := <-reportChan:
+			assert.Equal(t, start, r.StartTs)
+			assert.Equal(t, start.Add(d), r.EndTs)
+			assert.Equal(t, queries, r.Global.TotalQueries)
+			assert.Equal(t, amended, r.Amended)
+		default:
+			t.Error("expected report for", start)
+		}
+	}
+
+	// first interval is kept open for one more interval
+	add(t0)
+	add(t0.Add(d + 10*time.Second))
+	noReport()
+
+	// so late doc is added to it
+	add(t0.Add(30 * time.Second))
+	noReport()
+
+	// until the watermark passes the grace window
+	add(t0.Add(2 * d))
+	report(t0, 2, false)
+	noReport()
+
+	// later docs are sent in amended report with the next interval
+	add(t0.Add(40 * time.Second))
+	noReport()
+	add(t0.Add(3 * d))
+	report(t0.Add(d), 1, false)
+	report(t0, 1, true)
+	noReport()
+
+	status := aggregator.Status()
+	assert.Equal(t, "1", status["docs-late"])
+	assert.Equal(t, "1", status["reports-amended"])
+}
+
+func TestAggregator_StopFlushes(t *testing.T) {
+	t.Parallel()
+
+	t0, err := time.Parse("2006-01-02 15:04:05", "2017-07-02 07:55:00")
+	require.NoError(t, err)
+	d := 60 * time.Second
+
+	config := pc.QAN{
+		UUID:           "abc",
+		Interval:       60, // 60s
+		GraceIntervals: 1,
+	}
+
+	aggregator := New(t0, config)
+	reportChan := aggregator.Start()
+
+	add := func(ts time.Time) {
+		err := aggregator.Add(proto.SystemProfile{Ts: ts, Millis: 1000})
+		require.NoError(t, err)
+	}
+
+	// first interval is sent, second is in the grace window, third is current,
+	// and a late doc amends the first
+	add(t0)
+	add(t0.Add(2 * d))
+	add(t0.Add(10 * time.Second))
+	add(t0.Add(d))
+	add(t0.Add(2*d + 10*time.Second))
+	r := <-reportChan
+	assert.Equal(t, t0, r.StartTs)
+
+	// open intervals and the amended one are sent on stop
+	aggregator.Stop()
+	got := []time.Time{}
+	amended := []bool{}
+	for r := range reportChan {
+		got = append(got, r.StartTs)
+		amended = append(amended, r.Amended)
+	}
+	assert.Equal(t, []time.Time{t0.Add(d), t0.Add(2 * d), t0}, got)
+	assert.Equal(t, []bool{false, false, true}, amended)
+}
+
+func TestAggregator_Sampled(t *testing.T) {
+	t.Parallel()
+
+	t0, err := time.Parse("2006-01-02 15:04:05", "2017-07-02 07:55:00")
+	require.NoError(t, err)
+
+	aggregator := New(t0, pc.QAN{UUID: "abc", Interval: 60})
+	aggregator.SetSampled(500 * time.Millisecond)
+	reportChan := aggregator.Start()
+	err = aggregator.Add(proto.SystemProfile{Ts: t0, Millis: 1000})
+	require.NoError(t, err)
+	aggregator.Stop()
+
+	r := <-reportChan
+	assert.True(t, r.Sampled)
+	assert.Equal(t, uint(500), r.SampleInterval)
+}
@@ -7,7 +7,9 @@ import (
 type stats struct {
 	DocsIn         *expvar.Int    `name:"docs-in"`
 	DocsSkippedOld *expvar.Int    `name:"docs-skipped-old"`
+	DocsLate       *expvar.Int    `name:"docs-late"`
 	ReportsOut     *expvar.Int    `name:"reports-out"`
+	ReportsAmended *expvar.Int    `name:"reports-amended"`
 	IntervalStart  *expvar.String `name:"interval-start"`
 	IntervalEnd    *expvar.String `name:"interval-end"`
 }
@@ -0,0 +1,42 @@
+// Package checkpoint reads and writes checkpoints of collectors, i.e. where
+// collecting continues after restart, as JSON files.
+package checkpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// Read reads checkpoint from file into cp. It leaves cp as is if file
+// is empty or doesn't exist.
+func Read(file string, cp interface{}) error {
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, cp)
+}
+
+// Write writes checkpoint cp to file, if it's not empty. The file is replaced
+// by rename so a crash doesn't leave a partially written checkpoint.
+func Write(file string, cp interface{}) error {
+	if file == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
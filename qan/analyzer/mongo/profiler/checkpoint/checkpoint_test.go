@@ -0,0 +1,41 @@
+package checkpoint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCheckpoint struct {
+	File   string
+	Offset int64
+}
+
+func TestReadWrite(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "checkpoint.json")
+
+	// no file, no checkpoint
+	cp := testCheckpoint{}
+	require.NoError(t, Read(file, &cp))
+	assert.Equal(t, testCheckpoint{}, cp)
+	require.NoError(t, Read("", &cp))
+	require.NoError(t, Write("", cp))
+
+	require.NoError(t, Write(file, testCheckpoint{File: "mongod.log", Offset: 100}))
+	require.NoError(t, Read(file, &cp))
+	assert.Equal(t, testCheckpoint{File: "mongod.log", Offset: 100}, cp)
+	_, err = os.Stat(file + ".tmp")
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("{"), 0600))
+	assert.Error(t, Read(file, &cp))
+}
@@ -0,0 +1,231 @@
+package profiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/pct"
+)
+
+// DiscoverInterval is how often members of replica set or sharded cluster are discovered.
+const DiscoverInterval = 1 * time.Minute
+
+// NewCluster returns profiler which collects queries from every member
+// of the replica set or sharded cluster dialInfo points to. Members are
+// discovered periodically, so members added or removed are handled
+// without restarting it.
+func NewCluster(
+	dialInfo *pmgo.DialInfo,
+	dialer pmgo.Dialer,
+	logger *pct.Logger,
+	spool data.Spooler,
+	config pc.QAN,
+) *cluster {
+	return &cluster{
+		dialInfo: dialInfo,
+		dialer:   dialer,
+		logger:   logger,
+		spool:    spool,
+		config:   config,
+		discover: discoverMembers,
+	}
+}
+
+type cluster struct {
+	// dependencies
+	dialInfo *pmgo.DialInfo
+	dialer   pmgo.Dialer
+	spool    data.Spooler
+	logger   *pct.Logger
+	config   pc.QAN
+
+	// internal deps
+	discover  func(*pmgo.DialInfo, pmgo.Dialer) ([]member, error)
+	profilers map[member]*profiler
+
+	// state
+	sync.RWMutex                 // Lock() to protect internal consistency of the service
+	running      bool            // Is this service running?
+	doneChan     chan struct{}   // close(doneChan) to notify goroutines that they should shutdown
+	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// Start starts analyzer but doesn't wait until it exits
+func (self *cluster) Start() error {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil
+	}
+
+	members, err := self.discover(self.dialInfo, self.dialer)
+	if err != nil {
+		return err
+	}
+	self.profilers = map[member]*profiler{}
+	self.update(members)
+
+	// create new channel over which
+	// we will tell goroutine it should close
+	self.doneChan = make(chan struct{})
+
+	// start a goroutine and Add() it to WaitGroup
+	// so we could later Wait() for it to finish
+	self.wg = &sync.WaitGroup{}
+	self.wg.Add(1)
+	go self.run(self.wg, self.doneChan)
+
+	self.running = true
+	return nil
+}
+
+// Status returns list of statuses
+func (self *cluster) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	statuses := map[string]string{}
+	addrs := []string{}
+	for m, p := range self.profilers {
+		for k, v := range p.Status() {
+			statuses[fmt.Sprintf("%s-%s", m.Addr, k)] = v
+		}
+		addrs = append(addrs, m.Addr)
+	}
+	sort.Strings(addrs)
+	statuses["members"] = strings.Join(addrs, ", ")
+	return statuses
+}
+
+// Stop stops running analyzer, waits until it stops
+func (self *cluster) Stop() error {
+	self.Lock()
+	if !self.running {
+		self.Unlock()
+		return nil
+	}
+
+	// set state to "not running" and take the profilers,
+	// so run doesn't update them anymore
+	self.running = false
+	profilers := self.profilers
+	self.profilers = nil
+
+	// notify goroutine to close
+	close(self.doneChan)
+	wg := self.wg
+	self.Unlock()
+
+	// wait for goroutine to exit; don't hold the lock as
+	// goroutine takes it after discovering members
+	wg.Wait()
+
+	// stop all profilers; do it after goroutine is closed
+	for m, p := range profilers {
+		p.Stop()
+		self.logger.Info("Stopped collecting queries from", m.Addr)
+	}
+	return nil
+}
+
+// Restart restarts the profilers of all members without restoring
+// profiling in between, see profiler.Restart.
+func (self *cluster) Restart() error {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return nil
+	}
+	for m, p := range self.profilers {
+		if err := p.Restart(); err != nil {
+			return fmt.Errorf("cannot restart profiler on %s: %s", m.Addr, err)
+		}
+	}
+	return nil
+}
+
+// run periodically discovers members.
+func (self *cluster) run(wg *sync.WaitGroup, doneChan <-chan struct{}) {
+	// signal WaitGroup when goroutine finished
+	defer wg.Done()
+
+	for {
+		// check if we should shutdown
+		select {
+		case <-doneChan:
+			return
+		case <-time.After(DiscoverInterval):
+			// just continue after delay if not
+		}
+
+		members, err := self.discover(self.dialInfo, self.dialer)
+		if err != nil {
+			// keep collecting from members we know
+			self.logger.Warn("Cannot discover members:", err)
+			continue
+		}
+
+		// discovering takes a while, so check again if we should shutdown
+		select {
+		case <-doneChan:
+			return
+		default:
+		}
+
+		// Stop may close doneChan while we wait for the lock,
+		// it's closed under the lock, so check it once more
+		self.Lock()
+		select {
+		case <-doneChan:
+			self.Unlock()
+			return
+		default:
+		}
+		self.update(members)
+		self.Unlock()
+	}
+}
+
+// update starts profilers of new members and stops profilers of removed members.
+// Profilers which fail to start are retried on next update.
+func (self *cluster) update(members []member) {
+	current := map[member]bool{}
+	for _, m := range members {
+		current[m] = true
+		if _, ok := self.profilers[m]; ok {
+			continue
+		}
+		p := New(
+			copyDialInfo(self.dialInfo, []string{m.Addr}),
+			self.dialer,
+			self.logger,
+			self.spool,
+			self.config,
+		)
+		p.SetMember(m)
+		if err := p.Start(); err != nil {
+			self.logger.Warn(fmt.Sprintf("Cannot start profiler on %s: %s", m.Addr, err))
+			continue
+		}
+		self.logger.Info("Collecting queries from", m.Addr)
+		self.profilers[m] = p
+	}
+
+	for m, p := range self.profilers {
+		if current[m] {
+			continue
+		}
+		p.Stop()
+		delete(self.profilers, m)
+		self.logger.Info("Stopped collecting queries from", m.Addr)
+	}
+}
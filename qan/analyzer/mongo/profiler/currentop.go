@@ -0,0 +1,179 @@
+package profiler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/parser"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/sampler"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/sender"
+)
+
+// NewCurrentOpProfiler returns profiler which samples in-flight operations
+// with $currentOp instead of reading system.profile, for deployments where
+// profiling can't be enabled. Reports are estimates and flagged as sampled.
+func NewCurrentOpProfiler(
+	dialInfo *pmgo.DialInfo,
+	dialer pmgo.Dialer,
+	logger *pct.Logger,
+	spool data.Spooler,
+	config pc.QAN,
+) *currentOpProfiler {
+	return &currentOpProfiler{
+		dialInfo: dialInfo,
+		dialer:   dialer,
+		logger:   logger,
+		spool:    spool,
+		config:   config,
+	}
+}
+
+type currentOpProfiler struct {
+	// dependencies
+	dialInfo *pmgo.DialInfo
+	dialer   pmgo.Dialer
+	spool    data.Spooler
+	logger   *pct.Logger
+	config   pc.QAN
+
+	// internal deps
+	session    pmgo.SessionManager
+	sampler    *sampler.Sampler
+	parser     *parser.Parser
+	aggregator *aggregator.Aggregator
+	sender     *sender.Sender
+
+	// state
+	sync.RWMutex      // Lock() to protect internal consistency of the service
+	running      bool // Is this service running?
+}
+
+// Start starts analyzer but doesn't wait until it exits
+func (self *currentOpProfiler) Start() (err error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil
+	}
+
+	databases, namespaces, err := newFilters(self.config)
+	if err != nil {
+		return err
+	}
+
+	// create new session
+	self.session, err = createSession(self.dialInfo, self.dialer)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// if we failed to start be sure that any started internal service is shutdown
+		if err != nil {
+			self.stop()
+		}
+	}()
+
+	// create aggregator which collects documents and aggregates them into qan report
+	interval := time.Duration(self.config.SampleInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = sampler.DefaultInterval
+	}
+	self.aggregator = aggregator.New(time.Now(), self.config)
+	self.aggregator.SetSampled(interval)
+	reportChan := self.aggregator.Start()
+
+	// create sender which sends qan reports and start it
+	self.sender = sender.New(reportChan, self.spool, self.logger)
+	if err = self.sender.Start(); err != nil {
+		return err
+	}
+
+	// create sampler which samples in-flight operations
+	self.sampler = sampler.New(self.session, interval)
+	docsChan, err := self.sampler.Start()
+	if err != nil {
+		return err
+	}
+
+	// create parser which passes sampled operations to aggregator
+	self.parser = parser.New(docsChan, self.aggregator)
+	self.parser.SetFilter(databases, namespaces)
+	if err = self.parser.Start(); err != nil {
+		return err
+	}
+
+	self.running = true
+	return nil
+}
+
+// Status returns list of statuses
+func (self *currentOpProfiler) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	statuses := map[string]string{}
+	for k, v := range self.sampler.Status() {
+		statuses[fmt.Sprintf("%s-%s", self.sampler.Name(), k)] = v
+	}
+	for k, v := range self.parser.Status() {
+		statuses[fmt.Sprintf("%s-%s", self.parser.Name(), k)] = v
+	}
+	for k, v := range self.aggregator.Status() {
+		statuses[fmt.Sprintf("%s-%s", "aggregator", k)] = v
+	}
+	for k, v := range self.sender.Status() {
+		statuses[fmt.Sprintf("%s-%s", "sender", k)] = v
+	}
+	statuses["servers"] = strings.Join(self.session.LiveServers(), ", ")
+	return statuses
+}
+
+// Stop stops running analyzer, waits until it stops
+func (self *currentOpProfiler) Stop() error {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return nil
+	}
+
+	self.stop()
+
+	// set state to "not running"
+	self.running = false
+	return nil
+}
+
+// stop stops internal services in order data flows through them.
+func (self *currentOpProfiler) stop() {
+	if self.sampler != nil {
+		self.sampler.Stop()
+		self.sampler = nil
+	}
+	if self.parser != nil {
+		self.parser.Stop()
+		self.parser = nil
+	}
+	if self.aggregator != nil {
+		self.aggregator.Stop()
+		self.aggregator = nil
+	}
+	if self.sender != nil {
+		self.sender.Stop()
+		self.sender = nil
+	}
+	if self.session != nil {
+		self.session.Close()
+		self.session = nil
+	}
+}
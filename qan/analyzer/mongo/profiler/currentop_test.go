@@ -0,0 +1,117 @@
+package profiler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/pmgo"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// sampleDialer dials a server where $currentOp returns the next of samples,
+// and no operations once samples run out.
+type sampleDialer struct {
+	pmgo.Dialer
+	session *sampleSession
+}
+
+func (d *sampleDialer) DialWithInfo(*pmgo.DialInfo) (pmgo.SessionManager, error) {
+	return d.session, nil
+}
+
+type sampleSession struct {
+	pmgo.SessionManager
+	sync.Mutex
+	samples [][]bson.M
+}
+
+func (s *sampleSession) SetMode(mgo.Mode, bool)         {}
+func (s *sampleSession) SetSyncTimeout(time.Duration)   {}
+func (s *sampleSession) SetSocketTimeout(time.Duration) {}
+func (s *sampleSession) Close()                         {}
+func (s *sampleSession) Copy() pmgo.SessionManager      { return s }
+func (s *sampleSession) LiveServers() []string          { return []string{"localhost:27017"} }
+func (s *sampleSession) DB(string) pmgo.DatabaseManager { return &sampleDatabase{session: s} }
+
+func (s *sampleSession) run(cmd, result interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	ops := []bson.M{}
+	if len(s.samples) > 0 {
+		ops = s.samples[0]
+		s.samples = s.samples[1:]
+	}
+	data, err := bson.Marshal(bson.M{"cursor": bson.M{"firstBatch": ops}})
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+type sampleDatabase struct {
+	pmgo.DatabaseManager
+	session *sampleSession
+}
+
+func (d *sampleDatabase) Run(cmd, result interface{}) error { return d.session.run(cmd, result) }
+
+func TestCurrentOpProfiler(t *testing.T) {
+	find := bson.M{
+		"opid":              int64(1),
+		"active":            true,
+		"op":                "query",
+		"ns":                "test.people",
+		"command":           bson.D{{Name: "find", Value: "people"}, {Name: "filter", Value: bson.M{"name": "Kamil"}}},
+		"microsecs_running": int64(50000),
+	}
+	dialer := &sampleDialer{
+		session: &sampleSession{samples: [][]bson.M{{find}, {find}}},
+	}
+	dialInfo := &pmgo.DialInfo{Addrs: []string{"localhost:27017"}}
+	logChan := make(chan proto.LogEntry, 100)
+	logger := pct.NewLogger(logChan, "currentop")
+	dataChan := make(chan interface{}, 10)
+	spool := mock.NewSpooler(dataChan)
+	qanConfig := config.QAN{
+		UUID:           "12345678",
+		Interval:       1,  // seconds
+		SampleInterval: 50, // milliseconds
+	}
+	p := NewCurrentOpProfiler(dialInfo, dialer, logger, spool, qanConfig)
+
+	assert.Empty(t, p.Status())
+	err := p.Start()
+	require.NoError(t, err)
+
+	// operation seen in two samples is reported once, flagged as sampled
+	select {
+	case data := <-dataChan:
+		qanReport := data.(*qan.Report)
+		assert.True(t, qanReport.Sampled)
+		assert.EqualValues(t, 50, qanReport.SampleInterval)
+		assert.EqualValues(t, 1, qanReport.Global.TotalQueries)
+		assert.EqualValues(t, 1, qanReport.Global.UniqueQueries)
+		require.Len(t, qanReport.Class, 1)
+		assert.Equal(t, "FIND people name", qanReport.Class[0].Fingerprint)
+	case <-time.After(5 * time.Duration(qanConfig.Interval) * time.Second):
+		t.Fatal("timeout waiting for data")
+	}
+
+	status := p.Status()
+	assert.Equal(t, "localhost:27017", status["servers"])
+	assert.Equal(t, "1", status["sampler-out"])
+	assert.Equal(t, "1", status["aggregator-docs-in"])
+
+	err = p.Stop()
+	require.NoError(t, err)
+	assert.Empty(t, p.Status())
+}
@@ -0,0 +1,45 @@
+package logcollector
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// HeadSize is how many bytes from the beginning of the log we keep
+// to recognize the file after restart; mongod starts every log with
+// a timestamped line so it's unique for every rotated file.
+const HeadSize = 128
+
+// Checkpoint is the position in the log up to which entries were read.
+type Checkpoint struct {
+	File   string
+	Head   []byte
+	Offset int64
+	Ts     time.Time // of the last slow query read
+}
+
+// readHead returns the first HeadSize bytes of the file, or less if it's shorter.
+func readHead(f *os.File) ([]byte, error) {
+	head := make([]byte, HeadSize)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// resumes returns true if reading of file f can continue from checkpoint,
+// i.e. it's the same file and it wasn't truncated in meantime.
+func (cp Checkpoint) resumes(file string, head []byte, size int64) bool {
+	if cp.File != file || cp.Offset > size || len(cp.Head) == 0 {
+		return false
+	}
+	// The head grows until it's HeadSize long.
+	n := len(cp.Head)
+	if len(head) < n {
+		return false
+	}
+	return bytes.Equal(cp.Head, head[:n])
+}
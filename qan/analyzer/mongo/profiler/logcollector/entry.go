@@ -0,0 +1,93 @@
+package logcollector
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+)
+
+// SlowQueryMsg is the message of structured log entries (MongoDB 4.4+)
+// that describe slow operations, see log component COMMAND id 51803.
+const SlowQueryMsg = "Slow query"
+
+// entry is a structured mongod log line, we only decode what we need.
+type entry struct {
+	T struct {
+		Date time.Time `json:"$date"`
+	} `json:"t"`
+	Msg  string    `json:"msg"`
+	Attr slowQuery `json:"attr"`
+}
+
+// slowQuery is the "attr" of "Slow query" log entry.
+type slowQuery struct {
+	Type               string      `json:"type"`
+	Ns                 string      `json:"ns"`
+	AppName            string      `json:"appName"`
+	Command            proto.BsonD `json:"command"`
+	OriginatingCommand proto.BsonD `json:"originatingCommand"`
+	PlanSummary        string      `json:"planSummary"`
+	KeysExamined       int         `json:"keysExamined"`
+	DocsExamined       int         `json:"docsExamined"`
+	NReturned          int         `json:"nreturned"`
+	NumYields          int         `json:"numYields"`
+	Reslen             int         `json:"reslen"`
+	WriteConflicts     int         `json:"writeConflicts"`
+	Protocol           string      `json:"protocol"`
+	DurationMillis     int         `json:"durationMillis"`
+	Remote             string      `json:"remote"`
+}
+
+// Parse parses single line of mongod log.
+// It returns false if the line is valid but it's not a slow query.
+func Parse(line []byte) (proto.SystemProfile, bool, error) {
+	doc := proto.SystemProfile{}
+
+	e := entry{}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return doc, false, fmt.Errorf("cannot parse log line: %s", err)
+	}
+	if e.Msg != SlowQueryMsg || e.Attr.Ns == "" {
+		return doc, false, nil
+	}
+
+	doc.Ts = e.T.Date
+	doc.Ns = e.Attr.Ns
+	doc.Op = op(e.Attr.Type, e.Attr.Command)
+	doc.Command = e.Attr.Command
+	doc.OriginatingCommand = e.Attr.OriginatingCommand
+	doc.Millis = e.Attr.DurationMillis
+	doc.KeysExamined = e.Attr.KeysExamined
+	doc.DocsExamined = e.Attr.DocsExamined
+	doc.Nreturned = e.Attr.NReturned
+	doc.NumYield = e.Attr.NumYields
+	doc.ResponseLength = e.Attr.Reslen
+	doc.WriteConflicts = e.Attr.WriteConflicts
+	doc.Protocol = e.Attr.Protocol
+	doc.Client = e.Attr.Remote
+	return doc, true, nil
+}
+
+// op translates type of logged operation to "op" field of system.profile.
+// Commands are logged with type "command" but the profiler reports
+// finds, getMores and inserts as separate operations.
+func op(typ string, command proto.BsonD) string {
+	switch typ {
+	case "update", "remove", "insert", "query", "getmore":
+		return typ
+	}
+	if command.Len() == 0 {
+		return "command"
+	}
+	switch command[0].Name {
+	case "find":
+		return "query"
+	case "getMore":
+		return "getmore"
+	case "insert":
+		return "insert"
+	}
+	return "command"
+}
@@ -0,0 +1,49 @@
+package logcollector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	findLine   = `{"t":{"$date":"2020-09-25T12:10:08.731+00:00"},"s":"I",  "c":"COMMAND",  "id":51803,   "ctx":"conn12","msg":"Slow query","attr":{"type":"command","ns":"test.coll","appName":"MongoDB Shell","command":{"find":"coll","filter":{"a":{"$gt":5}},"lsid":{"id":{"$uuid":"0a6bbb5e-a3ad-4a8c-9ffe-1b1d8a9ee1e1"}},"$db":"test"},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":1000,"cursorExhausted":true,"numYields":1,"nreturned":10,"reslen":459,"locks":{},"protocol":"op_msg","durationMillis":120}}`
+	updateLine = `{"t":{"$date":"2020-09-25T12:10:09.000+00:00"},"s":"I",  "c":"WRITE",    "id":51803,   "ctx":"conn12","msg":"Slow query","attr":{"type":"update","ns":"test.coll","command":{"q":{"a":1},"u":{"$set":{"b":2}},"multi":false,"upsert":false},"planSummary":"COLLSCAN","keysExamined":0,"docsExamined":1000,"nMatched":1,"nModified":1,"numYields":0,"locks":{},"durationMillis":101}}`
+	otherLine  = `{"t":{"$date":"2020-09-25T12:10:10.000+00:00"},"s":"I",  "c":"NETWORK",  "id":22943,   "ctx":"listener","msg":"Connection accepted","attr":{"remote":"127.0.0.1:53422","connectionCount":2}}`
+)
+
+func TestParse(t *testing.T) {
+	doc, ok, err := Parse([]byte(findLine))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "query", doc.Op)
+	assert.Equal(t, "test.coll", doc.Ns)
+	assert.Equal(t, 120, doc.Millis)
+	assert.Equal(t, 1000, doc.DocsExamined)
+	assert.Equal(t, 10, doc.Nreturned)
+	assert.Equal(t, 459, doc.ResponseLength)
+	assert.Equal(t, 1, doc.NumYield)
+	assert.Equal(t, "op_msg", doc.Protocol)
+	assert.True(t, doc.Ts.Equal(time.Date(2020, 9, 25, 12, 10, 8, 731000000, time.UTC)))
+	require.True(t, doc.Command.Len() > 0)
+	assert.Equal(t, "find", doc.Command[0].Name) // key order is kept
+	assert.Equal(t, "coll", doc.Command[0].Value)
+
+	doc, ok, err = Parse([]byte(updateLine))
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "update", doc.Op)
+	assert.Equal(t, "q", doc.Command[0].Name)
+	assert.Equal(t, 101, doc.Millis)
+
+	_, ok, err = Parse([]byte(otherLine))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Before 4.4 mongod logs plain text.
+	_, ok, err = Parse([]byte("2020-09-25T12:10:08.731+0000 I COMMAND  [conn12] command test.coll ... 120ms"))
+	assert.Error(t, err)
+	assert.False(t, ok)
+}
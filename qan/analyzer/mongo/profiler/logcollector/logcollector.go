@@ -0,0 +1,324 @@
+package logcollector
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/checkpoint"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
+)
+
+const (
+	PollInterval = 1 * time.Second
+)
+
+// New returns Collector which reads slow queries from mongod log file.
+// Position in the log after the last slow query spooled, see Written, is
+// saved to checkpointFile, if it's not empty, so collecting continues where
+// it stopped.
+func New(file, checkpointFile string) *Collector {
+	return &Collector{
+		file:           file,
+		checkpointFile: checkpointFile,
+	}
+}
+
+type Collector struct {
+	// dependencies
+	file           string
+	checkpointFile string
+
+	// provides
+	docsChan chan proto.SystemProfile
+
+	// status
+	status *status.Status
+	since  time.Time
+
+	// state
+	sync.RWMutex                 // Lock() to protect internal consistency of the service
+	running      bool            // Is this service running?
+	positions    *positions      // of the last Start()
+	doneChan     chan struct{}   // close(doneChan) to notify goroutines that they should shutdown
+	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// Start starts but doesn't wait until it exits
+func (self *Collector) Start() (<-chan proto.SystemProfile, error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil, nil
+	}
+
+	if self.file == "" {
+		return nil, fmt.Errorf("mongod log file is not set")
+	}
+
+	// set status
+	stats := &stats{}
+	self.status = status.New(stats)
+	stats.File.Set(self.file)
+
+	// a broken checkpoint isn't fatal, we just start at the end of the log
+	cp := Checkpoint{}
+	if err := checkpoint.Read(self.checkpointFile, &cp); err != nil {
+		stats.CheckpointErr.Set(err.Error())
+		cp = Checkpoint{}
+	}
+	self.since = cp.Ts
+
+	// open the log now so we collect everything logged after Start(),
+	// if it's not there yet we retry in the goroutine
+	t := newTail(self.file, cp)
+	if err := t.open(); err != nil {
+		stats.ReadErrCount.Add(1)
+		stats.ReadErrLast.Set(err.Error())
+	}
+
+	// create new channels over which we will communicate to...
+	// ... outside world by sending collected docs, unbuffered so a doc
+	// sent is accepted by the downstream and not lost in the channel on stop
+	self.docsChan = make(chan proto.SystemProfile)
+	// ... inside goroutine to close it
+	self.doneChan = make(chan struct{})
+
+	self.positions = &positions{
+		checkpointFile: self.checkpointFile,
+		stats:          stats,
+	}
+
+	// start a goroutine and Add() it to WaitGroup
+	// so we could later Wait() for it to finish
+	self.wg = &sync.WaitGroup{}
+	self.wg.Add(1)
+	go start(
+		self.wg,
+		t,
+		self.positions,
+		self.docsChan,
+		self.doneChan,
+		stats,
+	)
+
+	self.running = true
+	return self.docsChan, nil
+}
+
+// Since returns time of the last slow query read before the collector
+// was started, or zero time if collecting starts at the end of the log.
+func (self *Collector) Since() time.Time {
+	self.RLock()
+	defer self.RUnlock()
+	return self.since
+}
+
+// Stop stops running
+func (self *Collector) Stop() {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return
+	}
+	self.running = false
+
+	// notify goroutine to close
+	close(self.doneChan)
+
+	// wait for goroutines to exit
+	self.wg.Wait()
+
+	// we can now safely close channels goroutines write to as goroutine is stopped
+	close(self.docsChan)
+	return
+}
+
+func (self *Collector) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	return self.status.Map()
+}
+
+func (self *Collector) Name() string {
+	return "log-collector"
+}
+
+// Written must be called with every report after its spool write, it saves
+// the position after the last slow query in report if it was spooled. It may
+// be called after Stop for reports which were sent before it.
+func (self *Collector) Written(report *qan.Report, err error) {
+	self.RLock()
+	p := self.positions
+	self.RUnlock()
+	p.written(report, err)
+}
+
+func start(
+	wg *sync.WaitGroup,
+	t *tail,
+	p *positions,
+	docsChan chan<- proto.SystemProfile,
+	doneChan <-chan struct{},
+	stats *stats,
+) {
+	// signal WaitGroup when goroutine finished
+	defer wg.Done()
+
+	// close the log; docs read but not sent, or sent but not spooled, are
+	// read again after restart because their position isn't saved
+	defer t.close()
+
+	// save where we start, e.g. at the end of the log without a checkpoint,
+	// so docs logged from now on are read after restart
+	p.skipped(t.checkpoint())
+
+	for {
+		if !collect(t, p, docsChan, doneChan, stats) {
+			return
+		}
+
+		select {
+		// check if we should shutdown
+		case <-doneChan:
+			return
+		// wait for mongod to write more
+		case <-time.After(PollInterval):
+		}
+	}
+}
+
+// collect sends all slow queries logged since last call and records their
+// positions in p, it returns false if we should shutdown.
+func collect(
+	t *tail,
+	p *positions,
+	docsChan chan<- proto.SystemProfile,
+	doneChan <-chan struct{},
+	stats *stats,
+) bool {
+	for {
+		// check if we should shutdown
+		select {
+		case <-doneChan:
+			return false
+		default:
+			// just continue if not
+		}
+
+		line, err := t.next()
+		if err == io.EOF {
+			p.skipped(t.checkpoint())
+			return true
+		}
+		if err != nil {
+			stats.ReadErrCount.Add(1)
+			stats.ReadErrLast.Set(err.Error())
+			return true
+		}
+		stats.LinesIn.Add(1)
+
+		doc, ok, err := Parse(line)
+		if err != nil {
+			stats.ParseErrCount.Add(1)
+			stats.ParseErrLast.Set(err.Error())
+			p.skipped(t.checkpoint())
+			continue
+		}
+		if !ok {
+			p.skipped(t.checkpoint())
+			continue
+		}
+		stats.In.Add(1)
+		t.cp.Ts = doc.Ts
+
+		// try to push doc or exit if we should shutdown
+		select {
+		case docsChan <- doc:
+			stats.Out.Add(1)
+			p.sent(doc.Ts, t.checkpoint())
+		case <-doneChan:
+			return false
+		}
+	}
+}
+
+// positions saves the position in the log after the last slow query which
+// is in a spooled report. Positions after lines which aren't slow queries
+// are saved right away unless slow queries before them aren't spooled yet.
+type positions struct {
+	checkpointFile string
+	stats          *stats
+	// --
+	pending []pending // slow queries sent but not spooled yet, in order they were sent
+	saved   Checkpoint
+	sync.Mutex
+}
+
+type pending struct {
+	ts time.Time  // of the slow query
+	cp Checkpoint // after it and the lines which aren't slow queries after it
+}
+
+// sent records the position after a slow query sent downstream.
+func (p *positions) sent(ts time.Time, cp Checkpoint) {
+	p.Lock()
+	defer p.Unlock()
+	p.pending = append(p.pending, pending{ts: ts, cp: cp})
+}
+
+// skipped records the position after lines which aren't slow queries.
+func (p *positions) skipped(cp Checkpoint) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.pending) == 0 {
+		p.save(cp)
+		return
+	}
+	p.pending[len(p.pending)-1].cp = cp
+}
+
+// written saves the position after the last slow query in report if it was
+// spooled. The aggregator reports slow queries by time, so those before the
+// end of the report are in it or in reports written before.
+func (p *positions) written(report *qan.Report, err error) {
+	if err != nil {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	n := 0
+	for n < len(p.pending) && p.pending[n].ts.Before(report.EndTs) {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	p.save(p.pending[n-1].cp)
+	p.pending = p.pending[n:]
+}
+
+// save writes the checkpoint cp if the position in the log changed since last save.
+func (p *positions) save(cp Checkpoint) {
+	// Caller must lock p.
+	p.stats.Offset.Set(cp.Offset)
+	if cp.File == "" {
+		return // never opened
+	}
+	if cp.Offset == p.saved.Offset && bytes.Equal(cp.Head, p.saved.Head) {
+		return
+	}
+	if err := checkpoint.Write(p.checkpointFile, cp); err != nil {
+		p.stats.CheckpointErr.Set(err.Error())
+		return
+	}
+	p.saved = cp
+}
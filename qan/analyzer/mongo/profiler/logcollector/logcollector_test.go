@@ -0,0 +1,119 @@
+package logcollector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/proto/qan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector_StartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollector")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mongod.log")
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+
+	appendLog(t, file, otherLine+"\n")
+	c := New(file, checkpointFile)
+	docsChan, err := c.Start()
+	require.NoError(t, err)
+	assert.True(t, c.Since().IsZero())
+
+	appendLog(t, file, otherLine+"\n"+findLine+"\n")
+	select {
+	case doc := <-docsChan:
+		assert.Equal(t, "test.coll", doc.Ns)
+		assert.Equal(t, "query", doc.Op)
+		c.Written(&qan.Report{EndTs: doc.Ts.Add(time.Second)}, nil)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow query not collected")
+	}
+	c.Stop()
+	_, ok := <-docsChan
+	assert.False(t, ok, "docsChan closed")
+
+	// Queries logged while collector was stopped are collected
+	// and Since is the time of the last collected query.
+	appendLog(t, file, updateLine+"\n")
+	c = New(file, checkpointFile)
+	docsChan, err = c.Start()
+	require.NoError(t, err)
+	defer c.Stop()
+	assert.True(t, c.Since().Equal(time.Date(2020, 9, 25, 12, 10, 8, 731000000, time.UTC)))
+	select {
+	case doc := <-docsChan:
+		assert.Equal(t, "update", doc.Op)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow query not collected")
+	}
+
+	status := c.Status()
+	assert.Contains(t, status["file"], file)
+	assert.Equal(t, "1", status["in"])
+}
+
+func TestCollector_NoFile(t *testing.T) {
+	_, err := New("", "").Start()
+	assert.Error(t, err)
+}
+
+func TestCollector_CheckpointWritten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollector")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mongod.log")
+	checkpointFile := filepath.Join(dir, "checkpoint.json")
+
+	appendLog(t, file, otherLine+"\n")
+	c := New(file, checkpointFile)
+	_, err = c.Start()
+	require.NoError(t, err)
+
+	// The slow query is read but nobody receives it before stop,
+	// so it must be collected after restart.
+	appendLog(t, file, findLine+"\n")
+	for i := 0; c.Status()["in"] != "1"; i++ {
+		require.True(t, i < 500, "slow query not read")
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Stop()
+
+	// It's received, but its report isn't spooled, so again.
+	for _, spoolErr := range []error{fmt.Errorf("spool is full"), nil} {
+		c = New(file, checkpointFile)
+		docsChan, err := c.Start()
+		require.NoError(t, err)
+		var ts time.Time
+		select {
+		case doc := <-docsChan:
+			assert.Equal(t, "query", doc.Op)
+			ts = doc.Ts
+		case <-time.After(5 * time.Second):
+			t.Fatal("slow query not collected after restart")
+		}
+		c.Written(&qan.Report{EndTs: ts}, nil) // report before the query
+		c.Written(&qan.Report{EndTs: ts.Add(time.Second)}, spoolErr)
+		c.Stop()
+	}
+
+	// Its report is spooled, so it's not collected again, but what's
+	// logged after it is.
+	appendLog(t, file, updateLine+"\n")
+	c = New(file, checkpointFile)
+	docsChan, err := c.Start()
+	require.NoError(t, err)
+	defer c.Stop()
+	select {
+	case doc := <-docsChan:
+		assert.Equal(t, "update", doc.Op)
+	case <-time.After(5 * time.Second):
+		t.Fatal("slow query not collected after restart")
+	}
+}
@@ -0,0 +1,18 @@
+package logcollector
+
+import (
+	"expvar"
+)
+
+type stats struct {
+	LinesIn       *expvar.Int    `name:"lines-in"`
+	In            *expvar.Int    `name:"in"`
+	Out           *expvar.Int    `name:"out"`
+	File          *expvar.String `name:"file"`
+	Offset        *expvar.Int    `name:"offset"`
+	ParseErrLast  *expvar.String `name:"parse-err-last"`
+	ParseErrCount *expvar.Int    `name:"parse-err-counter"`
+	ReadErrLast   *expvar.String `name:"read-err-last"`
+	ReadErrCount  *expvar.Int    `name:"read-err-counter"`
+	CheckpointErr *expvar.String `name:"checkpoint-err-last"`
+}
@@ -0,0 +1,160 @@
+package logcollector
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// tail reads complete lines from the log, following it when it's rotated.
+// It's not safe for concurrent use.
+type tail struct {
+	file    string
+	f       *os.File
+	r       *bufio.Reader
+	pending []byte     // incomplete last line
+	cp      Checkpoint // cp.Offset is the end of the last complete line
+	opened  bool       // was the file opened before?
+}
+
+func newTail(file string, cp Checkpoint) *tail {
+	return &tail{
+		file: file,
+		cp:   cp,
+	}
+}
+
+// open opens the log and resumes from the checkpoint if it's the same file.
+// Without a checkpoint it starts at the end of the log so we don't report
+// old queries, and new files, e.g. after rotation, are read from the beginning.
+func (t *tail) open() error {
+	f, err := os.Open(t.file)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	head, err := readHead(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	var offset int64
+	switch {
+	case t.cp.resumes(t.file, head, fi.Size()):
+		offset = t.cp.Offset
+	case !t.opened && t.cp.File == "":
+		offset = fi.Size()
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+
+	t.close()
+	t.f = f
+	t.r = bufio.NewReader(f)
+	t.pending = nil
+	t.cp.File = t.file
+	t.cp.Head = head
+	t.cp.Offset = offset
+	t.opened = true
+	return nil
+}
+
+// next returns next complete line, or io.EOF if there is none yet.
+func (t *tail) next() ([]byte, error) {
+	if t.f == nil {
+		if err := t.open(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		line, err := t.r.ReadBytes('\n')
+		if err == nil {
+			line = append(t.pending, line...)
+			t.pending = nil
+			t.cp.Offset += int64(len(line))
+			return line, nil
+		}
+		if err != io.EOF {
+			t.close()
+			return nil, err
+		}
+		t.pending = append(t.pending, line...)
+
+		// We are at the end of the log, check if it was rotated or truncated.
+		cur, err := os.Stat(t.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// Renamed but new log isn't created yet.
+				return nil, io.EOF
+			}
+			return nil, err
+		}
+		fi, err := t.f.Stat()
+		if err != nil {
+			t.close()
+			return nil, err
+		}
+		if !os.SameFile(fi, cur) {
+			// The old log is drained, mongod writes only complete lines
+			// so if something is pending it's garbage.
+			if err := t.open(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		truncated := cur.Size() < t.cp.Offset+int64(len(t.pending))
+		if !truncated {
+			// If it was truncated and grew since, its head is different.
+			head, err := readHead(t.f)
+			if err != nil {
+				t.close()
+				return nil, err
+			}
+			n := len(head)
+			if len(t.cp.Head) < n {
+				n = len(t.cp.Head)
+			}
+			truncated = !bytes.Equal(head[:n], t.cp.Head[:n])
+		}
+		if truncated {
+			// Truncated, e.g. by logrotate copytruncate.
+			if _, err := t.f.Seek(0, io.SeekStart); err != nil {
+				t.close()
+				return nil, err
+			}
+			t.r.Reset(t.f)
+			t.pending = nil
+			t.cp.Offset = 0
+			t.cp.Head = nil
+			continue
+		}
+		return nil, io.EOF
+	}
+}
+
+// checkpoint returns the current position in the log.
+func (t *tail) checkpoint() Checkpoint {
+	// The head of a new log grows until it's HeadSize long.
+	if t.f != nil && len(t.cp.Head) < HeadSize {
+		if head, err := readHead(t.f); err == nil {
+			t.cp.Head = head
+		}
+	}
+	return t.cp
+}
+
+func (t *tail) close() {
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+		t.r = nil
+	}
+}
@@ -0,0 +1,104 @@
+package logcollector
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/checkpoint"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendLog(t *testing.T, file, data string) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+}
+
+// lines returns all complete lines the tail has.
+func lines(t *testing.T, tl *tail) []string {
+	got := []string{}
+	for {
+		line, err := tl.next()
+		if err == io.EOF {
+			return got
+		}
+		require.NoError(t, err)
+		got = append(got, string(line))
+	}
+}
+
+func TestTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollector")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "mongod.log")
+
+	// Without checkpoint old lines are skipped.
+	appendLog(t, file, "old 1\nold 2\n")
+	tl := newTail(file, Checkpoint{})
+	assert.Empty(t, lines(t, tl))
+
+	// Incomplete lines are returned when they are complete.
+	appendLog(t, file, "line 1\nline")
+	assert.Equal(t, []string{"line 1\n"}, lines(t, tl))
+	appendLog(t, file, " 2\n")
+	assert.Equal(t, []string{"line 2\n"}, lines(t, tl))
+	cp := tl.checkpoint()
+	assert.Equal(t, file, cp.File)
+	assert.EqualValues(t, 26, cp.Offset)
+	assert.Equal(t, "old 1\nold 2\nline 1\nline 2\n", string(cp.Head))
+	tl.close()
+
+	// Resume from the checkpoint.
+	appendLog(t, file, "line 3\n")
+	tl = newTail(file, cp)
+	assert.Equal(t, []string{"line 3\n"}, lines(t, tl))
+
+	// Rotation by rename: the rest of the old log is read,
+	// then the new log from the beginning.
+	appendLog(t, file, "line 4\n")
+	require.NoError(t, os.Rename(file, file+".1"))
+	appendLog(t, file+".1", "line 5\n")
+	appendLog(t, file, "new 1\n")
+	assert.Equal(t, []string{"line 4\n", "line 5\n", "new 1\n"}, lines(t, tl))
+
+	// Truncation.
+	require.NoError(t, os.Truncate(file, 0))
+	appendLog(t, file, "trunc\n")
+	assert.Equal(t, []string{"trunc\n"}, lines(t, tl))
+	cp = tl.checkpoint()
+	tl.close()
+
+	// Rotated while we weren't running: the new log is read from the beginning.
+	require.NoError(t, os.Rename(file, file+".2"))
+	appendLog(t, file, "other 1\nother 2\n")
+	tl = newTail(file, cp)
+	assert.Equal(t, []string{"other 1\n", "other 2\n"}, lines(t, tl))
+	tl.close()
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logcollector")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "checkpoint.json")
+
+	cp := Checkpoint{File: "/var/log/mongod.log", Head: []byte("head"), Offset: 100}
+	require.NoError(t, checkpoint.Write(file, cp))
+	got := Checkpoint{}
+	require.NoError(t, checkpoint.Read(file, &got))
+	assert.Equal(t, cp.File, got.File)
+	assert.Equal(t, cp.Head, got.Head)
+	assert.Equal(t, cp.Offset, got.Offset)
+
+	assert.True(t, cp.resumes("/var/log/mongod.log", []byte("head and more"), 100))
+	assert.False(t, cp.resumes("/var/log/mongod.log", []byte("head and more"), 99))
+	assert.False(t, cp.resumes("/var/log/mongod.log", []byte("other head"), 100))
+	assert.False(t, cp.resumes("/var/log/other.log", []byte("head"), 100))
+}
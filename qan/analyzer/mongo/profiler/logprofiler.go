@@ -0,0 +1,200 @@
+package profiler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/logcollector"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/parser"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/sender"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// NewLogProfiler returns profiler which collects slow queries from the mongod
+// log file instead of system.profile, so it doesn't need profiling enabled.
+// If config.LogFile is empty then the log file configured in mongod is used.
+func NewLogProfiler(
+	dialInfo *pmgo.DialInfo,
+	dialer pmgo.Dialer,
+	logger *pct.Logger,
+	spool data.Spooler,
+	config pc.QAN,
+	checkpointFile string,
+) *logProfiler {
+	return &logProfiler{
+		dialInfo:       dialInfo,
+		dialer:         dialer,
+		logger:         logger,
+		spool:          spool,
+		config:         config,
+		checkpointFile: checkpointFile,
+	}
+}
+
+type logProfiler struct {
+	// dependencies
+	dialInfo       *pmgo.DialInfo
+	dialer         pmgo.Dialer
+	spool          data.Spooler
+	logger         *pct.Logger
+	config         pc.QAN
+	checkpointFile string
+
+	// internal deps
+	file       string
+	collector  *logcollector.Collector
+	parser     *parser.Parser
+	aggregator *aggregator.Aggregator
+	sender     *sender.Sender
+
+	// state
+	sync.RWMutex      // Lock() to protect internal consistency of the service
+	running      bool // Is this service running?
+}
+
+// Start starts analyzer but doesn't wait until it exits
+func (self *logProfiler) Start() (err error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil
+	}
+
+	databases, namespaces, err := newFilters(self.config)
+	if err != nil {
+		return err
+	}
+
+	self.file = self.config.LogFile
+	if self.file == "" {
+		self.file, err = getLogFile(self.dialInfo, self.dialer)
+		if err != nil {
+			return err
+		}
+	}
+
+	defer func() {
+		// if we failed to start be sure that any started internal service is shutdown
+		if err != nil {
+			self.stop()
+		}
+	}()
+
+	// create collector which reads slow queries from the log
+	self.collector = logcollector.New(self.file, self.checkpointFile)
+	docsChan, err := self.collector.Start()
+	if err != nil {
+		return err
+	}
+
+	// create aggregator which collects documents and aggregates them into qan report,
+	// if we continue reading the log then start where we stopped
+	timeStart := time.Now()
+	if since := self.collector.Since(); !since.IsZero() && since.Before(timeStart) {
+		timeStart = since
+	}
+	self.aggregator = aggregator.New(timeStart, self.config)
+	reportChan := self.aggregator.Start()
+
+	// create sender which sends qan reports and start it
+	self.sender = sender.New(reportChan, self.spool, self.logger)
+	self.sender.SetWritten(self.collector.Written)
+	if err = self.sender.Start(); err != nil {
+		return err
+	}
+
+	// create parser which passes collected documents to aggregator
+	self.parser = parser.New(docsChan, self.aggregator)
+	self.parser.SetFilter(databases, namespaces)
+	if err = self.parser.Start(); err != nil {
+		return err
+	}
+
+	self.running = true
+	return nil
+}
+
+// Status returns list of statuses
+func (self *logProfiler) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	statuses := map[string]string{}
+	for k, v := range self.collector.Status() {
+		statuses[fmt.Sprintf("%s-%s", self.collector.Name(), k)] = v
+	}
+	for k, v := range self.parser.Status() {
+		statuses[fmt.Sprintf("%s-%s", self.parser.Name(), k)] = v
+	}
+	for k, v := range self.aggregator.Status() {
+		statuses[fmt.Sprintf("%s-%s", "aggregator", k)] = v
+	}
+	for k, v := range self.sender.Status() {
+		statuses[fmt.Sprintf("%s-%s", "sender", k)] = v
+	}
+	statuses["log"] = self.file
+	return statuses
+}
+
+// Stop stops running analyzer, waits until it stops
+func (self *logProfiler) Stop() error {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return nil
+	}
+
+	self.stop()
+
+	// set state to "not running"
+	self.running = false
+	return nil
+}
+
+// stop stops internal services in order data flows through them.
+func (self *logProfiler) stop() {
+	if self.collector != nil {
+		self.collector.Stop()
+		self.collector = nil
+	}
+	if self.parser != nil {
+		self.parser.Stop()
+		self.parser = nil
+	}
+	if self.aggregator != nil {
+		self.aggregator.Stop()
+		self.aggregator = nil
+	}
+	if self.sender != nil {
+		self.sender.Stop()
+		self.sender = nil
+	}
+}
+
+// getLogFile returns path of the log file mongod writes to.
+func getLogFile(dialInfo *pmgo.DialInfo, dialer pmgo.Dialer) (string, error) {
+	session, err := createSession(dialInfo, dialer)
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	opts := proto.CommandLineOptions{}
+	if err := session.DB("admin").Run(bson.D{{Name: "getCmdLineOpts", Value: 1}}, &opts); err != nil {
+		return "", err
+	}
+	if opts.Parsed.SystemLog.Destination != "file" || opts.Parsed.SystemLog.Path == "" {
+		return "", fmt.Errorf("mongod doesn't log to a file (systemLog.destination: %q), set the log file in QAN config", opts.Parsed.SystemLog.Destination)
+	}
+	return opts.Parsed.SystemLog.Path, nil
+}
@@ -8,6 +8,7 @@ import (
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/collector"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/parser"
@@ -16,6 +17,7 @@ import (
 func NewMonitor(
 	session pmgo.SessionManager,
 	dbName string,
+	namespaces *filter.Filter,
 	aggregator *aggregator.Aggregator,
 	logger *pct.Logger,
 	spool data.Spooler,
@@ -24,6 +26,7 @@ func NewMonitor(
 	return &monitor{
 		session:    session,
 		dbName:     dbName,
+		namespaces: namespaces,
 		aggregator: aggregator,
 		logger:     logger,
 		spool:      spool,
@@ -35,6 +38,7 @@ type monitor struct {
 	// dependencies
 	session    pmgo.SessionManager
 	dbName     string
+	namespaces *filter.Filter
 	aggregator *aggregator.Aggregator
 	spool      data.Spooler
 	logger     *pct.Logger
@@ -77,6 +81,7 @@ func (self *monitor) Start() error {
 
 	// create parser and start it
 	p := parser.New(docsChan, self.aggregator)
+	p.SetFilter(nil, self.namespaces)
 	err = p.Start()
 	if err != nil {
 		return err
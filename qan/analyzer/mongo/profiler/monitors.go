@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/percona/pmgo"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
 )
 
 const (
@@ -21,10 +22,12 @@ type newMonitor func(
 
 func NewMonitors(
 	session pmgo.SessionManager,
+	databases *filter.Filter,
 	newMonitor newMonitor,
 ) *monitors {
 	return &monitors{
 		session:    session,
+		databases:  databases,
 		newMonitor: newMonitor,
 		monitors:   map[string]*monitor{},
 	}
@@ -33,10 +36,12 @@ func NewMonitors(
 type monitors struct {
 	// dependencies
 	session    pmgo.SessionManager
+	databases  *filter.Filter
 	newMonitor newMonitor
 
 	// monitors
 	monitors map[string]*monitor
+	filtered []string // databases which aren't monitored because of filter
 
 	// state
 	sync.RWMutex // Lock() to protect internal consistency of the service
@@ -48,13 +53,15 @@ func (self *monitors) MonitorAll() error {
 	if err != nil {
 		return err
 	}
+	filtered := []string{}
 	for _, dbName := range databasesSlice {
-		// Skip admin and local databases to avoid collecting queries from replication and mongodb_exporter
-		//switch dbName {
-		//case "admin", "local":
-		//	continue
-		//default:
-		//}
+		// Skip filtered out databases, by default admin, local and config
+		// to avoid collecting queries from replication and mongodb_exporter,
+		// see DefaultExcludeDatabases
+		if !self.databases.Allow(dbName) {
+			filtered = append(filtered, dbName)
+			continue
+		}
 
 		// change slice to map for easier lookup
 		databases[dbName] = struct{}{}
@@ -80,6 +87,10 @@ func (self *monitors) MonitorAll() error {
 		self.monitors[dbName] = m
 	}
 
+	self.Lock()
+	self.filtered = filtered
+	self.Unlock()
+
 	// if database is no longer present then stop monitoring it
 	for dbName := range self.monitors {
 		if _, ok := databases[dbName]; !ok {
@@ -127,6 +138,13 @@ func (self *monitors) GetAll() map[string]*monitor {
 	return list
 }
 
+// Filtered returns databases which aren't monitored because of filter.
+func (self *monitors) Filtered() []string {
+	self.RLock()
+	defer self.RUnlock()
+	return self.filtered
+}
+
 func (self *monitors) listDatabases() ([]string, error) {
 	session := self.session.Copy()
 	defer session.Close()
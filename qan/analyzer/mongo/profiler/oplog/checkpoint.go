@@ -0,0 +1,14 @@
+package oplog
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Checkpoint is the oplog timestamp up to which writes were reported,
+// it's always the end of a reported interval.
+type Checkpoint struct {
+	Ts   bson.MongoTimestamp
+	Time time.Time // of Ts
+}
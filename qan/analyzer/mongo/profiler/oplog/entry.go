@@ -0,0 +1,291 @@
+package oplog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// entry is a document of local.oplog.rs.
+type entry struct {
+	Ts        bson.MongoTimestamp `bson:"ts"`
+	Op        string              `bson:"op"` // i, u, d, c or n
+	Ns        string              `bson:"ns"`
+	O         bson.Raw            `bson:"o"`
+	O2        bson.Raw            `bson:"o2"`
+	Lsid      *lsid               `bson:"lsid"`      // session of transaction or retryable write
+	TxnNumber *int64              `bson:"txnNumber"` // transaction or retryable write in the session
+}
+
+type lsid struct {
+	Id bson.Binary `bson:"id"`
+}
+
+// write is a write operation fingerprinted by namespace and shape.
+type write struct {
+	Ts          time.Time
+	Ns          string
+	Fingerprint string // e.g. "UPDATE col1 a,b.c"
+	Size        int    // bytes of the document, update or _id of removed document
+	TxnOps      int    // operations in the transaction, 0 if not in transaction
+	Example     string // JSON of the oplog entry
+}
+
+// tsTime returns time of oplog timestamp.
+func tsTime(ts bson.MongoTimestamp) time.Time {
+	return time.Unix(int64(ts)>>32, 0).UTC()
+}
+
+// timeTs returns the first oplog timestamp at t.
+func timeTs(t time.Time) bson.MongoTimestamp {
+	return bson.MongoTimestamp(t.Unix() << 32)
+}
+
+// converter converts oplog entries into writes. Operations of multi-document
+// transactions are kept until the transaction commits.
+type converter struct {
+	txns map[string][]write // keyed by txnKey
+}
+
+func newConverter() *converter {
+	return &converter{
+		txns: map[string][]write{},
+	}
+}
+
+// writes returns write operations of e, if any.
+func (c *converter) writes(e entry) ([]write, error) {
+	switch e.Op {
+	case "i":
+		return []write{newWrite(e, "INSERT", nil)}, nil
+	case "u":
+		o := mongoproto.BsonD{}
+		if err := e.O.Unmarshal(&o); err != nil {
+			return nil, err
+		}
+		return []write{newWrite(e, "UPDATE", updateFields(o))}, nil
+	case "d":
+		return []write{newWrite(e, "REMOVE", nil)}, nil
+	case "c":
+		return c.command(e)
+	}
+	// "n" no-op entries and unknown entries have no writes
+	return nil, nil
+}
+
+func (c *converter) command(e entry) ([]write, error) {
+	o := mongoproto.BsonD{}
+	if err := e.O.Unmarshal(&o); err != nil {
+		return nil, err
+	}
+	if o.Len() == 0 {
+		return nil, nil
+	}
+
+	name := o[0].Name
+	switch name {
+	case "applyOps":
+		ops := struct {
+			ApplyOps   []entry `bson:"applyOps"`
+			PartialTxn bool    `bson:"partialTxn"` // more operations of the transaction follow, MongoDB 4.2+
+			Prepare    bool    `bson:"prepare"`    // commitTransaction or abortTransaction follows
+		}{}
+		if err := e.O.Unmarshal(&ops); err != nil {
+			return nil, err
+		}
+		writes := []write{}
+		for _, op := range ops.ApplyOps {
+			op.Ts = e.Ts
+			w, err := c.writes(op)
+			if err != nil {
+				return nil, err
+			}
+			writes = append(writes, w...)
+		}
+		// applyOps outside of a transaction, e.g. run by the applyOps command
+		if e.Lsid == nil {
+			return writes, nil
+		}
+		key := txnKey(e)
+		writes = append(c.txns[key], writes...)
+		if ops.PartialTxn || ops.Prepare {
+			c.txns[key] = writes
+			return nil, nil
+		}
+		delete(c.txns, key)
+		return commit(e, writes), nil
+	case "commitTransaction":
+		key := txnKey(e)
+		writes := c.txns[key]
+		delete(c.txns, key)
+		return commit(e, writes), nil
+	case "abortTransaction":
+		delete(c.txns, txnKey(e))
+		return nil, nil
+	}
+
+	// DDL, e.g. create or createIndexes; the target collection is the value
+	db := strings.SplitN(e.Ns, ".", 2)[0]
+	coll, _ := o[0].Value.(string)
+	w := newWrite(e, "COMMAND", nil)
+	w.Ns = db
+	w.Fingerprint = "COMMAND " + name
+	if coll != "" {
+		w.Ns = db + "." + coll
+		w.Fingerprint += " " + coll
+	}
+	return []write{w}, nil
+}
+
+// pending returns the number of transactions waiting for commit.
+func (c *converter) pending() int {
+	return len(c.txns)
+}
+
+func newWrite(e entry, op string, fields []string) write {
+	parts := []string{op}
+	if s := strings.SplitN(e.Ns, ".", 2); len(s) == 2 {
+		parts = append(parts, s[1])
+	}
+	if len(fields) > 0 {
+		parts = append(parts, strings.Join(fields, ","))
+	}
+	return write{
+		Ts:          tsTime(e.Ts),
+		Ns:          e.Ns,
+		Fingerprint: strings.Join(parts, " "),
+		Size:        len(e.O.Data),
+		Example:     example(e),
+	}
+}
+
+// commit sets writes of the transaction committed by e.
+func commit(e entry, writes []write) []write {
+	for i := range writes {
+		writes[i].Ts = tsTime(e.Ts)
+		writes[i].TxnOps = len(writes)
+	}
+	return writes
+}
+
+func txnKey(e entry) string {
+	key := ""
+	if e.Lsid != nil {
+		key = fmt.Sprintf("%x", e.Lsid.Id.Data)
+	}
+	if e.TxnNumber != nil {
+		key += fmt.Sprintf(":%d", *e.TxnNumber)
+	}
+	return key
+}
+
+// updateFields returns sorted top level fields changed by update o:
+// fields of update operators, fields of $v:2 diff (MongoDB 5.0+),
+// or fields of the replacement document.
+func updateFields(o mongoproto.BsonD) []string {
+	fields := map[string]bool{}
+	for _, e := range o {
+		switch {
+		case e.Name == "$v":
+		case e.Name == "diff":
+			for _, f := range diffFields(e.Value) {
+				fields[f] = true
+			}
+		case strings.HasPrefix(e.Name, "$"):
+			for _, f := range docKeys(e.Value) {
+				fields[f] = true
+			}
+		default:
+			// replacement document
+			if e.Name != "_id" {
+				fields[e.Name] = true
+			}
+		}
+	}
+
+	sorted := []string{}
+	for f := range fields {
+		sorted = append(sorted, f)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// diffFields returns fields of $v:2 update diff, e.g. {u: {a: 1}, sb: {...}}.
+func diffFields(diff interface{}) []string {
+	fields := []string{}
+	for _, k := range docKeys(diff) {
+		switch {
+		case k == "u" || k == "i" || k == "d":
+			fields = append(fields, docKeys(docValue(diff, k))...)
+		case strings.HasPrefix(k, "s") && len(k) > 1:
+			// diff of subdocument or array
+			fields = append(fields, k[1:])
+		}
+	}
+	return fields
+}
+
+// docKeys returns keys of document v.
+func docKeys(v interface{}) []string {
+	keys := []string{}
+	switch d := v.(type) {
+	case mongoproto.BsonD:
+		for _, e := range d {
+			keys = append(keys, e.Name)
+		}
+	case bson.D:
+		for _, e := range d {
+			keys = append(keys, e.Name)
+		}
+	case bson.M:
+		for k := range d {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// docValue returns value of key in document v.
+func docValue(v interface{}, key string) interface{} {
+	switch d := v.(type) {
+	case mongoproto.BsonD:
+		return d.Map()[key]
+	case bson.D:
+		return d.Map()[key]
+	case bson.M:
+		return d[key]
+	}
+	return nil
+}
+
+// example returns JSON of e, without timestamp and session.
+func example(e entry) string {
+	doc := mongoproto.BsonD{
+		{Name: "ns", Value: e.Ns},
+		{Name: "op", Value: e.Op},
+	}
+	for _, raw := range []struct {
+		name string
+		raw  bson.Raw
+	}{{"o", e.O}, {"o2", e.O2}} {
+		if raw.raw.Kind == 0 {
+			continue
+		}
+		v := mongoproto.BsonD{}
+		if err := raw.raw.Unmarshal(&v); err != nil {
+			continue
+		}
+		doc = append(doc, bson.DocElem{Name: raw.name, Value: v})
+	}
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
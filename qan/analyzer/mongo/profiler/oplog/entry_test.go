@@ -0,0 +1,167 @@
+package oplog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func raw(t *testing.T, doc interface{}) bson.Raw {
+	data, err := bson.Marshal(doc)
+	require.NoError(t, err)
+	return bson.Raw{Kind: 0x03, Data: data}
+}
+
+func TestTs(t *testing.T) {
+	t.Parallel()
+
+	tm := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, tm, tsTime(timeTs(tm)))
+	assert.Equal(t, tm, tsTime(timeTs(tm)+5)) // increment doesn't change time
+}
+
+func TestConverter_writes(t *testing.T) {
+	t.Parallel()
+
+	ts := timeTs(time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newConverter()
+
+	tests := []struct {
+		name        string
+		entry       entry
+		fingerprint string
+		ns          string
+	}{
+		{
+			name:        "insert",
+			entry:       entry{Op: "i", Ns: "test.col1", O: raw(t, bson.D{{Name: "_id", Value: 1}, {Name: "a", Value: 1}})},
+			fingerprint: "INSERT col1",
+			ns:          "test.col1",
+		},
+		{
+			name:        "update with operators",
+			entry:       entry{Op: "u", Ns: "test.col1", O: raw(t, bson.D{{Name: "$v", Value: 1}, {Name: "$set", Value: bson.D{{Name: "b.c", Value: 1}, {Name: "a", Value: 2}}}, {Name: "$unset", Value: bson.M{"d": true}}}), O2: raw(t, bson.M{"_id": 1})},
+			fingerprint: "UPDATE col1 a,b.c,d",
+			ns:          "test.col1",
+		},
+		{
+			name:        "update with diff",
+			entry:       entry{Op: "u", Ns: "test.col1", O: raw(t, bson.D{{Name: "$v", Value: 2}, {Name: "diff", Value: bson.D{{Name: "u", Value: bson.M{"a": 1}}, {Name: "d", Value: bson.M{"c": false}}, {Name: "sb", Value: bson.M{"x": 1}}}}}), O2: raw(t, bson.M{"_id": 1})},
+			fingerprint: "UPDATE col1 a,b,c",
+			ns:          "test.col1",
+		},
+		{
+			name:        "replacement",
+			entry:       entry{Op: "u", Ns: "test.col1", O: raw(t, bson.D{{Name: "_id", Value: 1}, {Name: "z", Value: 1}, {Name: "y", Value: 2}}), O2: raw(t, bson.M{"_id": 1})},
+			fingerprint: "UPDATE col1 y,z",
+			ns:          "test.col1",
+		},
+		{
+			name:        "remove",
+			entry:       entry{Op: "d", Ns: "test.col1", O: raw(t, bson.M{"_id": 1})},
+			fingerprint: "REMOVE col1",
+			ns:          "test.col1",
+		},
+		{
+			name:        "command",
+			entry:       entry{Op: "c", Ns: "test.$cmd", O: raw(t, bson.D{{Name: "create", Value: "col2"}})},
+			fingerprint: "COMMAND create col2",
+			ns:          "test.col2",
+		},
+	}
+	for _, tt := range tests {
+		tt.entry.Ts = ts
+		writes, err := c.writes(tt.entry)
+		require.NoError(t, err, tt.name)
+		require.Len(t, writes, 1, tt.name)
+		w := writes[0]
+		assert.Equal(t, tt.fingerprint, w.Fingerprint, tt.name)
+		assert.Equal(t, tt.ns, w.Ns, tt.name)
+		assert.Equal(t, tsTime(ts), w.Ts, tt.name)
+		assert.Equal(t, len(tt.entry.O.Data), w.Size, tt.name)
+		assert.Equal(t, 0, w.TxnOps, tt.name)
+		assert.NotEmpty(t, w.Example, tt.name)
+	}
+
+	// no-op
+	writes, err := c.writes(entry{Op: "n", Ns: "", O: raw(t, bson.M{"msg": "periodic noop"})})
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+}
+
+func TestConverter_transaction(t *testing.T) {
+	t.Parallel()
+
+	ts := timeTs(time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC))
+	session := &lsid{Id: bson.Binary{Kind: 0x04, Data: []byte("0123456789abcdef")}}
+	txnNumber := int64(1)
+	op := func(o string, id int) bson.M {
+		return bson.M{"op": o, "ns": "test.col1", "o": bson.M{"_id": id}}
+	}
+	c := newConverter()
+
+	// a large transaction is written in parts (MongoDB 4.2+), writes are reported on commit
+	writes, err := c.writes(entry{
+		Ts: ts, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber,
+		O: raw(t, bson.D{{Name: "applyOps", Value: []bson.M{op("i", 1), op("i", 2)}}, {Name: "partialTxn", Value: true}}),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+	assert.Equal(t, 1, c.pending())
+
+	writes, err = c.writes(entry{
+		Ts: ts + 1, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber,
+		O: raw(t, bson.D{{Name: "applyOps", Value: []bson.M{op("d", 3)}}}),
+	})
+	require.NoError(t, err)
+	require.Len(t, writes, 3)
+	assert.Equal(t, 0, c.pending())
+	for _, w := range writes {
+		assert.Equal(t, 3, w.TxnOps)
+	}
+	assert.Equal(t, "INSERT col1", writes[0].Fingerprint)
+	assert.Equal(t, "REMOVE col1", writes[2].Fingerprint)
+
+	// prepared transaction which is aborted
+	txnNumber2 := int64(2)
+	writes, err = c.writes(entry{
+		Ts: ts + 2, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber2,
+		O: raw(t, bson.D{{Name: "applyOps", Value: []bson.M{op("i", 4)}}, {Name: "prepare", Value: true}}),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+	writes, err = c.writes(entry{
+		Ts: ts + 3, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber2,
+		O: raw(t, bson.D{{Name: "abortTransaction", Value: 1}}),
+	})
+	require.NoError(t, err)
+	assert.Empty(t, writes)
+	assert.Equal(t, 0, c.pending())
+
+	// prepared transaction which is committed
+	txnNumber3 := int64(3)
+	_, err = c.writes(entry{
+		Ts: ts + 4, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber3,
+		O: raw(t, bson.D{{Name: "applyOps", Value: []bson.M{op("i", 5)}}, {Name: "prepare", Value: true}}),
+	})
+	require.NoError(t, err)
+	writes, err = c.writes(entry{
+		Ts: ts + 5, Op: "c", Ns: "admin.$cmd", Lsid: session, TxnNumber: &txnNumber3,
+		O: raw(t, bson.D{{Name: "commitTransaction", Value: 1}}),
+	})
+	require.NoError(t, err)
+	require.Len(t, writes, 1)
+	assert.Equal(t, 1, writes[0].TxnOps)
+
+	// applyOps outside of transaction
+	writes, err = c.writes(entry{
+		Ts: ts + 6, Op: "c", Ns: "admin.$cmd",
+		O: raw(t, bson.D{{Name: "applyOps", Value: []bson.M{op("i", 6), op("i", 7)}}}),
+	})
+	require.NoError(t, err)
+	require.Len(t, writes, 2)
+	assert.Equal(t, 0, writes[0].TxnOps)
+}
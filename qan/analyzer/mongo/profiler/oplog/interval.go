@@ -0,0 +1,114 @@
+package oplog
+
+import (
+	"crypto/md5"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/go-mysql/log"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+)
+
+// interval aggregates writes from timeStart to timeEnd into write classes.
+type interval struct {
+	timeStart  time.Time
+	timeEnd    time.Time
+	aggregator *event.Aggregator
+	examples   map[string]*event.Example // keyed on class ID
+	writes     int
+}
+
+func newInterval(timeStart time.Time, d time.Duration) *interval {
+	return &interval{
+		timeStart:  timeStart,
+		timeEnd:    timeStart.Add(d),
+		aggregator: event.NewAggregator(false, 0, 0),
+		examples:   map[string]*event.Example{},
+	}
+}
+
+// add aggregates w. Metrics are:
+//
+//	Query_time - always 0, the oplog has no timings, but reports are sorted by it
+//	Doc_size   - bytes of the document, update or _id of removed document
+//	Txn_ops    - operations in the transaction w is part of
+//	In_txn     - if w is part of a multi-document transaction
+func (self *interval) add(w write) {
+	id := classId(w.Ns, w.Fingerprint)
+	db := strings.SplitN(w.Ns, ".", 2)[0]
+
+	e := log.NewEvent()
+	e.Db = db
+	e.TimeMetrics["Query_time"] = 0
+	e.NumberMetrics["Doc_size"] = uint64(w.Size)
+	e.BoolMetrics["In_txn"] = w.TxnOps > 0
+	if w.TxnOps > 0 {
+		e.NumberMetrics["Txn_ops"] = uint64(w.TxnOps)
+	}
+	self.aggregator.AddEvent(e, id, w.Fingerprint)
+
+	// the first write of the class is the example, there are no slowest writes
+	if _, ok := self.examples[id]; !ok {
+		query := w.Example
+		if len(query) > event.MAX_EXAMPLE_BYTES {
+			query = query[0:event.MAX_EXAMPLE_BYTES-3] + "..."
+		}
+		self.examples[id] = &event.Example{
+			Db:    db,
+			Query: query,
+			Ts:    w.Ts.Format("2006-01-02 15:04:05"),
+		}
+	}
+	self.writes++
+}
+
+// report returns report of the interval, classes with most writes first.
+// Classes over config.ReportLimit are reported as low-ranking queries.
+func (self *interval) report(config pc.QAN) *qan.Report {
+	result := self.aggregator.Finalize()
+	classes := []*event.Class{}
+	for id, class := range result.Class {
+		if config.ExampleQueries != nil && *config.ExampleQueries {
+			class.Example = self.examples[id]
+		} else {
+			class.Example = nil
+		}
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if classes[i].TotalQueries != classes[j].TotalQueries {
+			return classes[i].TotalQueries > classes[j].TotalQueries
+		}
+		return classes[i].Id < classes[j].Id
+	})
+
+	// MakeReport ranks classes by Query_time which writes don't have,
+	// so top classes are chosen here by number of writes
+	limit := int(config.ReportLimit)
+	config.ReportLimit = 0
+	if limit > 0 && len(classes) > limit {
+		lrq := event.NewClass("lrq", "/* low-ranking queries */", false)
+		for _, class := range classes[limit:] {
+			lrq.AddClass(class)
+		}
+		classes = append(classes[:limit], lrq)
+	}
+
+	r := report.MakeReport(config, self.timeStart, self.timeEnd, nil, &report.Result{
+		Global: result.Global,
+		Class:  append([]*event.Class{}, classes...),
+	})
+	r.Class = classes
+	r.Writes = true
+	return r
+}
+
+// classId returns ID of the write class, like the ID of classes of the profiler.
+func classId(ns, fingerprint string) string {
+	return fmt.Sprintf("%x", md5.Sum([]byte(ns+" "+fingerprint)))
+}
@@ -0,0 +1,140 @@
+package oplog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/checkpoint"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterval_report(t *testing.T) {
+	t.Parallel()
+
+	timeStart := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	i := newInterval(timeStart, time.Minute)
+
+	add := func(fingerprint string, n, size, txnOps int) {
+		for k := 0; k < n; k++ {
+			i.add(write{
+				Ts:          timeStart.Add(time.Second),
+				Ns:          "test.col1",
+				Fingerprint: fingerprint,
+				Size:        size,
+				TxnOps:      txnOps,
+				Example:     `{"ns":"test.col1"}`,
+			})
+		}
+	}
+	add("INSERT col1", 3, 100, 0)
+	add("UPDATE col1 a", 5, 20, 2)
+	add("REMOVE col1", 1, 10, 0)
+
+	exampleQueries := true
+	config := pc.QAN{UUID: "1", Interval: 60, ExampleQueries: &exampleQueries, ReportLimit: 2}
+	r := i.report(config)
+
+	assert.True(t, r.Writes)
+	assert.Equal(t, timeStart, r.StartTs)
+	assert.Equal(t, timeStart.Add(time.Minute), r.EndTs)
+	assert.Equal(t, uint(9), r.Global.TotalQueries)
+
+	// classes with most writes first, the rest as low-ranking queries
+	require.Len(t, r.Class, 3)
+	assert.Equal(t, "UPDATE col1 a", r.Class[0].Fingerprint)
+	assert.Equal(t, uint(5), r.Class[0].TotalQueries)
+	assert.Equal(t, uint64(100), r.Class[0].Metrics.NumberMetrics["Doc_size"].Sum)
+	assert.Equal(t, uint64(10), r.Class[0].Metrics.NumberMetrics["Txn_ops"].Sum)
+	assert.Equal(t, uint64(5), r.Class[0].Metrics.BoolMetrics["In_txn"].Sum)
+	require.NotNil(t, r.Class[0].Example)
+	assert.Equal(t, "test", r.Class[0].Example.Db)
+	assert.Equal(t, "2017-01-01 12:00:01", r.Class[0].Example.Ts)
+
+	assert.Equal(t, "INSERT col1", r.Class[1].Fingerprint)
+	assert.Equal(t, uint64(0), r.Class[1].Metrics.BoolMetrics["In_txn"].Sum)
+	assert.Nil(t, r.Class[1].Metrics.NumberMetrics["Txn_ops"])
+
+	assert.Equal(t, "lrq", r.Class[2].Id)
+	assert.Equal(t, uint(1), r.Class[2].TotalQueries)
+}
+
+func TestCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "oplog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "checkpoint.json")
+
+	tm := time.Date(2017, 1, 1, 12, 1, 0, 0, time.UTC)
+	err = checkpoint.Write(file, Checkpoint{Ts: timeTs(tm), Time: tm})
+	require.NoError(t, err)
+
+	cp := Checkpoint{}
+	err = checkpoint.Read(file, &cp)
+	require.NoError(t, err)
+	assert.Equal(t, timeTs(tm), cp.Ts)
+	assert.Equal(t, tm, tsTime(cp.Ts))
+}
+
+func TestTailer_CheckpointWritten(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "oplog")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "checkpoint.json")
+
+	stats := &stats{}
+	status.New(stats)
+	reportChan := make(chan *qan.Report, 10)
+	tl := &tailer{
+		config:         pc.QAN{UUID: "1", Interval: 60},
+		checkpointFile: file,
+		d:              time.Minute,
+		reportChan:     reportChan,
+		doneChan:       make(chan struct{}),
+		stats:          stats,
+	}
+	t0 := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	saved := func() time.Time {
+		cp := Checkpoint{}
+		require.NoError(t, checkpoint.Read(file, &cp))
+		return cp.Time
+	}
+
+	// the checkpoint isn't saved until the report is written
+	tl.setInterval(t0)
+	tl.interval.add(write{Ts: t0, Ns: "test.col1", Fingerprint: "INSERT col1"})
+	require.NoError(t, tl.send())
+	r := <-reportChan
+	assert.True(t, saved().IsZero())
+
+	// nor at the end of an empty interval while the report is pending
+	tl.setInterval(t0.Add(time.Minute))
+	require.NoError(t, tl.send())
+	assert.True(t, saved().IsZero())
+
+	// a lost report doesn't move the checkpoint
+	tl.written(r, fmt.Errorf("disk full"))
+	assert.True(t, saved().IsZero())
+
+	tl.setInterval(t0.Add(2 * time.Minute))
+	tl.interval.add(write{Ts: t0.Add(2 * time.Minute), Ns: "test.col1", Fingerprint: "INSERT col1"})
+	require.NoError(t, tl.send())
+	tl.written(<-reportChan, nil)
+	assert.Equal(t, t0.Add(3*time.Minute), saved())
+
+	// nothing is pending so empty interval saves it
+	tl.setInterval(t0.Add(3 * time.Minute))
+	require.NoError(t, tl.send())
+	assert.Equal(t, t0.Add(4*time.Minute), saved())
+}
@@ -0,0 +1,364 @@
+package oplog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/checkpoint"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// TailTimeout is how long to wait for new oplog entries
+	// before checking if the collector should stop.
+	TailTimeout = 1 * time.Second
+	// RetryDelay is how long to wait before reading the oplog again after a failure.
+	RetryDelay       = 1 * time.Second
+	ReportChanBuffer = 1000
+)
+
+// errStopped is returned by tailer when the collector is stopped.
+var errStopped = fmt.Errorf("stopped")
+
+// New returns Collector which tails local.oplog.rs and aggregates write
+// operations by namespace and shape into reports, so writes are reported
+// even if they aren't profiled, e.g. on secondaries. Reports contain only
+// intervals which ended; the end of the last one spooled, see Written, is
+// saved to checkpointFile, if it's not empty, so collecting continues where
+// it stopped.
+func New(session pmgo.SessionManager, config pc.QAN, checkpointFile string) *Collector {
+	return &Collector{
+		session:        session,
+		config:         config,
+		checkpointFile: checkpointFile,
+	}
+}
+
+type Collector struct {
+	// dependencies
+	session        pmgo.SessionManager
+	config         pc.QAN
+	checkpointFile string
+
+	// dependencies from setter SetFilter
+	databases  *filter.Filter
+	namespaces *filter.Filter
+
+	// provides
+	reportChan chan *qan.Report
+
+	// status
+	status *status.Status
+
+	// state
+	sync.RWMutex                 // Lock() to protect internal consistency of the service
+	running      bool            // Is this service running?
+	tailer       *tailer         // of the last Start()
+	doneChan     chan struct{}   // close(doneChan) to notify goroutines that they should shutdown
+	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// SetFilter sets filters of databases and namespaces (db.collection) of reported writes.
+// It must be called before Start.
+func (self *Collector) SetFilter(databases, namespaces *filter.Filter) {
+	self.databases = databases
+	self.namespaces = namespaces
+}
+
+// Start starts but doesn't wait until it exits
+func (self *Collector) Start() (<-chan *qan.Report, error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil, nil
+	}
+
+	// set status
+	stats := &stats{}
+	self.status = status.New(stats)
+
+	// verify config, the same as aggregator does
+	config := self.config
+	if config.Interval == 0 {
+		defaultExampleQueries := aggregator.DefaultExampleQueries
+		config.Interval = aggregator.DefaultInterval
+		config.ExampleQueries = &defaultExampleQueries
+	}
+	d := time.Duration(config.Interval) * time.Second
+
+	// a broken checkpoint isn't fatal, we just start with the current interval
+	cp := Checkpoint{}
+	if err := checkpoint.Read(self.checkpointFile, &cp); err != nil {
+		stats.CheckpointErr.Set(err.Error())
+		cp = Checkpoint{}
+	}
+	timeStart := time.Now().UTC().Truncate(d)
+	if cp.Ts != 0 {
+		timeStart = tsTime(cp.Ts)
+	}
+
+	// the oplog exists only on replica set members
+	first := entry{}
+	if err := self.session.DB("local").C("oplog.rs").Find(nil).Sort("$natural").One(&first); err != nil {
+		return nil, fmt.Errorf("cannot read local.oplog.rs, mongod must be a replica set member: %s", err)
+	}
+	if cp.Ts != 0 && first.Ts > cp.Ts {
+		stats.Gap.Set(fmt.Sprintf("oplog starts at %s, writes since %s are lost",
+			tsTime(first.Ts).Format("2006-01-02 15:04:05"),
+			tsTime(cp.Ts).Format("2006-01-02 15:04:05"),
+		))
+	}
+
+	// create new channels over which we will communicate to...
+	// ... outside world by sending reports
+	self.reportChan = make(chan *qan.Report, ReportChanBuffer)
+	// ... inside goroutine to close it
+	self.doneChan = make(chan struct{})
+
+	t := &tailer{
+		session:        self.session,
+		config:         config,
+		checkpointFile: self.checkpointFile,
+		databases:      self.databases,
+		namespaces:     self.namespaces,
+		d:              d,
+		converter:      newConverter(),
+		reportChan:     self.reportChan,
+		doneChan:       self.doneChan,
+		stats:          stats,
+	}
+	t.setInterval(timeStart)
+	self.tailer = t
+
+	// start a goroutine and Add() it to WaitGroup
+	// so we could later Wait() for it to finish
+	self.wg = &sync.WaitGroup{}
+	self.wg.Add(1)
+	go start(self.wg, t, self.doneChan, stats)
+
+	self.running = true
+	return self.reportChan, nil
+}
+
+// Stop stops running
+func (self *Collector) Stop() {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return
+	}
+	self.running = false
+
+	// notify goroutine to close
+	close(self.doneChan)
+
+	// wait for goroutines to exit
+	self.wg.Wait()
+
+	// we can now safely close channels goroutines write to as goroutine is stopped
+	close(self.reportChan)
+	return
+}
+
+func (self *Collector) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	return self.status.Map()
+}
+
+func (self *Collector) Name() string {
+	return "oplog"
+}
+
+// Written must be called with every report after its spool write, it saves
+// the checkpoint at the end of report if it was spooled. It may be called
+// after Stop for reports which were sent before it.
+func (self *Collector) Written(report *qan.Report, err error) {
+	self.tailer.written(report, err)
+}
+
+func start(
+	wg *sync.WaitGroup,
+	t *tailer,
+	doneChan <-chan struct{},
+	stats *stats,
+) {
+	// signal WaitGroup when goroutine finished
+	defer wg.Done()
+
+	for {
+		err := t.tail()
+		if err == errStopped {
+			return
+		}
+		if err != nil {
+			stats.ReadErrCount.Add(1)
+			stats.ReadErrLast.Set(err.Error())
+		}
+
+		// check if we should shutdown
+		select {
+		case <-doneChan:
+			return
+		case <-time.After(RetryDelay):
+			// just continue after delay if not
+		}
+	}
+}
+
+// tailer reads the oplog and sends reports of intervals which ended.
+type tailer struct {
+	// dependencies
+	session        pmgo.SessionManager
+	config         pc.QAN
+	checkpointFile string
+	databases      *filter.Filter
+	namespaces     *filter.Filter
+	d              time.Duration
+
+	converter  *converter
+	interval   *interval
+	last       bson.MongoTimestamp // of the last entry read
+	pending    int32               // reports sent but not written yet, use atomic
+	reportChan chan<- *qan.Report
+	doneChan   <-chan struct{}
+	stats      *stats
+}
+
+// tail reads the oplog until the cursor fails, or returns errStopped if it should stop.
+func (self *tailer) tail() error {
+	session := self.session.Copy()
+	defer session.Close()
+
+	query := bson.M{"ts": bson.M{"$gt": self.last}}
+	if self.last == 0 {
+		query = bson.M{"ts": bson.M{"$gte": timeTs(self.interval.timeStart)}}
+	}
+	iter := session.DB("local").C("oplog.rs").Find(query).LogReplay().Tail(TailTimeout)
+	defer iter.Close()
+
+	for {
+		e := entry{}
+		for iter.Next(&e) {
+			if err := self.add(e); err != nil {
+				return err
+			}
+			e = entry{}
+		}
+		if err := iter.Err(); err != nil {
+			return err
+		}
+		// the cursor is dead, e.g. the oplog rolled over
+		if !iter.Timeout() {
+			return nil
+		}
+
+		// check if we should shutdown
+		select {
+		case <-self.doneChan:
+			return errStopped
+		default:
+		}
+	}
+}
+
+// add adds writes of e to the current interval. Entries past the interval,
+// including no-ops which replica set primary writes periodically, end it.
+func (self *tailer) add(e entry) error {
+	self.last = e.Ts
+	self.stats.EntriesIn.Add(1)
+	ts := tsTime(e.Ts)
+	self.stats.Ts.Set(ts.Format("2006-01-02 15:04:05"))
+
+	if !ts.Before(self.interval.timeEnd) {
+		if err := self.send(); err != nil {
+			return err
+		}
+		// skip empty intervals
+		timeStart := self.interval.timeEnd
+		if t := ts.Truncate(self.d); t.After(timeStart) {
+			timeStart = t
+		}
+		self.setInterval(timeStart)
+	}
+
+	writes, err := self.converter.writes(e)
+	if err != nil {
+		self.stats.ParseErrCount.Add(1)
+		self.stats.ParseErrLast.Set(err.Error())
+		return nil
+	}
+	self.stats.TxnsPending.Set(int64(self.converter.pending()))
+	for _, w := range writes {
+		db := strings.SplitN(w.Ns, ".", 2)[0]
+		if !self.databases.Allow(db) || !self.namespaces.Allow(w.Ns) {
+			self.stats.WritesSkipped.Add(1)
+			continue
+		}
+		self.interval.add(w)
+		self.stats.WritesIn.Add(1)
+	}
+	return nil
+}
+
+// send sends report of the current interval. The checkpoint is saved at its
+// end when the report is written, or now if the interval is empty and all
+// reports sent before were written, so a checkpoint never skips a report.
+func (self *tailer) send() error {
+	if self.interval.writes == 0 {
+		if atomic.LoadInt32(&self.pending) == 0 {
+			self.save(self.interval.timeEnd)
+		}
+		return nil
+	}
+
+	r := self.interval.report(self.config)
+	atomic.AddInt32(&self.pending, 1)
+	// try to push report or exit if we should shutdown
+	select {
+	case self.reportChan <- r:
+		self.stats.ReportsOut.Add(1)
+	case <-self.doneChan:
+		atomic.AddInt32(&self.pending, -1)
+		return errStopped
+	}
+	return nil
+}
+
+// written saves the checkpoint at the end of report if it was spooled.
+// Reports are written in order they were sent.
+func (self *tailer) written(report *qan.Report, err error) {
+	if err == nil {
+		self.save(report.EndTs)
+	}
+	// after save, so send doesn't save concurrently
+	atomic.AddInt32(&self.pending, -1)
+}
+
+func (self *tailer) save(timeEnd time.Time) {
+	cp := Checkpoint{
+		Ts:   timeTs(timeEnd),
+		Time: timeEnd,
+	}
+	if err := checkpoint.Write(self.checkpointFile, cp); err != nil {
+		self.stats.CheckpointErr.Set(err.Error())
+	}
+}
+
+func (self *tailer) setInterval(timeStart time.Time) {
+	self.interval = newInterval(timeStart, self.d)
+	self.stats.IntervalStart.Set(timeStart.Format("2006-01-02 15:04:05"))
+}
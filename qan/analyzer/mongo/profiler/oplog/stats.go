@@ -0,0 +1,21 @@
+package oplog
+
+import (
+	"expvar"
+)
+
+type stats struct {
+	EntriesIn     *expvar.Int    `name:"entries-in"`
+	WritesIn      *expvar.Int    `name:"writes-in"`
+	WritesSkipped *expvar.Int    `name:"writes-skipped"`
+	ReportsOut    *expvar.Int    `name:"reports-out"`
+	TxnsPending   *expvar.Int    `name:"txns-pending"`
+	IntervalStart *expvar.String `name:"interval-start"`
+	Ts            *expvar.String `name:"ts"`
+	Gap           *expvar.String `name:"gap"`
+	ParseErrLast  *expvar.String `name:"parse-err-last"`
+	ParseErrCount *expvar.Int    `name:"parse-err-counter"`
+	ReadErrLast   *expvar.String `name:"read-err-last"`
+	ReadErrCount  *expvar.Int    `name:"read-err-counter"`
+	CheckpointErr *expvar.String `name:"checkpoint-err-last"`
+}
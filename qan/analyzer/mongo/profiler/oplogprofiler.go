@@ -0,0 +1,149 @@
+package profiler
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/data"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/oplog"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/sender"
+)
+
+// NewOplogProfiler returns profiler which reports write operations read
+// from the oplog, so writes on secondaries and writes the profiler misses
+// under load are reported too. Reports are flagged as writes, they have no timings.
+func NewOplogProfiler(
+	dialInfo *pmgo.DialInfo,
+	dialer pmgo.Dialer,
+	logger *pct.Logger,
+	spool data.Spooler,
+	config pc.QAN,
+	checkpointFile string,
+) *oplogProfiler {
+	return &oplogProfiler{
+		dialInfo:       dialInfo,
+		dialer:         dialer,
+		logger:         logger,
+		spool:          spool,
+		config:         config,
+		checkpointFile: checkpointFile,
+	}
+}
+
+type oplogProfiler struct {
+	// dependencies
+	dialInfo       *pmgo.DialInfo
+	dialer         pmgo.Dialer
+	spool          data.Spooler
+	logger         *pct.Logger
+	config         pc.QAN
+	checkpointFile string
+
+	// internal deps
+	session   pmgo.SessionManager
+	collector *oplog.Collector
+	sender    *sender.Sender
+
+	// state
+	sync.RWMutex      // Lock() to protect internal consistency of the service
+	running      bool // Is this service running?
+}
+
+// Start starts analyzer but doesn't wait until it exits
+func (self *oplogProfiler) Start() (err error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil
+	}
+
+	databases, namespaces, err := newFilters(self.config)
+	if err != nil {
+		return err
+	}
+
+	// create new session
+	self.session, err = createSession(self.dialInfo, self.dialer)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		// if we failed to start be sure that any started internal service is shutdown
+		if err != nil {
+			self.stop()
+		}
+	}()
+
+	// create collector which aggregates writes from the oplog into qan reports
+	self.collector = oplog.New(self.session, self.config, self.checkpointFile)
+	self.collector.SetFilter(databases, namespaces)
+	reportChan, err := self.collector.Start()
+	if err != nil {
+		return err
+	}
+
+	// create sender which sends qan reports and start it
+	self.sender = sender.New(reportChan, self.spool, self.logger)
+	self.sender.SetWritten(self.collector.Written)
+	if err = self.sender.Start(); err != nil {
+		return err
+	}
+
+	self.running = true
+	return nil
+}
+
+// Status returns list of statuses
+func (self *oplogProfiler) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	statuses := map[string]string{}
+	for k, v := range self.collector.Status() {
+		statuses[fmt.Sprintf("%s-%s", self.collector.Name(), k)] = v
+	}
+	for k, v := range self.sender.Status() {
+		statuses[fmt.Sprintf("%s-%s", "sender", k)] = v
+	}
+	statuses["servers"] = strings.Join(self.session.LiveServers(), ", ")
+	return statuses
+}
+
+// Stop stops running analyzer, waits until it stops
+func (self *oplogProfiler) Stop() error {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return nil
+	}
+
+	self.stop()
+
+	// set state to "not running"
+	self.running = false
+	return nil
+}
+
+// stop stops internal services in order data flows through them.
+func (self *oplogProfiler) stop() {
+	if self.collector != nil {
+		self.collector.Stop()
+		self.collector = nil
+	}
+	if self.sender != nil {
+		self.sender.Stop()
+		self.sender = nil
+	}
+	if self.session != nil {
+		self.session.Close()
+		self.session = nil
+	}
+}
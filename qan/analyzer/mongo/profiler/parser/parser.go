@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"strings"
 	"sync"
 
 	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	mstats "github.com/percona/percona-toolkit/src/go/mongolib/stats"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
 )
@@ -24,6 +26,10 @@ type Parser struct {
 	docsChan   <-chan proto.SystemProfile
 	aggregator *aggregator.Aggregator
 
+	// dependencies from setter SetFilter
+	databases  *filter.Filter
+	namespaces *filter.Filter
+
 	// status
 	status *status.Status
 
@@ -34,6 +40,13 @@ type Parser struct {
 	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
 }
 
+// SetFilter sets filters of databases and namespaces, documents which
+// aren't allowed by them aren't aggregated. It must be called before Start.
+func (self *Parser) SetFilter(databases, namespaces *filter.Filter) {
+	self.databases = databases
+	self.namespaces = namespaces
+}
+
 // Start starts but doesn't wait until it exits
 func (self *Parser) Start() error {
 	self.Lock()
@@ -58,6 +71,8 @@ func (self *Parser) Start() error {
 		self.wg,
 		self.docsChan,
 		self.aggregator,
+		self.databases,
+		self.namespaces,
 		self.doneChan,
 		stats,
 	)
@@ -101,6 +116,8 @@ func start(
 	wg *sync.WaitGroup,
 	docsChan <-chan proto.SystemProfile,
 	aggregator *aggregator.Aggregator,
+	databases *filter.Filter,
+	namespaces *filter.Filter,
 	doneChan <-chan struct{},
 	stats *stats,
 ) {
@@ -128,6 +145,13 @@ func start(
 			// we got new doc, increase stats
 			stats.InDocs.Add(1)
 
+			// skip doc if its database or namespace is filtered out
+			db := strings.SplitN(doc.Ns, ".", 2)[0]
+			if !databases.Allow(db) || !namespaces.Allow(doc.Ns) {
+				stats.FilteredDocs.Add(1)
+				continue
+			}
+
 			// aggregate the doc
 			var err error
 			err = aggregator.Add(doc)
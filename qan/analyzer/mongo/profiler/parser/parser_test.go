@@ -8,6 +8,7 @@ import (
 	pm "github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -139,3 +140,38 @@ func TestParser_running(t *testing.T) {
 
 	parser1.Stop()
 }
+
+func TestParser_filter(t *testing.T) {
+	docsChan := make(chan pm.SystemProfile)
+	a := aggregator.New(time.Now(), pc.QAN{Interval: 60})
+	a.Start()
+	defer a.Stop()
+
+	databases, err := filter.New(nil, []string{"admin", "local"})
+	require.NoError(t, err)
+	namespaces, err := filter.New(nil, []string{"*.sessions"})
+	require.NoError(t, err)
+
+	parser1 := New(docsChan, a)
+	parser1.SetFilter(databases, namespaces)
+	err = parser1.Start()
+	require.NoError(t, err)
+	defer parser1.Stop()
+
+	for _, ns := range []string{"admin.system.version", "app.sessions", "app.users"} {
+		select {
+		case docsChan <- pm.SystemProfile{Ts: time.Now(), Ns: ns, Op: "query", Query: pm.BsonD{{Name: "find", Value: "test"}}}:
+		case <-time.After(5 * time.Second):
+			t.Fatal("test timeout")
+		}
+	}
+
+	// wait for the last doc to be processed
+	for i := 0; i < 50 && parser1.Status()["docs-ok"] != "1"; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	status := parser1.Status()
+	assert.Equal(t, "3", status["docs-in"])
+	assert.Equal(t, "2", status["filtered-docs"])
+	assert.Equal(t, "1", status["docs-ok"])
+}
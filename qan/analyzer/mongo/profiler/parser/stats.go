@@ -13,4 +13,5 @@ type stats struct {
 	ErrFingerprint *expvar.Int    `name:"err-fingerprint"`
 	ErrParse       *expvar.Int    `name:"err-parse"`
 	SkippedDocs    *expvar.Int    `name:"skipped-docs"`
+	FilteredDocs   *expvar.Int    `name:"filtered-docs"`
 }
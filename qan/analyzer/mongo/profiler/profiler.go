@@ -10,6 +10,7 @@ import (
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/filter"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/aggregator"
 	"github.com/percona/qan-agent/qan/analyzer/mongo/profiler/sender"
 )
@@ -38,11 +39,16 @@ type profiler struct {
 	logger   *pct.Logger
 	config   pc.QAN
 
+	// dependency from setter SetMember
+	member *member
+
 	// internal deps
 	monitors   *monitors
 	session    pmgo.SessionManager
 	aggregator *aggregator.Aggregator
 	sender     *sender.Sender
+	profiling  map[string]profilingLevel // databases with profiling enabled since first start
+	previous   map[string]profilingLevel // profiling before it was set per config, restored on stop
 
 	// state
 	sync.RWMutex                 // Lock() to protect internal consistency of the service
@@ -51,6 +57,12 @@ type profiler struct {
 	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
 }
 
+// SetMember sets the replica set or sharded cluster member the profiler
+// collects from, reports are tagged with it. It must be called before Start.
+func (self *profiler) SetMember(m member) {
+	self.member = &m
+}
+
 // Start starts analyzer but doesn't wait until it exits
 func (self *profiler) Start() error {
 	self.Lock()
@@ -59,6 +71,14 @@ func (self *profiler) Start() error {
 		return nil
 	}
 
+	if err := validateProfiling(self.config); err != nil {
+		return err
+	}
+	databases, namespaces, err := newFilters(self.config)
+	if err != nil {
+		return err
+	}
+
 	// create new session
 	session, err := createSession(self.dialInfo, self.dialer)
 	if err != nil {
@@ -66,8 +86,25 @@ func (self *profiler) Start() error {
 	}
 	self.session = session
 
+	// Re-enable profiling where it was enabled before, e.g. if the profiler
+	// is restarted because mongod restarted, then add where it's enabled now.
+	if self.profiling == nil {
+		self.profiling = map[string]profilingLevel{}
+	}
+	restoreProfiling(session, self.profiling, self.logger)
+	if levels, err := getProfilingLevels(session); err != nil {
+		self.logger.Warn("Cannot get profiling levels:", err)
+	} else {
+		for dbName, level := range levels {
+			self.profiling[dbName] = level
+		}
+	}
+
 	// create aggregator which collects documents and aggregates them into qan report
 	self.aggregator = aggregator.New(time.Now(), self.config)
+	if self.member != nil {
+		self.aggregator.SetMember(self.member.Addr, self.member.ReplSet, self.member.Shard)
+	}
 	reportChan := self.aggregator.Start()
 
 	// create sender which sends qan reports and start it
@@ -77,13 +114,22 @@ func (self *profiler) Start() error {
 		return err
 	}
 
+	// set profiling per config on every database we start to monitor,
+	// including databases created later
+	if self.previous == nil {
+		self.previous = map[string]profilingLevel{}
+	}
 	f := func(
 		session pmgo.SessionManager,
 		dbName string,
 	) *monitor {
+		if err := configureProfiling(session, dbName, self.config, self.previous); err != nil {
+			self.logger.Warn(fmt.Sprintf("Cannot configure profiling on %s: %s", dbName, err))
+		}
 		return NewMonitor(
 			session,
 			dbName,
+			namespaces,
 			self.aggregator,
 			self.logger,
 			self.spool,
@@ -94,6 +140,7 @@ func (self *profiler) Start() error {
 	// create monitors service which we use to periodically scan server for new/removed databases
 	self.monitors = NewMonitors(
 		session,
+		databases,
 		f,
 	)
 
@@ -174,6 +221,9 @@ func (self *profiler) Status() map[string]string {
 		return true
 	})
 	statusesMap["servers"] = strings.Join(self.session.LiveServers(), ", ")
+	if filtered := self.monitors.Filtered(); len(filtered) > 0 {
+		statusesMap["databases-filtered"] = fmt.Sprintf("%d (%s)", len(filtered), strings.Join(filtered, ", "))
+	}
 	return statusesMap
 }
 
@@ -181,8 +231,24 @@ func (self *profiler) Status() map[string]string {
 func (self *profiler) Stop() error {
 	self.Lock()
 	defer self.Unlock()
+	self.stop(true)
+	return nil
+}
+
+// Restart stops and starts the profiler, e.g. after mongod restarted.
+// Profiling is left as configured in between, so it isn't seen disabled.
+func (self *profiler) Restart() error {
+	self.Lock()
+	self.stop(false)
+	self.Unlock()
+	return self.Start()
+}
+
+// stop stops running analyzer and, if unconfigure is true, restores
+// the profiling settings it changed.
+func (self *profiler) stop(unconfigure bool) {
 	if !self.running {
-		return nil
+		return
 	}
 
 	// notify goroutine to close
@@ -197,12 +263,16 @@ func (self *profiler) Stop() error {
 	// stop sender; do it after goroutine is closed
 	self.sender.Stop()
 
+	// restore profiling we changed; do it after goroutine is closed
+	if unconfigure {
+		unconfigureProfiling(self.session, self.previous, self.logger)
+	}
+
 	// close the session; do it after goroutine is closed
 	self.session.Close()
 
 	// set state to "not running"
 	self.running = false
-	return nil
 }
 
 func start(
@@ -243,3 +313,21 @@ func signalReady(ready *sync.Cond) {
 	defer ready.L.Unlock()
 	ready.Broadcast()
 }
+
+// DefaultExcludeDatabases are the databases not monitored if QAN config has
+// no database filter: queries there are from replication, sharding and
+// mongodb_exporter, not from the application.
+var DefaultExcludeDatabases = []string{"admin", "local", "config"}
+
+// newFilters returns filters of databases and namespaces per config.
+func newFilters(config pc.QAN) (databases, namespaces *filter.Filter, err error) {
+	databases, err = filter.New(config.IncludeDatabases, config.ExcludeDatabases)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid database filter: %s", err)
+	}
+	namespaces, err = filter.New(config.IncludeNamespaces, config.ExcludeNamespaces)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid namespace filter: %s", err)
+	}
+	return databases, namespaces, nil
+}
@@ -0,0 +1,142 @@
+package profiler
+
+import (
+	"fmt"
+
+	"github.com/percona/pmgo"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/pct"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// profilingLevel is the result of the profile command.
+type profilingLevel struct {
+	Was        int
+	Slowms     int
+	SampleRate float64 `bson:"sampleRate"` // MongoDB 3.6+
+}
+
+// getProfilingLevels returns the profiling levels of the databases on which
+// profiling is enabled.
+func getProfilingLevels(session pmgo.SessionManager) (map[string]profilingLevel, error) {
+	session = session.Copy()
+	defer session.Close()
+
+	dbNames, err := session.DatabaseNames()
+	if err != nil {
+		return nil, err
+	}
+	levels := map[string]profilingLevel{}
+	for _, dbName := range dbNames {
+		level := profilingLevel{}
+		if err := session.DB(dbName).Run(bson.M{"profile": -1}, &level); err != nil {
+			return nil, err
+		}
+		if level.Was > 0 {
+			levels[dbName] = level
+		}
+	}
+	return levels, nil
+}
+
+// restoreProfiling re-enables profiling on the databases on which it was
+// enabled, per levels, but has been disabled since, e.g. by a mongod restart
+// or db.setProfilingLevel(0).
+func restoreProfiling(session pmgo.SessionManager, levels map[string]profilingLevel, logger *pct.Logger) {
+	session = session.Copy()
+	defer session.Close()
+
+	for dbName, level := range levels {
+		now := profilingLevel{}
+		if err := session.DB(dbName).Run(bson.M{"profile": -1}, &now); err != nil {
+			logger.Warn(fmt.Sprintf("Cannot get profiling level of %s: %s", dbName, err))
+			continue
+		}
+		if now.Was > 0 {
+			continue
+		}
+		cmd := bson.D{
+			{Name: "profile", Value: level.Was},
+			{Name: "slowms", Value: level.Slowms},
+		}
+		if err := session.DB(dbName).Run(cmd, nil); err != nil {
+			logger.Warn(fmt.Sprintf("Cannot re-enable profiling on %s: %s", dbName, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("Re-enabled profiling on %s (level %d, slowms %d)", dbName, level.Was, level.Slowms))
+	}
+}
+
+// profileCmd returns the profile command which sets the profiling level
+// and, if not nil, slowms and sampleRate.
+func profileCmd(level int, slowms *int, sampleRate *float64) bson.D {
+	cmd := bson.D{{Name: "profile", Value: level}}
+	if slowms != nil {
+		cmd = append(cmd, bson.DocElem{Name: "slowms", Value: *slowms})
+	}
+	if sampleRate != nil {
+		cmd = append(cmd, bson.DocElem{Name: "sampleRate", Value: *sampleRate})
+	}
+	return cmd
+}
+
+// configureProfiling sets the profiling configured in QAN config on dbName.
+// The settings it had before are saved to previous, unless they are
+// saved already, so they can be restored by unconfigureProfiling.
+func configureProfiling(session pmgo.SessionManager, dbName string, config pc.QAN, previous map[string]profilingLevel) error {
+	if config.ProfilingLevel == nil {
+		return nil
+	}
+	session = session.Copy()
+	defer session.Close()
+
+	if _, ok := previous[dbName]; !ok {
+		level := profilingLevel{}
+		if err := session.DB(dbName).Run(bson.M{"profile": -1}, &level); err != nil {
+			return err
+		}
+		previous[dbName] = level
+	}
+	cmd := profileCmd(*config.ProfilingLevel, config.Slowms, config.SampleRate)
+	return session.DB(dbName).Run(cmd, nil)
+}
+
+// unconfigureProfiling restores the profiling settings saved by configureProfiling.
+func unconfigureProfiling(session pmgo.SessionManager, previous map[string]profilingLevel, logger *pct.Logger) {
+	session = session.Copy()
+	defer session.Close()
+
+	for dbName, level := range previous {
+		var sampleRate *float64
+		if level.SampleRate > 0 {
+			sampleRate = &level.SampleRate
+		}
+		cmd := profileCmd(level.Was, &level.Slowms, sampleRate)
+		if err := session.DB(dbName).Run(cmd, nil); err != nil {
+			logger.Warn(fmt.Sprintf("Cannot restore profiling on %s: %s", dbName, err))
+			continue
+		}
+		logger.Info(fmt.Sprintf("Restored profiling on %s (level %d, slowms %d)", dbName, level.Was, level.Slowms))
+		delete(previous, dbName)
+	}
+}
+
+// validateProfiling returns error if profiling options in QAN config are invalid.
+func validateProfiling(config pc.QAN) error {
+	if config.ProfilingLevel == nil {
+		if config.Slowms != nil || config.SampleRate != nil {
+			return fmt.Errorf("Slowms and SampleRate require ProfilingLevel")
+		}
+		return nil
+	}
+	if level := *config.ProfilingLevel; level < 0 || level > 2 {
+		return fmt.Errorf("invalid ProfilingLevel: %d; expected 0, 1 or 2", level)
+	}
+	if config.Slowms != nil && *config.Slowms < 0 {
+		return fmt.Errorf("invalid Slowms: %d; expected >= 0", *config.Slowms)
+	}
+	if config.SampleRate != nil && (*config.SampleRate <= 0 || *config.SampleRate > 1) {
+		return fmt.Errorf("invalid SampleRate: %g; expected > 0 and <= 1", *config.SampleRate)
+	}
+	return nil
+}
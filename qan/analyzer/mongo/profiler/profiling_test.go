@@ -0,0 +1,36 @@
+package profiler
+
+import (
+	"testing"
+
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestProfileCmd(t *testing.T) {
+	slowms := 50
+	sampleRate := 0.5
+
+	assert.Equal(t, bson.D{{Name: "profile", Value: 2}}, profileCmd(2, nil, nil))
+	assert.Equal(t,
+		bson.D{
+			{Name: "profile", Value: 1},
+			{Name: "slowms", Value: 50},
+			{Name: "sampleRate", Value: 0.5},
+		},
+		profileCmd(1, &slowms, &sampleRate),
+	)
+}
+
+func TestValidateProfiling(t *testing.T) {
+	level := func(v int) *int { return &v }
+	rate := func(v float64) *float64 { return &v }
+
+	assert.NoError(t, validateProfiling(pc.QAN{}))
+	assert.NoError(t, validateProfiling(pc.QAN{ProfilingLevel: level(1), Slowms: level(100), SampleRate: rate(1)}))
+	assert.Error(t, validateProfiling(pc.QAN{ProfilingLevel: level(3)}))
+	assert.Error(t, validateProfiling(pc.QAN{ProfilingLevel: level(1), Slowms: level(-1)}))
+	assert.Error(t, validateProfiling(pc.QAN{ProfilingLevel: level(1), SampleRate: rate(0)}))
+	assert.Error(t, validateProfiling(pc.QAN{Slowms: level(100)}))
+}
@@ -0,0 +1,194 @@
+package sampler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmgo"
+	"github.com/percona/qan-agent/qan/analyzer/mongo/status"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	DefaultInterval = 1 * time.Second
+)
+
+// New returns Sampler which samples in-flight operations every interval,
+// for deployments where profiling can't be enabled.
+func New(session pmgo.SessionManager, interval time.Duration) *Sampler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	return &Sampler{
+		session:  session,
+		interval: interval,
+	}
+}
+
+type Sampler struct {
+	// dependencies
+	session  pmgo.SessionManager
+	interval time.Duration
+
+	// provides
+	docsChan chan proto.SystemProfile
+
+	// status
+	status *status.Status
+
+	// state
+	sync.RWMutex                 // Lock() to protect internal consistency of the service
+	running      bool            // Is this service running?
+	doneChan     chan struct{}   // close(doneChan) to notify goroutines that they should shutdown
+	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
+}
+
+// Start starts but doesn't wait until it exits
+func (self *Sampler) Start() (<-chan proto.SystemProfile, error) {
+	self.Lock()
+	defer self.Unlock()
+	if self.running {
+		return nil, nil
+	}
+
+	// create new channels over which we will communicate to...
+	// ... outside world by sending collected docs
+	self.docsChan = make(chan proto.SystemProfile, 100)
+	// ... inside goroutine to close it
+	self.doneChan = make(chan struct{})
+
+	// set status
+	stats := &stats{}
+	self.status = status.New(stats)
+
+	// start a goroutine and Add() it to WaitGroup
+	// so we could later Wait() for it to finish
+	self.wg = &sync.WaitGroup{}
+	self.wg.Add(1)
+	go start(
+		self.wg,
+		self.session,
+		self.interval,
+		self.docsChan,
+		self.doneChan,
+		stats,
+	)
+
+	self.running = true
+	return self.docsChan, nil
+}
+
+// Stop stops running
+func (self *Sampler) Stop() {
+	self.Lock()
+	defer self.Unlock()
+	if !self.running {
+		return
+	}
+	self.running = false
+
+	// notify goroutine to close
+	close(self.doneChan)
+
+	// wait for goroutines to exit
+	self.wg.Wait()
+
+	// we can now safely close channels goroutines write to as goroutine is stopped
+	close(self.docsChan)
+	return
+}
+
+func (self *Sampler) Status() map[string]string {
+	self.RLock()
+	defer self.RUnlock()
+	if !self.running {
+		return nil
+	}
+
+	return self.status.Map()
+}
+
+func (self *Sampler) Name() string {
+	return "sampler"
+}
+
+func start(
+	wg *sync.WaitGroup,
+	session pmgo.SessionManager,
+	interval time.Duration,
+	docsChan chan<- proto.SystemProfile,
+	doneChan <-chan struct{},
+	stats *stats,
+) {
+	// signal WaitGroup when goroutine finished
+	defer wg.Done()
+
+	t := newTracker()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		// check if we should shutdown
+		case <-doneChan:
+			return
+		case <-ticker.C:
+		}
+
+		sampled, err := sample(session)
+		if err != nil {
+			// operations in flight are reported when sampling works again
+			stats.SampleErrCount.Add(1)
+			stats.SampleErrLast.Set(err.Error())
+			continue
+		}
+		now := time.Now().UTC()
+		stats.Samples.Add(1)
+		stats.SampleLast.Set(now.Format("2006-01-02 15:04:05"))
+
+		finished := t.sample(now, sampled)
+		stats.InFlight.Set(int64(len(t.inFlight)))
+		for _, doc := range finished {
+			// try to push doc or exit if we should shutdown
+			select {
+			case docsChan <- doc:
+				stats.Out.Add(1)
+			case <-doneChan:
+				return
+			}
+		}
+	}
+}
+
+// sample returns operations in flight, using $currentOp aggregation stage (MongoDB 3.6+)
+// or, if it's not supported, the currentOp command.
+func sample(session pmgo.SessionManager) ([]currentOp, error) {
+	session = session.Copy()
+	defer session.Close()
+
+	result := struct {
+		Cursor struct {
+			FirstBatch []currentOp `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}{}
+	cmd := bson.D{
+		{Name: "aggregate", Value: 1},
+		{Name: "pipeline", Value: []bson.M{
+			{"$currentOp": bson.M{"allUsers": true}},
+			{"$match": bson.M{"active": true}},
+		}},
+		{Name: "cursor", Value: bson.M{"batchSize": 10000}},
+	}
+	err := session.DB("admin").Run(cmd, &result)
+	if err == nil {
+		return result.Cursor.FirstBatch, nil
+	}
+
+	inprog := struct {
+		Inprog []currentOp `bson:"inprog"`
+	}{}
+	if err := session.DB("admin").Run(bson.D{{Name: "currentOp", Value: 1}, {Name: "active", Value: true}}, &inprog); err != nil {
+		return nil, err
+	}
+	return inprog.Inprog, nil
+}
@@ -0,0 +1,132 @@
+package sampler
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/percona/pmgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeSession replies to the command with the next of samples, and with no
+// operations once samples run out. Without aggregate it replies only to the
+// currentOp command, like MongoDB before 3.6.
+type fakeSession struct {
+	pmgo.SessionManager
+	aggregate bool
+
+	sync.Mutex
+	samples [][]bson.M
+	runs    []string
+}
+
+func (s *fakeSession) Copy() pmgo.SessionManager      { return s }
+func (s *fakeSession) Close()                         {}
+func (s *fakeSession) DB(string) pmgo.DatabaseManager { return &fakeDatabase{session: s} }
+
+func (s *fakeSession) run(cmd, result interface{}) error {
+	s.Lock()
+	defer s.Unlock()
+	name := cmd.(bson.D)[0].Name
+	s.runs = append(s.runs, name)
+
+	var reply bson.M
+	switch {
+	case name == "aggregate" && s.aggregate:
+		reply = bson.M{"cursor": bson.M{"firstBatch": s.next()}}
+	case name == "currentOp" && !s.aggregate:
+		reply = bson.M{"inprog": s.next()}
+	default:
+		return fmt.Errorf("no such command: %s", name)
+	}
+	data, err := bson.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, result)
+}
+
+func (s *fakeSession) next() []bson.M {
+	if len(s.samples) == 0 {
+		return []bson.M{}
+	}
+	ops := s.samples[0]
+	s.samples = s.samples[1:]
+	return ops
+}
+
+type fakeDatabase struct {
+	pmgo.DatabaseManager
+	session *fakeSession
+}
+
+func (d *fakeDatabase) Run(cmd, result interface{}) error { return d.session.run(cmd, result) }
+
+func TestSampler(t *testing.T) {
+	find := bson.M{
+		"opid":              int64(1),
+		"active":            true,
+		"op":                "query",
+		"ns":                "test.coll",
+		"command":           bson.D{{Name: "find", Value: "coll"}},
+		"microsecs_running": int64(200000),
+	}
+	session := &fakeSession{
+		aggregate: true,
+		samples:   [][]bson.M{{find}, {find}},
+	}
+
+	s := New(session, 10*time.Millisecond)
+	docsChan, err := s.Start()
+	require.NoError(t, err)
+
+	// operation is reported once it's no longer in flight
+	select {
+	case doc := <-docsChan:
+		assert.Equal(t, "query", doc.Op)
+		assert.Equal(t, "test.coll", doc.Ns)
+		assert.Equal(t, "coll", doc.Command.Map()["find"])
+		assert.True(t, doc.Millis >= 200)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for sampled operation")
+	}
+
+	s.Stop()
+	_, ok := <-docsChan
+	assert.False(t, ok)
+	assert.Nil(t, s.Status())
+}
+
+func TestSamplerCurrentOpCommand(t *testing.T) {
+	insert := bson.M{
+		"opid":   int64(1),
+		"active": true,
+		"op":     "insert",
+		"ns":     "test.coll",
+	}
+	session := &fakeSession{
+		samples: [][]bson.M{{insert}},
+	}
+
+	s := New(session, 10*time.Millisecond)
+	docsChan, err := s.Start()
+	require.NoError(t, err)
+	defer s.Stop()
+
+	select {
+	case doc := <-docsChan:
+		assert.Equal(t, "insert", doc.Op)
+		assert.Equal(t, "test.coll", doc.Ns)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for sampled operation")
+	}
+
+	// $currentOp isn't supported, so currentOp command is used instead
+	session.Lock()
+	defer session.Unlock()
+	assert.Equal(t, []string{"aggregate", "currentOp"}, session.runs[:2])
+}
@@ -0,0 +1,14 @@
+package sampler
+
+import (
+	"expvar"
+)
+
+type stats struct {
+	Samples        *expvar.Int    `name:"samples"`
+	SampleLast     *expvar.String `name:"sample-last"`
+	InFlight       *expvar.Int    `name:"in-flight"`
+	Out            *expvar.Int    `name:"out"`
+	SampleErrLast  *expvar.String `name:"sample-err-last"`
+	SampleErrCount *expvar.Int    `name:"sample-err-counter"`
+}
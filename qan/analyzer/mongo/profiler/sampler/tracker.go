@@ -0,0 +1,124 @@
+package sampler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// currentOp is an in-flight operation reported by $currentOp.
+type currentOp struct {
+	Opid               interface{} `bson:"opid"` // number, or "shard:number" on mongos
+	Active             bool        `bson:"active"`
+	Op                 string      `bson:"op"`
+	Ns                 string      `bson:"ns"`
+	Command            proto.BsonD `bson:"command"`
+	OriginatingCommand proto.BsonD `bson:"originatingCommand"`
+	MicrosecsRunning   int64       `bson:"microsecs_running"`
+	NumYields          int         `bson:"numYields"`
+	Client             string      `bson:"client"`
+}
+
+// ops we report, the same as system.profile has.
+var ops = map[string]bool{
+	"query":   true,
+	"getmore": true,
+	"insert":  true,
+	"update":  true,
+	"remove":  true,
+	"command": true,
+}
+
+// inFlight is an operation seen in the last sample.
+type inFlight struct {
+	op       currentOp
+	lastSeen time.Time
+}
+
+// tracker follows operations across samples. An operation which isn't
+// in a sample anymore has finished, so its execution time is estimated
+// from the last sample it was seen in.
+type tracker struct {
+	inFlight map[string]*inFlight
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		inFlight: map[string]*inFlight{},
+	}
+}
+
+// sample adds operations sampled at now and returns operations which finished
+// since the previous sample. Operations shorter than the time between samples
+// are likely missed and the result isn't extrapolated for them, so reports
+// are flagged as sampled, see aggregator.SetSampled.
+func (t *tracker) sample(now time.Time, sampled []currentOp) []proto.SystemProfile {
+	seen := map[string]bool{}
+	for _, op := range sampled {
+		if !op.Active || !ops[op.Op] || op.Ns == "" || isCurrentOp(op.Command) {
+			continue
+		}
+		id := fmt.Sprint(op.Opid)
+		seen[id] = true
+		f, ok := t.inFlight[id]
+		if !ok {
+			f = &inFlight{}
+			t.inFlight[id] = f
+		}
+		f.op = op
+		f.lastSeen = now
+	}
+
+	finished := []proto.SystemProfile{}
+	for id, f := range t.inFlight {
+		if seen[id] {
+			continue
+		}
+		finished = append(finished, f.doc(now))
+		delete(t.inFlight, id)
+	}
+	return finished
+}
+
+// doc returns system.profile-like document of the operation which finished
+// between lastSeen and now, so we assume it finished halfway.
+func (f *inFlight) doc(now time.Time) proto.SystemProfile {
+	half := now.Sub(f.lastSeen) / 2
+	running := time.Duration(f.op.MicrosecsRunning)*time.Microsecond + half
+	return proto.SystemProfile{
+		Ts:                 f.lastSeen.Add(half),
+		Op:                 f.op.Op,
+		Ns:                 f.op.Ns,
+		Command:            f.op.Command,
+		OriginatingCommand: f.op.OriginatingCommand,
+		Millis:             int(running / time.Millisecond),
+		NumYield:           f.op.NumYields,
+		Client:             f.op.Client,
+	}
+}
+
+// isCurrentOp returns true if cmd is our own $currentOp aggregation or currentOp command.
+func isCurrentOp(cmd proto.BsonD) bool {
+	if len(cmd) > 0 && cmd[0].Name == "currentOp" {
+		return true
+	}
+	for _, e := range cmd {
+		if e.Name != "pipeline" {
+			continue
+		}
+		stages, ok := e.Value.([]interface{})
+		if !ok || len(stages) == 0 {
+			return false
+		}
+		switch stage := stages[0].(type) {
+		case bson.M:
+			_, ok := stage["$currentOp"]
+			return ok
+		case bson.D:
+			return len(stage) > 0 && stage[0].Name == "$currentOp"
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package sampler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestTracker(t *testing.T) {
+	t0 := time.Date(2020, 9, 25, 12, 0, 0, 0, time.UTC)
+	find := currentOp{
+		Opid:             int64(1),
+		Active:           true,
+		Op:               "query",
+		Ns:               "test.coll",
+		Command:          proto.BsonD{{Name: "find", Value: "coll"}},
+		MicrosecsRunning: 200000,
+	}
+	own := currentOp{
+		Opid:   int64(2),
+		Active: true,
+		Op:     "command",
+		Ns:     "admin.$cmd.aggregate",
+		Command: proto.BsonD{
+			{Name: "aggregate", Value: int64(1)},
+			{Name: "pipeline", Value: []interface{}{bson.M{"$currentOp": bson.M{"allUsers": true}}}},
+		},
+	}
+	internal := currentOp{Opid: int64(3), Active: true, Op: "none"}
+
+	tr := newTracker()
+	assert.Empty(t, tr.sample(t0, []currentOp{find, own, internal}))
+	assert.Len(t, tr.inFlight, 1)
+
+	// still running
+	find.MicrosecsRunning = 1200000
+	assert.Empty(t, tr.sample(t0.Add(time.Second), []currentOp{find}))
+
+	// finished between samples, so it's assumed it finished halfway
+	docs := tr.sample(t0.Add(2*time.Second), nil)
+	require.Len(t, docs, 1)
+	assert.Equal(t, "query", docs[0].Op)
+	assert.Equal(t, "test.coll", docs[0].Ns)
+	assert.Equal(t, 1700, docs[0].Millis)
+	assert.Equal(t, t0.Add(1500*time.Millisecond), docs[0].Ts)
+	assert.Empty(t, tr.inFlight)
+}
+
+func TestIsCurrentOp(t *testing.T) {
+	assert.True(t, isCurrentOp(proto.BsonD{{Name: "currentOp", Value: 1}}))
+	assert.True(t, isCurrentOp(proto.BsonD{
+		{Name: "aggregate", Value: 1},
+		{Name: "pipeline", Value: []interface{}{bson.D{{Name: "$currentOp", Value: bson.M{}}}}},
+	}))
+	assert.False(t, isCurrentOp(proto.BsonD{
+		{Name: "aggregate", Value: "coll"},
+		{Name: "pipeline", Value: []interface{}{bson.M{"$match": bson.M{}}}},
+	}))
+	assert.False(t, isCurrentOp(proto.BsonD{{Name: "find", Value: "coll"}}))
+}
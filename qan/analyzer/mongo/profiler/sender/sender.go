@@ -23,6 +23,9 @@ type Sender struct {
 	spool      data.Spooler
 	logger     *pct.Logger
 
+	// dependencies from setter SetWritten
+	written func(*qan.Report, error)
+
 	// stats
 	status *status.Status
 
@@ -33,6 +36,13 @@ type Sender struct {
 	wg           *sync.WaitGroup // Wait() for goroutines to stop after being notified they should shutdown
 }
 
+// SetWritten sets f which is called with every report and the error of its
+// spool write, e.g. so its producer saves a checkpoint only when the report
+// is spooled. It must be called before Start.
+func (self *Sender) SetWritten(f func(report *qan.Report, err error)) {
+	self.written = f
+}
+
 // Start starts but doesn't wait until it exits
 func (self *Sender) Start() error {
 	self.Lock()
@@ -58,6 +68,7 @@ func (self *Sender) Start() error {
 		self.reportChan,
 		self.spool,
 		self.logger,
+		self.written,
 		self.doneChan,
 		stats,
 	)
@@ -102,39 +113,51 @@ func start(
 	reportChan <-chan *qan.Report,
 	spool data.Spooler,
 	logger *pct.Logger,
+	written func(*qan.Report, error),
 	doneChan <-chan struct{},
 	stats *stats,
 ) {
 	// signal WaitGroup when goroutine finished
 	defer wg.Done()
 
+	// sent report
+	send := func(report *qan.Report) {
+		stats.In.Add(1)
+		err := spool.Write("qan", report)
+		if written != nil {
+			written(report, err)
+		}
+		if err != nil {
+			stats.ErrIter.Add(1)
+			logger.Warn("Lost report:", err)
+			return
+		}
+		stats.Out.Add(1)
+	}
+
 	for {
 
 		select {
 		case report, ok := <-reportChan:
-			stats.In.Add(1)
 			// if channel got closed we should exit as there is nothing we can listen to
 			if !ok {
 				return
 			}
-
-			// check if we should shutdown
-			select {
-			case <-doneChan:
-				return
-			default:
-				// just continue if not
-			}
-
-			// sent report
-			if err := spool.Write("qan", report); err != nil {
-				stats.ErrIter.Add(1)
-				logger.Warn("Lost report:", err)
-				continue
-			}
-			stats.Out.Add(1)
+			send(report)
 		case <-doneChan:
-			return
+			// send reports queued before shutdown, e.g. flushed
+			// when the aggregator stopped, then exit
+			for {
+				select {
+				case report, ok := <-reportChan:
+					if !ok {
+						return
+					}
+					send(report)
+				default:
+					return
+				}
+			}
 		}
 	}
 
@@ -69,3 +69,26 @@ func TestSender_Start(t *testing.T) {
 	sender1.Stop()
 	sender1.Stop()
 }
+
+func TestSender_SetWritten(t *testing.T) {
+	reportChan := make(chan *qan.Report)
+	dataChan := make(chan interface{}, 1)
+	spool := mock.NewSpooler(dataChan)
+	logger := pct.NewLogger(make(chan proto.LogEntry, 10), "test")
+	sender1 := New(reportChan, spool, logger)
+
+	writtenChan := make(chan *qan.Report, 1)
+	sender1.SetWritten(func(report *qan.Report, err error) {
+		require.NoError(t, err)
+		// the report is spooled before it's reported as written
+		require.Len(t, dataChan, 1)
+		writtenChan <- report
+	})
+	require.NoError(t, sender1.Start())
+	defer sender1.Stop()
+
+	report := &qan.Report{UUID: "abc"}
+	reportChan <- report
+	require.Equal(t, report, <-writtenChan)
+	require.Equal(t, report, <-dataChan)
+}
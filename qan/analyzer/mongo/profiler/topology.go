@@ -0,0 +1,138 @@
+package profiler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmgo"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// member is a mongod which runs queries we collect.
+type member struct {
+	Addr    string // host:port
+	ReplSet string // replica set name, "" if standalone
+	Shard   string // shard id, "" if not sharded
+}
+
+// dataStates are states of replica set members which serve queries.
+var dataStates = map[string]bool{
+	"PRIMARY":   true,
+	"SECONDARY": true,
+}
+
+// discoverMembers returns members of the replica set or sharded cluster
+// dialInfo points to: every shard's members for mongos, every member
+// for a replica set member, or the server itself for a standalone mongod.
+func discoverMembers(dialInfo *pmgo.DialInfo, dialer pmgo.Dialer) ([]member, error) {
+	session, err := createSession(copyDialInfo(dialInfo, dialInfo.Addrs), dialer)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	master := proto.MasterDoc{}
+	if err := session.Run(bson.M{"isMaster": 1}, &master); err != nil {
+		return nil, err
+	}
+
+	// mongos
+	if master.Msg == "isdbgrid" {
+		shards := proto.ShardsInfo{}
+		if err := session.DB("admin").Run(bson.M{"listShards": 1}, &shards); err != nil {
+			return nil, err
+		}
+		members := []member{}
+		for _, shard := range shards.Shards {
+			replSet, hosts := parseShardHost(shard.Host)
+			// a shard which isn't a replica set is a standalone mongod
+			shardMembers := []member{{Addr: hosts[0]}}
+			if replSet != "" {
+				shardMembers, err = replSetMembers(dialInfo, dialer, hosts)
+				if err != nil {
+					return nil, fmt.Errorf("cannot get members of shard %s: %s", shard.ID, err)
+				}
+			}
+			for _, m := range shardMembers {
+				m.Shard = shard.ID
+				members = append(members, m)
+			}
+		}
+		return sortMembers(members), nil
+	}
+
+	// replica set member
+	if setName, _ := master.SetName.(string); setName != "" {
+		members, err := replSetStatus(session)
+		if err != nil {
+			return nil, err
+		}
+		return sortMembers(members), nil
+	}
+
+	// standalone
+	return []member{{Addr: dialInfo.Addrs[0]}}, nil
+}
+
+// replSetMembers returns members of the replica set from the first of hosts which responds.
+func replSetMembers(dialInfo *pmgo.DialInfo, dialer pmgo.Dialer, hosts []string) ([]member, error) {
+	var lastErr error
+	for _, host := range hosts {
+		session, err := createSession(copyDialInfo(dialInfo, []string{host}), dialer)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		members, err := replSetStatus(session)
+		session.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return members, nil
+	}
+	return nil, lastErr
+}
+
+// replSetStatus returns members which serve queries per replSetGetStatus.
+func replSetStatus(session pmgo.SessionManager) ([]member, error) {
+	status := proto.ReplicaSetStatus{}
+	if err := session.DB("admin").Run(bson.M{"replSetGetStatus": 1}, &status); err != nil {
+		return nil, err
+	}
+	members := []member{}
+	for _, m := range status.Members {
+		if !dataStates[m.StateStr] {
+			continue
+		}
+		members = append(members, member{
+			Addr:    m.Name,
+			ReplSet: status.Set,
+		})
+	}
+	return members, nil
+}
+
+// parseShardHost parses host of listShards, e.g. "rs0/host1:27017,host2:27017".
+func parseShardHost(host string) (replSet string, hosts []string) {
+	if i := strings.Index(host, "/"); i >= 0 {
+		replSet = host[:i]
+		host = host[i+1:]
+	}
+	return replSet, strings.Split(host, ",")
+}
+
+// copyDialInfo returns copy of dialInfo for addrs, so it uses the same credentials.
+func copyDialInfo(dialInfo *pmgo.DialInfo, addrs []string) *pmgo.DialInfo {
+	di := *dialInfo
+	di.Addrs = addrs
+	di.ReplicaSetName = ""
+	return &di
+}
+
+func sortMembers(members []member) []member {
+	sort.Slice(members, func(i, j int) bool { return members[i].Addr < members[j].Addr })
+	return members
+}
@@ -0,0 +1,125 @@
+package profiler
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/percona/pmgo"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakeDialer dials servers which reply to commands with replies[addr][command].
+// A command without reply fails, like replSetGetStatus on a standalone mongod.
+type fakeDialer struct {
+	pmgo.Dialer
+	replies map[string]map[string]bson.M
+}
+
+func (d *fakeDialer) DialWithInfo(dialInfo *pmgo.DialInfo) (pmgo.SessionManager, error) {
+	replies, ok := d.replies[dialInfo.Addrs[0]]
+	if !ok {
+		return nil, fmt.Errorf("no reachable servers")
+	}
+	return &fakeSession{replies: replies}, nil
+}
+
+type fakeSession struct {
+	pmgo.SessionManager
+	replies map[string]bson.M
+}
+
+func (s *fakeSession) SetMode(mgo.Mode, bool)            {}
+func (s *fakeSession) SetSyncTimeout(time.Duration)      {}
+func (s *fakeSession) SetSocketTimeout(time.Duration)    {}
+func (s *fakeSession) Close()                            {}
+func (s *fakeSession) DB(string) pmgo.DatabaseManager    { return &fakeDatabase{session: s} }
+func (s *fakeSession) Run(cmd, result interface{}) error { return s.run(cmd, result) }
+
+func (s *fakeSession) run(cmd, result interface{}) error {
+	for name := range cmd.(bson.M) {
+		reply, ok := s.replies[name]
+		if !ok {
+			return fmt.Errorf("no such command: %s", name)
+		}
+		data, err := bson.Marshal(reply)
+		if err != nil {
+			return err
+		}
+		return bson.Unmarshal(data, result)
+	}
+	return fmt.Errorf("empty command")
+}
+
+type fakeDatabase struct {
+	pmgo.DatabaseManager
+	session *fakeSession
+}
+
+func (d *fakeDatabase) Run(cmd, result interface{}) error { return d.session.run(cmd, result) }
+
+func TestDiscoverMembersStandaloneShard(t *testing.T) {
+	dialer := &fakeDialer{
+		replies: map[string]map[string]bson.M{
+			"mongos:27017": {
+				"isMaster": {"msg": "isdbgrid"},
+				"listShards": {"shards": []bson.M{
+					{"_id": "shard0", "host": "rs0/host1:27017,host2:27017"},
+					{"_id": "shard1", "host": "host3:27018"},
+				}},
+			},
+			"host1:27017": {
+				"replSetGetStatus": {"set": "rs0", "members": []bson.M{
+					{"name": "host1:27017", "stateStr": "PRIMARY"},
+					{"name": "host2:27017", "stateStr": "SECONDARY"},
+				}},
+			},
+			// standalone mongod, replSetGetStatus fails
+			"host3:27018": {
+				"isMaster": {},
+			},
+		},
+	}
+	dialInfo := &pmgo.DialInfo{Addrs: []string{"mongos:27017"}}
+
+	members, err := discoverMembers(dialInfo, dialer)
+	require.NoError(t, err)
+	assert.Equal(t, []member{
+		{Addr: "host1:27017", ReplSet: "rs0", Shard: "shard0"},
+		{Addr: "host2:27017", ReplSet: "rs0", Shard: "shard0"},
+		{Addr: "host3:27018", Shard: "shard1"},
+	}, members)
+}
+
+func TestParseShardHost(t *testing.T) {
+	replSet, hosts := parseShardHost("rs0/host1:27017,host2:27017")
+	assert.Equal(t, "rs0", replSet)
+	assert.Equal(t, []string{"host1:27017", "host2:27017"}, hosts)
+
+	replSet, hosts = parseShardHost("host3:27018")
+	assert.Equal(t, "", replSet)
+	assert.Equal(t, []string{"host3:27018"}, hosts)
+}
+
+func TestCopyDialInfo(t *testing.T) {
+	dialInfo := &pmgo.DialInfo{
+		Addrs:          []string{"mongos:27017"},
+		ReplicaSetName: "rs0",
+		Username:       "qan",
+		Password:       "pass",
+		Source:         "admin",
+	}
+	di := copyDialInfo(dialInfo, []string{"host1:27017"})
+	assert.Equal(t, []string{"host1:27017"}, di.Addrs)
+	assert.Equal(t, "", di.ReplicaSetName)
+	assert.Equal(t, "qan", di.Username)
+	assert.Equal(t, "pass", di.Password)
+	assert.Equal(t, "admin", di.Source)
+
+	// the original is not changed
+	assert.Equal(t, []string{"mongos:27017"}, dialInfo.Addrs)
+	assert.Equal(t, "rs0", dialInfo.ReplicaSetName)
+}
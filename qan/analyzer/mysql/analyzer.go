@@ -21,18 +21,22 @@ import (
 	"fmt"
 	"log"
 	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	qp "github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/data"
 	"github.com/percona/qan-agent/mrms"
+	"github.com/percona/qan-agent/mrms/checker"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/userstat"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/perfschema"
 	"github.com/percona/qan-agent/qan/analyzer/report"
 	"github.com/percona/qan-agent/ticker"
 )
@@ -47,10 +51,15 @@ type RealAnalyzer struct {
 	iter        iter.IntervalIter
 	mysqlConn   mysql.Connector
 	mrms        mrms.Monitor
-	restartChan chan proto.Instance
+	restartChan chan mrms.Change
 	worker      worker.Worker
 	clock       ticker.Manager
 	spool       data.Spooler
+	tableWorker *perfschema.TableWorker
+	userStats   *userstat.Collector
+	flavor      mysql.Flavor
+	roleConn    mysql.Connector
+	monitor     mrms.Monitor
 	// --
 	name                string
 	mysqlConfiguredChan chan bool
@@ -62,6 +71,8 @@ type RealAnalyzer struct {
 	mux                 *sync.RWMutex
 	start               []string
 	stop                []string
+	settings            *util.Settings // before the first start, see setMySQLConfig
+	paused              string         // why reports aren't sent, see pauseReason
 }
 
 func NewRealAnalyzer(
@@ -69,7 +80,7 @@ func NewRealAnalyzer(
 	config pc.QAN,
 	it iter.IntervalIter,
 	mysqlConn mysql.Connector,
-	restartChan chan proto.Instance,
+	restartChan chan mrms.Change,
 	worker worker.Worker,
 	clock ticker.Manager,
 	spool data.Spooler,
@@ -97,6 +108,37 @@ func NewRealAnalyzer(
 	return a
 }
 
+// SetTableWorker sets the worker which reports table and index I/O every
+// interval after the query worker. It must be called before Start.
+func (a *RealAnalyzer) SetTableWorker(tableWorker *perfschema.TableWorker) {
+	a.tableWorker = tableWorker
+}
+
+// SetUserStatCollector sets the collector of userstat and QUERY_RESPONSE_TIME
+// stats added to every report. It must be called before Start.
+func (a *RealAnalyzer) SetUserStatCollector(userStats *userstat.Collector) {
+	a.userStats = userStats
+}
+
+// SetFlavor sets the distro and version of MySQL, usually from the instance.
+// If not set, they're read from MySQL when it's configured.
+func (a *RealAnalyzer) SetFlavor(flavor mysql.Flavor) {
+	a.flavor = flavor
+}
+
+// SetRoleConn sets the connection on which the replication role of MySQL is
+// read every interval, to add it to the report and to pause collection if
+// the config says so. It must be called before Start.
+func (a *RealAnalyzer) SetRoleConn(roleConn mysql.Connector) {
+	a.roleConn = roleConn
+}
+
+// SetMonitor sets the MRMS monitor whose events during an interval, like
+// restarts or replica lag, annotate the report. It must be called before Start.
+func (a *RealAnalyzer) SetMonitor(monitor mrms.Monitor) {
+	a.monitor = monitor
+}
+
 func (a *RealAnalyzer) String() string {
 	return a.name
 }
@@ -141,6 +183,9 @@ func (a *RealAnalyzer) Status() map[string]string {
 	} else {
 		a.status.Update(a.name+"-next-interval", "")
 	}
+	if a.tableWorker != nil {
+		return a.status.Merge(a.worker.Status(), a.tableWorker.Status())
+	}
 	return a.status.Merge(a.worker.Status())
 }
 
@@ -166,7 +211,7 @@ func (a *RealAnalyzer) TakeOverPerconaServerRotation() error {
 	defer a.logger.Debug("TakeOverPerconaServerRotation:return")
 
 	// If slow log rotation is disabled, don't take over Percona Server slow log rotation.
-	if !boolValue(a.config.SlowLogRotation) {
+	if !pct.BoolValue(a.config.SlowLogRotation) {
 		return nil
 	}
 
@@ -200,7 +245,27 @@ func (a *RealAnalyzer) setMySQLConfig() error {
 	a.logger.Debug("setMySQLConfig:call")
 	defer a.logger.Debug("setMySQLConfig:return")
 
-	start, stop, err := util.GetMySQLConfig(a.config)
+	flavor := a.flavor
+	if flavor.Distro == "" {
+		// If MySQL can't tell either, Oracle MySQL is assumed.
+		var err error
+		if flavor, err = mysql.GetFlavor(a.mysqlConn); err != nil {
+			a.logger.Warn("Cannot get MySQL distro and version:", err)
+		}
+	}
+
+	// Server-wide settings are read once, before they're changed, so that
+	// reconfiguring MySQL on restart or config drift doesn't take what QAN
+	// set as what to restore on stop.
+	if a.settings == nil {
+		settings, err := util.GetSettings(a.mysqlConn, a.config)
+		if err != nil {
+			return err
+		}
+		a.settings = &settings
+	}
+
+	start, stop, err := util.GetMySQLConfig(a.config, flavor, *a.settings)
 	if err != nil {
 		return err
 	}
@@ -303,6 +368,7 @@ func (a *RealAnalyzer) run() {
 			a.status.Update(a.name, "Stopping QAN on MySQL")
 			a.configureMySQL("stop", 1) // try once
 		}
+		a.settings = nil
 
 		if err := recover(); err != nil {
 			a.logger.Error("QAN crashed: ", err)
@@ -386,11 +452,19 @@ func (a *RealAnalyzer) run() {
 			} else {
 				a.logger.Info(fmt.Sprintf("First interval begins in %.1f seconds", t))
 			}
-		case <-a.restartChan:
+		case change := <-a.restartChan:
 			a.logger.Debug("run:mysql:restart")
+			// MySQL restarted or someone changed its config, e.g. SET GLOBAL
+			// slow_query_log=OFF, so the Start queries must be re-applied.
 			// If MySQL is not configured, then configureMySQL() should already
 			// be running, trying to configure it. Else, we need to run
 			// configureMySQL again.
+			reason := a.reconfigureReason(change)
+			if reason == "" {
+				a.logger.Debug("run:mysql:restart:ignored:", change.Reason)
+				continue
+			}
+			a.logger.Info("Reconfiguring MySQL:", reason)
 			if mysqlConfigured {
 				mysqlConfigured = false
 				a.iter.Stop()
@@ -416,6 +490,24 @@ func (a *RealAnalyzer) runWorker(interval *iter.Interval) {
 		a.logger.Debug(fmt.Sprintf("runWorker:return:%d", interval.Number))
 	}()
 
+	// The role is read before the worker runs because parsing the slow log
+	// can take a while, and it's the role when the interval ended that counts.
+	role := a.getRole()
+	paused := a.pauseReason(role)
+	if paused != a.paused {
+		if paused != "" {
+			a.logger.Info("Pausing, not sending reports:", paused)
+		} else {
+			a.logger.Info("Resuming, sending reports")
+		}
+		a.paused = paused
+	}
+
+	// Table and index I/O is reported whatever the query worker does.
+	if a.tableWorker != nil {
+		defer a.runTableWorker(interval, paused)
+	}
+
 	// Let worker do whatever it needs before it starts processing
 	// the interval. This mostly makes testing easier.
 	if err := a.worker.Setup(interval); err != nil {
@@ -449,19 +541,193 @@ func (a *RealAnalyzer) runWorker(interval *iter.Interval) {
 	}
 	result.RunTime = t1.Sub(t0).Seconds()
 
+	// The worker runs while paused so that it keeps its place in the slow
+	// log or its last perfschema snapshot, else the first report after
+	// resuming would have the data of the pause, too.
+	if paused != "" {
+		return
+	}
+
 	// Translate the results into a report and spool.
 	// NOTE: "qan" here is correct; do not use a.name.
 	report := report.MakeReport(a.config, interval.StartTime, interval.StopTime, interval, result)
+	if role != nil {
+		report.Role = role.Name()
+		report.ReadOnly = role.ReadOnly
+		report.GroupMemberState = role.GroupMemberState
+	}
+	report.Annotations = a.annotations(interval.StartTime, interval.StopTime)
+	if a.userStats != nil {
+		// Stats are since the previous report, which is usually the
+		// previous interval.
+		stats, err := a.userStats.Collect()
+		if err != nil {
+			a.logger.Warn(err)
+		} else if stats != nil {
+			report.TableStats = stats.Tables
+			report.UserStats = stats.Users
+			report.ResponseTime = stats.ResponseTime
+		}
+	}
 	if err := a.spool.Write("qan", report); err != nil {
 		a.logger.Warn("Lost report:", err)
 	}
 }
 
-// boolValue returns the value of the bool pointer passed in or
-// false if the pointer is nil.
-func boolValue(v *bool) bool {
-	if v != nil {
-		return *v
+func (a *RealAnalyzer) runTableWorker(interval *iter.Interval, paused string) {
+	a.logger.Debug(fmt.Sprintf("runTableWorker:call:%d", interval.Number))
+	defer a.logger.Debug(fmt.Sprintf("runTableWorker:return:%d", interval.Number))
+
+	if err := a.tableWorker.Setup(interval); err != nil {
+		a.logger.Warn(err)
+		return
+	}
+	defer func() {
+		if err := a.tableWorker.Cleanup(); err != nil {
+			a.logger.Warn(err)
+		}
+	}()
+
+	t0 := time.Now()
+	result, err := a.tableWorker.Run()
+	t1 := time.Now()
+	if err != nil {
+		a.logger.Error(err)
+		return
+	}
+	if result == nil {
+		return
+	}
+	result.RunTime = t1.Sub(t0).Seconds()
+	if paused != "" {
+		return
+	}
+
+	report := report.MakeTableReport(a.config, interval.StartTime, interval.StopTime, result)
+	if err := a.spool.Write("qan-tableio", report); err != nil {
+		a.logger.Warn("Lost table report:", err)
+	}
+}
+
+// annotations returns the MRMS events of the instance from start to stop.
+func (a *RealAnalyzer) annotations(start, stop time.Time) []qp.Annotation {
+	if a.monitor == nil {
+		return nil
+	}
+	var annotations []qp.Annotation
+	for _, e := range a.monitor.GetHistory(a.config.UUID) {
+		if e.Ts.Before(start) || !e.Ts.Before(stop) {
+			continue
+		}
+		annotations = append(annotations, qp.Annotation{
+			Ts:    e.Ts,
+			Type:  e.Type,
+			Name:  e.Name,
+			Old:   e.Old,
+			New:   e.New,
+			Limit: e.Limit,
+			Over:  e.Over,
+		})
+	}
+	return annotations
+}
+
+// getRole returns the replication role of MySQL, or nil if it's unknown.
+func (a *RealAnalyzer) getRole() *mysql.Role {
+	if a.roleConn == nil {
+		return nil
+	}
+	if err := a.roleConn.Connect(); err != nil {
+		a.logger.Warn("Cannot get replication role:", err)
+		return nil
+	}
+	defer a.roleConn.Close()
+	role, err := mysql.GetRole(a.roleConn)
+	if err != nil {
+		a.logger.Warn("Cannot get replication role:", err)
+		return nil
+	}
+	return &role
+}
+
+// reconfigureReason returns why MySQL must be reconfigured for the change,
+// or "" if it needn't be. Only restarts and drift of config the source
+// depends on are reasons, and drift is not if its new value is what the
+// Start queries set, e.g. when MRMS sees them being re-applied. Changes
+// without events are always reasons.
+func (a *RealAnalyzer) reconfigureReason(change mrms.Change) string {
+	if len(change.Events) == 0 {
+		return change.Reason
+	}
+	reasons := []string{}
+	for _, e := range change.Events {
+		switch e.Type {
+		case checker.EventRestart:
+		case checker.EventDrift:
+			if !checker.IsDrift(a.config.CollectFrom, e) || a.isConfigured(e) {
+				continue
+			}
+		default:
+			continue
+		}
+		reasons = append(reasons, e.String())
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// isConfigured returns true if the new value of the drift event is what the
+// Start queries set. Consumers are as configured if all the Start queries
+// enable are enabled. Values the Start queries don't set are never.
+func (a *RealAnalyzer) isConfigured(e checker.Event) bool {
+	if e.Name == checker.DriftConsumers {
+		if !pct.BoolValue(a.config.StageWaitBreakdown) {
+			return false
+		}
+		enabled := map[string]bool{}
+		for _, name := range strings.Split(e.New, ",") {
+			enabled[name] = true
+		}
+		for _, name := range util.StageWaitConsumers {
+			if !enabled[name] {
+				return false
+			}
+		}
+		return true
+	}
+	value, ok := "", false
+	prefix := "SET GLOBAL " + e.Name + "="
+	for _, query := range a.config.Start {
+		if strings.HasPrefix(query, prefix) {
+			value, ok = strings.TrimPrefix(query, prefix), true // last one wins
+		}
+	}
+	return ok && normalizeVar(value) == normalizeVar(e.New)
+}
+
+// normalizeVar returns the variable value as MySQL reports it, e.g. 1 for ON
+// and FILE for 'file', so values set and read can be compared.
+func normalizeVar(value string) string {
+	value = strings.ToUpper(strings.Trim(value, "'\""))
+	switch value {
+	case "ON", "TRUE":
+		return "1"
+	case "OFF", "FALSE":
+		return "0"
+	}
+	return value
+}
+
+// pauseReason returns why reports must not be sent for the role, or "" if
+// they must be. Reports are sent if the role is unknown.
+func (a *RealAnalyzer) pauseReason(role *mysql.Role) string {
+	if role == nil {
+		return ""
+	}
+	if pct.BoolValue(a.config.PauseOnReplica) && role.Replica {
+		return "MySQL is a replica"
+	}
+	if pct.BoolValue(a.config.PauseWithoutQuorum) && !role.GroupQuorum {
+		return fmt.Sprintf("MySQL is a group replication member without quorum (%s)", role.GroupMemberState)
 	}
-	return false
+	return ""
 }
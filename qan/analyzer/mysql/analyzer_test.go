@@ -26,6 +26,8 @@ import (
 	pc "github.com/percona/pmm/proto/config"
 	qp "github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/instance"
+	"github.com/percona/qan-agent/mrms"
+	"github.com/percona/qan-agent/mrms/checker"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	mysqlAnalyzer "github.com/percona/qan-agent/qan/analyzer/mysql"
@@ -49,7 +51,7 @@ type AnalyzerTestSuite struct {
 	clock         *mock.Clock
 	api           *mock.API
 	worker        *qan_worker.QanWorker
-	restartChan   chan proto.Instance
+	restartChan   chan mrms.Change
 	logChan       chan proto.LogEntry
 	logger        *pct.Logger
 	intervalChan  chan *iter.Interval
@@ -110,7 +112,7 @@ func (s *AnalyzerTestSuite) SetUpSuite(t *C) {
 	err = s.im.Add(s.mysqlInstance, true)
 	t.Assert(err, IsNil)
 
-	s.restartChan = make(chan proto.Instance, 1)
+	s.restartChan = make(chan mrms.Change, 1)
 }
 
 func (s *AnalyzerTestSuite) SetUpTest(t *C) {
@@ -275,7 +277,7 @@ func (s *AnalyzerTestSuite) TestMySQLRestart(t *C) {
 	s.nullmysql.Reset()
 	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
 	s.nullmysql.SetCond.L.Lock()
-	s.restartChan <- s.mysqlInstance
+	s.restartChan <- mrms.Change{Instance: s.mysqlInstance, Reason: checker.ReasonRestart}
 	s.nullmysql.SetCond.Wait()
 	s.nullmysql.SetCond.L.Unlock()
 	test.WaitStatus(1, a, "qan-analyzer", "Idle")
@@ -297,7 +299,7 @@ func (s *AnalyzerTestSuite) TestMySQLRestart(t *C) {
 	s.nullmysql.Reset()
 	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 100000)
 	s.nullmysql.SetCond.L.Lock()
-	s.restartChan <- s.mysqlInstance
+	s.restartChan <- mrms.Change{Instance: s.mysqlInstance, Reason: checker.ReasonRestart}
 	s.nullmysql.SetCond.Wait()
 	s.nullmysql.SetCond.L.Unlock()
 	test.WaitStatus(1, a, "qan-analyzer", "Idle")
@@ -315,6 +317,138 @@ func (s *AnalyzerTestSuite) TestMySQLRestart(t *C) {
 	t.Assert(err, IsNil)
 }
 
+func (s *AnalyzerTestSuite) TestMySQLConfigDrift(t *C) {
+	s.nullmysql.Reset()
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
+
+	// Changes come from MRMS, like in production.
+	mrm := mock.NewMrmsMonitor()
+	restartChan := mrm.Add(s.mysqlInstance)
+	defer mrm.Remove(s.mysqlInstance.UUID, restartChan)
+
+	a := mysqlAnalyzer.NewRealAnalyzer(
+		pct.NewLogger(s.logChan, "qan-analyzer"),
+		s.config,
+		s.iter,
+		s.nullmysql,
+		restartChan,
+		s.worker,
+		s.clock,
+		s.spool,
+	)
+	err := a.Start()
+	t.Assert(err, IsNil)
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(s.iter.Calls(), DeepEquals, []string{"Start"})
+	s.iter.Reset()
+
+	// Someone disabled the slow log, so the analyzer re-applies its Start queries.
+	s.nullmysql.Reset()
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0)
+	s.nullmysql.SetCond.L.Lock()
+	mrm.SimulateConfigChange("slow_query_log changed from 1 to 0")
+	s.nullmysql.SetCond.Wait()
+	s.nullmysql.SetCond.L.Unlock()
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(s.nullmysql.GetExec(), DeepEquals, []string{
+		"SET GLOBAL slow_query_log=OFF",
+		"SET GLOBAL log_output='file'",
+		"SET GLOBAL slow_query_log=ON",
+		"SET time_zone='+0:00'",
+	})
+	t.Check(s.iter.Calls(), DeepEquals, []string{"Stop", "Start"})
+
+	err = a.Stop()
+	t.Assert(err, IsNil)
+}
+
+func (s *AnalyzerTestSuite) TestMySQLConfigDriftOtherSource(t *C) {
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
+
+	mrm := mock.NewMrmsMonitor()
+	restartChan := mrm.Add(s.mysqlInstance)
+	defer mrm.Remove(s.mysqlInstance.UUID, restartChan)
+
+	a := mysqlAnalyzer.NewRealAnalyzer(
+		pct.NewLogger(s.logChan, "qan-analyzer"),
+		s.config,
+		s.iter,
+		s.nullmysql,
+		restartChan,
+		s.worker,
+		s.clock,
+		s.spool,
+	)
+	err := a.Start()
+	t.Assert(err, IsNil)
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	s.iter.Reset()
+
+	// The slow log analyzer doesn't depend on performance_schema consumers.
+	mrm.SimulateDrift(checker.Event{Type: checker.EventDrift, Name: checker.DriftConsumers, Old: "a", New: "b"})
+	time.Sleep(200 * time.Millisecond)
+	t.Check(s.iter.Calls(), HasLen, 0)
+
+	// But it does on the slow log.
+	s.nullmysql.Reset()
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0)
+	s.nullmysql.SetCond.L.Lock()
+	mrm.SimulateDrift(checker.Event{Type: checker.EventDrift, Name: "slow_query_log", Old: "1", New: "0"})
+	s.nullmysql.SetCond.Wait()
+	s.nullmysql.SetCond.L.Unlock()
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(s.iter.Calls(), DeepEquals, []string{"Stop", "Start"})
+
+	err = a.Stop()
+	t.Assert(err, IsNil)
+}
+
+func (s *AnalyzerTestSuite) TestMySQLConfigDriftConfigured(t *C) {
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0) // TakeOverPerconaServerRotation
+
+	mrm := mock.NewMrmsMonitor()
+	restartChan := mrm.Add(s.mysqlInstance)
+	defer mrm.Remove(s.mysqlInstance.UUID, restartChan)
+
+	a := mysqlAnalyzer.NewRealAnalyzer(
+		pct.NewLogger(s.logChan, "qan-analyzer"),
+		s.config,
+		s.iter,
+		s.nullmysql,
+		restartChan,
+		s.worker,
+		s.clock,
+		s.spool,
+	)
+	err := a.Start()
+	t.Assert(err, IsNil)
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	s.iter.Reset()
+
+	// Drift to the values the Start queries set is QAN's own, and role
+	// changes don't change the config.
+	mrm.SimulateDrift(
+		checker.Event{Type: checker.EventDrift, Name: "slow_query_log", Old: "0", New: "1"},
+		checker.Event{Type: checker.EventDrift, Name: "log_output", Old: "TABLE", New: "FILE"},
+		checker.Event{Type: checker.EventRole, Old: "replica", New: "source"},
+	)
+	time.Sleep(200 * time.Millisecond)
+	t.Check(s.iter.Calls(), HasLen, 0)
+
+	// A restart is never ignored.
+	s.nullmysql.Reset()
+	s.nullmysql.SetGlobalVarInteger("max_slowlog_size", 0)
+	s.nullmysql.SetCond.L.Lock()
+	mrm.SimulateDrift(checker.Event{Type: checker.EventRestart, Old: "100", New: "5"})
+	s.nullmysql.SetCond.Wait()
+	s.nullmysql.SetCond.L.Unlock()
+	test.WaitStatus(1, a, "qan-analyzer", "Idle")
+	t.Check(s.iter.Calls(), DeepEquals, []string{"Stop", "Start"})
+
+	err = a.Stop()
+	t.Assert(err, IsNil)
+}
+
 func (s *AnalyzerTestSuite) TestRealSlowLogWorker(t *C) {
 	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
 	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
@@ -15,6 +15,7 @@ import (
 	"github.com/percona/qan-agent/qan/analyzer/mysql/config"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/factory"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/userstat"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/perfschema"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/worker/slowlog"
@@ -35,8 +36,10 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 	logChan := logger.LogChan()
 	iterFactory := factory.NewRealIntervalIterFactory(logChan)
 	slowlogWorkerFactory := slowlog.NewRealWorkerFactory(logChan)
-	perfschemaWorkerFactory := perfschema.NewRealWorkerFactory(logChan)
-	mysqlConnFactory := &mysql.RealConnectionFactory{}
+	// The analyzer's components share pooled connections to the instance.
+	mysqlPool := mysql.NewPool(mysql.DefaultMaxOpenConns, mysql.DefaultMaxIdleConns)
+	mysqlConnFactory := &mysql.RealConnectionFactory{Pool: mysqlPool}
+	perfschemaWorkerFactory := perfschema.NewRealWorkerFactory(logChan, mysqlConnFactory)
 
 	// return initialized MySQLAnalyzer
 	return &MySQLAnalyzer{
@@ -53,6 +56,7 @@ func New(ctx context.Context, protoInstance proto.Instance) analyzer.Analyzer {
 		slowlogWorkerFactory:    slowlogWorkerFactory,
 		perfschemaWorkerFactory: perfschemaWorkerFactory,
 		mysqlConnFactory:        mysqlConnFactory,
+		mysqlPool:               mysqlPool,
 	}
 }
 
@@ -72,9 +76,10 @@ type MySQLAnalyzer struct {
 	slowlogWorkerFactory    slowlog.WorkerFactory
 	perfschemaWorkerFactory perfschema.WorkerFactory
 	mysqlConnFactory        mysql.ConnectionFactory
+	mysqlPool               *mysql.Pool
 	// real analyzer channels
 	tickChan    chan time.Time
-	restartChan chan proto.Instance
+	restartChan chan mrms.Change
 }
 
 // SetConfig sets the config
@@ -98,8 +103,16 @@ func (m *MySQLAnalyzer) Config() pc.QAN {
 func (m *MySQLAnalyzer) Start() error {
 	setConfig := m.Config()
 
-	// Create a MySQL connection.
-	mysqlConn := m.mysqlConnFactory.Make(m.protoInstance.DSN)
+	// Get the DSN with the instance TLS config, if any, registered.
+	dsn, err := mysql.InstanceDSN(m.protoInstance)
+	if err != nil {
+		return err
+	}
+
+	// Create a MySQL connection. The worker and the iter get their own because
+	// each component connects and closes its connection when it wants to.
+	// They're leases on the same pooled connection, so that's cheap.
+	mysqlConn := m.mysqlConnFactory.Make(dsn)
 
 	// Validate and transform the set config and into a running config.
 	config, err := config.ValidateConfig(setConfig)
@@ -122,9 +135,9 @@ func (m *MySQLAnalyzer) Start() error {
 	analyzerType := config.CollectFrom
 	switch analyzerType {
 	case "slowlog":
-		worker = m.slowlogWorkerFactory.Make(name+"-worker", config, mysqlConn)
+		worker = m.slowlogWorkerFactory.Make(name+"-worker", config, m.mysqlConnFactory.Make(dsn))
 	case "perfschema":
-		worker = m.perfschemaWorkerFactory.Make(name+"-worker", mysqlConn)
+		worker = m.perfschemaWorkerFactory.Make(name+"-worker", m.mysqlConnFactory.Make(dsn))
 	default:
 		panic("Invalid analyzerType: " + analyzerType)
 	}
@@ -133,16 +146,37 @@ func (m *MySQLAnalyzer) Start() error {
 	// Create and start a new analyzer. This should return immediately.
 	// The analyzer will configure MySQL, start its iter, then run it worker
 	// for each interval.
-	m.analyzer = NewRealAnalyzer(
+	realAnalyzer := NewRealAnalyzer(
 		pct.NewLogger(logChan, name),
 		config,
-		m.iterFactory.Make(analyzerType, mysqlConn, tickChan),
+		m.iterFactory.Make(analyzerType, m.mysqlConnFactory.Make(dsn), tickChan),
 		mysqlConn,
 		restartChan,
 		worker,
 		m.clock,
 		m.spool,
 	)
+	realAnalyzer.SetFlavor(mysql.NewFlavor(m.protoInstance.Distro, m.protoInstance.Version))
+	realAnalyzer.SetRoleConn(m.mysqlConnFactory.Make(dsn))
+	realAnalyzer.SetMonitor(m.mrms)
+	if pct.BoolValue(config.UserStats) {
+		userStats := userstat.NewCollector(
+			pct.NewLogger(logChan, name+"-userstat"),
+			m.mysqlConnFactory.Make(dsn),
+		)
+		realAnalyzer.SetUserStatCollector(userStats)
+	}
+	if pct.BoolValue(config.TableIO) {
+		// Like the query worker, the table worker gets its own connection
+		// because it connects and closes it every interval.
+		tableWorker := perfschema.NewTableWorker(
+			pct.NewLogger(logChan, name+"-tables"),
+			m.mysqlConnFactory.Make(dsn),
+			nil,
+		)
+		realAnalyzer.SetTableWorker(tableWorker)
+	}
+	m.analyzer = realAnalyzer
 
 	return m.analyzer.Start()
 }
@@ -150,7 +184,11 @@ func (m *MySQLAnalyzer) Start() error {
 // Status returns list of statuses
 func (m *MySQLAnalyzer) Status() map[string]string {
 	if m.analyzer != nil {
-		return m.analyzer.Status()
+		status := m.analyzer.Status()
+		for k, v := range m.mysqlPool.Status() {
+			status[m.logger.Service()+"-"+k] = v
+		}
+		return status
 	}
 
 	service := m.logger.Service()
@@ -182,7 +220,12 @@ func (m *MySQLAnalyzer) Stop() error {
 	// instance are not affected.
 	m.mrms.Remove(m.protoInstance.UUID, restartChan)
 
-	return a.Stop()
+	err := a.Stop()
+
+	// The analyzer's components are stopped, so they've released their
+	// leases on the pooled connections.
+	m.mysqlPool.Close()
+	return err
 }
 
 func (m *MySQLAnalyzer) GetDefaults(uuid string) map[string]interface{} {
@@ -194,12 +237,23 @@ func (m *MySQLAnalyzer) GetDefaults(uuid string) map[string]interface{} {
 		"RetainSlowLogs":  m.config.RetainSlowLogs,
 		"SlowLogRotation": m.config.SlowLogRotation,
 		"ExampleQueries":  m.config.ExampleQueries,
+		"TableIO":         m.config.TableIO,
+		"UserStats":       m.config.UserStats,
 		"ReportLimit":     m.config.ReportLimit,
+		// perfschema
+		"StageWaitBreakdown": m.config.StageWaitBreakdown,
+		// replication
+		"PauseOnReplica":     m.config.PauseOnReplica,
+		"PauseWithoutQuorum": m.config.PauseWithoutQuorum,
 	}
 
 	// Info from SHOW GLOBAL STATUS
-	mysqlInstance := m.protoInstance
-	mysqlConn := m.mysqlConnFactory.Make(mysqlInstance.DSN)
+	dsn, err := mysql.InstanceDSN(m.protoInstance)
+	if err != nil {
+		m.logger.Warn(err)
+		return cfg
+	}
+	mysqlConn := m.mysqlConnFactory.Make(dsn)
 	mysqlConn.Connect()
 	defer mysqlConn.Close()
 	info := config.ReadInfoFromShowGlobalStatus(mysqlConn) // Read current values
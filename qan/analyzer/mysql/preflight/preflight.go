@@ -0,0 +1,407 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package preflight checks that QAN can run on a MySQL instance before it's
+// started: the privileges QAN needs, the MySQL config, and access to the slow
+// log and disk space on this host.
+package preflight
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/config"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/util"
+	"golang.org/x/sys/unix"
+)
+
+// MinFreeSpoolSpace is the minimum free space for spooling reports.
+const MinFreeSpoolSpace = 100 * 1024 * 1024 // bytes
+
+// InstanceChecker checks MySQL instances for the instance manager without
+// a QAN config, i.e. whether QAN can run at all (see instance.InstanceChecker).
+type InstanceChecker struct{}
+
+func (InstanceChecker) CheckInstance(in proto.Instance) (qan.InstanceCheck, error) {
+	return CheckInstance(in, pc.QAN{})
+}
+
+// CheckInstance checks that QAN can run on the MySQL instance with the config.
+// Only invalid TLS or credential settings are errors; everything else is
+// reported as a failed check.
+func CheckInstance(in proto.Instance, config pc.QAN) (qan.InstanceCheck, error) {
+	dsn, err := mysql.InstanceDSN(in)
+	if err != nil {
+		return qan.InstanceCheck{}, err
+	}
+	config.UUID = in.UUID
+	c := NewChecker(mysql.NewConnection(dsn), nil)
+	return c.Check(config), nil
+}
+
+// GetGrantsFunc returns the SHOW GRANTS lines of the current user.
+type GetGrantsFunc func(conn mysql.Connector) ([]string, error)
+
+// A Checker checks that QAN can run on a MySQL instance with a config.
+type Checker struct {
+	conn      mysql.Connector
+	getGrants GetGrantsFunc
+	// --
+	diskFree func(path string) (uint64, error)
+	checks   []qan.Check
+	config   pc.QAN
+}
+
+// NewChecker returns a Checker which connects with conn. If getGrants is nil,
+// GetGrants is used.
+func NewChecker(conn mysql.Connector, getGrants GetGrantsFunc) *Checker {
+	if getGrants == nil {
+		getGrants = GetGrants
+	}
+	c := &Checker{
+		conn:      conn,
+		getGrants: getGrants,
+		// --
+		diskFree: diskFree,
+	}
+	return c
+}
+
+// Check runs all checks and returns their results. If config.CollectFrom is
+// empty, the checks for both slowlog and perfschema are run, but they only
+// warn because QAN can run if either works.
+func (c *Checker) Check(config pc.QAN) qan.InstanceCheck {
+	c.checks = []qan.Check{}
+	c.config = config
+
+	if err := c.conn.Connect(); err != nil {
+		c.fail("Connect", err.Error())
+		return c.report()
+	}
+	defer c.conn.Close()
+	c.pass("Connect")
+
+	c.checkConfig()
+	c.checkGrants()
+	c.checkPerfSchema()
+	c.checkSlowLog()
+	c.checkSpool()
+
+	return c.report()
+}
+
+func (c *Checker) checkConfig() {
+	flavor, err := mysql.GetFlavor(c.conn)
+	if err != nil {
+		c.warn("MySQL version", fmt.Sprintf("cannot get MySQL version: %s", err))
+		return
+	}
+	for _, source := range []string{"slowlog", "perfschema"} {
+		status := c.required(source)
+		if status == "" {
+			continue
+		}
+		cfg := c.config
+		cfg.CollectFrom = source
+		if _, _, err := util.GetMySQLConfig(cfg, flavor, util.Settings{}); err != nil {
+			c.add("MySQL version", status, err.Error())
+			return
+		}
+	}
+	c.pass("MySQL version")
+}
+
+func (c *Checker) checkGrants() {
+	lines, err := c.getGrants(c.conn)
+	if err != nil {
+		c.warn("Grants", fmt.Sprintf("cannot read grants, privileges not checked: %s", err))
+		return
+	}
+	grants := ParseGrants(lines)
+
+	// SET GLOBAL slow_query_log, userstat, etc.
+	setGlobal := c.required("slowlog")
+	if pct.BoolValue(c.config.UserStats) {
+		setGlobal = qan.CheckFail
+	}
+	if setGlobal != "" {
+		if grants.Has("SUPER", "") || grants.Has("SYSTEM_VARIABLES_ADMIN", "") {
+			c.pass("SUPER privilege")
+		} else {
+			c.add("SUPER privilege", setGlobal, "required to SET GLOBAL slow_query_log and other variables")
+		}
+	}
+
+	// FLUSH NO_WRITE_TO_BINLOG SLOW LOGS after rotating the slow log.
+	if status := c.required("slowlog"); status != "" && c.slowLogRotation() {
+		if grants.Has("RELOAD", "") {
+			c.pass("RELOAD privilege")
+		} else {
+			c.add("RELOAD privilege", status, "required to FLUSH SLOW LOGS when rotating the slow log")
+		}
+	}
+
+	selectStatus := c.required("perfschema")
+	if pct.BoolValue(c.config.TableIO) {
+		selectStatus = qan.CheckFail
+	}
+	if selectStatus != "" {
+		if grants.Has("SELECT", "performance_schema") {
+			c.pass("SELECT privilege on performance_schema")
+		} else {
+			c.add("SELECT privilege on performance_schema", selectStatus, "required to read statement digests")
+		}
+	}
+
+	if status := c.required("perfschema"); status != "" && pct.BoolValue(c.config.StageWaitBreakdown) {
+		if grants.Has("UPDATE", "performance_schema") {
+			c.pass("UPDATE privilege on performance_schema")
+		} else {
+			c.add("UPDATE privilege on performance_schema", status, "required to enable stage and wait consumers")
+		}
+	}
+}
+
+func (c *Checker) checkPerfSchema() {
+	status := c.required("perfschema")
+	if status == "" {
+		return
+	}
+	info := config.ReadInfoFromShowGlobalStatus(c.conn)
+	switch v := info["PerformanceSchema"].(type) {
+	case bool:
+		if v {
+			c.pass("performance_schema")
+		} else {
+			c.add("performance_schema", status, "performance_schema=OFF, it must be enabled in my.cnf and MySQL restarted")
+		}
+	case error:
+		c.warn("performance_schema", v.Error())
+	default:
+		c.warn("performance_schema", "cannot read performance_schema variable")
+	}
+}
+
+func (c *Checker) checkSlowLog() {
+	status := c.required("slowlog")
+	if status == "" {
+		return
+	}
+	file, err := c.conn.GetGlobalVarString("slow_query_log_file")
+	if err != nil {
+		c.warn("Slow log file", fmt.Sprintf("cannot read slow_query_log_file: %s", err))
+		return
+	}
+	slowLogFile := file.String
+	if !filepath.IsAbs(slowLogFile) {
+		if dataDir, err := c.conn.GetGlobalVarString("datadir"); err == nil {
+			slowLogFile = filepath.Join(dataDir.String, slowLogFile)
+		}
+	}
+
+	// The slow log is read locally, so QAN must run on the MySQL host. MySQL
+	// creates the file when it enables the slow log, so if it doesn't exist
+	// yet, check the directory instead.
+	dir := filepath.Dir(slowLogFile)
+	if _, err := os.Stat(dir); err != nil {
+		c.add("Slow log file", status, fmt.Sprintf("%s: %s, the agent must run on the MySQL host", dir, err))
+		return
+	}
+	if f, err := os.Open(slowLogFile); err == nil {
+		f.Close()
+		c.pass("Slow log file")
+	} else if os.IsNotExist(err) {
+		c.pass("Slow log file")
+	} else {
+		c.add("Slow log file", status, err.Error())
+		return
+	}
+
+	if c.slowLogRotation() {
+		// Rotating renames the slow log and removes old ones.
+		if err := unix.Access(dir, unix.W_OK); err != nil {
+			c.add("Slow log rotation", qan.CheckWarn, fmt.Sprintf("%s is not writable, cannot rotate slow logs: %s", dir, err))
+		} else {
+			c.pass("Slow log rotation")
+		}
+	}
+
+	maxSlowLogSize := c.config.MaxSlowLogSize
+	if maxSlowLogSize <= 0 {
+		maxSlowLogSize = pc.DefaultMaxSlowLogSize
+	}
+	c.checkDiskSpace("Disk space for slow log", dir, uint64(maxSlowLogSize))
+}
+
+func (c *Checker) checkSpool() {
+	if pct.Basedir.Path() == "" {
+		return
+	}
+	c.checkDiskSpace("Disk space for spool", pct.Basedir.Path(), MinFreeSpoolSpace)
+}
+
+func (c *Checker) checkDiskSpace(name, path string, min uint64) {
+	free, err := c.diskFree(path)
+	switch {
+	case err != nil:
+		c.warn(name, fmt.Sprintf("cannot get free space of %s: %s", path, err))
+	case free < min:
+		c.warn(name, fmt.Sprintf("%s has %s free, need %s", path, pct.Bytes(free), pct.Bytes(min)))
+	default:
+		c.pass(name)
+	}
+}
+
+// required returns the status of a failed check which is required to collect
+// from the source, or "" if the source isn't used.
+func (c *Checker) required(source string) string {
+	switch c.config.CollectFrom {
+	case source:
+		return qan.CheckFail
+	case "":
+		return qan.CheckWarn
+	}
+	return ""
+}
+
+func (c *Checker) slowLogRotation() bool {
+	if c.config.SlowLogRotation == nil {
+		return pc.DefaultSlowLogRotation
+	}
+	return *c.config.SlowLogRotation
+}
+
+func (c *Checker) report() qan.InstanceCheck {
+	status := qan.CheckPass
+	for _, check := range c.checks {
+		if check.Status == qan.CheckFail {
+			status = qan.CheckFail
+			break
+		}
+		if check.Status == qan.CheckWarn {
+			status = qan.CheckWarn
+		}
+	}
+	return qan.InstanceCheck{
+		UUID:   c.config.UUID,
+		Status: status,
+		Checks: c.checks,
+	}
+}
+
+func (c *Checker) add(name, status, message string) {
+	c.checks = append(c.checks, qan.Check{Name: name, Status: status, Message: message})
+}
+
+func (c *Checker) pass(name string) {
+	c.add(name, qan.CheckPass, "")
+}
+
+func (c *Checker) warn(name, message string) {
+	c.add(name, qan.CheckWarn, message)
+}
+
+func (c *Checker) fail(name, message string) {
+	c.add(name, qan.CheckFail, message)
+}
+
+// --------------------------------------------------------------------------
+
+// GetGrants returns the SHOW GRANTS lines of the current user.
+func GetGrants(conn mysql.Connector) ([]string, error) {
+	rows, err := conn.DB().Query("SHOW GRANTS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lines := []string{}
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// Grants are the privileges of a user, keyed on database ("" for *.*).
+// Table and column privileges are ignored.
+type Grants map[string]map[string]bool
+
+var (
+	grantRe       = regexp.MustCompile(`^GRANT (.+?) ON (\S+) TO `)
+	grantColumnRe = regexp.MustCompile(`\s*\([^)]*\)`)
+)
+
+// ParseGrants parses SHOW GRANTS lines.
+func ParseGrants(lines []string) Grants {
+	grants := Grants{}
+	for _, line := range lines {
+		m := grantRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		on := strings.NewReplacer("`", "", `\`, "").Replace(m[2])
+		var db string
+		switch {
+		case on == "*.*":
+			db = ""
+		case strings.HasSuffix(on, ".*"):
+			db = strings.TrimSuffix(on, ".*")
+		default:
+			continue // table privileges
+		}
+		if grants[db] == nil {
+			grants[db] = map[string]bool{}
+		}
+		for _, priv := range strings.Split(grantColumnRe.ReplaceAllString(m[1], ""), ",") {
+			grants[db][strings.ToUpper(strings.TrimSpace(priv))] = true
+		}
+	}
+	return grants
+}
+
+// Has returns true if the privilege is granted on all databases or on db.
+func (g Grants) Has(priv, db string) bool {
+	for _, on := range []string{"", db} {
+		privs := g[on]
+		if privs[priv] || privs["ALL PRIVILEGES"] || privs["ALL"] {
+			return true
+		}
+		if db == "" {
+			break
+		}
+	}
+	return false
+}
+
+func diskFree(path string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,113 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package preflight
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/test/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGrants(t *testing.T) {
+	grants := ParseGrants([]string{
+		"GRANT SELECT, RELOAD, PROCESS ON *.* TO 'pmm'@'localhost' IDENTIFIED BY PASSWORD '*F4'",
+		"GRANT SELECT, UPDATE, DELETE, DROP ON `performance\\_schema`.* TO 'pmm'@'localhost'",
+		"GRANT SELECT (id, name), INSERT ON `db`.`t` TO 'pmm'@'localhost'",
+		"GRANT `role`@`%` TO 'pmm'@'localhost'",
+	})
+	assert.True(t, grants.Has("RELOAD", ""))
+	assert.False(t, grants.Has("SUPER", ""))
+	assert.True(t, grants.Has("SELECT", "performance_schema"))
+	assert.True(t, grants.Has("UPDATE", "performance_schema"))
+	assert.False(t, grants.Has("UPDATE", "db"))
+	assert.False(t, grants.Has("UPDATE", ""))
+
+	grants = ParseGrants([]string{"GRANT ALL PRIVILEGES ON *.* TO 'root'@'localhost' WITH GRANT OPTION"})
+	assert.True(t, grants.Has("SUPER", ""))
+	assert.True(t, grants.Has("UPDATE", "performance_schema"))
+}
+
+func TestCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "qan-preflight-")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	slowLog := filepath.Join(dir, "slow.log")
+	require.NoError(t, ioutil.WriteFile(slowLog, []byte{}, 0600))
+
+	conn := mock.NewNullMySQL()
+	conn.Reset()
+	conn.SetGlobalVarString("version_comment", "MySQL Community Server (GPL)")
+	conn.SetGlobalVarString("version", "5.7.22")
+	conn.SetGlobalVarString("slow_query_log_file", slowLog)
+	conn.SetGlobalVarBoolean("performance_schema", false)
+
+	grants := []string{"GRANT SELECT, PROCESS, SUPER ON *.* TO 'pmm'@'localhost'"}
+	c := NewChecker(conn, func(mysql.Connector) ([]string, error) { return grants, nil })
+	c.diskFree = func(string) (uint64, error) { return 1024 * 1024 * 1024 * 10, nil }
+
+	// slowlog: RELOAD is required to rotate the slow log.
+	check := c.Check(pc.QAN{UUID: "313", CollectFrom: "slowlog"})
+	assert.Equal(t, qan.InstanceCheck{
+		UUID:   "313",
+		Status: qan.CheckFail,
+		Checks: []qan.Check{
+			{Name: "Connect", Status: qan.CheckPass},
+			{Name: "MySQL version", Status: qan.CheckPass},
+			{Name: "SUPER privilege", Status: qan.CheckPass},
+			{Name: "RELOAD privilege", Status: qan.CheckFail, Message: "required to FLUSH SLOW LOGS when rotating the slow log"},
+			{Name: "Slow log file", Status: qan.CheckPass},
+			{Name: "Slow log rotation", Status: qan.CheckPass},
+			{Name: "Disk space for slow log", Status: qan.CheckPass},
+		},
+	}, check)
+
+	// Not if rotation is disabled.
+	rotate := false
+	check = c.Check(pc.QAN{UUID: "313", CollectFrom: "slowlog", SlowLogRotation: &rotate})
+	assert.Equal(t, qan.CheckPass, check.Status)
+
+	// perfschema: performance_schema=OFF fails.
+	check = c.Check(pc.QAN{UUID: "313", CollectFrom: "perfschema"})
+	assert.Equal(t, qan.CheckFail, check.Status)
+	assert.Equal(t, []qan.Check{
+		{Name: "Connect", Status: qan.CheckPass},
+		{Name: "MySQL version", Status: qan.CheckPass},
+		{Name: "SELECT privilege on performance_schema", Status: qan.CheckPass},
+		{Name: "performance_schema", Status: qan.CheckFail, Message: "performance_schema=OFF, it must be enabled in my.cnf and MySQL restarted"},
+	}, check.Checks)
+
+	// No source: what fails for one source only warns.
+	check = c.Check(pc.QAN{})
+	assert.Equal(t, qan.CheckWarn, check.Status)
+
+	// Low disk space and unreadable grants only warn.
+	c.diskFree = func(string) (uint64, error) { return 1024, nil }
+	c.getGrants = func(mysql.Connector) ([]string, error) { return nil, errors.New("denied") }
+	check = c.Check(pc.QAN{UUID: "313", CollectFrom: "slowlog"})
+	assert.Equal(t, qan.CheckWarn, check.Status)
+	assert.Contains(t, check.Checks, qan.Check{Name: "Grants", Status: qan.CheckWarn, Message: "cannot read grants, privileges not checked: denied"})
+}
@@ -0,0 +1,255 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+// Package userstat collects the Percona Server and MariaDB userstat tables
+// and the QUERY_RESPONSE_TIME plugin table, which are added to QAN reports.
+package userstat
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+)
+
+// Stats are the changes of the tables since the previous Collect.
+type Stats struct {
+	Tables       []qan.TableStat
+	Users        []qan.UserStat
+	ResponseTime []qan.ResponseTimeBucket
+}
+
+// A snapshot is the rows of the tables at a single time, like a perfschema
+// Snapshot. Two consecutive snapshots are needed to produce Stats.
+type snapshot struct {
+	tables       map[string]qan.TableStat // keyed on schema.table
+	users        map[string]qan.UserStat  // keyed on user
+	responseTime []qan.ResponseTimeBucket
+}
+
+// Collector reads the tables through its own connection every interval.
+type Collector struct {
+	logger    *pct.Logger
+	mysqlConn mysql.Connector
+	// --
+	flavor mysql.Flavor
+	prev   *snapshot
+}
+
+func NewCollector(logger *pct.Logger, mysqlConn mysql.Connector) *Collector {
+	c := &Collector{
+		logger:    logger,
+		mysqlConn: mysqlConn,
+	}
+	return c
+}
+
+// Collect returns the stats since the previous call, or nil if this is
+// the first call, the server doesn't have userstat, or the tables were
+// flushed since the previous call.
+func (c *Collector) Collect() (*Stats, error) {
+	if err := c.mysqlConn.Connect(); err != nil {
+		return nil, err
+	}
+	defer c.mysqlConn.Close()
+
+	flavor, err := mysql.GetFlavor(c.mysqlConn)
+	if err != nil {
+		return nil, err
+	}
+	if !flavor.HasUserStats() {
+		return nil, nil
+	}
+	if flavor != c.flavor {
+		c.logger.Info("Flavor:", flavor)
+		c.flavor = flavor
+		c.prev = nil // columns might have changed
+	}
+
+	curr, err := c.getSnapshot()
+	if err != nil {
+		c.prev = nil
+		return nil, err
+	}
+	prev := c.prev
+	c.prev = curr
+	if prev == nil {
+		return nil, nil
+	}
+	stats, ok := diff(prev, curr)
+	if !ok {
+		c.logger.Info("Statistics were flushed, starting over")
+		return nil, nil
+	}
+	return stats, nil
+}
+
+// diff returns curr - prev, or false if a counter decreased, which means
+// the tables were flushed (FLUSH TABLE_STATISTICS, etc.) or MySQL restarted.
+func diff(prev, curr *snapshot) (*Stats, bool) {
+	stats := &Stats{}
+	for k, t := range curr.tables {
+		p := prev.tables[k]
+		if t.RowsRead < p.RowsRead || t.RowsChanged < p.RowsChanged || t.RowsChangedXIndexes < p.RowsChangedXIndexes {
+			return nil, false
+		}
+		if t.RowsRead == p.RowsRead && t.RowsChanged == p.RowsChanged {
+			continue
+		}
+		t.RowsRead -= p.RowsRead
+		t.RowsChanged -= p.RowsChanged
+		t.RowsChangedXIndexes -= p.RowsChangedXIndexes
+		stats.Tables = append(stats.Tables, t)
+	}
+	for k, u := range curr.users {
+		p := prev.users[k]
+		if u.TotalConnections < p.TotalConnections || u.BytesReceived < p.BytesReceived {
+			return nil, false
+		}
+		if u.BytesReceived == p.BytesReceived {
+			continue // no commands
+		}
+		stats.Users = append(stats.Users, qan.UserStat{
+			User:                 u.User,
+			TotalConnections:     u.TotalConnections - p.TotalConnections,
+			BusyTime:             u.BusyTime - p.BusyTime,
+			CPUTime:              u.CPUTime - p.CPUTime,
+			BytesReceived:        u.BytesReceived - p.BytesReceived,
+			BytesSent:            u.BytesSent - p.BytesSent,
+			RowsRead:             u.RowsRead - p.RowsRead,
+			RowsSent:             u.RowsSent - p.RowsSent,
+			RowsUpdated:          u.RowsUpdated - p.RowsUpdated,
+			SelectCommands:       u.SelectCommands - p.SelectCommands,
+			UpdateCommands:       u.UpdateCommands - p.UpdateCommands,
+			OtherCommands:        u.OtherCommands - p.OtherCommands,
+			CommitTransactions:   u.CommitTransactions - p.CommitTransactions,
+			RollbackTransactions: u.RollbackTransactions - p.RollbackTransactions,
+			DeniedConnections:    u.DeniedConnections - p.DeniedConnections,
+			LostConnections:      u.LostConnections - p.LostConnections,
+			AccessDenied:         u.AccessDenied - p.AccessDenied,
+			EmptyQueries:         u.EmptyQueries - p.EmptyQueries,
+		})
+	}
+	if len(curr.responseTime) == len(prev.responseTime) {
+		for i, b := range curr.responseTime {
+			p := prev.responseTime[i]
+			if b.Count < p.Count {
+				return nil, false
+			}
+			stats.ResponseTime = append(stats.ResponseTime, qan.ResponseTimeBucket{
+				Time:  b.Time,
+				Count: b.Count - p.Count,
+				Total: b.Total - p.Total,
+			})
+		}
+	}
+	return stats, true
+}
+
+func (c *Collector) getSnapshot() (*snapshot, error) {
+	s := &snapshot{
+		tables: map[string]qan.TableStat{},
+		users:  map[string]qan.UserStat{},
+	}
+
+	rows, err := c.mysqlConn.DB().Query(
+		"SELECT TABLE_SCHEMA, TABLE_NAME, ROWS_READ, ROWS_CHANGED, ROWS_CHANGED_X_INDEXES" +
+			" FROM INFORMATION_SCHEMA.TABLE_STATISTICS")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read TABLE_STATISTICS: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t := qan.TableStat{}
+		if err := rows.Scan(&t.Schema, &t.Table, &t.RowsRead, &t.RowsChanged, &t.RowsChangedXIndexes); err != nil {
+			return nil, err
+		}
+		s.tables[t.Schema+"."+t.Table] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Percona Server and MariaDB name rows read and sent differently.
+	rowsRead, rowsSent := "TABLE_ROWS_READ", "ROWS_FETCHED"
+	if c.flavor.IsMariaDB() {
+		rowsRead, rowsSent = "ROWS_READ", "ROWS_SENT"
+	}
+	rows, err = c.mysqlConn.DB().Query(
+		"SELECT USER, TOTAL_CONNECTIONS, BUSY_TIME, CPU_TIME, BYTES_RECEIVED, BYTES_SENT, " +
+			rowsRead + ", " + rowsSent + ", ROWS_UPDATED, SELECT_COMMANDS, UPDATE_COMMANDS, OTHER_COMMANDS," +
+			" COMMIT_TRANSACTIONS, ROLLBACK_TRANSACTIONS, DENIED_CONNECTIONS, LOST_CONNECTIONS, ACCESS_DENIED, EMPTY_QUERIES" +
+			" FROM INFORMATION_SCHEMA.USER_STATISTICS")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read USER_STATISTICS: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u := qan.UserStat{}
+		err := rows.Scan(
+			&u.User,
+			&u.TotalConnections,
+			&u.BusyTime,
+			&u.CPUTime,
+			&u.BytesReceived,
+			&u.BytesSent,
+			&u.RowsRead,
+			&u.RowsSent,
+			&u.RowsUpdated,
+			&u.SelectCommands,
+			&u.UpdateCommands,
+			&u.OtherCommands,
+			&u.CommitTransactions,
+			&u.RollbackTransactions,
+			&u.DeniedConnections,
+			&u.LostConnections,
+			&u.AccessDenied,
+			&u.EmptyQueries,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.users[u.User] = u
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// QUERY_RESPONSE_TIME is a plugin, so it's optional.
+	rows, err = c.mysqlConn.DB().Query("SELECT TIME, COUNT, TOTAL FROM INFORMATION_SCHEMA.QUERY_RESPONSE_TIME")
+	if err != nil {
+		if mysql.MySQLErrorCode(err) == mysql.ER_UNKNOWN_TABLE {
+			return s, nil
+		}
+		return nil, fmt.Errorf("cannot read QUERY_RESPONSE_TIME: %s", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var time, total string
+		b := qan.ResponseTimeBucket{}
+		if err := rows.Scan(&time, &b.Count, &total); err != nil {
+			return nil, err
+		}
+		b.Time = strings.TrimSpace(time)
+		b.Total, _ = strconv.ParseFloat(strings.TrimSpace(total), 64) // "TOO LONG" = 0
+		s.responseTime = append(s.responseTime, b)
+	}
+	return s, rows.Err()
+}
@@ -0,0 +1,79 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package userstat
+
+import (
+	"testing"
+
+	"github.com/percona/pmm/proto/qan"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	prev := &snapshot{
+		tables: map[string]qan.TableStat{
+			"db.t1": {Schema: "db", Table: "t1", RowsRead: 10, RowsChanged: 1},
+			"db.t2": {Schema: "db", Table: "t2", RowsRead: 5},
+		},
+		users: map[string]qan.UserStat{
+			"app": {User: "app", TotalConnections: 1, BytesReceived: 100, SelectCommands: 2, BusyTime: 1.5},
+		},
+		responseTime: []qan.ResponseTimeBucket{
+			{Time: "0.000001", Count: 1, Total: 0.000001},
+			{Time: "TOO LONG", Count: 0},
+		},
+	}
+	curr := &snapshot{
+		tables: map[string]qan.TableStat{
+			"db.t1": {Schema: "db", Table: "t1", RowsRead: 15, RowsChanged: 3, RowsChangedXIndexes: 6},
+			"db.t2": {Schema: "db", Table: "t2", RowsRead: 5},
+		},
+		users: map[string]qan.UserStat{
+			"app":    {User: "app", TotalConnections: 1, BytesReceived: 300, SelectCommands: 5, BusyTime: 2},
+			"backup": {User: "backup", TotalConnections: 1, BytesReceived: 50, OtherCommands: 1},
+		},
+		responseTime: []qan.ResponseTimeBucket{
+			{Time: "0.000001", Count: 4, Total: 0.000004},
+			{Time: "TOO LONG", Count: 1},
+		},
+	}
+
+	stats, ok := diff(prev, curr)
+	require.True(t, ok)
+
+	// t2 didn't change.
+	assert.Equal(t, []qan.TableStat{
+		{Schema: "db", Table: "t1", RowsRead: 5, RowsChanged: 2, RowsChangedXIndexes: 6},
+	}, stats.Tables)
+
+	require.Len(t, stats.Users, 2)
+	users := map[string]qan.UserStat{}
+	for _, u := range stats.Users {
+		users[u.User] = u
+	}
+	assert.Equal(t, qan.UserStat{User: "app", BytesReceived: 200, SelectCommands: 3, BusyTime: 0.5}, users["app"])
+	assert.Equal(t, uint64(1), users["backup"].OtherCommands)
+
+	assert.Equal(t, uint64(3), stats.ResponseTime[0].Count)
+	assert.Equal(t, uint64(1), stats.ResponseTime[1].Count)
+
+	// Flushed
+	_, ok = diff(curr, prev)
+	assert.False(t, ok)
+}
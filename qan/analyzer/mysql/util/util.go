@@ -1,20 +1,127 @@
 package util
 
 import (
+	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
 
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
 )
 
-func GetMySQLConfig(config pc.QAN) ([]string, []string, error) {
+// Settings are the server-wide settings QAN changes, as they were before QAN
+// changed them, so the un-configure queries only revert what QAN enabled.
+type Settings struct {
+	Consumers   map[string]bool       // setup_consumers NAME => ENABLED
+	Instruments map[string]Instrument // StageWaitInstruments not enabled and timed, keyed on NAME
+	UserStat    bool                  // userstat was ON
+}
+
+// An Instrument is the ENABLED and TIMED columns of a setup_instruments row.
+type Instrument struct {
+	Enabled bool
+	Timed   bool
+}
+
+// StageWaitConsumers are the setup_consumers enabled for the stage and wait breakdown.
+var StageWaitConsumers = []string{
+	"events_statements_history_long",
+	"events_stages_current",
+	"events_stages_history_long",
+	"events_waits_current",
+	"events_waits_history_long",
+}
+
+// StageWaitInstruments are the setup_instruments (NAME LIKE patterns) enabled
+// and timed for the stage and wait breakdown. wait/synch/% instruments
+// (mutexes, rwlocks, conditions) are not because of their overhead, so their
+// waits aren't in the breakdown unless they're enabled and timed otherwise.
+var StageWaitInstruments = []string{
+	"stage/%",
+	"wait/io/%",
+	"wait/lock/%",
+}
+
+// GetSettings returns the current server-wide settings which GetMySQLConfig
+// would change for the config. It must be called before the configure queries
+// are executed.
+func GetSettings(mysqlConn mysql.Connector, config pc.QAN) (Settings, error) {
+	settings := Settings{}
+	if pct.BoolValue(config.UserStats) {
+		// NULL if the server doesn't have userstat.
+		userStat, err := mysqlConn.GetGlobalVarBoolean("userstat")
+		if err != nil {
+			return settings, err
+		}
+		settings.UserStat = userStat.Valid && userStat.Bool
+	}
+
+	if config.CollectFrom != "perfschema" || !pct.BoolValue(config.StageWaitBreakdown) {
+		return settings, nil
+	}
+
+	settings.Consumers = map[string]bool{}
+	rows, err := mysqlConn.DB().Query("SELECT NAME, ENABLED FROM performance_schema.setup_consumers")
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, enabled string
+		if err := rows.Scan(&name, &enabled); err != nil {
+			return settings, err
+		}
+		settings.Consumers[name] = enabled == "YES"
+	}
+	if err := rows.Err(); err != nil {
+		return settings, err
+	}
+
+	settings.Instruments = map[string]Instrument{}
+	rows, err = mysqlConn.DB().Query("SELECT NAME, ENABLED, TIMED FROM performance_schema.setup_instruments" +
+		" WHERE " + instrumentsLike() + " AND (ENABLED <> 'YES' OR TIMED <> 'YES')")
+	if err != nil {
+		return settings, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name, enabled string
+		var timed sql.NullString // NULL if the instrument can't be timed
+		if err := rows.Scan(&name, &enabled, &timed); err != nil {
+			return settings, err
+		}
+		settings.Instruments[name] = Instrument{Enabled: enabled == "YES", Timed: timed.String == "YES"}
+	}
+	return settings, rows.Err()
+}
+
+// GetMySQLConfig returns the queries to configure and un-configure MySQL for
+// the config. If the flavor is unknown (zero), Oracle MySQL is assumed.
+// Server-wide settings are only reverted if they were not already set in
+// settings, which should be from GetSettings.
+func GetMySQLConfig(config pc.QAN, flavor mysql.Flavor, settings Settings) ([]string, []string, error) {
+	var on, off []string
+	var err error
 	switch config.CollectFrom {
 	case "slowlog":
-		return makeSlowLogConfig()
+		on, off, err = makeSlowLogConfig()
 	case "perfschema":
-		return makePerfSchemaConfig()
+		on, off, err = makePerfSchemaConfig(config, flavor, settings)
 	default:
 		return nil, nil, fmt.Errorf("invalid CollectFrom: '%s'; expected 'slowlog' or 'perfschema'", config.CollectFrom)
 	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if pct.BoolValue(config.UserStats) && flavor.HasUserStats() {
+		on = append(on, "SET GLOBAL userstat=ON")
+		if !settings.UserStat {
+			off = append(off, "SET GLOBAL userstat=OFF")
+		}
+	}
+	return on, off, nil
 }
 
 func makeSlowLogConfig() ([]string, []string, error) {
@@ -33,6 +140,77 @@ func makeSlowLogConfig() ([]string, []string, error) {
 	return on, off, nil
 }
 
-func makePerfSchemaConfig() ([]string, []string, error) {
-	return []string{"SET time_zone='+0:00'"}, []string{}, nil
+func makePerfSchemaConfig(config pc.QAN, flavor mysql.Flavor, settings Settings) ([]string, []string, error) {
+	// Statement digests are new in MySQL 5.6 and MariaDB 10.0.
+	minVersion := "5.6"
+	if flavor.IsMariaDB() {
+		minVersion = "10.0"
+	}
+	if !flavor.AtLeastVersion(minVersion) {
+		return nil, nil, fmt.Errorf("performance_schema statement digests require %s %s or newer, have %s",
+			flavor.Distro, minVersion, flavor.Version)
+	}
+
+	on := []string{"SET time_zone='+0:00'"}
+	off := []string{}
+	if pct.BoolValue(config.StageWaitBreakdown) {
+		// Stages and waits are attributed to statements through the _long
+		// history tables, which are disabled by default.
+		on = append(on,
+			"UPDATE performance_schema.setup_consumers SET ENABLED='YES' WHERE NAME IN ("+quoteList(StageWaitConsumers)+")",
+			"UPDATE performance_schema.setup_instruments SET ENABLED='YES', TIMED='YES' WHERE "+instrumentsLike(),
+		)
+		consumers := []string{}
+		for _, name := range StageWaitConsumers {
+			if !settings.Consumers[name] {
+				consumers = append(consumers, name)
+			}
+		}
+		if len(consumers) > 0 {
+			off = append(off,
+				"UPDATE performance_schema.setup_consumers SET ENABLED='NO' WHERE NAME IN ("+quoteList(consumers)+")",
+			)
+		}
+		// Restore ENABLED and TIMED of each instrument as they were, with
+		// one query per combination.
+		names := map[Instrument][]string{}
+		for name, instrument := range settings.Instruments {
+			names[instrument] = append(names[instrument], name)
+		}
+		for _, instrument := range []Instrument{{false, false}, {false, true}, {true, false}} {
+			if len(names[instrument]) == 0 {
+				continue
+			}
+			sort.Strings(names[instrument])
+			off = append(off, fmt.Sprintf(
+				"UPDATE performance_schema.setup_instruments SET ENABLED='%s', TIMED='%s' WHERE NAME IN (%s)",
+				yesNo(instrument.Enabled), yesNo(instrument.Timed), quoteList(names[instrument]),
+			))
+		}
+	}
+	return on, off, nil
+}
+
+// instrumentsLike returns the condition matching StageWaitInstruments.
+func instrumentsLike() string {
+	like := make([]string, len(StageWaitInstruments))
+	for i, pattern := range StageWaitInstruments {
+		like[i] = "NAME LIKE '" + pattern + "'"
+	}
+	return "(" + strings.Join(like, " OR ") + ")"
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.Replace(v, "'", "''", -1) + "'"
+	}
+	return strings.Join(quoted, ", ")
 }
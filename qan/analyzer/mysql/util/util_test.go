@@ -21,12 +21,13 @@ import (
 	"testing"
 
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/mysql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestSlowLogMySQLBasic(t *testing.T) {
-	on, off, err := GetMySQLConfig(pc.QAN{CollectFrom: "slowlog"})
+	on, off, err := GetMySQLConfig(pc.QAN{CollectFrom: "slowlog"}, mysql.Flavor{}, Settings{})
 	require.NoError(t, err)
 	assert.Equal(t, []string{
 		"SET GLOBAL slow_query_log=OFF",
@@ -38,3 +39,77 @@ func TestSlowLogMySQLBasic(t *testing.T) {
 		"SET GLOBAL slow_query_log=OFF",
 	}, off)
 }
+
+func TestPerfSchemaMySQLStageWaitBreakdown(t *testing.T) {
+	on, off, err := GetMySQLConfig(pc.QAN{CollectFrom: "perfschema"}, mysql.Flavor{}, Settings{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SET time_zone='+0:00'"}, on)
+	assert.Equal(t, []string{}, off)
+
+	breakdown := true
+	on, off, err = GetMySQLConfig(pc.QAN{CollectFrom: "perfschema", StageWaitBreakdown: &breakdown}, mysql.Flavor{}, Settings{})
+	require.NoError(t, err)
+	require.Len(t, on, 3)
+	assert.Contains(t, on[1], "'events_stages_history_long'")
+	assert.Equal(t, "UPDATE performance_schema.setup_instruments SET ENABLED='YES', TIMED='YES'"+
+		" WHERE (NAME LIKE 'stage/%' OR NAME LIKE 'wait/io/%' OR NAME LIKE 'wait/lock/%')", on[2])
+	// Nothing was enabled before, so everything is disabled on stop.
+	require.Len(t, off, 1)
+	assert.Equal(t, "UPDATE performance_schema.setup_consumers SET ENABLED='NO' WHERE NAME IN ("+
+		"'events_statements_history_long', 'events_stages_current', 'events_stages_history_long', "+
+		"'events_waits_current', 'events_waits_history_long')", off[0])
+
+	// Only what was not already enabled is disabled on stop.
+	settings := Settings{
+		Consumers: map[string]bool{
+			"events_statements_history_long": true,
+			"events_stages_current":          true,
+			"events_stages_history_long":     true,
+			"events_waits_current":           true,
+			"events_waits_history_long":      false,
+		},
+		Instruments: map[string]Instrument{
+			"stage/sql/Sending data":          {Enabled: false, Timed: false},
+			"stage/sql/checking permissions":  {Enabled: false, Timed: false},
+			"wait/io/file/innodb/innodb_data": {Enabled: true, Timed: false},
+			"wait/lock/table/sql/handler":     {Enabled: false, Timed: true},
+		},
+	}
+	_, off, err = GetMySQLConfig(pc.QAN{CollectFrom: "perfschema", StageWaitBreakdown: &breakdown}, mysql.Flavor{}, settings)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"UPDATE performance_schema.setup_consumers SET ENABLED='NO' WHERE NAME IN ('events_waits_history_long')",
+		"UPDATE performance_schema.setup_instruments SET ENABLED='NO', TIMED='NO' WHERE NAME IN ('stage/sql/Sending data', 'stage/sql/checking permissions')",
+		"UPDATE performance_schema.setup_instruments SET ENABLED='NO', TIMED='YES' WHERE NAME IN ('wait/lock/table/sql/handler')",
+		"UPDATE performance_schema.setup_instruments SET ENABLED='YES', TIMED='NO' WHERE NAME IN ('wait/io/file/innodb/innodb_data')",
+	}, off)
+}
+
+func TestFlavors(t *testing.T) {
+	userStats := true
+	config := pc.QAN{CollectFrom: "slowlog", UserStats: &userStats}
+
+	// userstat is only enabled on distros which have it.
+	on, _, err := GetMySQLConfig(config, mysql.NewFlavor("MySQL Community Server (GPL)", "5.7.21"), Settings{})
+	require.NoError(t, err)
+	assert.NotContains(t, on, "SET GLOBAL userstat=ON")
+	on, off, err := GetMySQLConfig(config, mysql.NewFlavor("Percona Server (GPL), Release 20", "5.7.21-20-log"), Settings{})
+	require.NoError(t, err)
+	assert.Contains(t, on, "SET GLOBAL userstat=ON")
+	assert.Contains(t, off, "SET GLOBAL userstat=OFF")
+
+	// userstat is left on at stop if it was on before.
+	_, off, err = GetMySQLConfig(config, mysql.NewFlavor("Percona Server (GPL), Release 20", "5.7.21-20-log"), Settings{UserStat: true})
+	require.NoError(t, err)
+	assert.NotContains(t, off, "SET GLOBAL userstat=OFF")
+
+	// Statement digests are not available before MySQL 5.6 and MariaDB 10.0.
+	config.CollectFrom = "perfschema"
+	_, _, err = GetMySQLConfig(config, mysql.NewFlavor("MySQL Community Server (GPL)", "5.5.60"), Settings{})
+	assert.Error(t, err)
+	_, _, err = GetMySQLConfig(config, mysql.NewFlavor("MariaDB Server", "5.5.60-MariaDB"), Settings{})
+	assert.Error(t, err)
+	on, _, err = GetMySQLConfig(config, mysql.NewFlavor("mariadb.org binary distribution", "10.2.14-MariaDB-log"), Settings{})
+	require.NoError(t, err)
+	assert.Contains(t, on, "SET GLOBAL userstat=ON")
+}
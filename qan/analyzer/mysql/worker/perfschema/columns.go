@@ -0,0 +1,68 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"github.com/percona/qan-agent/mysql"
+)
+
+// A ColumnSet is the set of columns a performance_schema table has on the
+// server. A nil ColumnSet has all columns: Oracle MySQL and Percona Server
+// 5.6 and newer have every column the collectors read, but MariaDB's
+// performance_schema is based on older MySQL releases and differs between
+// its own releases.
+type ColumnSet map[string]bool
+
+// Has returns true if the table has the column.
+func (cs ColumnSet) Has(column string) bool {
+	return cs == nil || cs[column]
+}
+
+// Col returns column if the table has it, else missing, the value to select
+// instead, so the number and order of selected columns doesn't change.
+func (cs ColumnSet) Col(column, missing string) string {
+	if cs.Has(column) {
+		return column
+	}
+	return missing
+}
+
+// GetColumnSet returns the columns of the performance_schema table, or nil
+// (all columns) if the server isn't MariaDB.
+func GetColumnSet(mysqlConn mysql.Connector, flavor mysql.Flavor, table string) (ColumnSet, error) {
+	if !flavor.IsMariaDB() {
+		return nil, nil
+	}
+	rows, err := mysqlConn.DB().Query(
+		"SELECT COLUMN_NAME FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = 'performance_schema' AND TABLE_NAME = ?",
+		table,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cs := ColumnSet{}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		cs[column] = true
+	}
+	return cs, rows.Err()
+}
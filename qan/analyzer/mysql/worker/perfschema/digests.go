@@ -49,5 +49,5 @@ func (d *Digests) MergeCurr() {
 // Reset drops all collected data
 func (d *Digests) Reset() {
 	d.All = Snapshot{}
-	d.All = Snapshot{}
+	d.Curr = Snapshot{}
 }
@@ -0,0 +1,119 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"sync"
+	"time"
+)
+
+type errorClass struct {
+	counts   map[uint16]uint64 // keyed on errno
+	lastSeen time.Time
+}
+
+// Errors counts the statements of each class which failed, by MySQL error
+// number (events_statements_history.MYSQL_ERRNO). At most maxClasses classes
+// are kept; when full, the class that was seen least recently is evicted,
+// like Examples, so classes which are never reported don't fill it forever.
+type Errors struct {
+	maxClasses int
+	// --
+	classes  map[string]*errorClass // keyed on classId
+	nEvicted uint
+	mux      *sync.Mutex
+}
+
+func NewErrors(maxClasses int) *Errors {
+	if maxClasses <= 0 {
+		maxClasses = DefaultMaxExampleClasses
+	}
+	e := &Errors{
+		maxClasses: maxClasses,
+		// --
+		classes: map[string]*errorClass{},
+		mux:     &sync.Mutex{},
+	}
+	return e
+}
+
+// Add counts one statement of the class which failed with errno at ts.
+func (e *Errors) Add(classId string, errno uint16, ts time.Time) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	class, ok := e.classes[classId]
+	if !ok {
+		if len(e.classes) >= e.maxClasses {
+			e.evict()
+		}
+		class = &errorClass{counts: map[uint16]uint64{}}
+		e.classes[classId] = class
+	}
+	if ts.After(class.lastSeen) {
+		class.lastSeen = ts
+	}
+	class.counts[errno]++
+}
+
+// Pop returns the error counts of the class, keyed on errno, and removes
+// them so they are not reported again in the next interval.
+func (e *Errors) Pop(classId string) map[uint16]uint64 {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	class, ok := e.classes[classId]
+	if !ok {
+		return nil
+	}
+	delete(e.classes, classId)
+	return class.counts
+}
+
+// Len returns the number of classes with errors.
+func (e *Errors) Len() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return len(e.classes)
+}
+
+// Evicted returns the number of classes whose errors were evicted, not
+// reported, because too many classes had errors.
+func (e *Errors) Evicted() uint {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.nEvicted
+}
+
+func (e *Errors) Reset() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.classes = map[string]*errorClass{}
+}
+
+func (e *Errors) evict() {
+	// Caller must lock e.mux.
+	oldestId := ""
+	var oldest time.Time
+	for classId, class := range e.classes {
+		if oldestId == "" || class.lastSeen.Before(oldest) {
+			oldestId = classId
+			oldest = class.lastSeen
+		}
+	}
+	delete(e.classes, oldestId)
+	e.nEvicted++
+}
@@ -0,0 +1,399 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+)
+
+const (
+	DefaultExamplesPerClass  = 5     // slowest examples kept per class
+	DefaultMaxExampleClasses = 10000 // classes kept before the least recently seen is evicted
+	HistoryPollInterval      = 1 * time.Second
+)
+
+// An Example is a single statement from performance_schema.events_statements_history
+// or events_statements_history_long.
+type Example struct {
+	Schema    string
+	SQLText   string
+	TimerWait uint64    // picoseconds
+	Ts        time.Time // when the statement started, see GetExampleRows
+	// --
+	threadId uint64
+	eventId  uint64
+}
+
+// ExampleRow is a row from events_statements_history(_long) with the digest
+// the statement belongs to.
+type ExampleRow struct {
+	Digest string
+	Errno  uint16 // MYSQL_ERRNO, 0 if the statement succeeded
+	Example
+}
+
+type exampleClass struct {
+	examples []Example // slowest first
+	lastSeen time.Time
+}
+
+// Examples keeps the slowest examples of every class. Memory is bounded by
+// perClass examples for each of at most maxClasses classes; when full, the
+// class that was seen least recently is evicted.
+type Examples struct {
+	perClass   int
+	maxClasses int
+	// --
+	classes map[string]*exampleClass // keyed on classId
+	mux     *sync.Mutex
+}
+
+func NewExamples(perClass, maxClasses int) *Examples {
+	if perClass <= 0 {
+		perClass = DefaultExamplesPerClass
+	}
+	if maxClasses <= 0 {
+		maxClasses = DefaultMaxExampleClasses
+	}
+	e := &Examples{
+		perClass:   perClass,
+		maxClasses: maxClasses,
+		// --
+		classes: map[string]*exampleClass{},
+		mux:     &sync.Mutex{},
+	}
+	return e
+}
+
+// Add saves the example if it's one of the slowest of its class.
+func (e *Examples) Add(classId string, ex Example) {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+
+	class, ok := e.classes[classId]
+	if !ok {
+		if len(e.classes) >= e.maxClasses {
+			e.evict()
+		}
+		class = &exampleClass{}
+		e.classes[classId] = class
+	}
+	if ex.Ts.After(class.lastSeen) {
+		class.lastSeen = ex.Ts
+	}
+
+	// The same statement is returned by every poll until performance_schema
+	// overwrites it, so ignore it if we already have it.
+	for _, have := range class.examples {
+		if have.threadId == ex.threadId && have.eventId == ex.eventId && ex.eventId != 0 {
+			return
+		}
+	}
+
+	n := len(class.examples)
+	if n >= e.perClass && ex.TimerWait <= class.examples[n-1].TimerWait {
+		return // not slower than the fastest example we keep
+	}
+	i := sort.Search(n, func(i int) bool { return class.examples[i].TimerWait < ex.TimerWait })
+	class.examples = append(class.examples, Example{})
+	copy(class.examples[i+1:], class.examples[i:])
+	class.examples[i] = ex
+	if len(class.examples) > e.perClass {
+		class.examples = class.examples[:e.perClass]
+	}
+}
+
+// Pop returns the examples of the class, slowest first, and removes them
+// so they are not reported again in the next interval.
+func (e *Examples) Pop(classId string) []Example {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	class, ok := e.classes[classId]
+	if !ok {
+		return nil
+	}
+	delete(e.classes, classId)
+	return class.examples
+}
+
+// Len returns the number of classes with examples.
+func (e *Examples) Len() int {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return len(e.classes)
+}
+
+func (e *Examples) Reset() {
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	e.classes = map[string]*exampleClass{}
+}
+
+func (e *Examples) evict() {
+	// Caller must lock e.mux.
+	oldestId := ""
+	var oldest time.Time
+	for classId, class := range e.classes {
+		if oldestId == "" || class.lastSeen.Before(oldest) {
+			oldestId = classId
+			oldest = class.lastSeen
+		}
+	}
+	delete(e.classes, oldestId)
+}
+
+// EventExample converts the example into the event.Example sent in reports.
+func EventExample(ex Example) *event.Example {
+	query := ex.SQLText
+	if len(query) > event.MAX_EXAMPLE_BYTES {
+		query = query[0:event.MAX_EXAMPLE_BYTES-3] + "..."
+	}
+	return &event.Example{
+		QueryTime: float64(ex.TimerWait) * math.Pow10(-12),
+		Db:        ex.Schema,
+		Query:     query,
+		Ts:        ex.Ts.UTC().Format("2006-01-02 15:04:05"),
+	}
+}
+
+// --------------------------------------------------------------------------
+
+// GetExampleRowsFunc fetches all statements currently in the history table.
+type GetExampleRowsFunc func() ([]ExampleRow, error)
+
+// ExampleCollector polls statement history tables, keeps the slowest examples
+// of each class and counts the errors of each class by error number. The
+// history tables only keep the last statements of each thread, so the error
+// counts are a sample, unlike SUM_ERRORS of the digest table.
+type ExampleCollector struct {
+	*poller
+	getRows  GetExampleRowsFunc
+	examples *Examples
+	errors   *Errors
+	// --
+	seen  map[uint64]uint64 // THREAD_ID => max EVENT_ID
+	nRows uint
+	mux   *sync.Mutex
+}
+
+func NewExampleCollector(logger *pct.Logger, getRows GetExampleRowsFunc, examples *Examples, errors *Errors) *ExampleCollector {
+	c := &ExampleCollector{
+		getRows:  getRows,
+		examples: examples,
+		errors:   errors,
+		// --
+		seen: map[uint64]uint64{},
+		mux:  &sync.Mutex{},
+	}
+	c.poller = newPoller(logger, c.Collect)
+	return c
+}
+
+// Status returns a one-line summary of the collector.
+func (c *ExampleCollector) Status() string {
+	if !c.Running() {
+		return "Stopped"
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	s := fmt.Sprintf("classes: %d, errors: %d, rows: %d", c.examples.Len(), c.errors.Len(), c.nRows)
+	if err := c.LastErr(); err != nil {
+		s += fmt.Sprintf(", error: %s", err)
+	}
+	return s
+}
+
+// Collect fetches the history table once, counts new errors and saves new examples.
+func (c *ExampleCollector) Collect() error {
+	rows, err := c.getRows()
+	if err != nil {
+		return fmt.Errorf("cannot read statement history: %s", err)
+	}
+
+	now := time.Now().UTC()
+	seen := make(map[uint64]uint64, len(c.seen))
+	for _, row := range rows {
+		if row.eventId > seen[row.threadId] {
+			seen[row.threadId] = row.eventId
+		}
+		if row.eventId <= c.seen[row.threadId] {
+			continue // already saved by a previous poll
+		}
+		if len(row.Digest) < 32 {
+			continue
+		}
+		classId := digestClassId(row.Digest)
+		if row.Errno != 0 {
+			c.errors.Add(classId, row.Errno, now)
+		}
+		if row.SQLText != "" {
+			if row.Ts.IsZero() {
+				row.Ts = now // start time unknown, use when it was collected
+			}
+			c.examples.Add(classId, row.Example)
+		}
+	}
+	// Threads which are no longer in the history table will never be
+	// returned again, so forget them to bound the map.
+	c.seen = seen
+
+	c.mux.Lock()
+	c.nRows += uint(len(rows))
+	c.mux.Unlock()
+	return nil
+}
+
+// --------------------------------------------------------------------------
+
+// MakeGetExampleRowsFunc returns a GetExampleRowsFunc which reads examples through
+// its own connection, so it's not affected by the worker connecting and
+// closing its connection every interval. The connection is closed after
+// every call, like the worker's, to not hold it between polls. The history
+// table is checked again after an error. events_statements_history_long
+// is used if its consumer is enabled because it keeps more statements,
+// else events_statements_history.
+func MakeGetExampleRowsFunc(mysqlConn mysql.Connector) GetExampleRowsFunc {
+	table := ""
+	var columns ColumnSet
+	var serverStart time.Time
+	return func() ([]ExampleRow, error) {
+		if err := mysqlConn.Connect(); err != nil {
+			table = ""
+			return nil, err
+		}
+		defer mysqlConn.Close()
+
+		if table == "" {
+			var err error
+			table, err = historyTable(mysqlConn)
+			if err == nil {
+				var flavor mysql.Flavor
+				if flavor, err = mysql.GetFlavor(mysqlConn); err == nil {
+					columns, err = GetColumnSet(mysqlConn, flavor, table)
+				}
+			}
+			if err == nil {
+				serverStart, err = serverStartTime(mysqlConn)
+			}
+			if err != nil {
+				table = ""
+				return nil, err
+			}
+		}
+
+		rows, err := GetExampleRows(mysqlConn, table, columns, serverStart)
+		if err != nil {
+			// Check the consumers again on next call, maybe MySQL
+			// restarted or the consumers were changed.
+			table = ""
+			return nil, err
+		}
+		return rows, nil
+	}
+}
+
+func historyTable(mysqlConn mysql.Connector) (string, error) {
+	var enabled string
+	err := mysqlConn.DB().QueryRow(
+		"SELECT ENABLED FROM performance_schema.setup_consumers WHERE NAME = 'events_statements_history_long'",
+	).Scan(&enabled)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	if strings.ToUpper(enabled) == "YES" {
+		return "events_statements_history_long", nil
+	}
+	return "events_statements_history", nil
+}
+
+// serverStartTime returns when MySQL started, per its uptime. TIMER_START
+// of performance_schema events is relative to it.
+func serverStartTime(mysqlConn mysql.Connector) (time.Time, error) {
+	uptime, err := mysqlConn.Uptime()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if uptime <= 0 {
+		return time.Time{}, nil // unknown
+	}
+	return time.Now().UTC().Add(-time.Duration(uptime) * time.Second), nil
+}
+
+// GetExampleRows returns all completed statements with a digest from the given
+// performance_schema history table. SQL_TEXT is empty if it's NULL; such
+// statements count for errors but are not saved as examples. Columns not in
+// columns are selected as NULL (see GetColumnSet). Ts of each statement is
+// serverStart plus its TIMER_START, or zero if serverStart is zero. It's
+// accurate to about a second because uptime is in seconds.
+func GetExampleRows(mysqlConn mysql.Connector, table string, columns ColumnSet, serverStart time.Time) ([]ExampleRow, error) {
+	q := `
+SELECT
+	THREAD_ID,
+	EVENT_ID,
+	DIGEST,
+	COALESCE(` + columns.Col("CURRENT_SCHEMA", "NULL") + `, ''),
+	COALESCE(SQL_TEXT, ''),
+	COALESCE(TIMER_START, 0),
+	COALESCE(TIMER_WAIT, 0),
+	COALESCE(` + columns.Col("MYSQL_ERRNO", "NULL") + `, 0)
+	FROM performance_schema.` + table + `
+	WHERE DIGEST IS NOT NULL AND END_EVENT_ID IS NOT NULL
+`
+	rows, err := mysqlConn.DB().Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exampleRows := []ExampleRow{}
+	for rows.Next() {
+		row := ExampleRow{}
+		var timerStart uint64 // picoseconds since server start
+		err := rows.Scan(
+			&row.threadId,
+			&row.eventId,
+			&row.Digest,
+			&row.Schema,
+			&row.SQLText,
+			&timerStart,
+			&row.TimerWait,
+			&row.Errno,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !serverStart.IsZero() {
+			row.Ts = serverStart.Add(time.Duration(timerStart / 1000)) // ps to ns
+		}
+		exampleRows = append(exampleRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return exampleRows, nil
+}
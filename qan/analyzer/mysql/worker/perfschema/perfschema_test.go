@@ -32,6 +32,8 @@ import (
 
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/pmm/proto"
+	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
@@ -190,7 +192,7 @@ func test001(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
 	rows, err := loadData("001")
 	require.NoError(t, err)
 	getRows := makeGetRowsFunc(rows)
-	w := NewWorker(logger, nullmysql, getRows)
+	w := NewWorker(logger, nullmysql, getRows, nil, nil)
 
 	// First run doesn't produce a result because 2 snapshots are required.
 	i := &iter.Interval{
@@ -238,7 +240,7 @@ func test002(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
 	rows, err := loadData("002")
 	require.NoError(t, err)
 	getRows := makeGetRowsFunc(rows)
-	w := NewWorker(logger, nullmysql, getRows)
+	w := NewWorker(logger, nullmysql, getRows, nil, nil)
 
 	// First run doesn't produce a result because 2 snapshots are required.
 	i := &iter.Interval{
@@ -283,7 +285,7 @@ func test003(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
 	rows, err := loadData("003")
 	require.NoError(t, err)
 	getRows := makeGetRowsFunc(rows)
-	w := NewWorker(logger, nullmysql, getRows)
+	w := NewWorker(logger, nullmysql, getRows, nil, nil)
 
 	// First interval doesn't produce a result because 2 snapshots are required.
 	i := &iter.Interval{
@@ -362,7 +364,7 @@ func test004EmptyDigest(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMy
 	rows, err := loadData("004")
 	require.NoError(t, err)
 	getRows := makeGetRowsFunc(rows)
-	w := NewWorker(logger, nullmysql, getRows)
+	w := NewWorker(logger, nullmysql, getRows, nil, nil)
 
 	// First run doesn't produce a result because 2 snapshots are required.
 	i := &iter.Interval{
@@ -390,7 +392,7 @@ func test005(t *testing.T, logger *pct.Logger, nullmysql *mock.NullMySQL) {
 	rows, err := loadData("005")
 	require.NoError(t, err)
 	getRows := makeGetRowsFunc(rows)
-	w := NewWorker(logger, nullmysql, getRows)
+	w := NewWorker(logger, nullmysql, getRows, nil, nil)
 
 	// First interval doesn't produce a result because 2 snapshots are required.
 	i := &iter.Interval{
@@ -462,7 +464,7 @@ func testRealWorker(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
@@ -564,7 +566,7 @@ func testIterOutOfSeq(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
@@ -652,7 +654,7 @@ func testIterClockReset(t *testing.T, logger *pct.Logger, dsn string) {
 	}
 
 	mysqlWorkerConn := mysql.NewConnection(dsn)
-	f := NewRealWorkerFactory(logger.LogChan())
+	f := NewRealWorkerFactory(logger.LogChan(), &mysql.RealConnectionFactory{})
 	w := f.Make("qan-worker", mysqlWorkerConn)
 
 	start := []mysql.Query{
@@ -746,3 +748,412 @@ func TestIter(t *testing.T) {
 	got = <-iterChan
 	assert.Equal(t, &iter.Interval{Number: 3, StartTime: t2, StopTime: t3}, got)
 }
+
+func TestExamples(t *testing.T) {
+	t.Parallel()
+
+	e := NewExamples(2, 2)
+	t0 := time.Now().UTC()
+
+	// Only the 2 slowest examples are kept, slowest first.
+	e.Add("A", Example{SQLText: "a1", TimerWait: 10, Ts: t0, threadId: 1, eventId: 1})
+	e.Add("A", Example{SQLText: "a2", TimerWait: 30, Ts: t0, threadId: 1, eventId: 2})
+	e.Add("A", Example{SQLText: "a3", TimerWait: 20, Ts: t0, threadId: 1, eventId: 3})
+	e.Add("A", Example{SQLText: "a4", TimerWait: 5, Ts: t0, threadId: 1, eventId: 4})
+	// The same statement polled again is ignored.
+	e.Add("A", Example{SQLText: "a2", TimerWait: 30, Ts: t0, threadId: 1, eventId: 2})
+
+	// Adding a 3rd class evicts the least recently seen class.
+	e.Add("B", Example{SQLText: "b1", TimerWait: 1, Ts: t0.Add(1 * time.Second)})
+	e.Add("C", Example{SQLText: "c1", TimerWait: 1, Ts: t0.Add(2 * time.Second)})
+	assert.Equal(t, 2, e.Len())
+
+	assert.Nil(t, e.Pop("A"))
+	got := e.Pop("B")
+	require.Len(t, got, 1)
+	assert.Equal(t, "b1", got[0].SQLText)
+
+	e.Reset()
+	e.Add("A", Example{SQLText: "a1", TimerWait: 10, Ts: t0, threadId: 1, eventId: 1})
+	e.Add("A", Example{SQLText: "a2", TimerWait: 30, Ts: t0, threadId: 1, eventId: 2})
+	e.Add("A", Example{SQLText: "a3", TimerWait: 20, Ts: t0, threadId: 1, eventId: 3})
+	got = e.Pop("A")
+	require.Len(t, got, 2)
+	assert.Equal(t, "a2", got[0].SQLText)
+	assert.Equal(t, "a3", got[1].SQLText)
+	assert.Nil(t, e.Pop("A"))
+
+	ex := EventExample(got[0])
+	assert.Equal(t, float64(30)*1e-12, ex.QueryTime)
+	assert.Equal(t, "a2", ex.Query)
+}
+
+func TestExampleCollector(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+	digest := "0123456789abcdef0123456789abcdef"
+	polls := [][]ExampleRow{
+		{
+			{Digest: digest, Example: Example{SQLText: "select 1", TimerWait: 10, threadId: 1, eventId: 1}},
+		},
+		nil, // error
+		{
+			// Statement from 1st poll still in history is not saved again.
+			{Digest: digest, Example: Example{SQLText: "select 1", TimerWait: 10, threadId: 1, eventId: 1}},
+			{Digest: digest, Example: Example{SQLText: "select 2", TimerWait: 20, threadId: 1, eventId: 2}},
+		},
+	}
+	getRows := func() ([]ExampleRow, error) {
+		rows := polls[0]
+		polls = polls[1:]
+		if rows == nil {
+			return nil, fmt.Errorf("connection lost")
+		}
+		return rows, nil
+	}
+	examples := NewExamples(5, 10)
+	c := NewExampleCollector(pct.NewLogger(logChan, "examples"), getRows, examples, NewErrors(10))
+
+	require.NoError(t, c.Collect())
+	got := examples.Pop("0123456789ABCDEF")
+	require.Len(t, got, 1)
+	assert.Equal(t, "select 1", got[0].SQLText)
+
+	// Errors are returned but don't lose what was already seen.
+	assert.Error(t, c.Collect())
+
+	require.NoError(t, c.Collect())
+	got = examples.Pop("0123456789ABCDEF")
+	require.Len(t, got, 1)
+	assert.Equal(t, "select 2", got[0].SQLText)
+
+	// Ts is when the statement started if it's known,
+	// else when it was collected.
+	ts := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	polls = [][]ExampleRow{
+		{
+			{Digest: digest, Example: Example{SQLText: "select 3", TimerWait: 30, Ts: ts, threadId: 1, eventId: 3}},
+			{Digest: digest, Example: Example{SQLText: "select 4", TimerWait: 20, threadId: 1, eventId: 4}},
+		},
+	}
+	t0 := time.Now().UTC()
+	require.NoError(t, c.Collect())
+	got = examples.Pop("0123456789ABCDEF")
+	require.Len(t, got, 2)
+	assert.Equal(t, ts, got[0].Ts)
+	assert.False(t, got[1].Ts.Before(t0))
+}
+
+func TestWorkerExampleCollector(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+	getExamples := func() ([]ExampleRow, error) { return nil, nil }
+	w := NewWorker(pct.NewLogger(logChan, "qan-worker"), mock.NewNullMySQL(), nil, getExamples, nil)
+	defer w.Stop()
+
+	on, off := true, false
+
+	// The statement history isn't polled without examples.
+	w.SetConfig(pc.QAN{ExampleQueries: &off})
+	assert.False(t, w.exampleCollector.Running())
+
+	w.SetConfig(pc.QAN{ExampleQueries: &on})
+	assert.True(t, w.exampleCollector.Running())
+
+	w.SetConfig(pc.QAN{ExampleQueries: &off})
+	assert.False(t, w.exampleCollector.Running())
+}
+
+func TestExampleCollectorErrors(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+	digest := "0123456789abcdef0123456789abcdef"
+	rows := []ExampleRow{
+		{Digest: digest, Errno: 1062, Example: Example{SQLText: "insert 1", TimerWait: 10, threadId: 1, eventId: 1}},
+		{Digest: digest, Errno: 1062, Example: Example{SQLText: "insert 2", TimerWait: 10, threadId: 2, eventId: 1}},
+		{Digest: digest, Errno: 1213, Example: Example{SQLText: "", TimerWait: 10, threadId: 2, eventId: 2}},
+		{Digest: digest, Example: Example{SQLText: "insert 3", TimerWait: 10, threadId: 2, eventId: 3}},
+	}
+	getRows := func() ([]ExampleRow, error) {
+		return rows, nil
+	}
+	examples := NewExamples(5, 10)
+	errors := NewErrors(10)
+	c := NewExampleCollector(pct.NewLogger(logChan, "examples"), getRows, examples, errors)
+
+	// Errors are counted once even though the rows are returned by every poll.
+	require.NoError(t, c.Collect())
+	require.NoError(t, c.Collect())
+	assert.Equal(t, map[uint16]uint64{1062: 2, 1213: 1}, errors.Pop("0123456789ABCDEF"))
+	assert.Nil(t, errors.Pop("0123456789ABCDEF"))
+
+	// Examples are saved from the same rows, except those without SQL text.
+	assert.Len(t, examples.Pop("0123456789ABCDEF"), 3)
+}
+
+func TestPollerStop(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+
+	// Stop while a poll is in progress returns once the poll does.
+	polling := make(chan struct{}, 1)
+	release := make(chan struct{})
+	p := newPoller(pct.NewLogger(logChan, "poller"), func() error {
+		select {
+		case polling <- struct{}{}:
+		default:
+		}
+		<-release
+		return fmt.Errorf("connection lost")
+	})
+	p.Start(1 * time.Millisecond)
+	<-polling
+	stopped := make(chan struct{})
+	go func() {
+		p.Stop()
+		close(stopped)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+	assert.False(t, p.Running())
+
+	// A panic in collect doesn't stop the poller, so Stop still works.
+	panicked := make(chan struct{}, 1)
+	p = newPoller(pct.NewLogger(logChan, "poller"), func() error {
+		select {
+		case panicked <- struct{}{}:
+		default:
+		}
+		panic("bad row")
+	})
+	p.Start(1 * time.Millisecond)
+	<-panicked
+	assert.True(t, p.Running())
+	p.Stop()
+	assert.False(t, p.Running())
+	assert.Error(t, p.LastErr())
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	e := NewErrors(2)
+	t0 := time.Now().UTC()
+	e.Add("A", 1062, t0)
+	e.Add("A", 1062, t0.Add(2*time.Second))
+	e.Add("B", 1213, t0.Add(1*time.Second))
+	e.Add("C", 1146, t0.Add(3*time.Second)) // evicts B, seen least recently
+	assert.Equal(t, 2, e.Len())
+	assert.Equal(t, uint(1), e.Evicted())
+	assert.Nil(t, e.Pop("B"))
+	assert.Equal(t, map[uint16]uint64{1062: 2}, e.Pop("A"))
+	assert.Equal(t, 1, e.Len())
+	e.Reset()
+	assert.Equal(t, 0, e.Len())
+}
+
+func TestStageCollector(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+	digest := "0123456789abcdef0123456789abcdef"
+	stages := []StageRow{
+		{Digest: digest, EventName: "stage/sql/Sending data", TimerWait: 3000000000000, threadId: 1, eventId: 2},
+		{Digest: digest, EventName: "stage/sql/Creating sort index", TimerWait: 1000000000000, threadId: 1, eventId: 3},
+	}
+	waits := []StageRow{}
+	for i := 0; i < MaxWaitsPerClass+1; i++ {
+		waits = append(waits, StageRow{
+			Digest:    digest,
+			EventName: fmt.Sprintf("wait/io/file/sql/f%d", i),
+			TimerWait: uint64(i+1) * 1000000000000,
+			threadId:  1,
+			eventId:   uint64(10 + i),
+		})
+	}
+	getRows := func() ([]StageRow, []StageRow, error) {
+		return stages, waits, nil
+	}
+	c := NewStageCollector(pct.NewLogger(logChan, "stages"), getRows, 10)
+
+	// Events still in history are only counted once.
+	require.NoError(t, c.Collect())
+	require.NoError(t, c.Collect())
+
+	b := c.Pop("0123456789ABCDEF")
+	require.NotNil(t, b)
+	assert.Equal(t, uint64(3000000000000), b.Stages["stage/sql/Sending data"])
+	assert.Nil(t, c.Pop("0123456789ABCDEF"))
+
+	metrics := event.NewMetrics()
+	b.AddMetrics(metrics)
+	assert.Equal(t, float64(3), metrics.TimeMetrics["Stage_sending_data"].Sum)
+	assert.Equal(t, float64(1), metrics.TimeMetrics["Stage_creating_sort_index"].Sum)
+	// Only the top waits are reported, so the fastest one is not.
+	assert.Equal(t, float64(6), metrics.TimeMetrics["Wait_io_file_sql_f5"].Sum)
+	assert.Nil(t, metrics.TimeMetrics["Wait_io_file_sql_f0"])
+	assert.Len(t, metrics.TimeMetrics, 2+MaxWaitsPerClass)
+
+	// When full, a new class evicts the class seen least recently.
+	digestB := "fedcba9876543210fedcba9876543210"
+	polls := [][]StageRow{
+		{{Digest: digest, EventName: "stage/sql/Sending data", TimerWait: 1, threadId: 1, eventId: 1}},
+		{{Digest: digestB, EventName: "stage/sql/Sending data", TimerWait: 2, threadId: 1, eventId: 2}},
+	}
+	getRows = func() ([]StageRow, []StageRow, error) {
+		rows := polls[0]
+		polls = polls[1:]
+		return rows, nil, nil
+	}
+	c = NewStageCollector(pct.NewLogger(logChan, "stages"), getRows, 1)
+	require.NoError(t, c.Collect())
+	require.NoError(t, c.Collect())
+	assert.Nil(t, c.Pop("0123456789ABCDEF"))
+	b = c.Pop("FEDCBA9876543210")
+	require.NotNil(t, b)
+	assert.Equal(t, uint64(2), b.Stages["stage/sql/Sending data"])
+}
+
+func TestStatementRangesAttribute(t *testing.T) {
+	t.Parallel()
+
+	digestA := "0123456789abcdef0123456789abcdef"
+	digestB := "fedcba9876543210fedcba9876543210"
+	r := NewStatementRanges([]StatementRange{
+		{Digest: digestB, threadId: 1, eventId: 20, endEventId: 25},
+		{Digest: digestA, threadId: 1, eventId: 10, endEventId: 15},
+		{Digest: digestA, threadId: 2, eventId: 10, endEventId: 15},
+	})
+	events := []StageRow{
+		{EventName: "a", threadId: 1, eventId: 11},
+		{EventName: "b", threadId: 1, eventId: 15},
+		{EventName: "c", threadId: 1, eventId: 16}, // between statements
+		{EventName: "d", threadId: 1, eventId: 22},
+		{EventName: "e", threadId: 1, eventId: 5}, // before first statement
+		{EventName: "f", threadId: 2, eventId: 12},
+		{EventName: "g", threadId: 3, eventId: 12}, // no statements on thread
+		{EventName: "h", threadId: 1, eventId: 10}, // the statement itself
+	}
+	got := r.Attribute(events)
+	expect := []StageRow{
+		{Digest: digestA, EventName: "a", threadId: 1, eventId: 11},
+		{Digest: digestA, EventName: "b", threadId: 1, eventId: 15},
+		{Digest: digestB, EventName: "d", threadId: 1, eventId: 22},
+		{Digest: digestA, EventName: "f", threadId: 2, eventId: 12},
+	}
+	assert.Equal(t, expect, got)
+}
+
+func TestTableWorker(t *testing.T) {
+	t.Parallel()
+
+	logChan := make(chan proto.LogEntry, 100)
+	tableSnapshot := func(tables, indexes []*TableRow) TableSnapshot {
+		s := NewTableSnapshot()
+		for _, row := range tables {
+			s.AddTable(row)
+		}
+		for _, row := range indexes {
+			s.AddIndex(row)
+		}
+		return s
+	}
+	snapshots := []TableSnapshot{
+		tableSnapshot(
+			[]*TableRow{
+				{Schema: "db", Table: "t1", CountStar: 10, SumTimerWait: 1000000000000, CountRead: 10, CountFetch: 10},
+				{Schema: "db", Table: "t2", CountStar: 5},
+			},
+			[]*TableRow{
+				{Schema: "db", Table: "t1", Index: "PRIMARY", CountStar: 10, CountRead: 10},
+				{Schema: "db", Table: "t1", Index: "idx_a"},
+				{Schema: "db", Table: "t2", CountStar: 5},
+			},
+		),
+		tableSnapshot(
+			[]*TableRow{
+				{Schema: "db", Table: "t1", CountStar: 15, SumTimerWait: 3000000000000, CountRead: 12, CountWrite: 3, CountInsert: 3, CountFetch: 12},
+				{Schema: "db", Table: "t2", CountStar: 5},
+			},
+			[]*TableRow{
+				{Schema: "db", Table: "t1", Index: "PRIMARY", CountStar: 15, CountRead: 12, CountWrite: 3},
+				{Schema: "db", Table: "t1", Index: "idx_a"},
+				{Schema: "db", Table: "t2", CountStar: 5},
+			},
+		),
+		// Truncated
+		tableSnapshot(
+			[]*TableRow{
+				{Schema: "db", Table: "t1", CountStar: 1},
+			},
+			nil,
+		),
+	}
+	getRows := func() (TableSnapshot, error) {
+		s := snapshots[0]
+		snapshots = snapshots[1:]
+		return s, nil
+	}
+	w := NewTableWorker(pct.NewLogger(logChan, "qan-tables"), mock.NewNullMySQL(), getRows)
+
+	// First interval only sets the baseline.
+	w.Setup(&iter.Interval{Number: 1})
+	res, err := w.Run()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	w.Cleanup()
+
+	w.Setup(&iter.Interval{Number: 2})
+	res, err = w.Run()
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	w.Cleanup()
+
+	// t2 had no I/O.
+	require.Len(t, res.Tables, 1)
+	assert.Equal(t, qan.TableIO{
+		Schema:      "db",
+		Table:       "t1",
+		CountStar:   5,
+		CountRead:   2,
+		CountWrite:  3,
+		CountFetch:  2,
+		CountInsert: 3,
+		Time:        2,
+	}, res.Tables[0])
+
+	// Only PRIMARY had I/O, unused idx_a is listed by name,
+	// and I/O without index on t2 is not reported.
+	require.Len(t, res.Indexes, 1)
+	assert.Equal(t, "PRIMARY", res.Indexes[0].Index)
+	assert.Equal(t, uint64(5), res.Indexes[0].CountStar)
+	assert.Equal(t, []qan.Index{{Schema: "db", Table: "t1", Index: "idx_a"}}, res.UnusedIndexes)
+
+	// Truncate resets the worker.
+	w.Setup(&iter.Interval{Number: 3})
+	res, err = w.Run()
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	w.Cleanup()
+}
+
+func TestColumnSet(t *testing.T) {
+	t.Parallel()
+
+	var all ColumnSet
+	assert.Equal(t, "SUM_ERRORS", all.Col("SUM_ERRORS", "0"))
+
+	cs := ColumnSet{"COUNT_STAR": true}
+	assert.True(t, cs.Has("COUNT_STAR"))
+	assert.False(t, cs.Has("LAST_SEEN"))
+	assert.Equal(t, "0", cs.Col("SUM_ERRORS", "0"))
+}
@@ -0,0 +1,140 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/percona/qan-agent/pct"
+)
+
+// A poller calls collect at an interval until stopped. It's used to read
+// performance_schema history tables which must be read more often than the
+// QAN interval because they only keep the last statements, stages, and waits.
+// If collect fails, the poller backs off but never stops polling, so it
+// survives MySQL restarts and lost connections.
+type poller struct {
+	logger  *pct.Logger
+	collect func() error
+	// --
+	mux      *sync.Mutex     // Lock() to protect running and lastErr
+	running  bool            // Is the poller running?
+	lastErr  error           // error from the last poll
+	doneChan chan struct{}   // close(doneChan) to notify run that it should stop
+	wg       *sync.WaitGroup // Wait() for run to stop after being notified it should stop
+}
+
+func newPoller(logger *pct.Logger, collect func() error) *poller {
+	p := &poller{
+		logger:  logger,
+		collect: collect,
+		// --
+		mux: &sync.Mutex{},
+	}
+	return p
+}
+
+func (p *poller) Start(interval time.Duration) {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if p.running {
+		return
+	}
+	p.doneChan = make(chan struct{})
+	p.wg = &sync.WaitGroup{}
+	p.wg.Add(1)
+	go p.run(interval, p.wg, p.doneChan)
+	p.running = true
+}
+
+// Stop stops the poller and waits for it to return. The lock isn't held
+// while waiting because a poll in progress can take as long as MySQL does
+// to answer, and run takes the lock to save the error of the poll.
+func (p *poller) Stop() {
+	p.mux.Lock()
+	if !p.running {
+		p.mux.Unlock()
+		return
+	}
+	p.running = false
+	close(p.doneChan)
+	wg := p.wg
+	p.mux.Unlock()
+
+	wg.Wait()
+}
+
+func (p *poller) Running() bool {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.running
+}
+
+// LastErr returns the error from the last poll, if any.
+func (p *poller) LastErr() error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	return p.lastErr
+}
+
+func (p *poller) run(interval time.Duration, wg *sync.WaitGroup, doneChan <-chan struct{}) {
+	// signal WaitGroup when goroutine finished
+	defer wg.Done()
+
+	backoff := pct.NewBackoff(int(5*time.Minute/time.Second), 5*time.Minute)
+	wait := interval
+	var lastErr string
+	for {
+		select {
+		case <-time.After(wait):
+		case <-doneChan:
+			return
+		}
+
+		err := p.poll()
+		p.mux.Lock()
+		p.lastErr = err
+		p.mux.Unlock()
+		if err != nil {
+			// Log an error only once until it changes, else a down MySQL
+			// would flood the log every poll.
+			if err.Error() != lastErr {
+				p.logger.Warn(err)
+				lastErr = err.Error()
+			}
+			wait = interval + backoff.Wait()
+			continue
+		}
+		lastErr = ""
+		backoff.Success()
+		wait = interval
+	}
+}
+
+// poll calls collect and returns a panic as an error, so a bad row doesn't
+// stop the poller and leave it marked as running.
+func (p *poller) poll() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("poller crashed: %v", r)
+		}
+	}()
+	return p.collect()
+}
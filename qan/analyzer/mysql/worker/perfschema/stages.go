@@ -0,0 +1,394 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/percona/go-mysql/event"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+)
+
+const (
+	MaxWaitsPerClass = 5 // top wait events reported per class
+)
+
+// A StageRow is a stage or wait event from events_stages_history_long or
+// events_waits_history_long and the digest of the statement it's nested in.
+type StageRow struct {
+	Digest    string
+	EventName string
+	TimerWait uint64 // picoseconds
+	// --
+	threadId uint64
+	eventId  uint64
+}
+
+// GetStageRowsFunc fetches all stages and waits currently in the history tables.
+type GetStageRowsFunc func() (stages []StageRow, waits []StageRow, err error)
+
+// A Breakdown is the time spent in each stage and wait by all statements
+// of a class, keyed on EVENT_NAME, in picoseconds.
+type Breakdown struct {
+	Stages map[string]uint64
+	Waits  map[string]uint64
+}
+
+func newBreakdown() *Breakdown {
+	return &Breakdown{
+		Stages: map[string]uint64{},
+		Waits:  map[string]uint64{},
+	}
+}
+
+// AddMetrics adds the time of every stage and the top waits to the class metrics
+// as Stage_* and Wait_* time metrics, e.g. "stage/sql/Sending data" becomes
+// Stage_sending_data.
+func (b *Breakdown) AddMetrics(metrics *event.Metrics) {
+	for name, wait := range b.Stages {
+		metrics.TimeMetrics[metricName("Stage", strings.TrimPrefix(name, "stage/sql/"))] = &event.TimeStats{
+			Sum: float64(wait) * math.Pow10(-12),
+		}
+	}
+
+	names := make([]string, 0, len(b.Waits))
+	for name := range b.Waits {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return b.Waits[names[i]] > b.Waits[names[j]]
+	})
+	if len(names) > MaxWaitsPerClass {
+		names = names[:MaxWaitsPerClass]
+	}
+	for _, name := range names {
+		metrics.TimeMetrics[metricName("Wait", strings.TrimPrefix(name, "wait/"))] = &event.TimeStats{
+			Sum: float64(b.Waits[name]) * math.Pow10(-12),
+		}
+	}
+}
+
+var reMetricName = regexp.MustCompile("[^a-z0-9]+")
+
+func metricName(prefix, eventName string) string {
+	return prefix + "_" + strings.Trim(reMetricName.ReplaceAllString(strings.ToLower(eventName), "_"), "_")
+}
+
+// --------------------------------------------------------------------------
+
+type stageClass struct {
+	breakdown *Breakdown
+	lastSeen  time.Time
+}
+
+// StageCollector polls stage and wait history tables and sums the time
+// of the events nested in statements by the class of the statement.
+// At most maxClasses classes are kept; when full, the class that was
+// seen least recently is evicted, like Examples.
+type StageCollector struct {
+	*poller
+	getRows    GetStageRowsFunc
+	maxClasses int
+	// --
+	classes    map[string]*stageClass // keyed on classId
+	seenStages map[uint64]uint64      // THREAD_ID => max EVENT_ID
+	seenWaits  map[uint64]uint64      // THREAD_ID => max EVENT_ID
+	nRows      uint
+	nEvicted   uint
+	mux        *sync.Mutex
+}
+
+func NewStageCollector(logger *pct.Logger, getRows GetStageRowsFunc, maxClasses int) *StageCollector {
+	if maxClasses <= 0 {
+		maxClasses = DefaultMaxExampleClasses
+	}
+	c := &StageCollector{
+		getRows:    getRows,
+		maxClasses: maxClasses,
+		// --
+		classes:    map[string]*stageClass{},
+		seenStages: map[uint64]uint64{},
+		seenWaits:  map[uint64]uint64{},
+		mux:        &sync.Mutex{},
+	}
+	c.poller = newPoller(logger, c.Collect)
+	return c
+}
+
+// Collect fetches the history tables once and adds new stages and waits
+// to the breakdown of their classes.
+func (c *StageCollector) Collect() error {
+	stages, waits, err := c.getRows()
+	if err != nil {
+		return fmt.Errorf("cannot collect stages and waits: %s", err)
+	}
+
+	now := time.Now().UTC()
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.seenStages = c.add(stages, c.seenStages, now, func(b *Breakdown) map[string]uint64 { return b.Stages })
+	c.seenWaits = c.add(waits, c.seenWaits, now, func(b *Breakdown) map[string]uint64 { return b.Waits })
+	c.nRows += uint(len(stages) + len(waits))
+	return nil
+}
+
+// Pop returns the breakdown of the class and removes it so it's not reported
+// again in the next interval.
+func (c *StageCollector) Pop(classId string) *Breakdown {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	class, ok := c.classes[classId]
+	if !ok {
+		return nil
+	}
+	delete(c.classes, classId)
+	return class.breakdown
+}
+
+func (c *StageCollector) Reset() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.classes = map[string]*stageClass{}
+}
+
+// Status returns a one-line summary of the collector.
+func (c *StageCollector) Status() string {
+	if !c.Running() {
+		return "Stopped"
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	s := fmt.Sprintf("classes: %d, rows: %d, evicted: %d", len(c.classes), c.nRows, c.nEvicted)
+	if err := c.LastErr(); err != nil {
+		s += fmt.Sprintf(", error: %s", err)
+	}
+	return s
+}
+
+func (c *StageCollector) add(rows []StageRow, prevSeen map[uint64]uint64, now time.Time, events func(*Breakdown) map[string]uint64) map[uint64]uint64 {
+	// Caller must lock c.mux.
+	seen := make(map[uint64]uint64, len(prevSeen))
+	for _, row := range rows {
+		if row.eventId > seen[row.threadId] {
+			seen[row.threadId] = row.eventId
+		}
+		if row.eventId <= prevSeen[row.threadId] {
+			continue // already added by a previous poll
+		}
+		if len(row.Digest) < 32 {
+			continue
+		}
+		classId := digestClassId(row.Digest)
+		class, ok := c.classes[classId]
+		if !ok {
+			if len(c.classes) >= c.maxClasses {
+				c.evict()
+			}
+			class = &stageClass{breakdown: newBreakdown()}
+			c.classes[classId] = class
+		}
+		class.lastSeen = now
+		events(class.breakdown)[row.EventName] += row.TimerWait
+	}
+	return seen
+}
+
+func (c *StageCollector) evict() {
+	// Caller must lock c.mux.
+	oldestId := ""
+	var oldest time.Time
+	for classId, class := range c.classes {
+		if oldestId == "" || class.lastSeen.Before(oldest) {
+			oldestId = classId
+			oldest = class.lastSeen
+		}
+	}
+	delete(c.classes, oldestId)
+	c.nEvicted++
+}
+
+// --------------------------------------------------------------------------
+
+// MakeGetStageRowsFunc returns a GetStageRowsFunc which reads stages and waits
+// through its own connection, closed after every call, like
+// MakeGetExampleRowsFunc. The history tables
+// have no indexes, so they're read separately and stages and waits are matched
+// to their statements here rather than joined by MySQL.
+func MakeGetStageRowsFunc(mysqlConn mysql.Connector) GetStageRowsFunc {
+	return func() ([]StageRow, []StageRow, error) {
+		if err := mysqlConn.Connect(); err != nil {
+			return nil, nil, err
+		}
+		defer mysqlConn.Close()
+		statements, err := GetStatementRanges(mysqlConn)
+		if err != nil {
+			return nil, nil, err
+		}
+		stages, err := GetStageRows(mysqlConn)
+		if err != nil {
+			return nil, nil, err
+		}
+		waits, err := GetWaitRows(mysqlConn)
+		if err != nil {
+			return nil, nil, err
+		}
+		return statements.Attribute(stages), statements.Attribute(waits), nil
+	}
+}
+
+// A StatementRange is a statement with a digest from events_statements_history_long
+// and the range of events nested in it: (EVENT_ID, END_EVENT_ID].
+type StatementRange struct {
+	Digest     string
+	threadId   uint64
+	eventId    uint64
+	endEventId uint64
+}
+
+// StatementRanges are statement ranges keyed on THREAD_ID, sorted by EVENT_ID.
+type StatementRanges map[uint64][]StatementRange
+
+func NewStatementRanges(statements []StatementRange) StatementRanges {
+	r := StatementRanges{}
+	for _, s := range statements {
+		r[s.threadId] = append(r[s.threadId], s)
+	}
+	for _, ss := range r {
+		sort.Slice(ss, func(i, j int) bool { return ss[i].eventId < ss[j].eventId })
+	}
+	return r
+}
+
+// Attribute sets the digest of the statement each stage or wait is nested in
+// and returns them, dropping the events not nested in a statement. An event is
+// attributed to the last statement on the thread which started before it,
+// if the event is in its range.
+func (r StatementRanges) Attribute(events []StageRow) []StageRow {
+	attributed := make([]StageRow, 0, len(events))
+	for _, e := range events {
+		ss := r[e.threadId]
+		i := sort.Search(len(ss), func(i int) bool { return ss[i].eventId >= e.eventId }) - 1
+		if i < 0 || e.eventId > ss[i].endEventId {
+			continue
+		}
+		e.Digest = ss[i].Digest
+		attributed = append(attributed, e)
+	}
+	return attributed
+}
+
+// GetStatementRanges returns the statements with a digest in the statement history.
+func GetStatementRanges(mysqlConn mysql.Connector) (StatementRanges, error) {
+	q := `
+SELECT
+	THREAD_ID,
+	EVENT_ID,
+	END_EVENT_ID,
+	DIGEST
+	FROM performance_schema.events_statements_history_long
+	WHERE DIGEST IS NOT NULL AND END_EVENT_ID IS NOT NULL
+`
+	rows, err := mysqlConn.DB().Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	statements := []StatementRange{}
+	for rows.Next() {
+		s := StatementRange{}
+		err := rows.Scan(
+			&s.threadId,
+			&s.eventId,
+			&s.endEventId,
+			&s.Digest,
+		)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return NewStatementRanges(statements), nil
+}
+
+// GetStageRows returns the stages in the stage history, without digests.
+func GetStageRows(mysqlConn mysql.Connector) ([]StageRow, error) {
+	q := `
+SELECT
+	THREAD_ID,
+	EVENT_ID,
+	EVENT_NAME,
+	TIMER_WAIT
+	FROM performance_schema.events_stages_history_long
+	WHERE TIMER_WAIT IS NOT NULL
+`
+	return getStageRows(mysqlConn, q)
+}
+
+// GetWaitRows returns the waits in the wait history, without digests.
+// Waits are usually nested in a stage, not directly in the statement,
+// so they're attributed by the range of events nested in the statement.
+func GetWaitRows(mysqlConn mysql.Connector) ([]StageRow, error) {
+	q := `
+SELECT
+	THREAD_ID,
+	EVENT_ID,
+	EVENT_NAME,
+	TIMER_WAIT
+	FROM performance_schema.events_waits_history_long
+	WHERE TIMER_WAIT IS NOT NULL
+`
+	return getStageRows(mysqlConn, q)
+}
+
+func getStageRows(mysqlConn mysql.Connector, q string) ([]StageRow, error) {
+	rows, err := mysqlConn.DB().Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stageRows := []StageRow{}
+	for rows.Next() {
+		row := StageRow{}
+		err := rows.Scan(
+			&row.threadId,
+			&row.eventId,
+			&row.EventName,
+			&row.TimerWait,
+		)
+		if err != nil {
+			return nil, err
+		}
+		stageRows = append(stageRows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return stageRows, nil
+}
@@ -0,0 +1,333 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package perfschema
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/percona/pmm/proto/qan"
+	"github.com/percona/qan-agent/mysql"
+	"github.com/percona/qan-agent/pct"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
+	"github.com/percona/qan-agent/qan/analyzer/report"
+)
+
+// A TableRow is a row from table_io_waits_summary_by_table or
+// table_io_waits_summary_by_index_usage.
+type TableRow struct {
+	Schema        string
+	Table         string
+	Index         string // only by_index_usage, "" if no index was used
+	CountStar     uint64
+	SumTimerWait  uint64
+	CountRead     uint64
+	SumTimerRead  uint64
+	CountWrite    uint64
+	SumTimerWrite uint64
+	CountFetch    uint64
+	CountInsert   uint64
+	CountUpdate   uint64
+	CountDelete   uint64
+}
+
+// A TableSnapshot represents all rows from the table I/O summary tables at a
+// single time: tables keyed on schema.table and indexes keyed on
+// schema.table.index. Like Snapshots of digests, two consecutive
+// TableSnapshots are needed to produce a report.TableResult.
+type TableSnapshot struct {
+	Tables  map[string]*TableRow
+	Indexes map[string]*TableRow
+}
+
+func NewTableSnapshot() TableSnapshot {
+	return TableSnapshot{
+		Tables:  map[string]*TableRow{},
+		Indexes: map[string]*TableRow{},
+	}
+}
+
+// AddTable adds a row from table_io_waits_summary_by_table.
+func (s TableSnapshot) AddTable(row *TableRow) {
+	s.Tables[row.Schema+"."+row.Table] = row
+}
+
+// AddIndex adds a row from table_io_waits_summary_by_index_usage.
+func (s TableSnapshot) AddIndex(row *TableRow) {
+	s.Indexes[row.Schema+"."+row.Table+"."+row.Index] = row
+}
+
+// GetTableRowsFunc fetches a snapshot of the table I/O summary tables.
+type GetTableRowsFunc func() (TableSnapshot, error)
+
+// --------------------------------------------------------------------------
+
+// TableWorker reports table and index I/O deltas every interval. It's run by
+// the analyzer after the query worker, whichever the query source, if the
+// TableIO option is enabled.
+type TableWorker struct {
+	logger    *pct.Logger
+	mysqlConn mysql.Connector
+	getRows   GetTableRowsFunc
+	// --
+	name          string
+	status        *pct.Status
+	prev          TableSnapshot
+	curr          TableSnapshot
+	iter          *iter.Interval
+	lastErr       error
+	lastRowCnt    uint
+	lastFetchTime time.Time
+}
+
+// NewTableWorker returns a new TableWorker. If getRows is nil, the summary
+// tables are read through mysqlConn.
+func NewTableWorker(logger *pct.Logger, mysqlConn mysql.Connector, getRows GetTableRowsFunc) *TableWorker {
+	name := logger.Service()
+	w := &TableWorker{
+		logger:    logger,
+		mysqlConn: mysqlConn,
+		getRows:   getRows,
+		// --
+		name:   name,
+		status: pct.NewStatus([]string{name, name + "-last"}),
+	}
+	if w.getRows == nil {
+		w.getRows = func() (TableSnapshot, error) {
+			return GetTableRows(mysqlConn)
+		}
+	}
+	return w
+}
+
+func (w *TableWorker) Setup(interval *iter.Interval) error {
+	if w.iter != nil && interval.Number != w.iter.Number+1 {
+		w.logger.Warn(fmt.Sprintf("Interval out of sequence: got %d, expected %d", interval.Number, w.iter.Number+1))
+		w.reset()
+	}
+	w.iter = interval
+	w.lastRowCnt = 0
+	w.lastErr = nil
+	return nil
+}
+
+// Run returns the table and index I/O since the previous interval, or nil
+// if there's no previous interval to compare to.
+func (w *TableWorker) Run() (*report.TableResult, error) {
+	w.logger.Debug("Run:call:", w.iter.Number)
+	defer w.logger.Debug("Run:return:", w.iter.Number)
+
+	defer w.status.Update(w.name, "Idle")
+
+	w.status.Update(w.name, "Connecting to MySQL")
+	if err := w.mysqlConn.Connect(); err != nil {
+		w.logger.Warn(err.Error())
+		w.lastErr = err
+		return nil, nil // not an error to caller
+	}
+	defer w.mysqlConn.Close()
+
+	w.status.Update(w.name, "Processing rows")
+	curr, err := w.getRows()
+	if err != nil {
+		w.lastErr = err
+		return nil, err
+	}
+	w.curr = curr
+	w.lastRowCnt = uint(len(curr.Tables) + len(curr.Indexes))
+	w.lastFetchTime = time.Now().UTC()
+
+	if w.prev.Tables == nil {
+		return nil, nil // first snapshot
+	}
+
+	return w.prepareResult(w.prev, curr), nil
+}
+
+func (w *TableWorker) Cleanup() error {
+	// The summary tables are read whole every interval, so the current
+	// snapshot replaces the previous one, unlike digests which are merged.
+	if w.curr.Tables != nil {
+		w.prev = w.curr
+		w.curr = TableSnapshot{}
+	}
+	last := fmt.Sprintf("rows: %d, fetch: %s", w.lastRowCnt, w.lastFetchTime.Format(time.RFC3339))
+	if w.lastErr != nil {
+		last += fmt.Sprintf(", error: %s", w.lastErr)
+	}
+	w.status.Update(w.name+"-last", last)
+	return nil
+}
+
+func (w *TableWorker) Status() map[string]string {
+	return w.status.All()
+}
+
+// --------------------------------------------------------------------------
+
+func (w *TableWorker) reset() {
+	w.iter = nil
+	w.prev = TableSnapshot{}
+	w.curr = TableSnapshot{}
+	w.lastErr = nil
+	w.lastRowCnt = 0
+	w.lastFetchTime = time.Time{}
+}
+
+// prepareResult returns the tables and indexes with I/O during the interval,
+// so the report grows with activity, not with the number of tables. Indexes
+// without I/O are only listed by name in UnusedIndexes.
+func (w *TableWorker) prepareResult(prev, curr TableSnapshot) *report.TableResult {
+	result := &report.TableResult{
+		Tables:        []qan.TableIO{},
+		Indexes:       []qan.TableIO{},
+		UnusedIndexes: []qan.Index{},
+	}
+	for key, row := range curr.Tables {
+		prevRow := prevTableRow(prev.Tables, key)
+		if row.CountStar < prevRow.CountStar {
+			// Table was truncated, start over like the digests worker does.
+			w.reset()
+			return nil
+		}
+		if row.CountStar != prevRow.CountStar { // else no I/O during interval
+			result.Tables = append(result.Tables, tableIODelta(row, prevRow))
+		}
+	}
+	for key, row := range curr.Indexes {
+		prevRow := prevTableRow(prev.Indexes, key)
+		if row.CountStar < prevRow.CountStar {
+			w.reset()
+			return nil
+		}
+		if row.CountStar != prevRow.CountStar {
+			result.Indexes = append(result.Indexes, tableIODelta(row, prevRow))
+		} else if row.Index != "" {
+			result.UnusedIndexes = append(result.UnusedIndexes, qan.Index{
+				Schema: row.Schema,
+				Table:  row.Table,
+				Index:  row.Index,
+			})
+		}
+	}
+	return result
+}
+
+// prevTableRow returns the row of prev, or a zero row if it's new.
+func prevTableRow(prev map[string]*TableRow, key string) *TableRow {
+	if row, ok := prev[key]; ok {
+		return row
+	}
+	return &TableRow{}
+}
+
+func tableIODelta(row, prevRow *TableRow) qan.TableIO {
+	return qan.TableIO{
+		Schema:      row.Schema,
+		Table:       row.Table,
+		Index:       row.Index,
+		CountStar:   row.CountStar - prevRow.CountStar,
+		CountRead:   row.CountRead - prevRow.CountRead,
+		CountWrite:  row.CountWrite - prevRow.CountWrite,
+		CountFetch:  row.CountFetch - prevRow.CountFetch,
+		CountInsert: row.CountInsert - prevRow.CountInsert,
+		CountUpdate: row.CountUpdate - prevRow.CountUpdate,
+		CountDelete: row.CountDelete - prevRow.CountDelete,
+		// Time metrics are in picoseconds, so multiply by 10^-12 to convert to seconds.
+		Time:      float64(row.SumTimerWait-prevRow.SumTimerWait) * math.Pow10(-12),
+		ReadTime:  float64(row.SumTimerRead-prevRow.SumTimerRead) * math.Pow10(-12),
+		WriteTime: float64(row.SumTimerWrite-prevRow.SumTimerWrite) * math.Pow10(-12),
+	}
+}
+
+// --------------------------------------------------------------------------
+
+const tableColumns = `
+	OBJECT_SCHEMA,
+	OBJECT_NAME,
+	COUNT_STAR,
+	SUM_TIMER_WAIT,
+	COUNT_READ,
+	SUM_TIMER_READ,
+	COUNT_WRITE,
+	SUM_TIMER_WRITE,
+	COUNT_FETCH,
+	COUNT_INSERT,
+	COUNT_UPDATE,
+	COUNT_DELETE`
+
+const tableWhere = `
+	WHERE OBJECT_TYPE = 'TABLE'
+	AND OBJECT_SCHEMA NOT IN ('mysql', 'performance_schema', 'information_schema', 'sys')`
+
+// GetTableRows returns a snapshot of table_io_waits_summary_by_table and
+// table_io_waits_summary_by_index_usage, without system schemas.
+func GetTableRows(mysqlConn mysql.Connector) (TableSnapshot, error) {
+	snapshot := NewTableSnapshot()
+
+	rows, err := mysqlConn.DB().Query(
+		"SELECT" + tableColumns + " FROM performance_schema.table_io_waits_summary_by_table" + tableWhere,
+	)
+	if err != nil {
+		return snapshot, err
+	}
+	err = scanTableRows(rows, false, snapshot.AddTable)
+	if err != nil {
+		return snapshot, err
+	}
+
+	rows, err = mysqlConn.DB().Query(
+		"SELECT COALESCE(INDEX_NAME, ''), " + tableColumns + " FROM performance_schema.table_io_waits_summary_by_index_usage" + tableWhere,
+	)
+	if err != nil {
+		return snapshot, err
+	}
+	err = scanTableRows(rows, true, snapshot.AddIndex)
+	return snapshot, err
+}
+
+func scanTableRows(rows *sql.Rows, withIndex bool, add func(*TableRow)) error {
+	defer rows.Close()
+	for rows.Next() {
+		row := &TableRow{}
+		dest := []interface{}{
+			&row.Schema,
+			&row.Table,
+			&row.CountStar,
+			&row.SumTimerWait,
+			&row.CountRead,
+			&row.SumTimerRead,
+			&row.CountWrite,
+			&row.SumTimerWrite,
+			&row.CountFetch,
+			&row.CountInsert,
+			&row.CountUpdate,
+			&row.CountDelete,
+		}
+		if withIndex {
+			dest = append([]interface{}{&row.Index}, dest...)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		add(row)
+	}
+	return rows.Err()
+}
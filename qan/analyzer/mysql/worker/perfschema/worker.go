@@ -22,7 +22,6 @@ import (
 	"fmt"
 	"math"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/percona/go-mysql/event"
@@ -81,34 +80,59 @@ type WorkerFactory interface {
 }
 
 type RealWorkerFactory struct {
-	logChan chan proto.LogEntry
+	logChan     chan proto.LogEntry
+	connFactory mysql.ConnectionFactory
 }
 
-type perfSchemaExample struct {
-	Schema   sql.NullString
-	SQLText  sql.NullString
-	LastSeen time.Time
-}
-
-func NewRealWorkerFactory(logChan chan proto.LogEntry) *RealWorkerFactory {
+// NewRealWorkerFactory returns a factory of Workers which read examples,
+// stages, and waits through connections from connFactory.
+func NewRealWorkerFactory(logChan chan proto.LogEntry, connFactory mysql.ConnectionFactory) *RealWorkerFactory {
 	f := &RealWorkerFactory{
-		logChan: logChan,
+		logChan:     logChan,
+		connFactory: connFactory,
 	}
 	return f
 }
 
 func (f *RealWorkerFactory) Make(name string, mysqlConn mysql.Connector) *Worker {
+	logger := pct.NewLogger(f.logChan, name)
+	// The columns of events_statements_summary_by_digest depend on the distro
+	// and version, so check them on first use and again after an error, maybe
+	// MySQL was upgraded.
+	var columns ColumnSet
+	haveColumns := false
 	getRows := func(c chan<- *DigestRow, lastFetchSeconds float64, doneChan chan<- error) error {
-		return GetDigestRows(mysqlConn, lastFetchSeconds, c, doneChan)
+		if !haveColumns {
+			flavor, err := mysql.GetFlavor(mysqlConn)
+			if err != nil {
+				return err
+			}
+			columns, err = GetColumnSet(mysqlConn, flavor, "events_statements_summary_by_digest")
+			if err != nil {
+				return err
+			}
+			logger.Debug("Flavor:", flavor)
+			haveColumns = true
+		}
+		err := GetDigestRows(mysqlConn, columns, lastFetchSeconds, c, doneChan)
+		if err != nil {
+			haveColumns = false
+		}
+		return err
 	}
-	return NewWorker(pct.NewLogger(f.logChan, name), mysqlConn, getRows)
+	// Examples, stages, and waits are read through their own connections
+	// because the worker closes its connection after every interval.
+	getExamples := MakeGetExampleRowsFunc(f.connFactory.Make(mysqlConn.DSN()))
+	getStages := MakeGetStageRowsFunc(f.connFactory.Make(mysqlConn.DSN()))
+	return NewWorker(logger, mysqlConn, getRows, getExamples, getStages)
 }
 
 // GetDigestRows connects to MySQL through `mysql.Connector`,
 // fetches snapshot of data from events_statements_summary_by_digest,
 // delivers it over a channel, and notifies success or error through `doneChan`.
 // If `lastFetchSeconds` equals `-1` then it fetches all data, not just since `lastFetchSeconds`.
-func GetDigestRows(mysqlConn mysql.Connector, lastFetchSeconds float64, c chan<- *DigestRow, doneChan chan<- error) error {
+// Columns not in `columns` are selected as zero (see GetColumnSet).
+func GetDigestRows(mysqlConn mysql.Connector, columns ColumnSet, lastFetchSeconds float64, c chan<- *DigestRow, doneChan chan<- error) error {
 	q := `
 SELECT
 	COALESCE(SCHEMA_NAME, ''),
@@ -119,28 +143,33 @@ SELECT
 	MIN_TIMER_WAIT,
 	AVG_TIMER_WAIT,
 	MAX_TIMER_WAIT,
-	SUM_LOCK_TIME,
-	SUM_ERRORS,
-	SUM_WARNINGS,
-	SUM_ROWS_AFFECTED,
-	SUM_ROWS_SENT,
-	SUM_ROWS_EXAMINED,
-	SUM_CREATED_TMP_DISK_TABLES,
-	SUM_CREATED_TMP_TABLES,
-	SUM_SELECT_FULL_JOIN,
-	SUM_SELECT_FULL_RANGE_JOIN,
-	SUM_SELECT_RANGE,
-	SUM_SELECT_RANGE_CHECK,
-	SUM_SELECT_SCAN,
-	SUM_SORT_MERGE_PASSES,
-	SUM_SORT_RANGE,
-	SUM_SORT_ROWS,
-	SUM_SORT_SCAN,
-	SUM_NO_INDEX_USED,
-	SUM_NO_GOOD_INDEX_USED
+	` + columns.Col("SUM_LOCK_TIME", "0") + `,
+	` + columns.Col("SUM_ERRORS", "0") + `,
+	` + columns.Col("SUM_WARNINGS", "0") + `,
+	` + columns.Col("SUM_ROWS_AFFECTED", "0") + `,
+	` + columns.Col("SUM_ROWS_SENT", "0") + `,
+	` + columns.Col("SUM_ROWS_EXAMINED", "0") + `,
+	` + columns.Col("SUM_CREATED_TMP_DISK_TABLES", "0") + `,
+	` + columns.Col("SUM_CREATED_TMP_TABLES", "0") + `,
+	` + columns.Col("SUM_SELECT_FULL_JOIN", "0") + `,
+	` + columns.Col("SUM_SELECT_FULL_RANGE_JOIN", "0") + `,
+	` + columns.Col("SUM_SELECT_RANGE", "0") + `,
+	` + columns.Col("SUM_SELECT_RANGE_CHECK", "0") + `,
+	` + columns.Col("SUM_SELECT_SCAN", "0") + `,
+	` + columns.Col("SUM_SORT_MERGE_PASSES", "0") + `,
+	` + columns.Col("SUM_SORT_RANGE", "0") + `,
+	` + columns.Col("SUM_SORT_ROWS", "0") + `,
+	` + columns.Col("SUM_SORT_SCAN", "0") + `,
+	` + columns.Col("SUM_NO_INDEX_USED", "0") + `,
+	` + columns.Col("SUM_NO_GOOD_INDEX_USED", "0") + `
 	FROM performance_schema.events_statements_summary_by_digest
 `
 
+	// Without LAST_SEEN every snapshot has all rows, which is slower but
+	// still correct because unchanged rows are skipped.
+	if !columns.Has("LAST_SEEN") {
+		lastFetchSeconds = -1
+	}
 	if lastFetchSeconds >= 0 {
 		q += fmt.Sprintf(" WHERE LAST_SEEN >= NOW() - INTERVAL %d SECOND", int64(lastFetchSeconds))
 	}
@@ -209,6 +238,12 @@ SELECT
 	return nil
 }
 
+// digestClassId returns the class ID of a 32-character digest. Using only last
+// 16 digits of checksum is historical: pt-query-digest does the same.
+func digestClassId(digest string) string {
+	return strings.ToUpper(digest[16:32])
+}
+
 type GetDigestRowsFunc func(c chan<- *DigestRow, lastFetchSeconds float64, doneChan chan<- error) error
 
 type Worker struct {
@@ -225,14 +260,18 @@ type Worker struct {
 	lastFetchTime   time.Time
 	lastPrepTime    float64
 	collectExamples bool
+	collectStages   bool
 	//
-	ticker        *time.Ticker
-	isRunning     bool
-	lock          sync.Mutex
-	queryExamples map[string]perfSchemaExample
+	examples         *Examples
+	errors           *Errors
+	exampleCollector *ExampleCollector
+	stageCollector   *StageCollector
 }
 
-func NewWorker(logger *pct.Logger, mysqlConn mysql.Connector, getRows GetDigestRowsFunc) *Worker {
+// NewWorker returns a new Worker. If getExamples is nil, query examples and
+// errors are not collected, like if examples are disabled by config. If getStages is nil, the stage and wait breakdown
+// is not collected even if enabled by config.
+func NewWorker(logger *pct.Logger, mysqlConn mysql.Connector, getRows GetDigestRowsFunc, getExamples GetExampleRowsFunc, getStages GetStageRowsFunc) *Worker {
 	name := logger.Service()
 	w := &Worker{
 		logger:    logger,
@@ -244,9 +283,27 @@ func NewWorker(logger *pct.Logger, mysqlConn mysql.Connector, getRows GetDigestR
 			name,
 			name + "-last",
 			name + "-digests",
+			name + "-examples",
+			name + "-stages",
 		}),
-		digests:       NewDigests(),
-		queryExamples: make(map[string]perfSchemaExample),
+		digests:  NewDigests(),
+		examples: NewExamples(DefaultExamplesPerClass, DefaultMaxExampleClasses),
+		errors:   NewErrors(DefaultMaxExampleClasses),
+	}
+	if getExamples != nil {
+		w.exampleCollector = NewExampleCollector(
+			pct.NewLogger(logger.LogChan(), name+"-examples"),
+			getExamples,
+			w.examples,
+			w.errors,
+		)
+	}
+	if getStages != nil {
+		w.stageCollector = NewStageCollector(
+			pct.NewLogger(logger.LogChan(), name+"-stages"),
+			getStages,
+			DefaultMaxExampleClasses,
+		)
 	}
 	return w
 }
@@ -319,21 +376,50 @@ func (w *Worker) Cleanup() error {
 }
 
 func (w *Worker) Stop() error {
-	if w.ticker != nil {
-		w.ticker.Stop()
+	if w.exampleCollector != nil {
+		w.exampleCollector.Stop()
+	}
+	if w.stageCollector != nil {
+		w.stageCollector.Stop()
 	}
 	return nil
 }
 
 func (w *Worker) Status() map[string]string {
+	if w.exampleCollector != nil {
+		w.status.Update(w.name+"-examples", w.exampleCollector.Status())
+	}
+	if w.stageCollector != nil {
+		w.status.Update(w.name+"-stages", w.stageCollector.Status())
+	}
 	return w.status.All()
 }
 
 func (w *Worker) SetConfig(config pc.QAN) {
-	w.collectExamples = *config.ExampleQueries
-	if w.collectExamples {
-		w.ticker = time.NewTicker(time.Millisecond * 1000)
-		go w.getQueryExamples(w.ticker.C)
+	// Errors by error number are counted from the statement history polled
+	// for examples, so they're only collected with examples.
+	w.collectExamples = pct.BoolValue(config.ExampleQueries) && w.exampleCollector != nil
+	if w.exampleCollector != nil {
+		if w.collectExamples {
+			w.exampleCollector.Start(HistoryPollInterval)
+		} else {
+			w.exampleCollector.Stop()
+			w.examples.Reset()
+			w.errors.Reset()
+		}
+	}
+
+	w.collectStages = pct.BoolValue(config.StageWaitBreakdown) && w.stageCollector != nil
+	if w.stageCollector != nil {
+		if w.collectStages {
+			// The stage and wait history tables are ring buffers, so like
+			// the statement history they're polled often, else most events
+			// are overwritten before they're read on a busy server.
+			w.stageCollector.Start(HistoryPollInterval)
+		} else {
+			w.stageCollector.Stop()
+			w.stageCollector.Reset()
+		}
 	}
 }
 
@@ -348,46 +434,6 @@ func (w *Worker) reset() {
 	w.lastPrepTime = 0
 }
 
-func (w *Worker) getQueryExamples(ticker <-chan time.Time) {
-	isRunning := false
-	for range ticker {
-		if isRunning {
-			continue
-		}
-		w.isRunning = true
-
-		err := w.mysqlConn.Connect()
-		if err != nil {
-			continue
-		}
-
-		query := "SELECT DIGEST, CURRENT_SCHEMA, SQL_TEXT FROM performance_schema.events_statements_history"
-		rows, err := w.mysqlConn.DB().Query(query)
-		if err != nil {
-			return
-		}
-		for rows.Next() {
-			ex := perfSchemaExample{
-				LastSeen: time.Now(),
-			}
-			var digest sql.NullString
-			err := rows.Scan(&digest, &ex.Schema, &ex.SQLText)
-			if err != nil {
-				continue
-			}
-			if !digest.Valid {
-				continue
-			}
-			w.lock.Lock()
-			classID := strings.ToUpper(digest.String[16:32])
-			w.queryExamples[classID] = ex
-			w.lock.Unlock()
-		}
-		rows.Close()
-		w.isRunning = false
-	}
-}
-
 func (w *Worker) getSnapshot() (Snapshot, error) {
 	w.logger.Debug("getSnapshot:call:", w.iter.Number)
 	defer w.logger.Debug("getSnapshot:return:", w.iter.Number)
@@ -429,7 +475,7 @@ func (w *Worker) getSnapshot() (Snapshot, error) {
 			// this summary in PCT
 			classId := "2"
 			if len(row.Digest) >= 32 {
-				classId = strings.ToUpper(row.Digest[16:32])
+				classId = digestClassId(row.Digest)
 			}
 			if class, haveClass := curr[classId]; haveClass {
 				if _, haveRow := class.Rows[row.Schema]; haveRow {
@@ -473,6 +519,8 @@ func (w *Worker) prepareResult(prev, curr Snapshot) (*report.Result, error) {
 
 	global := event.NewClass("", "", false)
 	classes := []*event.Class{}
+	allExamples := map[string][]*event.Example{}
+	allErrors := map[string]map[uint16]uint64{}
 
 	// Compare current classes to previous.
 ClassLoop:
@@ -605,18 +653,32 @@ ClassLoop:
 		stats.NumberMetrics["No_index_used"] = &event.NumberStats{Sum: d.SumNoIndexUsed}
 		stats.NumberMetrics["No_good_index_used"] = &event.NumberStats{Sum: d.SumNoGoodIndexUsed}
 
+		// Stage_* and Wait_* time metrics, if enabled.
+		if w.collectStages {
+			if breakdown := w.stageCollector.Pop(classId); breakdown != nil {
+				breakdown.AddMetrics(stats)
+			}
+		}
+
 		// Create and save the pre-aggregated class.  Using only last 16 digits
 		// of checksum is historical: pt-query-digest does the same:
 		// my $checksum = uc substr(md5_hex($val), -16);
-		// 0 as tzDiff (last param) because we are not saving examples
-		ex, ok := w.queryExamples[classId]
-		class := event.NewClass(classId, class.DigestText, ok)
-		if ok {
-			class.Example = &event.Example{
-				QueryTime: float64(ex.LastSeen.Unix()),
-				Db:        ex.Schema.String,
-				Query:     ex.SQLText.String,
+		var examples []Example
+		if w.collectExamples {
+			examples = w.examples.Pop(classId)
+		}
+		class := event.NewClass(classId, class.DigestText, len(examples) > 0)
+		if len(examples) > 0 {
+			// Examples are sorted slowest first.
+			class.Example = EventExample(examples[0])
+			eventExamples := make([]*event.Example, len(examples))
+			for i := range examples {
+				eventExamples[i] = EventExample(examples[i])
 			}
+			allExamples[classId] = eventExamples
+		}
+		if errors := w.errors.Pop(classId); len(errors) > 0 {
+			allErrors[classId] = errors
 		}
 		class.TotalQueries = d.CountStar
 		class.Metrics = stats
@@ -636,6 +698,13 @@ ClassLoop:
 		Global: global,
 		Class:  classes,
 	}
+	if len(allExamples) > 0 {
+		result.Examples = allExamples
+	}
+	if len(allErrors) > 0 {
+		result.Errors = allErrors
+		result.ErrorsSampled = true
+	}
 
 	return result, nil
 }
@@ -31,6 +31,7 @@ import (
 
 	"github.com/percona/go-mysql/event"
 	"github.com/percona/go-mysql/log"
+	"github.com/percona/go-mysql/query"
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
 	"github.com/percona/qan-agent/mysql"
@@ -258,6 +259,22 @@ func (s *WorkerTestSuite) TestWorkerSlow011(t *C) {
 	assert.JSONEq(t, string(expectBytes), string(gotBytes))
 }
 
+func (s *WorkerTestSuite) TestWorkerSlow013Errors(t *C) {
+	// Percona Server Last_errno: one query failed with 1146 (no such table)
+	i := &iter.Interval{
+		Number:      1,
+		StartTime:   s.now,
+		StopTime:    s.now.Add(1 * time.Minute),
+		Filename:    inputDir + "slow013.log",
+		StartOffset: 0,
+		EndOffset:   100000,
+	}
+	got, err := s.RunWorker(s.config, mock.NewNullMySQL(), i)
+	t.Check(err, IsNil)
+	classId := query.Id(query.Fingerprint("select count(*) into @discard from `information_schema`.`PARTITIONS`"))
+	assert.Equal(t, map[string]map[uint16]uint64{classId: {1146: 1}}, got.Errors)
+}
+
 func (s *WorkerTestSuite) TestRotateAndRemoveSlowLog(t *C) {
 	// Clean up files that may interfere with test.
 	slowlogFile := "slow006.log"
@@ -142,7 +142,7 @@ func (w *Worker) Setup(interval *iter.Interval) error {
 	w.logger.Debug("Setup:", interval)
 
 	// Check if slow log rotation is enabled.
-	if boolValue(w.config.SlowLogRotation) {
+	if pct.BoolValue(w.config.SlowLogRotation) {
 		// Check if max slow log size was reached.
 		if interval.EndOffset >= w.config.MaxSlowLogSize {
 			w.logger.Info(fmt.Sprintf("Rotating slow log: %s >= %s",
@@ -163,7 +163,7 @@ func (w *Worker) Setup(interval *iter.Interval) error {
 		StartOffset:    interval.StartOffset,
 		EndOffset:      interval.EndOffset,
 		RunTime:        workerRunTime,
-		ExampleQueries: boolValue(w.config.ExampleQueries),
+		ExampleQueries: pct.BoolValue(w.config.ExampleQueries),
 		RetainSlowLogs: intValue(w.config.RetainSlowLogs),
 	}
 	w.logger.Debug("Setup:", w.job)
@@ -231,6 +231,9 @@ func (w *Worker) Run() (*report.Result, error) {
 	rateType := ""
 	rateLimit := uint(0)
 
+	// Percona Server logs the error number of failed queries as Last_errno.
+	errors := map[string]map[uint16]uint64{} // keyed on class ID, then errno
+
 	// Do fingerprinting in a separate Go routine so we can recover in case
 	// query.Fingerprint() crashes. We don't want one bad fingerprint to stop
 	// parsing the entire interval. Also, we want to log crashes and hopefully
@@ -299,6 +302,12 @@ EVENT_LOOP:
 		case fingerprint = <-w.fingerprintChan:
 			id := query.Id(fingerprint)
 			aggregator.AddEvent(event, id, fingerprint)
+			if errno := event.NumberMetrics["Last_errno"]; errno > 0 {
+				if errors[id] == nil {
+					errors[id] = map[uint16]uint64{}
+				}
+				errors[id][uint16(errno)]++
+			}
 		case _ = <-w.errChan:
 			w.logger.Warn(fmt.Sprintf("Cannot fingerprint '%s'", event.Query))
 			go w.fingerprinter()
@@ -329,6 +338,9 @@ EVENT_LOOP:
 	result.Global = r.Global
 	result.Class = classes
 	result.RateLimit = rateLimit
+	if len(errors) > 0 {
+		result.Errors = errors
+	}
 
 	// Zero the runtime for testing.
 	if !w.ZeroRunTime {
@@ -463,15 +475,6 @@ func (w *Worker) rotateSlowLog(interval *iter.Interval) error {
 	return nil
 }
 
-// boolValue returns the value of the bool pointer passed in or
-// false if the pointer is nil.
-func boolValue(v *bool) bool {
-	if v != nil {
-		return *v
-	}
-	return false
-}
-
 // intValue returns the value of the int pointer passed in or
 // 0 if the pointer is nil.
 func intValue(v *int) int {
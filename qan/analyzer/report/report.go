@@ -39,8 +39,20 @@ type Result struct {
 	RunTime    float64        // seconds parsing data, hopefully < interval
 	StopOffset int64          // slow log offset where parsing stopped, should be <= end offset
 	Error      string         `json:",omitempty"`
+	// Slowest query examples per class, keyed on class ID, if the source
+	// keeps more than the one example in event.Class.
+	Examples map[string][]*event.Example `json:",omitempty"`
+	// Number of failed queries per class ID and MySQL error number.
+	Errors map[string]map[uint16]uint64 `json:",omitempty"`
+	// Errors are counted in a sample of queries, e.g. the perf schema
+	// statement history, so they're less than the real number of errors.
+	ErrorsSampled bool `json:",omitempty"`
 }
 
+// MaxTopErrors is the number of class and error number pairs reported
+// in qan.Report.TopErrors.
+const MaxTopErrors = 10
+
 type ByQueryTime []*event.Class
 
 func (a ByQueryTime) Len() int      { return len(a) }
@@ -57,13 +69,17 @@ func MakeReport(config pc.QAN, startTime, endTime time.Time, interval *iter.Inte
 
 	// Make qan.Report from Result and other metadata (e.g. Interval).
 	report := &qan.Report{
-		UUID:    config.UUID,
-		StartTs: startTime,
-		EndTs:   endTime,
-		RunTime: result.RunTime,
-		Global:  result.Global,
-		Class:   result.Class,
+		UUID:     config.UUID,
+		StartTs:  startTime,
+		EndTs:    endTime,
+		RunTime:  result.RunTime,
+		Global:   result.Global,
+		Class:    result.Class,
+		Examples: result.Examples,
+		Errors:   result.Errors,
 	}
+	report.ErrorsSampled = result.ErrorsSampled && result.Errors != nil
+	report.TopErrors = topErrors(result.Errors, MaxTopErrors)
 	if interval != nil {
 		size, err := pct.FileSize(interval.Filename)
 		if err != nil {
@@ -88,13 +104,135 @@ func MakeReport(config pc.QAN, startTime, endTime time.Time, interval *iter.Inte
 
 	// Top queries
 	report.Class = result.Class[0:config.ReportLimit]
+	if result.Examples != nil {
+		report.Examples = map[string][]*event.Example{}
+		for _, class := range report.Class {
+			if examples, ok := result.Examples[class.Id]; ok {
+				report.Examples[class.Id] = examples
+			}
+		}
+	}
+
+	if result.Errors != nil {
+		report.Errors = map[string]map[uint16]uint64{}
+		for _, class := range report.Class {
+			if errors, ok := result.Errors[class.Id]; ok {
+				report.Errors[class.Id] = errors
+			}
+		}
+	}
 
 	// Low-ranking Queries
 	lrq := event.NewClass("lrq", "/* low-ranking queries */", false)
 	for _, class := range result.Class[config.ReportLimit:n] {
 		lrq.AddClass(class)
+		for errno, cnt := range result.Errors[class.Id] {
+			if report.Errors[lrq.Id] == nil {
+				report.Errors[lrq.Id] = map[uint16]uint64{}
+			}
+			report.Errors[lrq.Id][errno] += cnt
+		}
 	}
 	report.Class = append(report.Class, lrq)
 
 	return report // top classes, the rest as LRQ
 }
+
+type byErrorCount []qan.ClassError
+
+func (a byErrorCount) Len() int      { return len(a) }
+func (a byErrorCount) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byErrorCount) Less(i, j int) bool {
+	// descending order, then by class and errno so the order is stable
+	if a[i].Count != a[j].Count {
+		return a[i].Count > a[j].Count
+	}
+	if a[i].Id != a[j].Id {
+		return a[i].Id < a[j].Id
+	}
+	return a[i].Errno < a[j].Errno
+}
+
+// topErrors returns the limit class and error number pairs with the most
+// errors, including classes which are reported as low-ranking queries.
+func topErrors(errors map[string]map[uint16]uint64, limit int) []qan.ClassError {
+	if len(errors) == 0 {
+		return nil
+	}
+	top := []qan.ClassError{}
+	for classId, counts := range errors {
+		for errno, cnt := range counts {
+			top = append(top, qan.ClassError{Id: classId, Errno: errno, Count: cnt})
+		}
+	}
+	sort.Sort(byErrorCount(top))
+	if len(top) > limit {
+		top = top[0:limit]
+	}
+	return top
+}
+
+// --------------------------------------------------------------------------
+
+// slowlog|perf schema --> TableResult --> qan.TableReport --> data.Spooler
+
+// Table and index I/O for an interval from performance_schema, passed to
+// MakeTableReport() which transforms into a qan.TableReport{}.
+type TableResult struct {
+	Tables        []qan.TableIO
+	Indexes       []qan.TableIO
+	UnusedIndexes []qan.Index
+	RunTime       float64 // seconds collecting data
+}
+
+type ByTableTime []qan.TableIO
+
+func (a ByTableTime) Len() int      { return len(a) }
+func (a ByTableTime) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByTableTime) Less(i, j int) bool {
+	// descending order, then by name
+	if a[i].Time != a[j].Time {
+		return a[i].Time > a[j].Time
+	}
+	if a[i].Schema != a[j].Schema {
+		return a[i].Schema < a[j].Schema
+	}
+	if a[i].Table != a[j].Table {
+		return a[i].Table < a[j].Table
+	}
+	return a[i].Index < a[j].Index
+}
+
+type ByIndexName []qan.Index
+
+func (a ByIndexName) Len() int      { return len(a) }
+func (a ByIndexName) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByIndexName) Less(i, j int) bool {
+	if a[i].Schema != a[j].Schema {
+		return a[i].Schema < a[j].Schema
+	}
+	if a[i].Table != a[j].Table {
+		return a[i].Table < a[j].Table
+	}
+	return a[i].Index < a[j].Index
+}
+
+func MakeTableReport(config pc.QAN, startTime, endTime time.Time, result *TableResult) *qan.TableReport {
+	// Sort tables and indexes by Time, descending.
+	sort.Sort(ByTableTime(result.Tables))
+	sort.Sort(ByTableTime(result.Indexes))
+
+	report := &qan.TableReport{
+		UUID:    config.UUID,
+		StartTs: startTime,
+		EndTs:   endTime,
+		RunTime: result.RunTime,
+		Tables:  result.Tables,
+		Indexes: result.Indexes,
+	}
+	if len(result.UnusedIndexes) > 0 {
+		sort.Sort(ByIndexName(result.UnusedIndexes))
+		report.UnusedIndexes = result.UnusedIndexes
+	}
+	return report
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/percona/go-mysql/event"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/qan/analyzer/mysql/iter"
 	. "github.com/percona/qan-agent/test/rootdir"
 	"github.com/stretchr/testify/assert"
@@ -86,3 +87,40 @@ func TestResult001(t *testing.T) {
 	assert.Equal(t, event.Float64(1.12), report.Class[2].Metrics.TimeMetrics["Query_time"].Max)
 	assert.Equal(t, event.Float64((1+1+0.101001)/10), report.Class[2].Metrics.TimeMetrics["Query_time"].Avg)
 }
+
+func TestResultErrors(t *testing.T) {
+	data, err := ioutil.ReadFile(outputDir + "/result001.json")
+	require.NoError(t, err)
+
+	result := &Result{}
+	err = json.Unmarshal(data, result)
+	require.NoError(t, err)
+	result.Errors = map[string]map[uint16]uint64{
+		"3000000000000003": {1062: 2},
+		"2000000000000002": {1213: 1},
+		"5000000000000005": {1146: 5, 1062: 1}, // LRQ
+	}
+	result.ErrorsSampled = true
+
+	config := pc.QAN{
+		UUID:        "1",
+		ReportLimit: 2,
+	}
+	report := MakeReport(config, time.Now(), time.Now(), nil, result)
+
+	// Errors of low-ranking queries are summed under "lrq".
+	assert.Equal(t, map[string]map[uint16]uint64{
+		"3000000000000003": {1062: 2},
+		"2000000000000002": {1213: 1},
+		"lrq":              {1146: 5, 1062: 1},
+	}, report.Errors)
+
+	// Top failing classes include classes reported as low-ranking queries.
+	assert.Equal(t, []qan.ClassError{
+		{Id: "5000000000000005", Errno: 1146, Count: 5},
+		{Id: "3000000000000003", Errno: 1062, Count: 2},
+		{Id: "2000000000000002", Errno: 1213, Count: 1},
+		{Id: "5000000000000005", Errno: 1062, Count: 1},
+	}, report.TopErrors)
+	assert.True(t, report.ErrorsSampled)
+}
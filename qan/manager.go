@@ -29,9 +29,11 @@ import (
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	qp "github.com/percona/pmm/proto/qan"
 	"github.com/percona/qan-agent/instance"
 	"github.com/percona/qan-agent/pct"
 	"github.com/percona/qan-agent/qan/analyzer"
+	"github.com/percona/qan-agent/qan/analyzer/mysql/preflight"
 )
 
 const (
@@ -247,6 +249,14 @@ func (m *Manager) Handle(cmd *proto.Cmd) *proto.Reply {
 		m.instanceRepo.Remove(uuid)
 
 		return cmd.Reply(nil, errs...)
+	case "CheckInstance":
+		// Check the instance and config of StartTool without starting it.
+		setConfig := pc.QAN{}
+		if err := json.Unmarshal(cmd.Data, &setConfig); err != nil {
+			return cmd.Reply(nil, err)
+		}
+		check, err := m.checkInstance(setConfig)
+		return cmd.Reply(check, err)
 	case "GetConfig":
 		config, errs := m.GetConfig()
 		return cmd.Reply(config, errs...)
@@ -380,6 +390,20 @@ func (m *Manager) startAnalyzer(setConfig pc.QAN) (err error) {
 	return nil // success
 }
 
+func (m *Manager) checkInstance(setConfig pc.QAN) (qp.InstanceCheck, error) {
+	m.logger.Debug("checkInstance:call")
+	defer m.logger.Debug("checkInstance:return")
+
+	protoInstance, err := m.instanceRepo.Get(setConfig.UUID, false) // true = cache (write to disk)
+	if err != nil {
+		return qp.InstanceCheck{}, fmt.Errorf("cannot get instance %s: %s", setConfig.UUID, err)
+	}
+	if protoInstance.Subsystem != "mysql" {
+		return qp.InstanceCheck{}, fmt.Errorf("CheckInstance is not supported for %s", protoInstance.Subsystem)
+	}
+	return preflight.CheckInstance(protoInstance, setConfig)
+}
+
 func (m *Manager) stopAnalyzer(uuid string) error {
 	/*
 		XXX Assume caller has locked m.mux.
@@ -0,0 +1,231 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package collectioninfo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmgo"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/mongo/explain"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// CollectionInfo returns stats, indexes with their usage, validator and shard key
+// of requested collections. Errors of a collection are reported per namespace.
+func CollectionInfo(dsn string, q *proto.CollectionInfoQuery) (proto.CollectionInfoResult, error) {
+	session, err := explain.Dial(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	res := make(proto.CollectionInfoResult)
+	for _, ns := range q.Namespaces {
+		collectionInfo, ok := res[ns]
+		if !ok {
+			res[ns] = &proto.CollectionInfo{}
+			collectionInfo = res[ns]
+		}
+
+		db, collection, err := splitNamespace(ns)
+		if err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, err.Error())
+			continue
+		}
+
+		collectionInfo.Stats, err = collStats(session, db, collection)
+		if err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, fmt.Sprintf("collStats %s: %s", ns, err))
+		}
+
+		collectionInfo.Indexes, err = listIndexes(session, db, collection)
+		if err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, fmt.Sprintf("listIndexes %s: %s", ns, err))
+		} else if err := indexUsage(session, db, collection, collectionInfo.Indexes); err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, fmt.Sprintf("$indexStats %s: %s", ns, err))
+		}
+
+		collectionInfo.Validator, err = validator(session, db, collection)
+		if err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, fmt.Sprintf("listCollections %s: %s", ns, err))
+		}
+
+		collectionInfo.ShardKey, err = shardKey(session, ns)
+		if err != nil {
+			collectionInfo.Errors = append(collectionInfo.Errors, fmt.Sprintf("config.collections %s: %s", ns, err))
+		}
+	}
+
+	return res, nil
+}
+
+// --------------------------------------------------------------------------
+
+// splitNamespace splits "db.collection"; collection names may contain dots.
+func splitNamespace(ns string) (db, collection string, err error) {
+	i := strings.Index(ns, ".")
+	if i <= 0 || i == len(ns)-1 {
+		return "", "", fmt.Errorf("invalid namespace %q, expected db.collection", ns)
+	}
+	return ns[:i], ns[i+1:], nil
+}
+
+func collStats(session pmgo.SessionManager, db, collection string) (string, error) {
+	var stats mongoproto.BsonD
+	if err := session.DB(db).Run(bson.D{{Name: "collStats", Value: collection}}, &stats); err != nil {
+		return "", err
+	}
+	return marshalJSON(stats)
+}
+
+func listIndexes(session pmgo.SessionManager, db, collection string) ([]proto.CollectionIndex, error) {
+	result := struct {
+		Cursor struct {
+			FirstBatch []bson.Raw `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}{}
+	if err := session.DB(db).Run(bson.D{{Name: "listIndexes", Value: collection}}, &result); err != nil {
+		return nil, err
+	}
+
+	indexes := []proto.CollectionIndex{}
+	for _, raw := range result.Cursor.FirstBatch {
+		index := struct {
+			Name string           `bson:"name"`
+			Key  mongoproto.BsonD `bson:"key"`
+		}{}
+		if err := raw.Unmarshal(&index); err != nil {
+			return nil, err
+		}
+		var spec mongoproto.BsonD
+		if err := raw.Unmarshal(&spec); err != nil {
+			return nil, err
+		}
+
+		key, err := marshalJSON(index.Key)
+		if err != nil {
+			return nil, err
+		}
+		specJSON, err := marshalJSON(spec)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, proto.CollectionIndex{
+			Name: index.Name,
+			Key:  key,
+			Spec: specJSON,
+		})
+	}
+	return indexes, nil
+}
+
+// indexStats is a document returned by $indexStats.
+type indexStats struct {
+	Name     string `bson:"name"`
+	Host     string `bson:"host"`
+	Accesses struct {
+		Ops   int64     `bson:"ops"`
+		Since time.Time `bson:"since"`
+	} `bson:"accesses"`
+}
+
+func indexUsage(session pmgo.SessionManager, db, collection string, indexes []proto.CollectionIndex) error {
+	stats := []indexStats{}
+	pipeline := []bson.M{{"$indexStats": bson.M{}}}
+	if err := session.DB(db).C(collection).Pipe(pipeline).All(&stats); err != nil {
+		return err
+	}
+	mergeIndexUsage(indexes, stats)
+	return nil
+}
+
+// mergeIndexUsage sets usage of indexes from $indexStats. Through mongos
+// there is a document per shard, so accesses are summed and the earliest
+// since is kept.
+func mergeIndexUsage(indexes []proto.CollectionIndex, stats []indexStats) {
+	for i := range indexes {
+		for _, s := range stats {
+			if s.Name != indexes[i].Name {
+				continue
+			}
+			indexes[i].Accesses += s.Accesses.Ops
+			if indexes[i].Since.IsZero() || s.Accesses.Since.Before(indexes[i].Since) {
+				indexes[i].Since = s.Accesses.Since
+			}
+		}
+	}
+}
+
+func validator(session pmgo.SessionManager, db, collection string) (string, error) {
+	result := struct {
+		Cursor struct {
+			FirstBatch []struct {
+				Options struct {
+					Validator mongoproto.BsonD `bson:"validator"`
+				} `bson:"options"`
+			} `bson:"firstBatch"`
+		} `bson:"cursor"`
+	}{}
+	cmd := bson.D{
+		{Name: "listCollections", Value: 1},
+		{Name: "filter", Value: bson.M{"name": collection}},
+	}
+	if err := session.DB(db).Run(cmd, &result); err != nil {
+		return "", err
+	}
+	if len(result.Cursor.FirstBatch) == 0 {
+		return "", fmt.Errorf("collection %s.%s doesn't exist", db, collection)
+	}
+	v := result.Cursor.FirstBatch[0].Options.Validator
+	if len(v) == 0 {
+		return "", nil
+	}
+	return marshalJSON(v)
+}
+
+// shardKey returns shard key of the collection, or "" if it isn't sharded.
+func shardKey(session pmgo.SessionManager, ns string) (string, error) {
+	result := struct {
+		Key     mongoproto.BsonD `bson:"key"`
+		Dropped bool             `bson:"dropped"`
+	}{}
+	err := session.DB("config").C("collections").Find(bson.M{"_id": ns}).One(&result)
+	if err == mgo.ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if result.Dropped || len(result.Key) == 0 {
+		return "", nil
+	}
+	return marshalJSON(result.Key)
+}
+
+func marshalJSON(v mongoproto.BsonD) (string, error) {
+	b, err := bson.MarshalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
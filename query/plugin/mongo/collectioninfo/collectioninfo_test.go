@@ -0,0 +1,97 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package collectioninfo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/percona/pmm/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitNamespace(t *testing.T) {
+	t.Parallel()
+
+	db, collection, err := splitNamespace("test.col1")
+	require.NoError(t, err)
+	assert.Equal(t, "test", db)
+	assert.Equal(t, "col1", collection)
+
+	// collection names may contain dots
+	db, collection, err = splitNamespace("test.system.profile")
+	require.NoError(t, err)
+	assert.Equal(t, "test", db)
+	assert.Equal(t, "system.profile", collection)
+
+	for _, ns := range []string{"", "test", ".col1", "test."} {
+		_, _, err = splitNamespace(ns)
+		assert.Error(t, err, ns)
+	}
+}
+
+func TestMergeIndexUsage(t *testing.T) {
+	t.Parallel()
+
+	t1 := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := t1.Add(time.Hour)
+
+	stat := func(name, host string, ops int64, since time.Time) indexStats {
+		s := indexStats{Name: name, Host: host}
+		s.Accesses.Ops = ops
+		s.Accesses.Since = since
+		return s
+	}
+
+	indexes := []proto.CollectionIndex{
+		{Name: "_id_"},
+		{Name: "a_1"},
+		{Name: "b_1"},
+	}
+	stats := []indexStats{
+		stat("_id_", "shard1:27017", 3, t2),
+		stat("_id_", "shard2:27017", 4, t1),
+		stat("a_1", "shard1:27017", 5, t2),
+	}
+	mergeIndexUsage(indexes, stats)
+
+	assert.Equal(t, int64(7), indexes[0].Accesses)
+	assert.Equal(t, t1, indexes[0].Since)
+	assert.Equal(t, int64(5), indexes[1].Accesses)
+	assert.Equal(t, t2, indexes[1].Since)
+	// index not in $indexStats, e.g. just created
+	assert.Equal(t, int64(0), indexes[2].Accesses)
+	assert.True(t, indexes[2].Since.IsZero())
+}
+
+func TestCollectionInfo(t *testing.T) {
+	t.Parallel()
+
+	dsn := "127.0.0.1:27017"
+
+	q := &proto.CollectionInfoQuery{
+		Namespaces: []string{"test.col1", "invalid"},
+	}
+	res, err := CollectionInfo(dsn, q)
+	require.NoError(t, err)
+
+	require.Contains(t, res, "invalid")
+	assert.Equal(t, []string{`invalid namespace "invalid", expected db.collection`}, res["invalid"].Errors)
+	assert.Contains(t, res, "test.col1")
+}
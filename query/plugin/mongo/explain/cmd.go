@@ -0,0 +1,161 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	// commands profiled with op "command" which are explained as they are;
+	// other ops are converted to commands by mongolib.
+	commands = map[string]bool{
+		"aggregate":     true,
+		"count":         true,
+		"delete":        true,
+		"distinct":      true,
+		"find":          true,
+		"findAndModify": true,
+		"findandmodify": true,
+		"update":        true,
+	}
+
+	// fields of profiled commands which explain rejects: state of the
+	// original session, transaction or cluster, and explain of aggregate.
+	sessionFields = map[string]bool{
+		"$audit":             true,
+		"$client":            true,
+		"$clusterTime":       true,
+		"$configServerState": true,
+		"$db":                true,
+		"$readPreference":    true,
+		"autocommit":         true,
+		"databaseVersion":    true,
+		"explain":            true,
+		"lsid":               true,
+		"readConcern":        true,
+		"shardVersion":       true,
+		"startTransaction":   true,
+		"txnNumber":          true,
+		"writeConcern":       true,
+	}
+
+	// aggregation stages which write, explain must not run them.
+	writeStages = map[string]bool{
+		"$merge": true,
+		"$out":   true,
+	}
+
+	verbosities = map[string]bool{
+		"":                  true,
+		"queryPlanner":      true,
+		"executionStats":    true,
+		"allPlansExecution": true,
+	}
+)
+
+// explainCmd returns explain command for the profiled operation.
+// If verbosity is empty the server default is used.
+func explainCmd(eq mongoproto.ExampleQuery, verbosity string) bson.D {
+	// drop session fields first, mongolib fails if $db isn't the last field
+	eq.Command = clean(eq.Command)
+	eq.OriginatingCommand = clean(eq.OriginatingCommand)
+	if eq.Query.Len() > 0 && eq.Query[0].Name == "find" {
+		eq.Query = clean(eq.Query)
+	}
+
+	var cmd mongoproto.BsonD
+	if eq.Op == "command" && eq.Command.Len() > 0 && commands[eq.Command[0].Name] {
+		cmd = eq.Command
+	} else {
+		cmd, _ = eq.ExplainCmd()[0].Value.(mongoproto.BsonD)
+	}
+
+	explain := bson.D{
+		{Name: "explain", Value: cmd},
+	}
+	if verbosity != "" {
+		explain = append(explain, bson.DocElem{Name: "verbosity", Value: verbosity})
+	}
+	return explain
+}
+
+// clean returns copy of cmd without session fields and, for aggregate, without write stages.
+func clean(cmd mongoproto.BsonD) mongoproto.BsonD {
+	cleaned := mongoproto.BsonD{}
+	for i, e := range cmd {
+		// first element is the command itself, e.g. {"explain": ""} for MongoDB 2.6
+		if i > 0 && sessionFields[e.Name] {
+			continue
+		}
+		if e.Name == "pipeline" && cmd[0].Name == "aggregate" {
+			e.Value = readOnlyPipeline(e.Value)
+		}
+		cleaned = append(cleaned, e)
+	}
+	return cleaned
+}
+
+// readOnlyPipeline returns pipeline without $out and $merge stages.
+// Nested pipelines of $lookup and $facet can't write, so they are kept as they are.
+func readOnlyPipeline(pipeline interface{}) interface{} {
+	switch stages := pipeline.(type) {
+	case []mongoproto.BsonD:
+		readOnly := []mongoproto.BsonD{}
+		for _, stage := range stages {
+			if stage.Len() > 0 && writeStages[stage[0].Name] {
+				continue
+			}
+			readOnly = append(readOnly, stage)
+		}
+		return readOnly
+	case []interface{}:
+		readOnly := []interface{}{}
+		for _, stage := range stages {
+			if isWriteStage(stage) {
+				continue
+			}
+			readOnly = append(readOnly, stage)
+		}
+		return readOnly
+	}
+	return pipeline
+}
+
+func isWriteStage(stage interface{}) bool {
+	switch s := stage.(type) {
+	case mongoproto.BsonD:
+		return s.Len() > 0 && writeStages[s[0].Name]
+	case bson.D:
+		return len(s) > 0 && writeStages[s[0].Name]
+	case bson.M:
+		for name := range s {
+			if writeStages[name] {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for name := range s {
+			if writeStages[name] {
+				return true
+			}
+		}
+	}
+	return false
+}
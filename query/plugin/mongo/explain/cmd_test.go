@@ -0,0 +1,106 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package explain
+
+import (
+	"encoding/json"
+	"testing"
+
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// --------------------------------------------------------------------------
+
+func TestExplainCmd(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		query     string
+		verbosity string
+		want      string
+	}{
+		{
+			name:  "find",
+			query: `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"},"$db":"test","lsid":{"id":1}}}`,
+			want:  `{"find":"col1","filter":{"name":"Alicja"}}`,
+		},
+		{
+			name:      "aggregate with $lookup and $facet",
+			query:     `{"ns":"test.col1","op":"command","command":{"aggregate":"col1","pipeline":[{"$match":{"a":1}},{"$lookup":{"from":"col2","localField":"a","foreignField":"b","as":"c"}},{"$facet":{"x":[{"$count":"n"}]}}],"cursor":{},"$db":"test","$clusterTime":{"t":1}}}`,
+			verbosity: "executionStats",
+			want:      `{"aggregate":"col1","pipeline":[{"$match":{"a":1}},{"$lookup":{"from":"col2","localField":"a","foreignField":"b","as":"c"}},{"$facet":{"x":[{"$count":"n"}]}}],"cursor":{}}`,
+		},
+		{
+			name:  "aggregate with $out",
+			query: `{"ns":"test.col1","op":"command","command":{"aggregate":"col1","pipeline":[{"$match":{"a":1}},{"$out":"col3"}],"cursor":{},"explain":false}}`,
+			want:  `{"aggregate":"col1","pipeline":[{"$match":{"a":1}}],"cursor":{}}`,
+		},
+		{
+			name:  "update",
+			query: `{"ns":"test.col1","op":"update","command":{"q":{"a":1},"u":{"$set":{"b":2}},"multi":true,"upsert":false}}`,
+			want:  `{"update":"col1","updates":[{"q":{"a":1},"u":{"$set":{"b":2}},"multi":true,"upsert":false}]}`,
+		},
+		{
+			name:  "delete",
+			query: `{"ns":"test.col1","op":"remove","command":{"q":{"a":1},"limit":0}}`,
+			want:  `{"delete":"col1","deletes":[{"q":{"a":1},"limit":0}]}`,
+		},
+		{
+			name:      "findAndModify",
+			query:     `{"ns":"test.col1","op":"command","command":{"findAndModify":"col1","query":{"a":1},"update":{"$inc":{"b":1}},"new":true,"writeConcern":{"w":"majority"},"txnNumber":1}}`,
+			verbosity: "queryPlanner",
+			want:      `{"findAndModify":"col1","query":{"a":1},"update":{"$inc":{"b":1}},"new":true}`,
+		},
+		{
+			name:  "distinct",
+			query: `{"ns":"test.col1","op":"command","command":{"distinct":"col1","key":"a","query":{"b":1},"readConcern":{"level":"local"}}}`,
+			want:  `{"distinct":"col1","key":"a","query":{"b":1}}`,
+		},
+	}
+	for _, tt := range tests {
+		eq := mongoproto.ExampleQuery{}
+		err := bson.UnmarshalJSON([]byte(tt.query), &eq)
+		require.NoError(t, err, tt.name)
+
+		cmd := explainCmd(eq, tt.verbosity)
+		require.Equal(t, "explain", cmd[0].Name, tt.name)
+		got, err := json.Marshal(cmd[0].Value)
+		require.NoError(t, err, tt.name)
+		assert.JSONEq(t, tt.want, string(got), tt.name)
+
+		if tt.verbosity == "" {
+			assert.Len(t, cmd, 1, tt.name)
+		} else {
+			require.Len(t, cmd, 2, tt.name)
+			assert.Equal(t, bson.DocElem{Name: "verbosity", Value: tt.verbosity}, cmd[1], tt.name)
+		}
+	}
+}
+
+func TestExplainUnknownVerbosity(t *testing.T) {
+	t.Parallel()
+
+	query := `{"ns":"test.col1","op":"query","query":{"find":"col1"}}`
+	explainResult, err := Explain("127.0.0.1:27017", "test", query, "everything", false)
+	assert.Nil(t, explainResult)
+	assert.EqualError(t, err, "explain: unknown verbosity everything")
+}
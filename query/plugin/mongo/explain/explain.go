@@ -18,12 +18,15 @@
 package explain
 
 import (
+	"fmt"
 	"time"
 
-	"github.com/percona/percona-toolkit/src/go/mongolib/explain"
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
 	"github.com/percona/pmgo"
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct/credential"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
 )
 
 const (
@@ -32,34 +35,77 @@ const (
 	MgoTimeoutSessionSocket = 5 * time.Second
 )
 
-func Explain(dsn, db, query string) (*proto.ExplainResult, error) {
-	// if dsn is incorrect we should exit immediately as this is not gonna correct itself
-	dialInfo, err := pmgo.ParseURL(dsn)
-	if err != nil {
-		return nil, err
+// Dial connects directly to the server dsn points to, with timeouts suitable
+// for commands executed on demand. Caller must Close() the session.
+func Dial(dsn string) (pmgo.SessionManager, error) {
+	return dial(dsn, false)
+}
+
+// Explain explains query, the example document of a profiled operation.
+// Verbosity is one of queryPlanner, executionStats or allPlansExecution,
+// the server default if empty. If secondary is true then query is explained
+// on a secondary of the replica set dsn points to.
+func Explain(dsn, db, query, verbosity string, secondary bool) (*proto.ExplainResult, error) {
+	if !verbosities[verbosity] {
+		return nil, fmt.Errorf("explain: unknown verbosity %s", verbosity)
 	}
-	dialer := pmgo.NewDialer()
 
-	dialInfo.Timeout = MgoTimeoutDialInfo
-	// Disable automatic replicaSet detection, connect directly to specified server
-	dialInfo.Direct = true
-	session, err := dialer.DialWithInfo(dialInfo)
+	eq := mongoproto.ExampleQuery{}
+	if err := bson.UnmarshalJSON([]byte(query), &eq); err != nil {
+		return nil, fmt.Errorf("explain: unable to decode query %s: %s", query, err)
+	}
+	if db == "" {
+		db = eq.Db()
+	}
+
+	session, err := dial(dsn, secondary)
 	if err != nil {
 		return nil, err
 	}
 	defer session.Close()
-	session.SetMode(mgo.Eventual, true)
-	session.SetSyncTimeout(MgoTimeoutSessionSync)
-	session.SetSocketTimeout(MgoTimeoutSessionSocket)
 
-	ex := explain.New(session)
-	resultJson, err := ex.Explain(db, []byte(query))
-	if err != nil {
+	var result mongoproto.BsonD
+	if err := session.DB(db).Run(explainCmd(eq, verbosity), &result); err != nil {
 		return nil, err
 	}
 
+	resultJson, err := bson.MarshalJSON(result)
+	if err != nil {
+		return nil, fmt.Errorf("explain: unable to encode explain result of %s: %s", query, err)
+	}
+
 	explainResult := &proto.ExplainResult{
 		JSON: string(resultJson),
 	}
 	return explainResult, nil
 }
+
+// dial connects to the server dsn points to or, if secondary is true,
+// to a secondary of its replica set.
+func dial(dsn string, secondary bool) (pmgo.SessionManager, error) {
+	// if dsn is incorrect we should exit immediately as this is not gonna correct itself
+	dialInfo, err := pmgo.ParseURL(dsn)
+	if err != nil {
+		return nil, err
+	}
+	dialer := credential.NewMongoDialer(pmgo.NewDialer())
+
+	dialInfo.Timeout = MgoTimeoutDialInfo
+	mode := mgo.Eventual
+	if secondary {
+		// Discover replicaSet members so we can pick a secondary
+		dialInfo.Direct = false
+		mode = mgo.Secondary
+	} else {
+		// Disable automatic replicaSet detection, connect directly to specified server
+		dialInfo.Direct = true
+	}
+	session, err := dialer.DialWithInfo(dialInfo)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mode, true)
+	session.SetSyncTimeout(MgoTimeoutSessionSync)
+	session.SetSocketTimeout(MgoTimeoutSessionSocket)
+	return session, nil
+}
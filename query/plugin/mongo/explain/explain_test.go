@@ -35,7 +35,7 @@ func TestExplain(t *testing.T) {
 	db := "test"
 	query := `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"}}}`
 
-	explainResult, err := Explain(dsn, db, query)
+	explainResult, err := Explain(dsn, db, query, "", false)
 	require.NoError(t, err)
 
 	got := bson.M{}
@@ -60,7 +60,7 @@ func TestExplainDecodeQueryError(t *testing.T) {
 	db := "test"
 	query := `{Jas`
 
-	explainResult, err := Explain(dsn, db, query)
+	explainResult, err := Explain(dsn, db, query, "", false)
 	assert.Nil(t, explainResult)
 	assert.Error(t, err)
 	assert.Equal(t, "explain: unable to decode query {Jas: unexpected EOF", err.Error())
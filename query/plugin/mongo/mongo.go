@@ -22,6 +22,7 @@ import (
 
 	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/query/plugin"
+	"github.com/percona/qan-agent/query/plugin/mongo/collectioninfo"
 	"github.com/percona/qan-agent/query/plugin/mongo/explain"
 	"github.com/percona/qan-agent/query/plugin/mongo/summary"
 )
@@ -32,8 +33,10 @@ var _ plugin.Plugin = (*Mongo)(nil)
 var (
 	// available cmds
 	cmds = map[string]execFunc{
-		"Explain": execExplain,
-		"Summary": execSummary,
+		"Explain":        execExplain,
+		"Summary":        execSummary,
+		"SummaryJSON":    execSummaryJSON,
+		"CollectionInfo": execCollectionInfo,
 	}
 )
 
@@ -67,9 +70,22 @@ func execExplain(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 		return nil, err
 	}
 
-	return explain.Explain(in.DSN, q.Db, q.Query)
+	return explain.Explain(in.DSN, q.Db, q.Query, q.Verbosity, q.Secondary)
 }
 
 func execSummary(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 	return summary.Summary(in.DSN)
 }
+
+func execSummaryJSON(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	return summary.Collect(in.DSN)
+}
+
+func execCollectionInfo(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
+	q := &proto.CollectionInfoQuery{}
+	if err := json.Unmarshal(cmd.Data, q); err != nil {
+		return nil, err
+	}
+
+	return collectioninfo.CollectionInfo(in.DSN, q)
+}
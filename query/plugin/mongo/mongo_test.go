@@ -42,6 +42,10 @@ func TestHandle(t *testing.T) {
 		Cmd: "Summary",
 	}
 
+	cmdSummaryJSON := &proto.Cmd{
+		Cmd: "SummaryJSON",
+	}
+
 	q := &proto.ExplainQuery{
 		Db:    "test",
 		Query: `{"ns":"test.col1","op":"query","query":{"find":"col1","filter":{"name":"Alicja"}}}`,
@@ -53,6 +57,16 @@ func TestHandle(t *testing.T) {
 		Data: data,
 	}
 
+	ciq := &proto.CollectionInfoQuery{
+		Namespaces: []string{"test.col1"},
+	}
+	data, err = json.Marshal(ciq)
+	require.NoError(t, err)
+	cmdCollectionInfo := &proto.Cmd{
+		Cmd:  "CollectionInfo",
+		Data: data,
+	}
+
 	fs := []struct {
 		cmd  *proto.Cmd
 		in   proto.Instance
@@ -74,6 +88,14 @@ func TestHandle(t *testing.T) {
 				assert.Regexp(t, "# Instances #", data)
 			},
 		},
+		// SummaryJSON
+		{
+			cmdSummaryJSON, in,
+			func(data interface{}, err error) {
+				require.NoError(t, err)
+				assert.NotEmpty(t, data.(*proto.MongoSummary).Version)
+			},
+		},
 		// Explain
 		{
 			cmdExplain,
@@ -96,6 +118,19 @@ func TestHandle(t *testing.T) {
 				assert.Len(t, got, 4)
 			},
 		},
+		// CollectionInfo
+		{
+			cmdCollectionInfo,
+			proto.Instance{
+				DSN: "127.0.0.1:27017",
+			},
+			func(data interface{}, err error) {
+				require.NoError(t, err)
+
+				res := data.(proto.CollectionInfoResult)
+				assert.Contains(t, res, "test.col1")
+			},
+		},
 	}
 	for _, f := range fs {
 		f.test(m.Handle(f.cmd, f.in))
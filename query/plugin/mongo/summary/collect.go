@@ -0,0 +1,209 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package summary
+
+import (
+	"fmt"
+	"sort"
+
+	mongoproto "github.com/percona/percona-toolkit/src/go/mongolib/proto"
+	"github.com/percona/pmgo"
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/query/plugin/mongo/explain"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TopCollections is how many of the largest collections are reported.
+const TopCollections = 10
+
+// MaxCollStats is how many collections stats are read for, one collStats
+// each, so a server with very many collections is summarized quickly. The
+// largest collections are those of the collections read.
+const MaxCollStats = 1000
+
+// internal databases are not profiled and their collections not reported.
+var internalDbs = map[string]bool{
+	"admin":  true,
+	"config": true,
+	"local":  true,
+}
+
+// serverStatus is a subset of serverStatus we report.
+type serverStatus struct {
+	Host          string `bson:"host"`
+	Version       string `bson:"version"`
+	Process       string `bson:"process"`
+	Uptime        int64  `bson:"uptime"`
+	StorageEngine struct {
+		Name string `bson:"name"`
+	} `bson:"storageEngine"`
+	WiredTiger *struct {
+		Cache mongoproto.CacheStats `bson:"cache"`
+	} `bson:"wiredTiger"`
+}
+
+// collStats is a subset of collStats we report.
+type collStats struct {
+	Count          int64 `bson:"count"`
+	Size           int64 `bson:"size"`
+	StorageSize    int64 `bson:"storageSize"`
+	TotalIndexSize int64 `bson:"totalIndexSize"`
+}
+
+// Collect returns summary of the server dsn points to, or of DefaultDSN if dsn is empty.
+// It fails only if the server can't be reached, errors of sections are reported in the summary.
+func Collect(dsn string) (*proto.MongoSummary, error) {
+	if dsn == "" {
+		dsn = DefaultDSN
+	}
+	session, err := explain.Dial(dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	s := &proto.MongoSummary{}
+
+	status := serverStatus{}
+	if err := session.DB("admin").Run(bson.D{{Name: "serverStatus", Value: 1}}, &status); err != nil {
+		return nil, err
+	}
+	s.Host = status.Host
+	s.Version = status.Version
+	s.Process = status.Process
+	s.Uptime = status.Uptime
+	s.StorageEngine = status.StorageEngine.Name
+	if status.WiredTiger != nil {
+		s.Cache = &proto.MongoCache{
+			MaxBytes:   status.WiredTiger.Cache.MaxBytesConfigured,
+			UsedBytes:  status.WiredTiger.Cache.CurrentCachedBytes,
+			DirtyBytes: status.WiredTiger.Cache.TrackedDirtyBytes,
+		}
+	}
+
+	master := mongoproto.MasterDoc{}
+	if err := session.Run(bson.M{"isMaster": 1}, &master); err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("isMaster: %s", err))
+	}
+	mongos := master.Msg == "isdbgrid"
+
+	if mongos {
+		shards := mongoproto.ShardsInfo{}
+		if err := session.DB("admin").Run(bson.M{"listShards": 1}, &shards); err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("listShards: %s", err))
+		}
+		for _, shard := range shards.Shards {
+			s.Shards = append(s.Shards, proto.MongoShard{Id: shard.ID, Host: shard.Host})
+		}
+	} else if setName, _ := master.SetName.(string); setName != "" {
+		replSet := mongoproto.ReplicaSetStatus{}
+		if err := session.DB("admin").Run(bson.M{"replSetGetStatus": 1}, &replSet); err != nil {
+			s.Errors = append(s.Errors, fmt.Sprintf("replSetGetStatus: %s", err))
+		}
+		s.ReplicaSet = &proto.MongoReplicaSet{Name: setName}
+		for _, m := range replSet.Members {
+			s.ReplicaSet.Members = append(s.ReplicaSet.Members, proto.MongoMember{
+				Name:  m.Name,
+				State: m.StateStr,
+				Self:  m.Self,
+			})
+		}
+	}
+
+	dbs := mongoproto.Databases{}
+	if err := session.DB("admin").Run(bson.M{"listDatabases": 1}, &dbs); err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("listDatabases: %s", err))
+	}
+	collections := []proto.MongoCollection{}
+	skipped := 0
+	for _, db := range dbs.Databases {
+		if internalDbs[db.Name] {
+			continue
+		}
+
+		// profiling is set on shards, not on mongos
+		if !mongos {
+			ps := mongoproto.ProfilerStatus{}
+			if err := session.DB(db.Name).Run(bson.M{"profile": -1}, &ps); err != nil {
+				s.Errors = append(s.Errors, fmt.Sprintf("profile %s: %s", db.Name, err))
+			} else {
+				s.Profiler = append(s.Profiler, proto.MongoProfiler{Db: db.Name, Level: ps.Was, Slowms: ps.SlowMs})
+			}
+		}
+
+		c, n, errs := dbCollections(session, db.Name, MaxCollStats-len(collections))
+		s.Errors = append(s.Errors, errs...)
+		collections = append(collections, c...)
+		skipped += n
+	}
+	if skipped > 0 {
+		s.Errors = append(s.Errors, fmt.Sprintf("collStats: skipped %d collections over %d", skipped, MaxCollStats))
+	}
+	s.TopCollections = topCollections(collections, TopCollections)
+
+	return s, nil
+}
+
+// --------------------------------------------------------------------------
+
+// dbCollections returns stats of at most max collections of db, how many
+// collections were skipped over max, and errors of collections which stats
+// can't be read, e.g. views.
+func dbCollections(session pmgo.SessionManager, db string, max int) ([]proto.MongoCollection, int, []string) {
+	names, err := session.DB(db).CollectionNames()
+	if err != nil {
+		return nil, 0, []string{fmt.Sprintf("listCollections %s: %s", db, err)}
+	}
+	skipped := 0
+	if max < 0 {
+		max = 0
+	}
+	if len(names) > max {
+		skipped = len(names) - max
+		names = names[:max]
+	}
+
+	collections := []proto.MongoCollection{}
+	errs := []string{}
+	for _, name := range names {
+		stats := collStats{}
+		if err := session.DB(db).Run(bson.D{{Name: "collStats", Value: name}}, &stats); err != nil {
+			errs = append(errs, fmt.Sprintf("collStats %s.%s: %s", db, name, err))
+			continue
+		}
+		collections = append(collections, proto.MongoCollection{
+			Ns:          db + "." + name,
+			Count:       stats.Count,
+			Size:        stats.Size,
+			StorageSize: stats.StorageSize,
+			IndexSize:   stats.TotalIndexSize,
+		})
+	}
+	return collections, skipped, errs
+}
+
+// topCollections returns at most n largest collections by data size.
+func topCollections(collections []proto.MongoCollection, n int) []proto.MongoCollection {
+	sort.SliceStable(collections, func(i, j int) bool {
+		return collections[i].Size > collections[j].Size
+	})
+	if len(collections) > n {
+		collections = collections[:n]
+	}
+	return collections
+}
@@ -17,51 +17,15 @@
 
 package summary
 
-import (
-	"github.com/percona/pmgo"
-	"github.com/percona/qan-agent/pct/cmd"
-)
+// DefaultDSN is used if dsn is empty, the same as pt-mongodb-summary did.
+const DefaultDSN = "localhost:27017"
 
-// Summary executes `pt-mongodb-summary` for given dsn
+// Summary returns summary of the server dsn points to as text,
+// in place of `pt-mongodb-summary`. Use Collect for the structured summary.
 func Summary(dsn string) (string, error) {
-	dialInfo, err := pmgo.ParseURL(dsn)
+	s, err := Collect(dsn)
 	if err != nil {
 		return "", err
 	}
-
-	name := "pt-mongodb-summary"
-
-	args := []string{}
-	// add username, password and auth database e.g. `pt-mongodb-summary -u admin -p admin -a admin`
-	args = append(args, authArgs(dialInfo.Username, dialInfo.Password, dialInfo.Source)...)
-	// add host[:port] e.g. `pt-mongodb-summary localhost:27017`
-	args = append(args, addrArgs(dialInfo.Addrs)...)
-
-	return cmd.NewRealCmd(name, args...).Run()
-}
-
-// authArgs returns authentication arguments for cmd
-func authArgs(username, password, authDatabase string) (args []string) {
-	if username != "" {
-		args = append(args, "--username="+username)
-	}
-	if password != "" {
-		args = append(args, "--password="+password)
-	}
-	if authDatabase != "" {
-		args = append(args, "--authenticationDatabase="+password)
-	}
-
-	return args
-}
-
-// addrArgs returns host[:port] arguments for cmd
-func addrArgs(addrs []string) (args []string) {
-	if len(addrs) == 0 {
-		return nil
-	}
-
-	args = append(args, addrs[0])
-
-	return args
+	return Text(s), nil
 }
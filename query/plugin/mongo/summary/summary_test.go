@@ -18,9 +18,12 @@
 package summary
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/percona/pmm/proto"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSummary(t *testing.T) {
@@ -33,3 +36,73 @@ func TestSummary(t *testing.T) {
 
 	assert.Regexp(t, "# Instances #", output)
 }
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	dsn := "127.0.0.1:27017"
+
+	s, err := Collect(dsn)
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, s.Version)
+	assert.NotEmpty(t, s.StorageEngine)
+	assert.Contains(t, []string{"mongod", "mongos"}, s.Process)
+}
+
+func TestText(t *testing.T) {
+	t.Parallel()
+
+	s := &proto.MongoSummary{
+		Host:          "db1:27017",
+		Version:       "3.4.10",
+		Process:       "mongod",
+		Uptime:        90,
+		StorageEngine: "wiredTiger",
+		ReplicaSet: &proto.MongoReplicaSet{
+			Name: "rs0",
+			Members: []proto.MongoMember{
+				{Name: "db1:27017", State: "PRIMARY", Self: true},
+				{Name: "db2:27017", State: "SECONDARY"},
+			},
+		},
+		Profiler: []proto.MongoProfiler{
+			{Db: "test", Level: 1, Slowms: 100},
+		},
+		Cache: &proto.MongoCache{
+			MaxBytes: 1024 * 1024 * 1024,
+		},
+		TopCollections: []proto.MongoCollection{
+			{Ns: "test.col1", Count: 3, Size: 1000},
+		},
+		Errors: []string{"collStats test.view1: Namespace test.view1 is a view, not a collection"},
+	}
+	text := Text(s)
+
+	assert.Regexp(t, "# Instances #", text)
+	assert.Regexp(t, `db1:27017\s+mongod\s+3.4.10`, text)
+	assert.Regexp(t, "# Replica Set rs0 #", text)
+	assert.Regexp(t, `db2:27017\s+SECONDARY`, text)
+	assert.Regexp(t, `test\s+1\s+100`, text)
+	assert.Regexp(t, "# Cache #", text)
+	assert.Regexp(t, `test.col1\s+3`, text)
+	assert.Regexp(t, "is a view, not a collection", text)
+	assert.NotRegexp(t, "# Shards #", text)
+}
+
+func TestTopCollections(t *testing.T) {
+	t.Parallel()
+
+	collections := []proto.MongoCollection{}
+	for i := int64(1); i <= 5; i++ {
+		collections = append(collections, proto.MongoCollection{Ns: fmt.Sprintf("test.col%d", i), Size: i * 10})
+	}
+
+	top := topCollections(collections, 3)
+	require.Len(t, top, 3)
+	assert.Equal(t, "test.col5", top[0].Ns)
+	assert.Equal(t, "test.col4", top[1].Ns)
+	assert.Equal(t, "test.col3", top[2].Ns)
+
+	assert.Len(t, topCollections(collections[:2], 3), 2)
+}
@@ -0,0 +1,125 @@
+/*
+   Copyright (c) 2016, Percona LLC and/or its affiliates. All rights reserved.
+
+   This program is free software: you can redistribute it and/or modify
+   it under the terms of the GNU Affero General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   This program is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU Affero General Public License for more details.
+
+   You should have received a copy of the GNU Affero General Public License
+   along with this program.  If not, see <http://www.gnu.org/licenses/>
+*/
+
+package summary
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/pct"
+)
+
+// width of section headers, as in pt-mongodb-summary.
+const headerWidth = 100
+
+// Text renders summary as plain text sections, like pt-mongodb-summary.
+func Text(s *proto.MongoSummary) string {
+	buf := &bytes.Buffer{}
+
+	section(buf, "Instances")
+	table(buf, []string{"Host", "Process", "Version", "Uptime", "Engine"},
+		[]string{s.Host, s.Process, s.Version, pct.Duration(float64(s.Uptime)), s.StorageEngine},
+	)
+
+	if s.ReplicaSet != nil {
+		section(buf, "Replica Set "+s.ReplicaSet.Name)
+		rows := [][]string{}
+		for _, m := range s.ReplicaSet.Members {
+			self := ""
+			if m.Self {
+				self = "*"
+			}
+			rows = append(rows, []string{m.Name, m.State, self})
+		}
+		table(buf, []string{"Member", "State", "Self"}, rows...)
+	}
+
+	if len(s.Shards) > 0 {
+		section(buf, "Shards")
+		rows := [][]string{}
+		for _, shard := range s.Shards {
+			rows = append(rows, []string{shard.Id, shard.Host})
+		}
+		table(buf, []string{"Shard", "Host"}, rows...)
+	}
+
+	if len(s.Profiler) > 0 {
+		section(buf, "Profiler")
+		rows := [][]string{}
+		for _, p := range s.Profiler {
+			rows = append(rows, []string{p.Db, fmt.Sprint(p.Level), fmt.Sprint(p.Slowms)})
+		}
+		table(buf, []string{"Database", "Level", "Slowms"}, rows...)
+	}
+
+	if s.Cache != nil {
+		section(buf, "Cache")
+		table(buf, []string{"Configured", "Used", "Dirty"},
+			[]string{bytesString(s.Cache.MaxBytes), bytesString(s.Cache.UsedBytes), bytesString(s.Cache.DirtyBytes)},
+		)
+	}
+
+	if len(s.TopCollections) > 0 {
+		section(buf, "Top Collections")
+		rows := [][]string{}
+		for _, c := range s.TopCollections {
+			rows = append(rows, []string{c.Ns, fmt.Sprint(c.Count), bytesString(c.Size), bytesString(c.StorageSize), bytesString(c.IndexSize)})
+		}
+		table(buf, []string{"Namespace", "Documents", "Size", "Storage", "Indexes"}, rows...)
+	}
+
+	if len(s.Errors) > 0 {
+		section(buf, "Errors")
+		for _, err := range s.Errors {
+			fmt.Fprintf(buf, "  %s\n", err)
+		}
+	}
+
+	return buf.String()
+}
+
+// --------------------------------------------------------------------------
+
+// section writes header, e.g. "# Instances ####...".
+func section(buf *bytes.Buffer, title string) {
+	header := "# " + title + " "
+	if n := headerWidth - len(header); n > 0 {
+		header += strings.Repeat("#", n)
+	}
+	fmt.Fprintln(buf, header)
+}
+
+func table(buf *bytes.Buffer, columns []string, rows ...[]string) {
+	w := tabwriter.NewWriter(buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "  %s\n", strings.Join(columns, "\t"))
+	for _, row := range rows {
+		fmt.Fprintf(w, "  %s\n", strings.Join(row, "\t"))
+	}
+	w.Flush()
+	fmt.Fprintln(buf)
+}
+
+func bytesString(b int64) string {
+	if b < 0 {
+		return fmt.Sprint(b)
+	}
+	return pct.Bytes(uint64(b))
+}
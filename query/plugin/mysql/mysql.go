@@ -63,7 +63,11 @@ func (m *MySQL) Handle(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
 type execFunc func(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 
 func (m *MySQL) explain(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	conn := m.connFactory.Make(in.DSN)
+	dsn, err := mysql.InstanceDSN(in)
+	if err != nil {
+		return nil, err
+	}
+	conn := m.connFactory.Make(dsn)
 	if err := conn.Connect(); err != nil {
 		return nil, err
 	}
@@ -78,7 +82,11 @@ func (m *MySQL) explain(cmd *proto.Cmd, in proto.Instance) (interface{}, error)
 }
 
 func (m *MySQL) tableInfo(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	conn := m.connFactory.Make(in.DSN)
+	dsn, err := mysql.InstanceDSN(in)
+	if err != nil {
+		return nil, err
+	}
+	conn := m.connFactory.Make(dsn)
 	if err := conn.Connect(); err != nil {
 		return nil, err
 	}
@@ -93,5 +101,5 @@ func (m *MySQL) tableInfo(cmd *proto.Cmd, in proto.Instance) (interface{}, error
 }
 
 func (m *MySQL) summary(cmd *proto.Cmd, in proto.Instance) (interface{}, error) {
-	return summary.Summary(in.DSN)
+	return summary.Summary(in.DSN, in.TLS)
 }
@@ -21,11 +21,17 @@ import (
 	"net"
 
 	"github.com/go-sql-driver/mysql"
+	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/pct/cmd"
+	"github.com/percona/qan-agent/pct/credential"
 )
 
-// Summary executes `pt-mysql-summary` for given dsn
-func Summary(dsn string) (string, error) {
+// Summary executes `pt-mysql-summary` for given dsn and, if not nil, TLS settings
+func Summary(dsn string, tls *proto.InstanceTLS) (string, error) {
+	dsn, err := credential.Resolve(dsn)
+	if err != nil {
+		return "", err
+	}
 	cfg, err := mysql.ParseDSN(dsn)
 	if err != nil {
 		return "", err
@@ -40,6 +46,7 @@ func Summary(dsn string) (string, error) {
 		return "", err
 	}
 	args = append(args, a...)
+	args = append(args, tlsArgs(tls)...)
 
 	return cmd.NewRealCmd(name, args...).Run()
 }
@@ -79,3 +86,29 @@ func addrArgs(protocol, addr string) (args []string, err error) {
 
 	return args, nil
 }
+
+// tlsArgs returns mysql client TLS arguments passed through by cmd, e.g.:
+// `pt-mysql-summary -- --ssl-ca /ca.pem --ssl-cert /cert.pem --ssl-key /key.pem`
+// The mysql client options must be last, after `--`.
+func tlsArgs(tls *proto.InstanceTLS) (args []string) {
+	if tls == nil {
+		return nil
+	}
+
+	args = append(args, "--")
+	flags := []struct{ flag, value string }{
+		{"--ssl-ca", tls.CA},
+		{"--ssl-cert", tls.Cert},
+		{"--ssl-key", tls.Key},
+	}
+	for _, f := range flags {
+		if f.value != "" {
+			args = append(args, f.flag, f.value)
+		}
+	}
+	if tls.CA != "" && !tls.SkipVerify {
+		args = append(args, "--ssl-verify-server-cert")
+	}
+
+	return args
+}
@@ -21,6 +21,7 @@ import (
 	"os"
 	"testing"
 
+	"github.com/percona/pmm/proto"
 	"github.com/percona/qan-agent/mysql"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -52,8 +53,23 @@ func TestSummary(t *testing.T) {
 	dsn := os.Getenv("PCT_TEST_MYSQL_DSN")
 	require.NotEmpty(t, dsn, "PCT_TEST_MYSQL_DSN is not set")
 
-	output, err := Summary(dsn)
+	output, err := Summary(dsn, nil)
 	require.NoError(t, err, "output: %s", output)
 
 	assert.Regexp(t, "# Percona Toolkit MySQL Summary Report #", output)
 }
+
+func TestTLSArgs(t *testing.T) {
+	t.Parallel()
+
+	assert.Empty(t, tlsArgs(nil))
+
+	args := tlsArgs(&proto.InstanceTLS{CA: "/ca.pem", Cert: "/cert.pem", Key: "/key.pem"})
+	assert.Equal(t, []string{
+		"--",
+		"--ssl-ca", "/ca.pem",
+		"--ssl-cert", "/cert.pem",
+		"--ssl-key", "/key.pem",
+		"--ssl-verify-server-cert",
+	}, args)
+}
@@ -18,13 +18,16 @@
 package mock
 
 import (
+	"strings"
 	"time"
 
 	"github.com/percona/pmm/proto"
+	"github.com/percona/qan-agent/mrms"
+	"github.com/percona/qan-agent/mrms/checker"
 )
 
 type MrmsMonitor struct {
-	c        chan proto.Instance
+	c        chan mrms.Change
 	instance proto.Instance
 }
 
@@ -33,13 +36,13 @@ func NewMrmsMonitor() *MrmsMonitor {
 	return m
 }
 
-func (m *MrmsMonitor) Add(in proto.Instance) chan proto.Instance {
+func (m *MrmsMonitor) Add(in proto.Instance) chan mrms.Change {
 	m.instance = in
-	m.c = make(chan proto.Instance, 10)
+	m.c = make(chan mrms.Change, 10)
 	return m.c
 }
 
-func (m *MrmsMonitor) Remove(uuid string, c chan proto.Instance) {
+func (m *MrmsMonitor) Remove(uuid string, c chan mrms.Change) {
 	m.instance = proto.Instance{}
 }
 
@@ -64,9 +67,29 @@ func (m *MrmsMonitor) ListenerCount(uuid string) uint {
 	return 0
 }
 
+func (m *MrmsMonitor) GetHistory(uuid string) []mrms.Event {
+	return []mrms.Event{}
+}
+
 // The restartChan in the real MrmsMonitor is read only.
 // To be consistent with that, instead of returning the channel just for
 // testing purposes, we have this method to simulate a MySQL restart
 func (m *MrmsMonitor) SimulateMySQLRestart() {
-	m.c <- m.instance
+	m.c <- mrms.Change{Instance: m.instance, Reason: checker.ReasonRestart}
+}
+
+// SimulateConfigChange simulates MRMS detecting that the MySQL config QAN
+// depends on changed.
+func (m *MrmsMonitor) SimulateConfigChange(reason string) {
+	m.c <- mrms.Change{Instance: m.instance, Reason: reason}
+}
+
+// SimulateDrift simulates MRMS detecting the events, usually config drift,
+// with the events in the change like the real MrmsMonitor sends.
+func (m *MrmsMonitor) SimulateDrift(events ...checker.Event) {
+	reasons := make([]string, len(events))
+	for i, e := range events {
+		reasons[i] = e.String()
+	}
+	m.c <- mrms.Change{Instance: m.instance, Reason: strings.Join(reasons, ", "), Events: events}
 }
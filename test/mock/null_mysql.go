@@ -156,6 +156,13 @@ func (n *NullMySQL) GetGlobalVarInteger(varName string) (varValue sql.NullInt64,
 	return varValue, ERR_NOT_FOUND
 }
 
+func (n *NullMySQL) SetGlobalVarBoolean(name string, value bool) {
+	n.boolVars[name] = sql.NullBool{
+		Bool:  value,
+		Valid: true,
+	}
+}
+
 func (n *NullMySQL) SetGlobalVarNumeric(name string, value float64) {
 	n.numericVars[name] = sql.NullFloat64{
 		Float64: value,
@@ -22,6 +22,7 @@ import (
 
 	"github.com/percona/pmm/proto"
 	pc "github.com/percona/pmm/proto/config"
+	"github.com/percona/qan-agent/mrms"
 	"github.com/percona/qan-agent/qan/analyzer"
 )
 
@@ -112,7 +113,7 @@ type AnalyzerArgs struct {
 	Type          string
 	Name          string
 	ProtoInstance proto.Instance
-	RestartChan   chan proto.Instance
+	RestartChan   chan mrms.Change
 	TickChan      chan time.Time
 }
 